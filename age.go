@@ -0,0 +1,122 @@
+//go:build age
+
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+)
+
+// NewAgeWriter creates a new Writer instance writing archive encrypted by
+// age to all recipients, e.g. X25519 and SSH keys of the operators, so any
+// of them can decrypt it with their own identity, see NewAgeReader. It
+// requires building with the age tag, which adds the dependency on
+// filippo.io/age.
+func NewAgeWriter(filename string, recipients ...age.Recipient) (*Writer, error) {
+	w, err := NewWriter(filename)
+	if err != nil {
+		return nil, err
+	}
+	w.encrypt = func(dest io.Writer) (io.WriteCloser, error) {
+		return age.Encrypt(dest, recipients...)
+	}
+	return w, nil
+}
+
+// NewAgeReader creates a new Reader instance of archive encrypted by age
+// decrypting it with the first matching identity. The archive is read
+// sequentially.
+func NewAgeReader(filename string, identities ...age.Identity) (*Reader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// signature trailer is not part of the encrypted stream
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	size, _ := signedSize(file, fi.Size())
+
+	src, err := age.Decrypt(bufio.NewReaderSize(io.NewSectionReader(file, 0, size), defaultBufferSize), identities...)
+	if err != nil {
+		file.Close()
+		return nil, &os.PathError{Op: "read", Path: filename, Err: err}
+	}
+
+	r := NewReaderFrom(src)
+	r.Filename, r.File = filename, file
+	return r, nil
+}
+
+// ParseAgeRecipients parses recipients listed one per line, age X25519
+// recipients ("age1...") and SSH public keys ("ssh-ed25519 ...", "ssh-rsa
+// ...") like in authorized_keys. Empty lines and lines starting with "#"
+// are skipped.
+func ParseAgeRecipients(list string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var recipient age.Recipient
+		var err error
+		if strings.HasPrefix(line, "age1") {
+			recipient, err = age.ParseX25519Recipient(line)
+		} else {
+			recipient, err = agessh.ParseRecipient(line)
+		}
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// ParseAgeIdentities parses content of age identity file or unencrypted SSH
+// private key
+func ParseAgeIdentities(content []byte) ([]age.Identity, error) {
+	if bytes.Contains(content, []byte("PRIVATE KEY-----")) {
+		identity, err := agessh.ParseIdentity(content)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Identity{identity}, nil
+	}
+	return age.ParseIdentities(bytes.NewReader(content))
+}
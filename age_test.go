@@ -0,0 +1,95 @@
+//go:build age
+
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// TestAge tests archives encrypted by age to several recipients
+func TestAge(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	alice, _ := age.GenerateX25519Identity()
+	bob, _ := age.GenerateX25519Identity()
+	recipients, err := ParseAgeRecipients("# operators\n" + alice.Recipient().String() + "\n\n" + bob.Recipient().String() + "\n")
+	if err != nil || len(recipients) != 2 {
+		t.Fatalf("Failed to parse recipients: %v", err)
+	}
+
+	filename := filepath.Join(tempPath, "output.wpress.age")
+	w, err := NewAgeWriter(filename, recipients...)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	err = w.AddDirectoryWithOptions(_getPathToTests(t), AddOptions{})
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("Failed to close archive: %s", err)
+	}
+
+	// any recipient decrypts it with their identity
+	identities, err := ParseAgeIdentities([]byte(bob.String() + "\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse identities: %s", err)
+	}
+	r, err := NewAgeReader(filename, identities...)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	dest := filepath.Join(tempPath, "restored")
+	filesCount, err := r.ExtractTo(dest)
+	if err != nil || filesCount != w.FilesAdded {
+		t.Errorf("Extracted %d files instead of %d: %v", filesCount, w.FilesAdded, err)
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(dest, "lipsum.txt"))
+	expected, _ := ioutil.ReadFile(filepath.Join(_getPathToTests(t), "lipsum.txt"))
+	if string(content) != string(expected) {
+		t.Errorf("Unexpected content `%s`", content)
+	}
+
+	// others can't
+	eve, _ := age.GenerateX25519Identity()
+	_, err = NewAgeReader(filename, eve)
+	if err == nil {
+		t.Errorf("Archive was decrypted by identity which is not a recipient")
+	}
+	_, err = NewReader(filename)
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrEncrypted {
+		t.Errorf("Expected ErrEncrypted, got %v", err)
+	}
+}
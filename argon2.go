@@ -0,0 +1,66 @@
+//go:build argon2
+
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// parameters of new argon2id derivations, the second recommended option of
+// RFC 9106
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 << 10 // KiB
+	argon2Threads = 4
+)
+
+// maxArgon2Memory guards against prologues exhausting memory, in KiB
+const maxArgon2Memory = 4 << 20
+
+// register argon2id key derivation when built with the argon2 tag
+func init() {
+	kdfs[Argon2id] = passphraseKDF{
+		id: kdfArgon2id,
+		params: func() []byte {
+			params := binary.BigEndian.AppendUint32(nil, argon2Time)
+			params = binary.BigEndian.AppendUint32(params, argon2Memory)
+			return append(params, argon2Threads)
+		},
+		derive: func(passphrase string, salt []byte, params []byte) ([]byte, error) {
+			if len(params) != 9 {
+				return nil, ErrDecryption
+			}
+			time, memory, threads := binary.BigEndian.Uint32(params), binary.BigEndian.Uint32(params[4:]), params[8]
+			if time == 0 || time > 64 || memory < 8*uint32(threads) || memory > maxArgon2Memory || threads == 0 {
+				return nil, ErrDecryption
+			}
+			return argon2.IDKey([]byte(passphrase), salt, time, memory, threads, 32), nil
+		},
+	}
+}
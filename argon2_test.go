@@ -0,0 +1,76 @@
+//go:build argon2
+
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestArgon2id tests archives encrypted by a key derived by argon2id
+func TestArgon2id(t *testing.T) {
+	enc := Encryption{Passphrase: "correct horse battery staple", KDF: Argon2id}
+	var archive bytes.Buffer
+	w := NewWriterTo(&archive)
+	w.Encryption = &enc
+	h, _ := NewHeader("wp-config.php", 9, time.Now())
+	err := w.Add(h, strings.NewReader("<?php // "))
+	if err != nil {
+		t.Errorf("Failed to add wp-config.php: %s", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("Failed to close archive: %s", err)
+	}
+	if archive.Bytes()[len(encryptionMagic)] != kdfArgon2id {
+		t.Errorf("Key was not derived by argon2id")
+	}
+
+	// only the passphrase is needed, the parameters are in the prologue
+	src, err := Decrypt(bytes.NewReader(archive.Bytes()), Encryption{Passphrase: enc.Passphrase})
+	if err != nil {
+		t.Fatalf("Failed to decrypt archive: %s", err)
+	}
+	r := NewReaderFrom(src)
+	_, err = r.Next()
+	if err != nil {
+		t.Fatalf("Failed to read header: %s", err)
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil || string(content) != "<?php // " {
+		t.Errorf("Unexpected content `%s`: %v", content, err)
+	}
+
+	src, _ = Decrypt(bytes.NewReader(archive.Bytes()), Encryption{Passphrase: "wrong"})
+	_, err = ioutil.ReadAll(src)
+	if err != ErrDecryption {
+		t.Errorf("Expected ErrDecryption, got %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// AttributesFileName is the name of the record in the root of archive
+// holding permissions, ownership and symbolic link target of the file which
+// follows it as JSON, see Writer.Attributes and StoreSymlinks. Other tools
+// extract the record as a regular file and links as empty files.
+const AttributesFileName = ".wpress-attributes"
+
+// maxAttributesRecord is the maximum length of attributes record content
+const maxAttributesRecord = longNameSize + 256
+
+// ErrUnsafeLink is added to Reader.Warnings when symbolic link points
+// outside of the destination directory, such links are not extracted
+var ErrUnsafeLink = errors.New("symbolic link points outside destination directory")
+
+// errTooManyLinks is returned when resolving path follows more than
+// maxLinks symbolic links, they likely form a loop
+var errTooManyLinks = errors.New("too many levels of symbolic links")
+
+// maxLinks is the maximum number of symbolic links followed by resolvePath
+const maxLinks = 255
+
+// Attributes are permissions, ownership and symbolic link target of a file,
+// UID and GID are -1 when unknown
+type Attributes struct {
+	Mode fs.FileMode `json:"mode"`
+	UID  int         `json:"uid"`
+	GID  int         `json:"gid"`
+	Link string      `json:"link,omitempty"`
+}
+
+// GetAttributes returns attributes of the file and whether they were
+// recorded
+func (h Header) GetAttributes() (Attributes, bool) {
+	if h.attrs == nil {
+		return Attributes{}, false
+	}
+	return *h.attrs, true
+}
+
+// fileAttributes returns attributes of the file described by fi, link is
+// the target of symbolic link
+func fileAttributes(fi os.FileInfo, link string) *Attributes {
+	uid, gid := fileOwner(fi)
+	return &Attributes{Mode: fi.Mode().Perm(), UID: uid, GID: gid, Link: link}
+}
+
+// attributesRecord returns the header block and content of the record
+// holding attributes of the file described by h
+func attributesRecord(h *Header) ([]byte, error) {
+	content, err := json.Marshal(h.attrs)
+	if err != nil {
+		return nil, err
+	}
+	return newRecord(AttributesFileName, h, string(content)), nil
+}
+
+// parseAttributesRecord returns attributes stored in attributes record
+func parseAttributesRecord(content []byte) (*Attributes, error) {
+	attrs := &Attributes{UID: -1, GID: -1}
+	err := json.Unmarshal(content, attrs)
+	if err != nil || attrs.Mode&^fs.ModePerm != 0 {
+		return nil, ErrCorruptHeader
+	}
+	return attrs, nil
+}
+
+// isAttributesRecord reports whether h describes an attributes record
+func isAttributesRecord(h *Header) bool {
+	return h.longName == "" && h.GetName() == AttributesFileName && h.GetPrefix() == "."
+}
+
+// storedSize returns length of the content stored for the file described
+// by fi, links are stored without content
+func storedSize(fi os.FileInfo) int64 {
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		return 0
+	}
+	return fi.Size()
+}
+
+// addSymlink adds the symbolic link at pathToFile stored under name as an
+// empty file with its target in the attributes record
+func (w *Writer) addSymlink(pathToFile string, name string, fi os.FileInfo) error {
+	target, err := os.Readlink(pathToFile)
+	if err != nil {
+		return err
+	}
+	h, err := NewHeader(name, 0, fi.ModTime())
+	if err != nil {
+		return err
+	}
+	h.attrs = fileAttributes(fi, filepath.ToSlash(target))
+	return w.writeEntry(h, strings.NewReader(""))
+}
+
+// safeLink reports whether target of the link extracted to pathToFile stays
+// inside the destination directory
+func (e *extraction) safeLink(pathToFile string, target string) bool {
+	if target == "" || path.IsAbs(target) || filepath.IsAbs(filepath.FromSlash(target)) {
+		return false
+	}
+	dest, err := filepath.Abs(e.opts.DestDir)
+	if err != nil {
+		return false
+	}
+	resolved, err := filepath.Abs(filepath.Join(filepath.Dir(pathToFile), filepath.FromSlash(target)))
+	if err != nil {
+		return false
+	}
+	if !isWithin(dest, resolved) {
+		return false
+	}
+
+	// links extracted earlier may lead elsewhere than the names suggest, the
+	// target is followed through them. Tar stream has no such links.
+	if e.tw != nil {
+		return true
+	}
+	dir, err := resolvePath(filepath.Dir(pathToFile))
+	if err != nil {
+		return false
+	}
+	resolved, err = resolvePath(dir + string(filepath.Separator) + filepath.FromSlash(target))
+	return err == nil && isWithin(e.root, resolved)
+}
+
+// isWithin reports whether pathToFile is dir or is inside it
+func isWithin(dir string, pathToFile string) bool {
+	rel, err := filepath.Rel(dir, pathToFile)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolvePath returns the real path pathToFile refers to. Symbolic links of
+// its existing part are followed and ".." elements are applied to the real
+// directory they were reached through, not to the name, missing part is
+// appended as it is.
+func resolvePath(pathToFile string) (string, error) {
+	if !filepath.IsAbs(pathToFile) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		pathToFile = wd + string(filepath.Separator) + pathToFile
+	}
+
+	volume := filepath.VolumeName(pathToFile)
+	resolved := volume + string(filepath.Separator)
+	pending := strings.Split(filepath.ToSlash(pathToFile[len(volume):]), "/")
+	links := 0
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		fi, err := os.Lstat(next)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		// continue with the elements of the target
+		links++
+		if links > maxLinks {
+			return "", &os.PathError{Op: "resolve", Path: pathToFile, Err: errTooManyLinks}
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			volume = filepath.VolumeName(target)
+			resolved = volume + string(filepath.Separator)
+			target = target[len(volume):]
+		}
+		pending = append(strings.Split(filepath.ToSlash(target), "/"), pending...)
+	}
+	return resolved, nil
+}
+
+// executeSymlink creates the symbolic link of job replacing the existing
+// file
+func (e *extraction) executeSymlink(job *extractJob) error {
+	err := os.MkdirAll(filepath.Dir(job.pathToFile), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(job.pathToFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Symlink(filepath.FromSlash(job.h.attrs.Link), job.pathToFile)
+	if err == nil {
+		err = e.restoreAttributes(job.pathToFile, job.h)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.extractedCount++
+	if e.extracted != nil {
+		e.extracted(job.h.GetPath())
+	}
+	return nil
+}
+
+// restoreAttributes sets permissions of pathToFile and its ownership when
+// RestoreOwner is set to those recorded in h
+func (e *extraction) restoreAttributes(pathToFile string, h *Header) error {
+	if h.attrs == nil {
+		return nil
+	}
+	if e.opts.RestoreOwner && h.attrs.UID >= 0 && h.attrs.GID >= 0 {
+		err := os.Lchown(pathToFile, h.attrs.UID, h.attrs.GID)
+		if err != nil {
+			return err
+		}
+	}
+	if h.attrs.Link != "" {
+		return nil
+	}
+	return os.Chmod(pathToFile, h.attrs.Mode)
+}
@@ -0,0 +1,172 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAttributes tests recording and restoring permissions, ownership and
+// symbolic links
+func TestAttributes(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	root := filepath.Join(tempPath, "site")
+	os.MkdirAll(filepath.Join(root, "bin"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "wp-config.php"), []byte("<?php"), 0600)
+	ioutil.WriteFile(filepath.Join(root, "bin", "cron.sh"), []byte("#!/bin/sh"), 0755)
+	err := os.Symlink("wp-config.php", filepath.Join(root, "config.php"))
+	if err != nil {
+		t.Skipf("Unable to create symbolic link: %s", err)
+	}
+	os.Symlink("/etc/passwd", filepath.Join(root, "passwd"))
+
+	for _, workers := range []int{1, 4} {
+		filename := filepath.Join(tempPath, "output.wpress")
+		w, err := NewWriter(filename)
+		if err != nil {
+			t.Fatalf("Failed to create a new Writer because %s", err)
+		}
+		w.Attributes = true
+		err = w.AddDirectoryWithOptions(root, AddOptions{Symlinks: StoreSymlinks, Workers: workers})
+		if err != nil {
+			t.Errorf("Failed to add directory: %s", err)
+		}
+		w.Close()
+
+		r, err := NewReader(filename)
+		if err != nil {
+			t.Fatalf("Failed to create a new Reader instance: %s", err)
+		}
+		attrs := make(map[string]Attributes)
+		for {
+			h, err := r.Next()
+			if err != nil {
+				break
+			}
+			attrs[h.GetPath()], _ = h.GetAttributes()
+		}
+		if attrs["wp-config.php"].Mode != 0600 || attrs["bin/cron.sh"].Mode != 0755 || attrs["config.php"].Link != "wp-config.php" {
+			t.Errorf("Unexpected attributes %v", attrs)
+		}
+		fi, _ := os.Stat(filepath.Join(root, "wp-config.php"))
+		if uid, _ := fileOwner(fi); attrs["wp-config.php"].UID != uid {
+			t.Errorf("Unexpected owner %d instead of %d", attrs["wp-config.php"].UID, uid)
+		}
+
+		// links pointing outside of the destination are left out
+		dest := filepath.Join(tempPath, "restored")
+		os.RemoveAll(dest)
+		filesCount, err := r.ExtractWithOptions(ExtractOptions{DestDir: dest, RestoreOwner: true, Workers: workers})
+		if err != nil || filesCount != 3 {
+			t.Errorf("Extracted %d files instead of 3: %v", filesCount, err)
+		}
+		if len(r.Warnings) != 1 || !errors.Is(r.Warnings[0], ErrUnsafeLink) {
+			t.Errorf("Unexpected warnings %v", r.Warnings)
+		}
+		r.Close()
+
+		for name, mode := range map[string]os.FileMode{"wp-config.php": 0600, "bin/cron.sh": 0755} {
+			fi, err := os.Stat(filepath.Join(dest, name))
+			if err != nil || fi.Mode().Perm() != mode {
+				t.Errorf("Unexpected permissions of %s: %v", name, err)
+			}
+		}
+		target, err := os.Readlink(filepath.Join(dest, "config.php"))
+		if err != nil || target != "wp-config.php" {
+			t.Errorf("Unexpected link target `%s`: %v", target, err)
+		}
+		_, err = os.Lstat(filepath.Join(dest, "passwd"))
+		if !os.IsNotExist(err) {
+			t.Errorf("Unsafe link was extracted: %v", err)
+		}
+	}
+}
+
+// TestChainedSymlinks tests links which point outside of the destination
+// only through links extracted before them
+func TestChainedSymlinks(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	err := os.Symlink(".", filepath.Join(tempPath, "probe"))
+	if err != nil {
+		t.Skipf("Unable to create symbolic link: %s", err)
+	}
+
+	filename := filepath.Join(tempPath, "crafted.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	for _, file := range []struct{ name, link, content string }{
+		{"d", ".", ""},
+		{"e", "d/..", ""},
+		{"e/evil.txt", "", "evil"},
+	} {
+		h, _ := NewHeader(file.name, int64(len(file.content)), time.Unix(1420382531, 0))
+		if file.link != "" {
+			h.attrs = &Attributes{Mode: 0777, UID: -1, GID: -1, Link: file.link}
+		}
+		err = w.Add(h, strings.NewReader(file.content))
+		if err != nil {
+			t.Fatalf("Failed to add %s: %s", file.name, err)
+		}
+	}
+	w.Close()
+
+	for _, workers := range []int{1, 4} {
+		dest := filepath.Join(tempPath, "restored")
+		os.RemoveAll(dest)
+		r, err := NewReader(filename)
+		if err != nil {
+			t.Fatalf("Failed to open archive: %s", err)
+		}
+		_, err = r.ExtractWithOptions(ExtractOptions{DestDir: dest, Workers: workers})
+		r.Close()
+		if err != nil {
+			t.Errorf("Failed to extract archive: %s", err)
+		}
+		if len(r.Warnings) != 1 || !errors.Is(r.Warnings[0], ErrUnsafeLink) {
+			t.Errorf("Unexpected warnings %v", r.Warnings)
+		}
+		_, err = os.Lstat(filepath.Join(tempPath, "evil.txt"))
+		if !os.IsNotExist(err) {
+			t.Errorf("File was written outside of destination: %v", err)
+		}
+		fi, err := os.Lstat(filepath.Join(dest, "e"))
+		if err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+			t.Errorf("Unsafe link was extracted")
+		}
+	}
+}
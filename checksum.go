@@ -0,0 +1,158 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"os"
+	"strings"
+)
+
+// ChecksumFileName is the name of the record in the root of archive holding
+// the checksum of the file which precedes it, see Writer.Checksum. Other
+// tools extract the record as a regular file.
+const ChecksumFileName = ".wpress-checksum"
+
+// ErrChecksumMismatch is returned when content of a file doesn't match the
+// checksum recorded after it
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumAlgorithm tells how checksums of files are computed
+type ChecksumAlgorithm int
+
+const (
+	// NoChecksum writes no checksums, it is the default
+	NoChecksum ChecksumAlgorithm = iota
+	// CRC32Checksum detects accidental corruption cheaply
+	CRC32Checksum
+	// SHA256Checksum detects corruption and tampering
+	SHA256Checksum
+)
+
+// checksumNames maps algorithms to their names in checksum records
+var checksumNames = map[ChecksumAlgorithm]string{
+	CRC32Checksum:  "crc32",
+	SHA256Checksum: "sha256",
+}
+
+// newHash returns hash of the algorithm
+func (a ChecksumAlgorithm) newHash() hash.Hash {
+	if a == CRC32Checksum {
+		return crc32.NewIEEE()
+	}
+	return sha256.New()
+}
+
+// checksumRecord returns the header block and content of the record holding
+// sum computed by algorithm of the file described by h
+func checksumRecord(h *Header, algorithm ChecksumAlgorithm, sum []byte) []byte {
+	return newRecord(ChecksumFileName, h, checksumNames[algorithm]+":"+hex.EncodeToString(sum))
+}
+
+// parseChecksum returns algorithm and sum recorded in content of checksum
+// record
+func parseChecksum(content []byte) (ChecksumAlgorithm, []byte, error) {
+	fields := strings.SplitN(string(content), ":", 2)
+	if len(fields) == 2 {
+		for algorithm, name := range checksumNames {
+			if fields[0] != name {
+				continue
+			}
+			sum, err := hex.DecodeString(fields[1])
+			if err == nil && len(sum) == algorithm.newHash().Size() {
+				return algorithm, sum, nil
+			}
+		}
+	}
+	return NoChecksum, nil, ErrCorruptHeader
+}
+
+// isChecksumRecord reports whether h describes a checksum record
+func isChecksumRecord(h *Header) bool {
+	return h.longName == "" && h.GetName() == ChecksumFileName && h.GetPrefix() == "."
+}
+
+// checksums computes checksums of the content read by cursor. Until the
+// first record is seen checksums of all algorithms are computed, after that
+// only the one of the last record or none when a file had no record.
+type checksums struct {
+	algorithm ChecksumAlgorithm
+	known     bool
+	hashes    map[ChecksumAlgorithm]hash.Hash
+}
+
+// reset starts computing checksums of the next file
+func (s *checksums) reset() {
+	s.hashes = nil
+	if s.known && s.algorithm == NoChecksum {
+		return
+	}
+
+	s.hashes = make(map[ChecksumAlgorithm]hash.Hash)
+	for algorithm := range checksumNames {
+		if !s.known || algorithm == s.algorithm {
+			s.hashes[algorithm] = algorithm.newHash()
+		}
+	}
+}
+
+// write adds b to the checksums of the current file
+func (s *checksums) write(b []byte) {
+	for _, h := range s.hashes {
+		h.Write(b)
+	}
+}
+
+// verify compares checksum of the current file with content of its record,
+// files whose checksum wasn't computed are accepted
+func (s *checksums) verify(name string, content []byte) error {
+	algorithm, sum, err := parseChecksum(content)
+	if err != nil {
+		return &os.PathError{Op: "read", Path: name, Err: err}
+	}
+	s.algorithm, s.known = algorithm, true
+
+	h, ok := s.hashes[algorithm]
+	s.hashes = nil
+	if ok && !bytes.Equal(h.Sum(nil), sum) {
+		return &os.PathError{Op: "read", Path: name, Err: ErrChecksumMismatch}
+	}
+	return nil
+}
+
+// unrecorded notes a file had no checksum record, so computing checksums
+// stops until a record appears
+func (s *checksums) unrecorded() {
+	s.algorithm, s.known = NoChecksum, true
+	s.hashes = nil
+}
+
+// maxChecksumRecord is the maximum size of checksum record content
+const maxChecksumRecord = 128
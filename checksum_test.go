@@ -0,0 +1,94 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestChecksum tests verifying checksums of extracted files
+func TestChecksum(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, algorithm := range []ChecksumAlgorithm{CRC32Checksum, SHA256Checksum} {
+		filename := _newArchiveWith(t, dir, func(w *Writer) { w.Checksum = algorithm },
+			"database.sql", "database.sql",
+			"wp-content/uploads/logo.png", "wp-content/uploads/logo.png")
+
+		// records are not listed
+		expected := []string{"database.sql", "wp-content/uploads/logo.png"}
+		paths := _listPaths(t, filename)
+		if !reflect.DeepEqual(paths, expected) {
+			t.Errorf("Listed %v instead of %v", paths, expected)
+		}
+
+		for _, workers := range []int{1, 4} {
+			r, err := NewReader(filename)
+			if err != nil {
+				t.Fatalf("Failed to create a new Reader instance: %s", err)
+			}
+			filesCount, err := r.ExtractWithOptions(ExtractOptions{DestDir: filepath.Join(dir, "restored"), Workers: workers})
+			r.Close()
+			if err != nil || filesCount != 2 {
+				t.Errorf("Extracted %d files instead of 2: %v", filesCount, err)
+			}
+		}
+
+		// flip a byte of the second file
+		archive, _ := ioutil.ReadFile(filename)
+		i := bytes.LastIndex(archive, []byte("wp-content/uploads/logo.png"))
+		if i == -1 {
+			t.Fatalf("Content not found in archive")
+		}
+		archive[i] = 'W'
+		ioutil.WriteFile(filename, archive, 0644)
+
+		for _, workers := range []int{1, 4} {
+			r, _ := NewReader(filename)
+			_, err := r.ExtractWithOptions(ExtractOptions{DestDir: filepath.Join(dir, "corrupt"), Workers: workers})
+			if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrChecksumMismatch {
+				t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+			}
+			r.Close()
+		}
+
+		r, _ := NewReader(filename)
+		content, err := r.ExtractFile("database.sql", ".")
+		if err != nil || string(content) != "database.sql" {
+			t.Errorf("Unexpected content `%s`: %v", content, err)
+		}
+		_, err = r.ExtractFile("logo.png", "wp-content/uploads")
+		if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrChecksumMismatch {
+			t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+		}
+		r.Close()
+	}
+}
@@ -26,10 +26,17 @@ package wpress
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
+	"math"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 )
 
 const (
@@ -40,17 +47,69 @@ const (
 	prefixSize   = 4096 // maximum number of bytes allowed  for prefix
 )
 
+// defaultBufferSize is the size of the buffer used to copy file contents
+const defaultBufferSize = 1 << 20
+
+// eofBlock is the byte sequence describing EOF, it must not be modified
+var eofBlock = bytes.Repeat([]byte("\x00"), headerSize)
+
+// bufferPool holds buffers of defaultBufferSize, so copying file contents
+// doesn't allocate a new buffer for every file
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, defaultBufferSize)
+		return &buf
+	},
+}
+
+// getBuffer returns a buffer of size bytes, buffers of defaultBufferSize are
+// taken from the pool and should be returned by putBuffer
+func getBuffer(size int) []byte {
+	if size != defaultBufferSize {
+		return make([]byte, size)
+	}
+	return *bufferPool.Get().(*[]byte)
+}
+
+// putBuffer returns the buffer to the pool
+func putBuffer(buf []byte) {
+	if len(buf) == defaultBufferSize {
+		bufferPool.Put(&buf)
+	}
+}
+
+// ErrUnsafeName is returned when filename is empty or filename or prefix
+// contain zero bytes or control characters
+var ErrUnsafeName = errors.New("filename contains unsafe characters")
+
 // Header block format of a file
 // Field Name    Offset    Length    Contents
 // Name               0       255    filename (no path, no slash)
-// Size             255        14    length of file contents
+// Size             255        14    length of file contents, see formatSize
 // Mtime            269        12    last modification date
 // Prefix           281      4096    path name, no trailing slashes
+// Longer paths are stored in a record preceding the header, see
+// LongNameFileName, so are compression of the content, attributes,
+// metadata and nanoseconds of the modification date of the file, see
+// CompressionFileName, AttributesFileName, MetadataFileName and
+// MtimeFileName.
 type Header struct {
 	Name   []byte
 	Size   []byte
 	Mtime  []byte
 	Prefix []byte
+
+	// full path of the file when it doesn't fit Name and Prefix
+	longName string
+	// compression of the content and its length once decompressed
+	compression  Compression
+	originalSize int64
+	// permissions, ownership and link target when they were recorded
+	attrs *Attributes
+	// key/value metadata of the file
+	metadata map[string]string
+	// nanoseconds of the last modification date, Mtime holds the seconds
+	mtimeNsec int
 }
 
 // PopulateFromBytes populates header struct from bytes array
@@ -85,16 +144,11 @@ func (h *Header) PopulateFromFilename(filename string) error {
 	// copy filename to the buffer leaving available space as zero-bytes
 	copy(h.Name, fi.Name())
 
-	// get filesize as string
-	size := strconv.FormatInt(fi.Size(), 10)
-	// validate if filesize fits the allowed length
-	if len(size) > contentSize {
-		return errors.New("file size is larger than max allowed")
+	// encode filesize to the size buffer
+	h.Size, err = formatSize(fi.Size())
+	if err != nil {
+		return err
 	}
-	// create size buffer
-	h.Size = make([]byte, contentSize)
-	// copy content size length to the buffer
-	copy(h.Size, size)
 
 	// get last modified date as string
 	unixTime := strconv.FormatInt(fi.ModTime().Unix(), 10)
@@ -126,6 +180,28 @@ func (h *Header) PopulateFromFilename(filename string) error {
 	return nil
 }
 
+// NewHeader creates header of a file stored in archive as name, prefix is
+// the directory part of name and "." when there is none. Paths whose parts
+// don't fit the header are written with a long name record.
+func NewHeader(name string, size int64, modTime time.Time) (*Header, error) {
+	name = path.Clean(filepath.ToSlash(name))
+
+	// validate if the values fit the allowed lengths
+	h := &Header{}
+	err := h.setPath(path.Base(name), path.Dir(name))
+	if err == nil {
+		err = h.SetSize(size)
+	}
+	if err == nil {
+		err = h.SetMtime(modTime)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
 // GetHeaderBlock returns byte sequence of header block populated with data
 func (h Header) GetHeaderBlock() []byte {
 	block := append(h.Name, h.Size...)
@@ -134,10 +210,144 @@ func (h Header) GetHeaderBlock() []byte {
 	return block
 }
 
-// GetSize returns content size
+// GetSize returns content size, strconv.ErrRange is returned when it
+// doesn't fit int, see GetSize64
 func (h Header) GetSize() (int, error) {
+	size, err := h.GetSize64()
+	if err != nil {
+		return 0, err
+	}
+	if int64(int(size)) != size {
+		return 0, strconv.ErrRange
+	}
+	return int(size), nil
+}
+
+// GetSize64 returns content size
+func (h Header) GetSize64() (int64, error) {
+	// sizes too large for decimal digits are stored in binary
+	if len(h.Size) == contentSize && h.Size[0]&binarySizeFlag != 0 {
+		return parseBinarySize(h.Size)
+	}
+
 	// remove any trailing zero bytes, convert to string, then convert to integer
-	return strconv.Atoi(string(bytes.Trim(h.Size, "\x00")))
+	return strconv.ParseInt(string(bytes.Trim(h.Size, "\x00")), 10, 64)
+}
+
+// binarySizeFlag marks size field holding the size in binary
+const binarySizeFlag = 0x80
+
+// formatSize returns size field holding size as decimal digits padded by
+// zero bytes, sizes of more digits than the field holds are stored as
+// binarySizeFlag followed by zero bytes and the size as big-endian uint64
+// in the last 8 bytes
+func formatSize(size int64) ([]byte, error) {
+	if size < 0 {
+		return nil, errors.New("file size is negative")
+	}
+
+	field := make([]byte, contentSize)
+	digits := strconv.FormatInt(size, 10)
+	if len(digits) <= contentSize {
+		copy(field, digits)
+		return field, nil
+	}
+
+	field[0] = binarySizeFlag
+	binary.BigEndian.PutUint64(field[contentSize-8:], uint64(size))
+	return field, nil
+}
+
+// parseBinarySize returns size stored in binary in field
+func parseBinarySize(field []byte) (int64, error) {
+	// the bytes between the flag and the size must be zero
+	if field[0] != binarySizeFlag || bytes.Count(field[1:contentSize-8], []byte{0}) != contentSize-9 {
+		return 0, ErrCorruptHeader
+	}
+
+	size := binary.BigEndian.Uint64(field[contentSize-8:])
+	if size > math.MaxInt64 {
+		return 0, ErrCorruptHeader
+	}
+	return int64(size), nil
+}
+
+// GetCompression returns compression of the content, Size is the length of
+// the compressed content
+func (h Header) GetCompression() Compression {
+	return h.compression
+}
+
+// GetOriginalSize returns length of the content once decompressed, it is
+// the content size for files stored uncompressed
+func (h Header) GetOriginalSize() (int64, error) {
+	if h.compression != NoCompression {
+		return h.originalSize, nil
+	}
+	return h.GetSize64()
+}
+
+// GetMtime returns last modified date as unix timestamp
+func (h Header) GetMtime() (int64, error) {
+	// remove any trailing zero bytes, convert to string, then convert to integer
+	return strconv.ParseInt(string(bytes.Trim(h.Mtime, "\x00")), 10, 64)
+}
+
+// GetName returns filename without trailing zero bytes, or the full
+// filename when it was stored in a long name record
+func (h Header) GetName() string {
+	if h.longName != "" {
+		return path.Base(h.longName)
+	}
+	return string(bytes.Trim(h.Name, "\x00"))
+}
+
+// GetPrefix returns path name without trailing zero bytes, or the full path
+// name when it was stored in a long name record
+func (h Header) GetPrefix() string {
+	if h.longName != "" {
+		return path.Dir(h.longName)
+	}
+	return string(bytes.Trim(h.Prefix, "\x00"))
+}
+
+// GetPath returns cleaned relative path of the file, prefix and name joined
+func (h Header) GetPath() string {
+	return path.Clean("." + string(os.PathSeparator) + h.GetPrefix() + string(os.PathSeparator) + h.GetName())
+}
+
+// ValidateName returns ErrUnsafeName if filename is empty or a dot entry, or
+// if filename or prefix contain zero bytes or control characters
+func (h Header) ValidateName() error {
+	name := h.GetName()
+	if name == "" || name == "." || name == ".." {
+		return ErrUnsafeName
+	}
+	if strings.IndexFunc(name+h.GetPrefix(), unicode.IsControl) != -1 {
+		return ErrUnsafeName
+	}
+	return nil
+}
+
+// GetSanitizedPath returns path of the file like GetPath, control characters
+// are replaced by underscores and empty or dot filename by "_"
+func (h Header) GetSanitizedPath() string {
+	name := sanitizeName(h.GetName())
+	if name == "" || name == "." || name == ".." {
+		name = "_"
+	}
+	return path.Clean("." + string(os.PathSeparator) + sanitizeName(h.GetPrefix()) + string(os.PathSeparator) + name)
+}
+
+// sanitizeName replaces control characters, including zero bytes, in name
+// with underscores
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, name)
 }
 
 // GetEOFBlock returns byte sequence describing EOF
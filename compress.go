@@ -0,0 +1,193 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math"
+	"os"
+)
+
+// ErrUnsupportedCompression is returned when the compression is not
+// available in this build
+var ErrUnsupportedCompression = errors.New("compression is not supported by this build")
+
+// Compression tells how the whole archive is compressed, the archive
+// inside is unchanged
+type Compression int
+
+const (
+	// NoCompression writes plain archives, it is the default
+	NoCompression Compression = iota
+	// GzipCompression writes archives readable by gzip, e.g.
+	// "site.wpress.gz"
+	GzipCompression
+	// ZstdCompression writes archives readable by zstd, e.g.
+	// "site.wpress.zst", it compresses better and faster than gzip. It
+	// requires building with the zstd tag, which adds the dependency on
+	// github.com/klauspost/compress.
+	ZstdCompression
+)
+
+// compressionMagic maps compressions to the bytes their streams start with
+var compressionMagic = map[Compression][]byte{
+	GzipCompression: {0x1f, 0x8b},
+	ZstdCompression: {0x28, 0xb5, 0x2f, 0xfd},
+}
+
+// codec creates compressing writers and decompressing readers, level zero
+// means the default level of the codec
+type codec struct {
+	newWriter func(dest io.Writer, level int) (io.WriteCloser, error)
+	newReader func(src io.Reader) (io.ReadCloser, error)
+}
+
+// codecs holds the codecs available in this build
+var codecs = map[Compression]codec{
+	GzipCompression: {
+		newWriter: func(dest io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			return gzip.NewWriterLevel(dest, level)
+		},
+		newReader: func(src io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(src)
+		},
+	},
+}
+
+// startCompression wraps the destination in the compressor before anything
+// is written
+func (w *Writer) startCompression() error {
+	if w.Compression == NoCompression || w.compressor != nil {
+		return nil
+	}
+
+	c, ok := codecs[w.Compression]
+	if !ok {
+		return ErrUnsupportedCompression
+	}
+	zw, err := c.newWriter(w.dest, w.CompressionLevel)
+	if err != nil {
+		return err
+	}
+	w.compressor, w.dest = zw, zw
+	return nil
+}
+
+// archiveSource is the file archive is read from or its decrypted content
+type archiveSource interface {
+	io.Reader
+	io.ReaderAt
+}
+
+// decompressedSource reads a compressed archive, it is rewound by starting
+// the decompression over at the beginning of the file
+type decompressedSource struct {
+	file  archiveSource
+	codec codec
+	zr    io.ReadCloser
+}
+
+// open starts decompressing the file from its beginning
+func (s *decompressedSource) open() error {
+	zr, err := s.codec.newReader(bufio.NewReaderSize(io.NewSectionReader(s.file, 0, math.MaxInt64), defaultBufferSize))
+	if err != nil {
+		return err
+	}
+	s.zr = zr
+	return nil
+}
+
+// Read reads the decompressed archive
+func (s *decompressedSource) Read(b []byte) (int, error) {
+	return s.zr.Read(b)
+}
+
+// rewind puts the pointer at the beginning of the decompressed archive
+func (s *decompressedSource) rewind() error {
+	s.zr.Close()
+	return s.open()
+}
+
+// Close releases the decompressor
+func (s *decompressedSource) Close() error {
+	return s.zr.Close()
+}
+
+// decompress returns reader of the archive read from file name,
+// decompressing it when it starts with magic bytes of a compression, the
+// returned closer releases the decompressor and is nil for plain archives
+func decompress(file archiveSource, name string) (io.Reader, io.Closer, error) {
+	magic := make([]byte, 4)
+	n, _ := file.ReadAt(magic, 0)
+	for compression, prefix := range compressionMagic {
+		if !bytes.HasPrefix(magic[:n], prefix) {
+			continue
+		}
+
+		c, ok := codecs[compression]
+		if !ok {
+			return nil, nil, &os.PathError{Op: "read", Path: name, Err: ErrUnsupportedCompression}
+		}
+		s := &decompressedSource{file: file, codec: c}
+		err := s.open()
+		if err != nil {
+			return nil, nil, &os.PathError{Op: "read", Path: name, Err: err}
+		}
+		return s, s, nil
+	}
+
+	return file, nil, nil
+}
+
+// decompressStream is decompress for sources which can only be read
+// sequentially, the magic bytes are peeked from the buffered source
+func decompressStream(src io.Reader, name string) (io.Reader, io.Closer, error) {
+	br := bufio.NewReaderSize(src, defaultBufferSize)
+	magic, _ := br.Peek(4)
+	for compression, prefix := range compressionMagic {
+		if !bytes.HasPrefix(magic, prefix) {
+			continue
+		}
+
+		c, ok := codecs[compression]
+		if !ok {
+			return nil, nil, &os.PathError{Op: "read", Path: name, Err: ErrUnsupportedCompression}
+		}
+		zr, err := c.newReader(br)
+		if err != nil {
+			return nil, nil, &os.PathError{Op: "read", Path: name, Err: err}
+		}
+		return zr, zr, nil
+	}
+
+	return br, nil, nil
+}
@@ -0,0 +1,134 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestGzipCompression tests writing and reading gzip compressed archives
+func TestGzipCompression(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := filepath.Join(tempPath, "output.wpress.gz")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	w.Compression = GzipCompression
+	w.CompressionLevel = gzip.BestCompression
+	err = w.AddDirectoryWithOptions(_getPathToTests(t), AddOptions{})
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("Failed to close archive: %s", err)
+	}
+
+	// gzip decompresses it to a plain archive
+	file, _ := os.Open(filename)
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Archive is not gzip compressed: %s", err)
+	}
+	archive, err := ioutil.ReadAll(zr)
+	file.Close()
+	if err != nil {
+		t.Fatalf("Unable to decompress archive: %s", err)
+	}
+	plain := NewReaderAt(bytes.NewReader(archive), int64(len(archive)))
+	entries, err := plain.ListEntries()
+	if err != nil || len(entries) != w.FilesAdded {
+		t.Errorf("Listed %d files instead of %d: %v", len(entries), w.FilesAdded, err)
+	}
+
+	// compressed archive is detected and read sequentially
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	dest := filepath.Join(tempPath, "restored")
+	filesCount, err := r.ExtractTo(dest)
+	if err != nil || filesCount != w.FilesAdded {
+		t.Errorf("Extracted %d files instead of %d: %v", filesCount, w.FilesAdded, err)
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(dest, "lipsum.txt"))
+	expected, _ := ioutil.ReadFile(filepath.Join(_getPathToTests(t), "lipsum.txt"))
+	if !bytes.Equal(content, expected) {
+		t.Errorf("Unexpected content `%s`", content)
+	}
+
+	// reading starts over for every operation
+	r, err = NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	filesCount, err = r.GetFilesCount()
+	if err != nil || filesCount != w.FilesAdded {
+		t.Errorf("Counted %d files instead of %d: %v", filesCount, w.FilesAdded, err)
+	}
+	entries, err = r.ListEntries()
+	if err != nil || len(entries) != w.FilesAdded {
+		t.Errorf("Listed %d files instead of %d: %v", len(entries), w.FilesAdded, err)
+	}
+	content, err = r.ExtractFile("lipsum.txt", ".")
+	if err != nil || !bytes.Equal(content, expected) {
+		t.Errorf("Unexpected content `%s`: %v", content, err)
+	}
+}
+
+// TestUnsupportedCompression tests compressions missing from the build
+func TestUnsupportedCompression(t *testing.T) {
+	if _, ok := codecs[ZstdCompression]; ok {
+		t.Skip("zstd is supported by this build")
+	}
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	w := NewWriterTo(ioutil.Discard)
+	w.Compression = ZstdCompression
+	h, _ := NewHeader("database.sql", 0, time.Now())
+	err := w.Add(h, bytes.NewReader(nil))
+	if err != ErrUnsupportedCompression {
+		t.Errorf("Expected ErrUnsupportedCompression, got %v", err)
+	}
+
+	filename := filepath.Join(tempPath, "output.wpress.zst")
+	ioutil.WriteFile(filename, []byte{0x28, 0xb5, 0x2f, 0xfd, 0}, 0644)
+	_, err = NewReader(filename)
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrUnsupportedCompression {
+		t.Errorf("Expected ErrUnsupportedCompression, got %v", err)
+	}
+}
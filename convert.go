@@ -0,0 +1,505 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// convertFunc writes the file described by h with content read from
+// content to the converted archive
+type convertFunc func(h *Header, content io.Reader) error
+
+// convert passes every file of the archive with its decompressed content
+// to fn and returns the number of files passed
+func (r *Reader) convert(fn convertFunc) (int, error) {
+	// get a cursor at the beginning of the file
+	c, err := r.scan()
+	if err != nil {
+		return 0, err
+	}
+
+	filesCount := 0
+	for {
+		h, err := c.next()
+		if err == io.EOF {
+			return filesCount, nil
+		}
+		if err != nil {
+			return filesCount, err
+		}
+
+		// paths other tools would write outside of their destination
+		name := h.GetPath()
+		if err = h.ValidateName(); err != nil {
+			return filesCount, &os.PathError{Op: "convert", Path: name, Err: err}
+		}
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return filesCount, &os.PathError{Op: "convert", Path: name, Err: ErrUnsafePath}
+		}
+
+		size, err := h.GetOriginalSize()
+		if err != nil {
+			return filesCount, err
+		}
+		content, err := openEntry(name, h.GetCompression(), size, c)
+		if err != nil {
+			return filesCount, err
+		}
+		err = fn(h, io.LimitReader(content, size))
+		content.Close()
+		if err != nil {
+			return filesCount, err
+		}
+		filesCount++
+	}
+}
+
+// convertedMode returns permissions and type of the file described by h,
+// those recorded in attributes record or 0644 for regular files
+func convertedMode(h *Header) fs.FileMode {
+	attrs, ok := h.GetAttributes()
+	if !ok {
+		return 0644
+	}
+	if attrs.Link != "" {
+		return attrs.Mode | fs.ModeSymlink
+	}
+	return attrs.Mode
+}
+
+// convertedModTime returns last modified date of the file described by h,
+// the zero time when it can't be parsed
+func convertedModTime(h *Header) time.Time {
+	modTime, err := h.GetModTime()
+	if err != nil {
+		return time.Time{}
+	}
+	return modTime
+}
+
+// WriteZip writes all files of the archive to dest as zip file, one zip
+// entry per file with its path and last modified date, so backups can be
+// opened by desktop tools. Compressed files are stored as they are, others
+// are deflated. Symbolic links stored by StoreSymlinks are written as zip
+// symbolic links. Number of files written is returned.
+func (r *Reader) WriteZip(dest io.Writer) (int, error) {
+	zw := zip.NewWriter(dest)
+	filesCount, err := r.convert(func(h *Header, content io.Reader) error {
+		fh := &zip.FileHeader{Name: h.GetPath(), Method: zip.Deflate, Modified: convertedModTime(h)}
+		fh.SetMode(convertedMode(h))
+		size, _ := h.GetOriginalSize()
+		if !compressible(fh.Name, size) {
+			fh.Method = zip.Store
+		}
+
+		fw, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+
+		// content of a link is its target
+		if attrs, ok := h.GetAttributes(); ok && attrs.Link != "" {
+			_, err = io.WriteString(fw, attrs.Link)
+			return err
+		}
+		_, err = io.Copy(fw, content)
+		return err
+	})
+	if err != nil {
+		return filesCount, err
+	}
+
+	return filesCount, zw.Close()
+}
+
+// ConvertToZip writes all files of archive filename to zip file dest, see
+// Reader.WriteZip. Dest appears only when the conversion succeeded. Number
+// of files written is returned.
+func ConvertToZip(filename string, dest string) (int, error) {
+	return convertFile(filename, dest, (*Reader).WriteZip)
+}
+
+// WriteTar writes all files of the archive to dest as tar file compressed
+// by compression, e.g. GzipCompression for "site.tar.gz", with their paths
+// and last modified dates. Permissions, ownership and symbolic links are
+// written when they were recorded, see Writer.Attributes. Number of files
+// written is returned.
+func (r *Reader) WriteTar(dest io.Writer, compression Compression) (int, error) {
+	// compress the tar stream unless it is plain
+	var zw io.WriteCloser
+	if compression != NoCompression {
+		c, ok := codecs[compression]
+		if !ok {
+			return 0, ErrUnsupportedCompression
+		}
+		var err error
+		zw, err = c.newWriter(dest, 0)
+		if err != nil {
+			return 0, err
+		}
+		dest = zw
+	}
+
+	tw := tar.NewWriter(dest)
+	filesCount, err := r.convert(func(h *Header, content io.Reader) error {
+		th := tarHeader(h, h.GetPath())
+		err := tw.WriteHeader(th)
+		if err == nil && th.Typeflag == tar.TypeReg {
+			_, err = io.Copy(tw, content)
+		}
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if zw != nil && err == nil {
+		err = zw.Close()
+	}
+
+	return filesCount, err
+}
+
+// tarHeader returns tar header of the file described by h stored in tar
+// file as name
+func tarHeader(h *Header, name string) *tar.Header {
+	size, _ := h.GetOriginalSize()
+	th := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     int64(convertedMode(h).Perm()),
+		ModTime:  convertedModTime(h),
+	}
+	if attrs, ok := h.GetAttributes(); ok {
+		// ownership is unknown when negative
+		if attrs.UID >= 0 && attrs.GID >= 0 {
+			th.Uid, th.Gid = attrs.UID, attrs.GID
+		}
+		if attrs.Link != "" {
+			th.Typeflag, th.Linkname, th.Size = tar.TypeSymlink, attrs.Link, 0
+		}
+	}
+	// other formats round the date to seconds
+	if th.ModTime.Nanosecond() != 0 {
+		th.Format = tar.FormatPAX
+	}
+	return th
+}
+
+// ConvertToTar writes all files of archive filename to tar file dest
+// compressed by compression, see Reader.WriteTar. Dest appears only when
+// the conversion succeeded. Number of files written is returned.
+func ConvertToTar(filename string, dest string, compression Compression) (int, error) {
+	return convertFile(filename, dest, func(r *Reader, dest io.Writer) (int, error) {
+		return r.WriteTar(dest, compression)
+	})
+}
+
+// convertFile writes archive filename converted by write to dest through a
+// temporary file, which is renamed to dest once complete
+func convertFile(filename string, dest string, write func(r *Reader, dest io.Writer) (int, error)) (int, error) {
+	r, err := NewReader(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	// write to a temporary file next to the destination, so it can be
+	// renamed over it
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), "."+strings.TrimPrefix(filepath.Base(dest), ".")+"-")
+	if err != nil {
+		return 0, err
+	}
+	// temporary files are readable only by the owner
+	err = tmp.Chmod(0644)
+	filesCount := 0
+	if err == nil {
+		filesCount, err = write(r, tmp)
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+
+	return filesCount, nil
+}
+
+// convertedName returns name of the file stored in other archive as name
+// cleaned, ErrUnsafePath is returned when it points outside of the root
+func convertedName(name string) (string, error) {
+	cleaned := path.Clean(filepath.ToSlash(name))
+	if !filepath.IsLocal(filepath.FromSlash(cleaned)) {
+		return "", &os.PathError{Op: "convert", Path: name, Err: ErrUnsafePath}
+	}
+	return cleaned, nil
+}
+
+// AddZip adds all files of zip file zr with their paths and last modified
+// dates, e.g. to import a site backed up by other tools. Directories are
+// skipped, symbolic links are stored like by StoreSymlinks. Permissions
+// are recorded when Attributes is set. Number of files added is returned.
+func (w *Writer) AddZip(zr *zip.Reader) (int, error) {
+	added := 0
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name, err := convertedName(f.Name)
+		if err != nil {
+			return added, err
+		}
+
+		err = w.addZipFile(f, name)
+		if err != nil {
+			return added, err
+		}
+		added++
+	}
+
+	return added, nil
+}
+
+// addZipFile adds file f of zip file stored as name
+func (w *Writer) addZipFile(f *zip.File, name string) error {
+	content, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	// content of a link is its target
+	mode := f.Mode()
+	if mode&fs.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(io.LimitReader(content, longNameSize+1))
+		if err != nil {
+			return err
+		}
+		return w.addConvertedLink(name, f.Modified, &Attributes{Mode: mode.Perm(), UID: -1, GID: -1, Link: string(target)})
+	}
+
+	h, err := NewHeader(name, int64(f.UncompressedSize64), f.Modified)
+	if err != nil {
+		return err
+	}
+	if w.Attributes {
+		h.attrs = &Attributes{Mode: mode.Perm(), UID: -1, GID: -1}
+	}
+	return w.writeEntry(h, content)
+}
+
+// addConvertedLink adds symbolic link stored as name with target and
+// permissions in attrs, like StoreSymlinks does
+func (w *Writer) addConvertedLink(name string, modTime time.Time, attrs *Attributes) error {
+	if len(attrs.Link) > longNameSize {
+		return &os.PathError{Op: "convert", Path: name, Err: ErrLongNameTooLong}
+	}
+	h, err := NewHeader(name, 0, modTime)
+	if err != nil {
+		return err
+	}
+	h.attrs = attrs
+	return w.writeEntry(h, strings.NewReader(""))
+}
+
+// ConvertFromZip writes all files of zip file src to a new archive dest,
+// see Writer.AddZip. Dest appears only when the conversion succeeded.
+// Number of files written is returned.
+func ConvertFromZip(src string, dest string) (int, error) {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+
+	w, err := NewAtomicWriter(dest)
+	if err != nil {
+		return 0, err
+	}
+	added, err := w.AddZip(&zr.Reader)
+	if err != nil {
+		w.discard()
+		return 0, err
+	}
+
+	return added, w.Close()
+}
+
+// AddTar adds all files of tar stream tr with their paths and last
+// modified dates, e.g. to import a backup made by a hosting panel.
+// Directories and special files are skipped, symbolic links are stored
+// like by StoreSymlinks and hard links are listed in LinksFileName.
+// Permissions and ownership are recorded when Attributes is set. Number of
+// files added is returned.
+func (w *Writer) AddTar(tr *tar.Reader) (int, error) {
+	added := 0
+	for {
+		th, err := tr.Next()
+		if err == io.EOF {
+			return added, nil
+		}
+		if err != nil {
+			return added, err
+		}
+
+		switch th.Typeflag {
+		case tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+		default:
+			continue
+		}
+		name, err := convertedName(th.Name)
+		if err != nil {
+			return added, err
+		}
+
+		attrs := &Attributes{Mode: fs.FileMode(th.Mode).Perm(), UID: th.Uid, GID: th.Gid}
+		switch th.Typeflag {
+		case tar.TypeSymlink:
+			attrs.Link = th.Linkname
+			err = w.addConvertedLink(name, th.ModTime, attrs)
+		case tar.TypeLink:
+			// the content was stored under the name of the link target
+			var source string
+			source, err = convertedName(th.Linkname)
+			if err == nil {
+				w.links = append(w.links, hardLink{Name: name, Source: source, Mtime: th.ModTime.Unix()})
+			}
+		default:
+			var h *Header
+			h, err = NewHeader(name, th.Size, th.ModTime)
+			if err == nil {
+				if w.Attributes {
+					h.attrs = attrs
+				}
+				err = w.writeEntry(h, tr)
+			}
+		}
+		if err != nil {
+			return added, err
+		}
+		added++
+	}
+}
+
+// ConvertFromTar writes all files of tar file src, compressed or not, to a
+// new archive dest, see Writer.AddTar. Compression is detected by magic
+// bytes. Dest appears only when the conversion succeeded. Number of files
+// written is returned.
+func ConvertFromTar(src string, dest string) (int, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	w, err := NewAtomicWriter(dest)
+	if err != nil {
+		return 0, err
+	}
+	added, err := w.addTarStream(file, file.Name())
+	if err != nil {
+		w.discard()
+		return 0, err
+	}
+
+	return added, w.Close()
+}
+
+// addTarStream adds all files of tar stream src named name, compressed or
+// not, see AddTar
+func (w *Writer) addTarStream(src io.Reader, name string) (int, error) {
+	// tar files compressed as a whole are read through the decompressor
+	source, decompressor, err := decompressStream(src, name)
+	if err != nil {
+		return 0, err
+	}
+	if decompressor != nil {
+		defer decompressor.Close()
+	}
+
+	return w.AddTar(tar.NewReader(source))
+}
+
+// ConvertFromTarStream writes all files of tar stream src, compressed or not,
+// as archive to dest in a single pass, without temporary files, e.g. from
+// a pipe. Number of files written is returned.
+func ConvertFromTarStream(src io.Reader, dest io.Writer) (int, error) {
+	w := NewWriterTo(dest)
+	added, err := w.addTarStream(src, "-")
+	if err != nil {
+		return added, err
+	}
+	return added, w.Close()
+}
+
+// ConvertToTarStream writes all files of archive read from src, compressed
+// as a whole or not, to dest as tar file compressed by compression in a
+// single pass, without temporary files, see Reader.WriteTar. Number of
+// files written is returned.
+func ConvertToTarStream(src io.Reader, dest io.Writer, compression Compression) (int, error) {
+	return convertStream(src, func(r *Reader) (int, error) {
+		return r.WriteTar(dest, compression)
+	})
+}
+
+// ConvertToZipStream writes all files of archive read from src, compressed
+// as a whole or not, to dest as zip file in a single pass, without
+// temporary files, see Reader.WriteZip. Zip files can't be converted the
+// other way as a stream, their directory is at the end. Number of files
+// written is returned.
+func ConvertToZipStream(src io.Reader, dest io.Writer) (int, error) {
+	return convertStream(src, func(r *Reader) (int, error) {
+		return r.WriteZip(dest)
+	})
+}
+
+// convertStream passes reader of archive read sequentially from src to
+// write
+func convertStream(src io.Reader, write func(r *Reader) (int, error)) (int, error) {
+	source, decompressor, err := decompressStream(src, "-")
+	if err != nil {
+		return 0, err
+	}
+	if decompressor != nil {
+		defer decompressor.Close()
+	}
+	return write(NewReaderFrom(source))
+}
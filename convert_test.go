@@ -0,0 +1,357 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestConvertToZip tests converting archive to zip file
+func TestConvertToZip(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	w.EntryCompression = GzipCompression
+	w.PreciseMtime = true
+	err = w.AddDirectoryWithOptions(_getPathToTests(t), AddOptions{})
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	w.Close()
+
+	dest := filepath.Join(tempPath, "output.zip")
+	n, err := ConvertToZip(filename, dest)
+	if err != nil || n != w.FilesAdded {
+		t.Fatalf("Converted %d files instead of %d: %v", n, w.FilesAdded, err)
+	}
+
+	zr, err := zip.OpenReader(dest)
+	if err != nil {
+		t.Fatalf("Failed to open zip file: %s", err)
+	}
+	defer zr.Close()
+	if len(zr.File) != n {
+		t.Errorf("Zip file has %d entries instead of %d", len(zr.File), n)
+	}
+	for _, f := range zr.File {
+		fi, err := os.Stat(filepath.Join(_getPathToTests(t), filepath.FromSlash(f.Name)))
+		if err != nil {
+			t.Errorf("Unexpected entry %s", f.Name)
+			continue
+		}
+		if f.UncompressedSize64 != uint64(fi.Size()) || !f.Modified.Equal(fi.ModTime().Truncate(time.Second)) {
+			t.Errorf("Entry %s has %d bytes modified at %s", f.Name, f.UncompressedSize64, f.Modified)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Errorf("Failed to open %s: %s", f.Name, err)
+			continue
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		expected, _ := ioutil.ReadFile(filepath.Join(_getPathToTests(t), filepath.FromSlash(f.Name)))
+		if err != nil || string(content) != string(expected) {
+			t.Errorf("Entry %s has wrong content: %v", f.Name, err)
+		}
+	}
+}
+
+// TestConvertUnsafePath tests conversion refuses paths escaping the
+// destination
+func TestConvertUnsafePath(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := _newArchive(t, tempPath, "../evil.php", "<?php")
+	dest := filepath.Join(tempPath, "output.zip")
+	_, err := ConvertToZip(filename, dest)
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Expected ErrUnsafePath, got %v", err)
+	}
+	if _, err = os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("Zip file of failed conversion was left behind")
+	}
+}
+
+// TestConvertToTar tests converting archive to compressed tar file
+func TestConvertToTar(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	root := filepath.Join(tempPath, "site")
+	os.MkdirAll(filepath.Join(root, "bin"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "bin", "cron.sh"), []byte("#!/bin/sh"), 0755)
+	modTime := time.Unix(1420382531, 123456789)
+	os.Chtimes(filepath.Join(root, "bin", "cron.sh"), modTime, modTime)
+	if fi, err := os.Stat(filepath.Join(root, "bin", "cron.sh")); err == nil {
+		// file systems may not keep nanoseconds
+		modTime = fi.ModTime()
+	}
+	err := os.Symlink("bin/cron.sh", filepath.Join(root, "cron.sh"))
+	if err != nil {
+		t.Skipf("Unable to create symbolic link: %s", err)
+	}
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	w.Attributes = true
+	w.PreciseMtime = true
+	err = w.AddDirectoryWithOptions(root, AddOptions{Symlinks: StoreSymlinks})
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	w.Close()
+
+	dest := filepath.Join(tempPath, "output.tar.gz")
+	n, err := ConvertToTar(filename, dest, GzipCompression)
+	if err != nil || n != 2 {
+		t.Fatalf("Converted %d files instead of 2: %v", n, err)
+	}
+
+	file, _ := os.Open(dest)
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Failed to decompress tar file: %s", err)
+	}
+	tr := tar.NewReader(zr)
+	headers := make(map[string]*tar.Header)
+	for {
+		th, err := tr.Next()
+		if err != nil {
+			break
+		}
+		headers[th.Name] = th
+		if th.Name == "bin/cron.sh" {
+			content, _ := ioutil.ReadAll(tr)
+			if string(content) != "#!/bin/sh" {
+				t.Errorf("Entry %s has content %q", th.Name, content)
+			}
+		}
+	}
+	script, link := headers["bin/cron.sh"], headers["cron.sh"]
+	if script == nil || script.Mode != 0755 || !script.ModTime.Equal(modTime) {
+		t.Errorf("Unexpected script entry %+v", script)
+	}
+	if link == nil || link.Typeflag != tar.TypeSymlink || link.Linkname != "bin/cron.sh" {
+		t.Errorf("Unexpected link entry %+v", link)
+	}
+}
+
+// TestConvertFromZip tests building archive from zip file
+func TestConvertFromZip(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	modTime := time.Date(2015, 1, 4, 14, 42, 11, 0, time.UTC)
+	src := filepath.Join(tempPath, "bundle.zip")
+	file, _ := os.Create(src)
+	zw := zip.NewWriter(file)
+	zw.CreateHeader(&zip.FileHeader{Name: "my-plugin/", Modified: modTime})
+	fw, _ := zw.CreateHeader(&zip.FileHeader{Name: "my-plugin/my-plugin.php", Method: zip.Deflate, Modified: modTime})
+	fw.Write([]byte("<?php"))
+	link := &zip.FileHeader{Name: "my-plugin/index.php", Modified: modTime}
+	link.SetMode(0777 | os.ModeSymlink)
+	fw, _ = zw.CreateHeader(link)
+	fw.Write([]byte("my-plugin.php"))
+	zw.Close()
+	file.Close()
+
+	dest := filepath.Join(tempPath, "output.wpress")
+	n, err := ConvertFromZip(src, dest)
+	if err != nil || n != 2 {
+		t.Fatalf("Converted %d files instead of 2: %v", n, err)
+	}
+
+	r, err := NewReader(dest)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	content, err := r.ExtractFile("my-plugin.php", "my-plugin")
+	e, statErr := r.Stat("my-plugin/my-plugin.php")
+	if err != nil || statErr != nil || string(content) != "<?php" || !e.ModTime.Equal(modTime) {
+		t.Errorf("Converted %q modified at %s: %v, %v", content, e.ModTime, err, statErr)
+	}
+	for {
+		h, err := r.Next()
+		if err != nil {
+			break
+		}
+		if attrs, ok := h.GetAttributes(); h.GetPath() == "my-plugin/index.php" && (!ok || attrs.Link != "my-plugin.php") {
+			t.Errorf("Link was converted with attributes %+v", attrs)
+		}
+	}
+
+	// converting fails on paths outside of the root
+	file, _ = os.Create(src)
+	zw = zip.NewWriter(file)
+	zw.Create("../evil.php")
+	zw.Close()
+	file.Close()
+	_, err = ConvertFromZip(src, dest+".evil")
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Expected ErrUnsafePath, got %v", err)
+	}
+	if _, err = os.Stat(dest + ".evil"); !os.IsNotExist(err) {
+		t.Errorf("Archive of failed conversion was left behind")
+	}
+}
+
+// TestConvertFromTar tests building archive from compressed tar file
+func TestConvertFromTar(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	modTime := time.Unix(1420382531, 123456789)
+	src := filepath.Join(tempPath, "backup.tar.gz")
+	file, _ := os.Create(src)
+	zw := gzip.NewWriter(file)
+	tw := tar.NewWriter(zw)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "public_html/", Mode: 0755, ModTime: modTime})
+	tw.WriteHeader(&tar.Header{Name: "public_html/wp-config.php", Mode: 0600, Size: 5, ModTime: modTime, Format: tar.FormatPAX})
+	tw.Write([]byte("<?php"))
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "public_html/wp-config-copy.php", Linkname: "public_html/wp-config.php", ModTime: modTime})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "public_html/config.php", Linkname: "wp-config.php", Mode: 0777, ModTime: modTime})
+	tw.Close()
+	zw.Close()
+	file.Close()
+
+	dest := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(dest)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	w.Attributes = true
+	w.PreciseMtime = true
+	file, _ = os.Open(src)
+	zr, _ := gzip.NewReader(file)
+	n, err := w.AddTar(tar.NewReader(zr))
+	file.Close()
+	if err != nil || n != 3 {
+		t.Fatalf("Converted %d files instead of 3: %v", n, err)
+	}
+	w.Close()
+
+	r, err := NewReader(dest)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	e, err := r.Stat("public_html/wp-config.php")
+	if err != nil || !e.ModTime.Equal(modTime) {
+		t.Errorf("Converted file modified at %s: %v", e.ModTime, err)
+	}
+	destDir := filepath.Join(tempPath, "extracted")
+	_, err = r.ExtractTo(destDir)
+	r.Close()
+	if err != nil {
+		t.Fatalf("Failed to extract archive: %s", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(destDir, "public_html", "wp-config-copy.php"))
+	if err != nil || string(content) != "<?php" {
+		t.Errorf("Hard link was extracted as %q: %v", content, err)
+	}
+	fi, err := os.Stat(filepath.Join(destDir, "public_html", "wp-config.php"))
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Extracted file has mode %v: %v", fi, err)
+	}
+	target, err := os.Readlink(filepath.Join(destDir, "public_html", "config.php"))
+	if err != nil || target != "wp-config.php" {
+		t.Errorf("Link points to %q: %v", target, err)
+	}
+
+	// compression is detected
+	n, err = ConvertFromTar(src, filepath.Join(tempPath, "converted.wpress"))
+	if err != nil || n != 3 {
+		t.Errorf("Converted %d files instead of 3: %v", n, err)
+	}
+}
+
+// TestConvertStreams tests converting archives read from pipes without
+// temporary files
+func TestConvertStreams(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	// temporary files would be created here
+	scratch := filepath.Join(tempPath, "scratch")
+	os.Mkdir(scratch, 0755)
+	t.Setenv("TMPDIR", scratch)
+
+	// compressed archive read from a pipe
+	archive := &bytes.Buffer{}
+	w := NewWriterTo(archive)
+	w.Compression = GzipCompression
+	err := w.AddDirectoryWithOptions(_getPathToTests(t), AddOptions{})
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	w.Close()
+
+	tarred := &bytes.Buffer{}
+	n, err := ConvertToTarStream(struct{ io.Reader }{archive}, tarred, GzipCompression)
+	if err != nil || n != w.FilesAdded {
+		t.Fatalf("Converted %d files instead of %d: %v", n, w.FilesAdded, err)
+	}
+
+	// and back
+	converted := &bytes.Buffer{}
+	n, err = ConvertFromTarStream(struct{ io.Reader }{tarred}, converted)
+	if err != nil || n != w.FilesAdded {
+		t.Fatalf("Converted %d files instead of %d: %v", n, w.FilesAdded, err)
+	}
+
+	zipped := &bytes.Buffer{}
+	n, err = ConvertToZipStream(struct{ io.Reader }{converted}, zipped)
+	if err != nil || n != w.FilesAdded {
+		t.Fatalf("Converted %d files instead of %d: %v", n, w.FilesAdded, err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(zipped.Bytes()), int64(zipped.Len()))
+	if err != nil || len(zr.File) != w.FilesAdded {
+		t.Errorf("Zip file has wrong entries: %v", err)
+	}
+
+	if files, _ := ioutil.ReadDir(scratch); len(files) != 0 {
+		t.Errorf("Conversion created %d temporary files", len(files))
+	}
+}
@@ -0,0 +1,426 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ErrCorruptHeader is returned when size of a file in header can't be parsed
+var ErrCorruptHeader = errors.New("corrupt header")
+
+// ErrTruncated is returned when archive ends in the middle of a file or
+// before the EOF block, results read up to that point are still usable
+var ErrTruncated = errors.New("archive is truncated")
+
+// ErrInvalidMtime is reported in lenient mode when last modification date in
+// header can't be parsed
+var ErrInvalidMtime = errors.New("invalid last modification date")
+
+// maxOffset is the size of sections used to read archives of unknown size
+const maxOffset = 1<<63 - 1
+
+// cursor reads headers and file contents sequentially from archive
+type cursor struct {
+	// source the archive is read from
+	source io.Reader
+	// current position of the pointer in archive
+	offset int64
+	// bytes left unread from the content of the current file
+	remaining int64
+	// warn receives corrupt headers which are skipped, they fail reading
+	// when nil
+	warn func(error)
+	// salvage searches for the next plausible header after a corrupt one
+	salvage bool
+	// layout of header blocks, DefaultLayout when nil
+	layout *Layout
+
+	// path of the current file and whether its checksum record is yet to
+	// be checked once its content was read
+	name      string
+	unchecked bool
+	checkErr  error
+	sums      checksums
+	// header block or error read ahead while looking for checksum record
+	pending    []byte
+	pendingErr error
+}
+
+// newCursor creates a cursor reading from the beginning of ra headers laid
+// out by layout, it doesn't share the pointer with other cursors
+func newCursor(ra io.ReaderAt, layout *Layout) *cursor {
+	return &cursor{source: io.NewSectionReader(ra, 0, maxOffset), layout: layout}
+}
+
+// newCursorAt creates a cursor reading ra from offset
+func newCursorAt(ra io.ReaderAt, offset int64, layout *Layout) *cursor {
+	return &cursor{source: io.NewSectionReader(ra, offset, maxOffset-offset), offset: offset, layout: layout}
+}
+
+// newEntryCursor creates a cursor reading content of size bytes of the file
+// name starting at offset of ra
+func newEntryCursor(ra io.ReaderAt, offset int64, size int64, name string, layout *Layout) *cursor {
+	c := newCursorAt(ra, offset, layout)
+	c.remaining, c.name, c.unchecked = size, name, true
+	c.sums.reset()
+	return c
+}
+
+// next skips unread content of the current file, reads the next header and
+// returns it, io.EOF is returned when EOF block is reached
+func (c *cursor) next() (*Header, error) {
+	// checksum of a file whose content wasn't read whole is not verified
+	c.unchecked, c.checkErr = false, nil
+
+	// skip unread content of the current file
+	if c.remaining > 0 {
+		err := c.skip(c.remaining)
+		if err != nil {
+			return nil, err
+		}
+		c.remaining = 0
+	}
+
+	// read header block
+	block, err := c.headerBlock()
+	longName := ""
+	compression, originalSize := NoCompression, int64(0)
+	var attrs *Attributes
+	var metadata map[string]string
+	mtimeNsec := 0
+	for {
+		if err != nil {
+			return nil, err
+		}
+
+		// check if block equals EOF sequence
+		if bytes.Equal(block, c.layout.get().eofBlock()) {
+			return nil, io.EOF
+		}
+
+		// populate header from our block bytes
+		h := c.layout.get().populate(block)
+
+		size, err := h.GetSize64()
+		if err == nil && (size < 0 || isLongNameRecord(h) && size > longNameSize ||
+			isCompressionRecord(h) && size > maxCompressionRecord ||
+			isAttributesRecord(h) && size > maxAttributesRecord ||
+			isMetadataRecord(h) && size > maxMetadataRecord ||
+			isInfoRecord(h) && size > maxInfoRecord ||
+			isMtimeRecord(h) && size > maxMtimeRecord) {
+			err = ErrCorruptHeader
+		}
+		if err != nil && c.warn == nil {
+			return nil, err
+		}
+		if err != nil {
+			c.warn(&os.PathError{Op: "read", Path: h.GetPath(), Err: ErrCorruptHeader})
+
+			// length of the content is unknown, either search for the next
+			// header or continue right after this one
+			if c.salvage {
+				block, err = c.resync(block)
+			} else {
+				block, err = c.headerBlock()
+			}
+			continue
+		}
+		c.remaining = size
+
+		// full path of the next file is the content of the record
+		if isLongNameRecord(h) {
+			var name []byte
+			name, err = c.readRecord(size)
+			if err != nil {
+				return nil, err
+			}
+			longName = string(name)
+			block, err = c.headerBlock()
+			continue
+		}
+
+		// compression of the next file is the content of the record
+		if isCompressionRecord(h) {
+			var content []byte
+			content, err = c.readRecord(size)
+			if err != nil {
+				return nil, err
+			}
+			compression, originalSize, err = parseCompressionRecord(content)
+			if err != nil {
+				return nil, &os.PathError{Op: "read", Path: CompressionFileName, Err: err}
+			}
+			block, err = c.headerBlock()
+			continue
+		}
+
+		// attributes of the next file are the content of the record
+		if isAttributesRecord(h) {
+			var content []byte
+			content, err = c.readRecord(size)
+			if err != nil {
+				return nil, err
+			}
+			attrs, err = parseAttributesRecord(content)
+			if err != nil {
+				return nil, &os.PathError{Op: "read", Path: AttributesFileName, Err: err}
+			}
+			block, err = c.headerBlock()
+			continue
+		}
+
+		// metadata of the next file are the content of the record
+		if isMetadataRecord(h) {
+			var content []byte
+			content, err = c.readRecord(size)
+			if err != nil {
+				return nil, err
+			}
+			metadata, err = parseMetadataRecord(content)
+			if err != nil {
+				return nil, &os.PathError{Op: "read", Path: MetadataFileName, Err: err}
+			}
+			block, err = c.headerBlock()
+			continue
+		}
+
+		// nanoseconds of the modification date of the next file are the
+		// content of the record
+		if isMtimeRecord(h) {
+			var content []byte
+			content, err = c.readRecord(size)
+			if err != nil {
+				return nil, err
+			}
+			mtimeNsec, err = parseMtimeRecord(content)
+			if err != nil {
+				return nil, &os.PathError{Op: "read", Path: MtimeFileName, Err: err}
+			}
+			block, err = c.headerBlock()
+			continue
+		}
+
+		// checksum record of a file whose content wasn't read whole and
+		// archive info, see Reader.Info
+		if isChecksumRecord(h) || isInfoRecord(h) {
+			err = c.skip(size)
+			c.remaining = 0
+			if err == nil {
+				block, err = c.headerBlock()
+			}
+			continue
+		}
+		h.longName = longName
+		h.compression, h.originalSize = compression, originalSize
+		h.attrs = attrs
+		h.metadata = metadata
+		h.mtimeNsec = mtimeNsec
+		c.name, c.unchecked = h.GetPath(), true
+		c.sums.reset()
+
+		// the file is still usable without its modification date
+		_, err = h.GetMtime()
+		if err != nil && c.warn != nil {
+			c.warn(&os.PathError{Op: "read", Path: h.GetPath(), Err: ErrInvalidMtime})
+		}
+
+		return h, nil
+	}
+}
+
+// readRecord reads content of size bytes of the record preceding a header
+func (c *cursor) readRecord(size int64) ([]byte, error) {
+	content := make([]byte, size)
+	_, err := io.ReadFull(c, content)
+	if err == io.ErrUnexpectedEOF {
+		err = ErrTruncated
+	}
+	return content, err
+}
+
+// Read reads from the content of the current file, it returns io.EOF when
+// the end of the file is reached
+func (c *cursor) Read(b []byte) (int, error) {
+	if c.remaining <= 0 {
+		return 0, c.verify(io.EOF)
+	}
+
+	// don't read past the content of the current file
+	if int64(len(b)) > c.remaining {
+		b = b[0:c.remaining]
+	}
+
+	n, err := c.source.Read(b)
+	c.offset += int64(n)
+	c.remaining -= int64(n)
+	c.sums.write(b[:n])
+	if err == io.EOF && c.remaining > 0 {
+		err = ErrTruncated
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	if err == nil && c.remaining == 0 {
+		err = c.verify(nil)
+	}
+
+	return n, err
+}
+
+// verify checks the checksum once content of the current file was read
+// whole and returns err when it matches
+func (c *cursor) verify(err error) error {
+	if c.unchecked {
+		c.unchecked = false
+		c.checkErr = c.check()
+	}
+	if c.checkErr != nil {
+		return c.checkErr
+	}
+	return err
+}
+
+// check reads the header following content of the current file and
+// verifies the checksum when it is a checksum record, other headers are
+// kept for the next call to headerBlock
+func (c *cursor) check() error {
+	block, err := c.headerBlock()
+	if err != nil {
+		c.pendingErr = err
+		return nil
+	}
+
+	h := c.layout.get().populate(block)
+	size, err := h.GetSize64()
+	if !isChecksumRecord(h) || err != nil || size < 0 || size > maxChecksumRecord {
+		c.pending = block
+		c.sums.unrecorded()
+		return nil
+	}
+
+	content := make([]byte, size)
+	n, err := io.ReadFull(c.source, content)
+	c.offset += int64(n)
+	if err != nil {
+		// the file itself is complete, the archive ends in the record
+		c.pendingErr = ErrTruncated
+		return nil
+	}
+	return c.sums.verify(c.name, content)
+}
+
+// headerBlock reads and returns header block from archive
+func (c *cursor) headerBlock() ([]byte, error) {
+	// return the header read ahead by check
+	if c.pending != nil || c.pendingErr != nil {
+		block, err := c.pending, c.pendingErr
+		c.pending, c.pendingErr = nil, nil
+		return block, err
+	}
+
+	// create buffer to keep the header block
+	layout := c.layout.get()
+	if err := layout.Validate(); err != nil {
+		return nil, err
+	}
+	block := make([]byte, layout.HeaderSize())
+
+	// read the header block
+	bytesRead, err := io.ReadFull(c.source, block)
+	c.offset += int64(bytesRead)
+	if err == io.EOF && c.offset == 0 {
+		// empty file is an archive without files, Writer doesn't append
+		// EOF block unless files were added
+		return nil, io.EOF
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// archive ended without EOF block or in the middle of a header
+		return nil, ErrTruncated
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// skip moves the pointer n bytes forward
+func (c *cursor) skip(n int64) error {
+	// seek over the bytes when possible
+	if seeker, ok := c.source.(io.Seeker); ok {
+		// sources of cursors created by newCursorAt start at their offset,
+		// the position they report is relative to it
+		_, err := seeker.Seek(n, 1)
+		if err != nil {
+			return err
+		}
+		c.offset += n
+		return nil
+	}
+
+	// otherwise read and discard them
+	skipped, err := io.CopyN(ioutil.Discard, c.source, n)
+	c.offset += skipped
+	if err == io.EOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+// rewind puts the pointer at the beginning of the archive
+func (c *cursor) rewind() error {
+	c.remaining = 0
+	c.unchecked, c.pending, c.pendingErr = false, nil, nil
+
+	if seeker, ok := c.source.(io.Seeker); ok {
+		_, err := seeker.Seek(0, 0)
+		if err != nil {
+			return err
+		}
+		c.offset = 0
+		return nil
+	}
+
+	// compressed archive is decompressed again
+	if s, ok := c.source.(*decompressedSource); ok {
+		err := s.rewind()
+		if err != nil {
+			return err
+		}
+		c.offset = 0
+		return nil
+	}
+
+	// sequential archive can't be read again once we moved forward
+	if c.offset != 0 {
+		return ErrNotSeekable
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"errors"
+	"io"
+)
+
+// DatabaseFileName is the name of the database dump in the root of archives
+// exported by All-in-One WP Migration
+const DatabaseFileName = "database.sql"
+
+// ErrNoDatabase is returned when archive has no database dump
+var ErrNoDatabase = errors.New("archive has no database.sql")
+
+// OpenDatabase returns reader of the database dump stored in archive as
+// database.sql, compressed on the fly by compression unless it is
+// NoCompression, so it can be piped to a file or to an import process
+// without extracting the rest of the archive. ErrNoDatabase is returned when
+// there is none.
+func (r *Reader) OpenDatabase(compression Compression) (io.ReadCloser, error) {
+	c, e, err := r.findFile(DatabaseFileName)
+	if err == ErrFileNotFound {
+		return nil, ErrNoDatabase
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, err := openEntry(e.Path(), e.Compression, e.OriginalSize, c)
+	if err != nil || compression == NoCompression {
+		return content, err
+	}
+
+	codec, ok := codecs[compression]
+	if !ok {
+		content.Close()
+		return nil, ErrUnsupportedCompression
+	}
+
+	// compress while the dump is read, closing the reader stops it
+	pr, pw := io.Pipe()
+	go func() {
+		defer content.Close()
+		zw, err := codec.newWriter(pw, 0)
+		if err == nil {
+			_, err = io.Copy(zw, content)
+			closeErr := zw.Close()
+			if err == nil {
+				err = closeErr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
@@ -0,0 +1,89 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestOpenDatabase tests streaming database dump out of archive
+func TestOpenDatabase(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	dump := "CREATE TABLE `wp_options` (`option_id` int);\n"
+	filename := _newArchive(t, tempPath, "wp-content/a.txt", "abc", "database.sql", dump)
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %s", err)
+	}
+	defer r.Close()
+
+	db, err := r.OpenDatabase(NoCompression)
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	content, err := ioutil.ReadAll(db)
+	db.Close()
+	if err != nil || string(content) != dump {
+		t.Errorf("Read %q instead of %q: %v", content, dump, err)
+	}
+
+	db, err = r.OpenDatabase(GzipCompression)
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	zr, err := gzip.NewReader(db)
+	if err == nil {
+		content, err = ioutil.ReadAll(zr)
+	}
+	db.Close()
+	if err != nil || string(content) != dump {
+		t.Errorf("Read %q instead of %q: %v", content, dump, err)
+	}
+
+	// archive read sequentially
+	archive, _ := ioutil.ReadFile(filename)
+	db, err = NewReaderFrom(bytes.NewBufferString(string(archive))).OpenDatabase(NoCompression)
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	content, err = ioutil.ReadAll(db)
+	if err != nil || string(content) != dump {
+		t.Errorf("Read %q instead of %q: %v", content, dump, err)
+	}
+
+	// archives without database
+	r2, _ := NewReader(_newArchive(t, tempPath, "wp-content/a.txt", "abc"))
+	defer r2.Close()
+	_, err = r2.OpenDatabase(NoCompression)
+	if err != ErrNoDatabase {
+		t.Errorf("Expected ErrNoDatabase, got %v", err)
+	}
+}
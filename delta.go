@@ -0,0 +1,558 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// DeltaFileName is the name of the first file of delta archive listing all
+// files of the target archive in order and where their contents come from,
+// see CreateDelta. Other tools extract it as a regular file.
+const DeltaFileName = ".wpress-delta"
+
+// ErrNotDelta is returned when archive applied as delta doesn't start with
+// DeltaFileName
+var ErrNotDelta = errors.New("archive is not a delta")
+
+// ErrDeltaMismatch is returned when delta is applied to another archive than
+// the one it was created from or reconstructed content differs from the
+// original
+var ErrDeltaMismatch = errors.New("delta doesn't match base archive")
+
+// maxDiffSize is the largest content diffed by DeltaOptions.BinaryDiff,
+// both contents are held in memory while diffing
+const maxDiffSize = 64 << 20
+
+// DeltaOptions are options of CreateDelta
+type DeltaOptions struct {
+	// CompareContents compares hashes of contents of files whose size and
+	// modification date didn't change, both archives are read whole then.
+	// Files are compared by size, modification date, attributes and
+	// metadata otherwise.
+	CompareContents bool
+
+	// BinaryDiff stores changed files as binary differences from the file
+	// of the same path in base archive, only files stored uncompressed of
+	// up to 64 MiB are diffed
+	BinaryDiff bool
+}
+
+// deltaIndex is the content of DeltaFileName
+type deltaIndex struct {
+	// Base identifies the base archive, see listDeltaEntries
+	Base  string      `json:"base"`
+	Files []deltaFile `json:"files"`
+}
+
+// deltaFile is a file of the target archive
+type deltaFile struct {
+	Path string `json:"path"`
+	Op   string `json:"op"`
+	// Base is the position of the copied or patched file in base archive
+	Base int `json:"base,omitempty"`
+	// SHA256 is the hash of the patched content
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+const (
+	deltaCopy  = "copy"  // file is copied from base archive
+	deltaAdd   = "add"   // file is stored in delta whole
+	deltaPatch = "patch" // binary difference from the base file is stored in delta
+)
+
+// deltaEntry is a file of an archive compared by CreateDelta
+type deltaEntry struct {
+	path      string
+	signature string
+	// position of the first header or record of the file and of its
+	// content as stored
+	start       int64
+	offset      int64
+	size        int64
+	compression Compression
+}
+
+// diffable reports whether content of the file can be binary diffed
+func (e deltaEntry) diffable() bool {
+	return e.compression == NoCompression && e.size <= maxDiffSize
+}
+
+// entrySignature returns string which differs for files of different path,
+// size, modification date, compression, attributes or metadata
+func entrySignature(h *Header) (string, error) {
+	size, err := h.GetSize64()
+	if err != nil {
+		return "", err
+	}
+	originalSize, err := h.GetOriginalSize()
+	if err != nil {
+		return "", err
+	}
+	mtime, _ := h.GetMtime()
+	attrs, err := json.Marshal(h.attrs)
+	if err != nil {
+		return "", err
+	}
+	metadata, err := json.Marshal(h.metadata)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\x00%d\x00%d.%09d\x00%d\x00%d\x00%s\x00%s", h.GetPath(), size, mtime, h.mtimeNsec,
+		h.compression, originalSize, attrs, metadata), nil
+}
+
+// listDeltaEntries lists all files of the archive read by r in order and
+// returns them with the hash of their signatures identifying the archive
+func listDeltaEntries(r *Reader) ([]deltaEntry, string, error) {
+	c, err := r.scan()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var entries []deltaEntry
+	hash := sha256.New()
+	for {
+		// the file starts right after content of the previous one
+		start := c.offset + c.remaining
+		h, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		signature, err := entrySignature(h)
+		if err != nil {
+			return nil, "", err
+		}
+		io.WriteString(hash, signature+"\n")
+		entries = append(entries, deltaEntry{h.GetPath(), signature, start, c.offset, c.remaining, h.GetCompression()})
+	}
+
+	return entries, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// contentSum returns SHA-256 hash of the content of e as stored in ra
+func contentSum(ra io.ReaderAt, e deltaEntry) ([]byte, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, io.NewSectionReader(ra, e.offset, e.size))
+	if err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// CreateDelta writes delta archive dest holding only the files of archive
+// target which differ from archive base, e.g. to keep nightly backups of a
+// site where few files change as deltas of a full backup. Unchanged files
+// are only listed, changed files are stored whole or as binary differences
+// according to opts. Dest appears only when complete. Number of files
+// stored in dest is returned.
+func CreateDelta(base string, target string, dest string, opts DeltaOptions) (int, error) {
+	rb, err := NewReader(base)
+	if err != nil {
+		return 0, err
+	}
+	defer rb.Close()
+	rt, err := NewReader(target)
+	if err != nil {
+		return 0, err
+	}
+	defer rt.Close()
+
+	// contents are read at the positions of the files
+	baseRA, ok := rb.source.(io.ReaderAt)
+	if !ok {
+		return 0, ErrNotSeekable
+	}
+	targetRA, ok := rt.source.(io.ReaderAt)
+	if !ok {
+		return 0, ErrNotSeekable
+	}
+
+	baseEntries, baseSum, err := listDeltaEntries(rb)
+	if err != nil {
+		return 0, err
+	}
+	targetEntries, _, err := listDeltaEntries(rt)
+	if err != nil {
+		return 0, err
+	}
+
+	// files are compared with the last occurrence of their path in base
+	latest := make(map[string]int)
+	for i, e := range baseEntries {
+		latest[e.path] = i
+	}
+
+	index := &deltaIndex{Base: baseSum}
+	for _, e := range targetEntries {
+		f := deltaFile{Path: e.path, Op: deltaAdd}
+		i, ok := latest[e.path]
+		if ok && e.signature == baseEntries[i].signature {
+			f.Op, f.Base = deltaCopy, i
+			if opts.CompareContents {
+				f.Op, err = compareContents(baseRA, baseEntries[i], targetRA, e)
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+		if ok && f.Op == deltaAdd && opts.BinaryDiff && e.diffable() && baseEntries[i].diffable() {
+			sum, err := contentSum(targetRA, e)
+			if err != nil {
+				return 0, err
+			}
+			f.Op, f.Base, f.SHA256 = deltaPatch, i, hex.EncodeToString(sum)
+		}
+		index.Files = append(index.Files, f)
+	}
+
+	return writeDelta(dest, index, baseRA, baseEntries, rt, targetEntries)
+}
+
+// compareContents returns deltaCopy when contents of files b and t are the
+// same and deltaAdd otherwise
+func compareContents(baseRA io.ReaderAt, b deltaEntry, targetRA io.ReaderAt, t deltaEntry) (string, error) {
+	baseSum, err := contentSum(baseRA, b)
+	if err != nil {
+		return "", err
+	}
+	targetSum, err := contentSum(targetRA, t)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(baseSum, targetSum) {
+		return deltaAdd, nil
+	}
+	return deltaCopy, nil
+}
+
+// writeDelta writes index and the files of target read by rt it doesn't
+// copy from base to delta archive dest
+func writeDelta(dest string, index *deltaIndex, baseRA io.ReaderAt, baseEntries []deltaEntry, rt *Reader, targetEntries []deltaEntry) (int, error) {
+	content, err := json.Marshal(index)
+	if err != nil {
+		return 0, err
+	}
+	h, err := NewHeader(DeltaFileName, int64(len(content)), time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	w, err := NewAtomicWriter(dest)
+	if err != nil {
+		return 0, err
+	}
+	w.PreciseMtime = true
+	if info, infoErr := rt.Info(); infoErr == nil {
+		w.Info = info
+	}
+	err = w.copyEntry(h, bytes.NewReader(content))
+	if err != nil {
+		w.discard()
+		return 0, err
+	}
+
+	stored := 0
+	for i, f := range index.Files {
+		if f.Op == deltaCopy {
+			continue
+		}
+		err = writeDeltaFile(w, f, baseRA, baseEntries, rt, targetEntries[i])
+		if err != nil {
+			w.discard()
+			return 0, err
+		}
+		stored++
+	}
+
+	return stored, w.Close()
+}
+
+// writeDeltaFile writes target file e read by rt to delta archive w whole
+// or as the binary difference from its base file
+func writeDeltaFile(w *Writer, f deltaFile, baseRA io.ReaderAt, baseEntries []deltaEntry, rt *Reader, e deltaEntry) error {
+	ra := rt.source.(io.ReaderAt)
+	c := newCursorAt(ra, e.start, &rt.Layout)
+	h, err := c.next()
+	if err != nil {
+		return err
+	}
+	if f.Op == deltaAdd {
+		return w.writeEntry(h, c)
+	}
+
+	// both contents are small enough to be diffed in memory
+	b := baseEntries[f.Base]
+	base, err := ioutil.ReadAll(io.NewSectionReader(baseRA, b.offset, b.size))
+	if err != nil {
+		return err
+	}
+	target, err := ioutil.ReadAll(c)
+	if err != nil {
+		return err
+	}
+	patch := diffContent(base, target)
+	err = h.SetSize(int64(len(patch)))
+	if err != nil {
+		return err
+	}
+	return w.writeEntry(h, bytes.NewReader(patch))
+}
+
+// ApplyDelta writes archive dest reconstructed from archive base and delta
+// archive delta created by CreateDelta from base, deltas of a chain are
+// applied one by one to restore a later backup. Dest appears only when
+// complete. Number of files written is returned.
+func ApplyDelta(base string, delta string, dest string) (int, error) {
+	d, err := NewReader(delta)
+	if err != nil {
+		return 0, err
+	}
+	defer d.Close()
+
+	w, err := NewAtomicWriter(dest)
+	if err != nil {
+		return 0, err
+	}
+	filesCount, err := applyDelta(base, d, w)
+	if err != nil {
+		w.discard()
+		return 0, err
+	}
+
+	return filesCount, w.Close()
+}
+
+// ApplyDeltaTo writes archive reconstructed from archive base and delta
+// archive read sequentially from delta to dest like ApplyDelta, e.g. to
+// apply delta downloaded from remote storage without saving it
+func ApplyDeltaTo(base string, delta io.Reader, dest io.Writer) (int, error) {
+	w := NewWriterTo(dest)
+	filesCount, err := applyDelta(base, NewReaderFrom(delta), w)
+	if err != nil {
+		return filesCount, err
+	}
+
+	return filesCount, w.Close()
+}
+
+// ExtractDelta extracts files of the archive reconstructed from archive base
+// and delta archive delta according to opts without writing the archive
+// itself. Number of files extracted is returned.
+func ExtractDelta(base string, delta string, opts ExtractOptions) (int, error) {
+	d, err := NewReader(delta)
+	if err != nil {
+		return 0, err
+	}
+	defer d.Close()
+
+	// the archive is extracted while it is reconstructed
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		w := NewWriterTo(pw)
+		_, err := applyDelta(base, d, w)
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	filesCount, err := NewReaderFrom(pr).ExtractWithOptions(opts)
+	// stop the reconstruction when extraction failed
+	pr.CloseWithError(err)
+	applyErr := <-done
+	if err == nil {
+		err = applyErr
+	}
+
+	return filesCount, err
+}
+
+// applyDelta writes files of the archive reconstructed from archive base and
+// delta archive read by d to w and returns their number, w is not closed
+func applyDelta(base string, d *Reader, w *Writer) (int, error) {
+	rb, err := NewReader(base)
+	if err != nil {
+		return 0, err
+	}
+	defer rb.Close()
+	baseRA, ok := rb.source.(io.ReaderAt)
+	if !ok {
+		return 0, ErrNotSeekable
+	}
+	baseEntries, baseSum, err := listDeltaEntries(rb)
+	if err != nil {
+		return 0, err
+	}
+
+	// info of the target archive precedes the index
+	c, err := d.scan()
+	if err != nil {
+		return 0, err
+	}
+	info, err := readInfo(c, d.Filename)
+	if err != nil && err != ErrNoInfo {
+		return 0, err
+	}
+	w.Info = info
+	w.PreciseMtime = true
+
+	index, err := readDeltaIndex(c)
+	if err != nil {
+		return 0, err
+	}
+	if index.Base != baseSum {
+		return 0, &os.PathError{Op: "apply", Path: base, Err: ErrDeltaMismatch}
+	}
+
+	for _, f := range index.Files {
+		err = applyDeltaFile(w, f, c, baseRA, &rb.Layout, baseEntries)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// delta ends with the last file it stores
+	_, err = c.next()
+	if err == nil {
+		err = &os.PathError{Op: "apply", Path: DeltaFileName, Err: ErrDeltaMismatch}
+	}
+	if err != io.EOF {
+		return 0, err
+	}
+
+	return len(index.Files), nil
+}
+
+// readDeltaIndex reads the index delta archive read by c starts with
+func readDeltaIndex(c *cursor) (*deltaIndex, error) {
+	h, err := c.next()
+	if err == io.EOF || err == nil && h.GetPath() != DeltaFileName {
+		return nil, ErrNotDelta
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadAll(c)
+	if err != nil {
+		return nil, err
+	}
+	index := &deltaIndex{}
+	err = json.Unmarshal(content, index)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: DeltaFileName, Err: err}
+	}
+	return index, nil
+}
+
+// applyDeltaFile writes file f of the target archive to w copying it from
+// base archive or reading it from delta archive read by c
+func applyDeltaFile(w *Writer, f deltaFile, c *cursor, baseRA io.ReaderAt, layout *Layout, baseEntries []deltaEntry) error {
+	// copied and patched files refer to the file of the same path in base
+	var b deltaEntry
+	if f.Op == deltaCopy || f.Op == deltaPatch {
+		if f.Base < 0 || f.Base >= len(baseEntries) || baseEntries[f.Base].path != f.Path {
+			return &os.PathError{Op: "apply", Path: f.Path, Err: ErrDeltaMismatch}
+		}
+		b = baseEntries[f.Base]
+	}
+
+	if f.Op == deltaCopy {
+		bc := newCursorAt(baseRA, b.start, layout)
+		h, err := bc.next()
+		if err != nil {
+			return err
+		}
+		return w.writeEntry(h, bc)
+	}
+
+	// stored files follow the index in order
+	h, err := c.next()
+	if err == io.EOF || err == nil && h.GetPath() != f.Path {
+		return &os.PathError{Op: "apply", Path: f.Path, Err: ErrDeltaMismatch}
+	}
+	if err != nil {
+		return err
+	}
+
+	switch f.Op {
+	case deltaAdd:
+		return w.writeEntry(h, c)
+	case deltaPatch:
+		return applyPatch(w, f, h, c, baseRA, b)
+	}
+	return &os.PathError{Op: "apply", Path: f.Path, Err: ErrNotDelta}
+}
+
+// applyPatch writes file f described by h with content of base file b
+// patched by the binary difference read from c to w
+func applyPatch(w *Writer, f deltaFile, h *Header, c io.Reader, baseRA io.ReaderAt, b deltaEntry) error {
+	size, err := h.GetSize64()
+	if err != nil {
+		return err
+	}
+	if !b.diffable() || size > 2*maxDiffSize {
+		return &os.PathError{Op: "apply", Path: f.Path, Err: ErrCorruptPatch}
+	}
+
+	base, err := ioutil.ReadAll(io.NewSectionReader(baseRA, b.offset, b.size))
+	if err != nil {
+		return err
+	}
+	patch, err := ioutil.ReadAll(c)
+	if err != nil {
+		return err
+	}
+	content, err := patchContent(base, patch)
+	if err != nil {
+		return &os.PathError{Op: "apply", Path: f.Path, Err: err}
+	}
+	sum := sha256.Sum256(content)
+	if hex.EncodeToString(sum[:]) != f.SHA256 {
+		return &os.PathError{Op: "apply", Path: f.Path, Err: ErrDeltaMismatch}
+	}
+
+	err = h.SetSize(int64(len(content)))
+	if err != nil {
+		return err
+	}
+	return w.writeEntry(h, bytes.NewReader(content))
+}
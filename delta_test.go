@@ -0,0 +1,217 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// _newDeltaArchive creates archive filename holding files, paths mapped to
+// contents, all modified at modTime
+func _newDeltaArchive(t *testing.T, filename string, modTime time.Time, files ...string) {
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	for i := 0; i+1 < len(files); i += 2 {
+		h, err := NewHeader(files[i], int64(len(files[i+1])), modTime)
+		if err == nil {
+			err = w.Add(h, bytes.NewReader([]byte(files[i+1])))
+		}
+		if err != nil {
+			t.Fatalf("Failed to add %s: %s", files[i], err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Failed to close archive: %s", err)
+	}
+}
+
+// _newDeltaArchives creates base archive and target archive in dir, target
+// has database.sql changed, wp-config.php unchanged, old.txt removed and
+// new.txt added
+func _newDeltaArchives(t *testing.T, dir string) (string, string) {
+	content := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(content)
+	changed := append([]byte{}, content...)
+	changed[30000] ^= 1
+	modTime := time.Unix(1420382531, 0)
+
+	base := filepath.Join(dir, "base.wpress")
+	_newDeltaArchive(t, base, modTime, "database.sql", string(content), "wp-config.php", "<?php", "old.txt", "old")
+	target := filepath.Join(dir, "target.wpress")
+	_newDeltaArchive(t, target, modTime.Add(time.Hour), "database.sql", string(changed))
+	_newDeltaArchive(t, target+".tmp", modTime, "wp-config.php", "<?php", "new.txt", "new")
+	n, err := Concat(target, []string{target, target + ".tmp"})
+	if err != nil || n != 3 {
+		t.Fatalf("Failed to create target archive: %v", err)
+	}
+	return base, target
+}
+
+// TestCreateDelta tests writing delta between archives
+func TestCreateDelta(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	base, target := _newDeltaArchives(t, tempPath)
+	modTime := time.Unix(1420382531, 0)
+
+	tests := []struct {
+		opts    DeltaOptions
+		ops     []string
+		maxSize int64
+	}{
+		{DeltaOptions{}, []string{deltaAdd, deltaCopy, deltaAdd}, 64 << 10},
+		{DeltaOptions{BinaryDiff: true}, []string{deltaPatch, deltaCopy, deltaAdd}, 3 * diffBlockSize},
+	}
+	for _, test := range tests {
+		dest := filepath.Join(tempPath, "delta.wpress")
+		stored, err := CreateDelta(base, target, dest, test.opts)
+		if err != nil || stored != 2 {
+			t.Fatalf("Stored %d files instead of 2: %v", stored, err)
+		}
+
+		r, err := NewReader(dest)
+		if err != nil {
+			t.Fatalf("Failed to open delta: %s", err)
+		}
+		entries, err := r.ListEntries()
+		if err != nil || len(entries) != 3 || entries[0].Path() != DeltaFileName ||
+			entries[1].Path() != "database.sql" || entries[2].Path() != "new.txt" {
+			t.Fatalf("Unexpected delta entries %v: %v", entries, err)
+		}
+		if entries[1].Size > test.maxSize || !entries[1].ModTime.Equal(modTime.Add(time.Hour)) {
+			t.Errorf("Unexpected stored file %+v", entries[1])
+		}
+
+		content, err := r.ExtractFile(DeltaFileName, ".")
+		index := &deltaIndex{}
+		if err == nil {
+			err = json.Unmarshal(content, index)
+		}
+		if err != nil || len(index.Files) != 3 || index.Base == "" {
+			t.Fatalf("Unexpected delta index %+v: %v", index, err)
+		}
+		for i, op := range test.ops {
+			if index.Files[i].Op != op {
+				t.Errorf("File %s is %s instead of %s", index.Files[i].Path, index.Files[i].Op, op)
+			}
+		}
+		if index.Files[1].Base != 1 {
+			t.Errorf("File %s is copied from %d instead of 1", index.Files[1].Path, index.Files[1].Base)
+		}
+		r.Close()
+	}
+}
+
+// TestCreateDeltaCompareContents tests detecting changed contents of the
+// same size and modification date
+func TestCreateDeltaCompareContents(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	modTime := time.Unix(1420382531, 0)
+	base := filepath.Join(tempPath, "base.wpress")
+	_newDeltaArchive(t, base, modTime, "a.txt", "abc", "b.txt", "abc")
+	target := filepath.Join(tempPath, "target.wpress")
+	_newDeltaArchive(t, target, modTime, "a.txt", "abc", "b.txt", "abd")
+
+	dest := filepath.Join(tempPath, "delta.wpress")
+	stored, err := CreateDelta(base, target, dest, DeltaOptions{})
+	if err != nil || stored != 0 {
+		t.Errorf("Stored %d files instead of 0: %v", stored, err)
+	}
+	stored, err = CreateDelta(base, target, dest, DeltaOptions{CompareContents: true})
+	if err != nil || stored != 1 {
+		t.Errorf("Stored %d files instead of 1: %v", stored, err)
+	}
+}
+
+// TestApplyDelta tests reconstructing archive from base archive and delta
+func TestApplyDelta(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	base, target := _newDeltaArchives(t, tempPath)
+	expected, _ := ioutil.ReadFile(target)
+
+	for _, opts := range []DeltaOptions{{}, {BinaryDiff: true}} {
+		delta := filepath.Join(tempPath, "delta.wpress")
+		_, err := CreateDelta(base, target, delta, opts)
+		if err != nil {
+			t.Fatalf("Failed to create delta: %s", err)
+		}
+
+		dest := filepath.Join(tempPath, "restored.wpress")
+		n, err := ApplyDelta(base, delta, dest)
+		if err != nil || n != 3 {
+			t.Fatalf("Restored %d files instead of 3: %v", n, err)
+		}
+		restored, _ := ioutil.ReadFile(dest)
+		if !bytes.Equal(restored, expected) {
+			t.Errorf("Restored archive differs from target")
+		}
+
+		// delta read as a stream
+		file, _ := os.Open(delta)
+		var buf bytes.Buffer
+		n, err = ApplyDeltaTo(base, file, &buf)
+		file.Close()
+		if err != nil || n != 3 || !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("Restored %d files differing from target: %v", n, err)
+		}
+
+		// files extracted without writing the archive
+		dir := filepath.Join(tempPath, "site")
+		n, err = ExtractDelta(base, delta, ExtractOptions{DestDir: dir})
+		if err != nil || n != 3 {
+			t.Fatalf("Extracted %d files instead of 3: %v", n, err)
+		}
+		content, _ := ioutil.ReadFile(filepath.Join(dir, "new.txt"))
+		if string(content) != "new" {
+			t.Errorf("Extracted %q instead of new", content)
+		}
+
+		// delta applies only to its base
+		_, err = ApplyDelta(target, delta, dest)
+		if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrDeltaMismatch {
+			t.Errorf("Expected ErrDeltaMismatch, got %v", err)
+		}
+	}
+
+	_, err := ApplyDelta(base, target, filepath.Join(tempPath, "restored.wpress"))
+	if err != ErrNotDelta {
+		t.Errorf("Expected ErrNotDelta, got %v", err)
+	}
+}
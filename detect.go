@@ -0,0 +1,195 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// Format tells what kind of data Detect has found
+type Format int
+
+const (
+	// FormatUnknown is neither an archive nor data Detect recognizes
+	FormatUnknown Format = iota
+	// FormatWpress is a plain archive readable by any wpress tool
+	FormatWpress
+	// FormatWpressExtended is an archive starting with an extension
+	// record, e.g. LongNameFileName or ManifestFileName, other tools read
+	// it but extract the records as regular files
+	FormatWpressExtended
+	// FormatEncrypted is an archive encrypted by Writer.Encryption
+	FormatEncrypted
+	// FormatAge is data encrypted by age, see NewAgeReader
+	FormatAge
+	// FormatZip is a zip file
+	FormatZip
+	// FormatTar is a tar file
+	FormatTar
+)
+
+// formatNames holds names of formats returned by String
+var formatNames = map[Format]string{
+	FormatUnknown:        "unknown",
+	FormatWpress:         "wpress",
+	FormatWpressExtended: "wpress with extensions",
+	FormatEncrypted:      "encrypted wpress",
+	FormatAge:            "age encrypted",
+	FormatZip:            "zip",
+	FormatTar:            "tar",
+}
+
+// String returns name of the format
+func (f Format) String() string {
+	if name, ok := formatNames[f]; ok {
+		return name
+	}
+	return formatNames[FormatUnknown]
+}
+
+// Detection is the result of Detect, Format is the kind of data once
+// decompressed by Compression
+type Detection struct {
+	Format      Format
+	Compression Compression
+}
+
+// IsArchive reports whether the data is an archive Reader opens, possibly
+// after setting Reader.Encryption
+func (d Detection) IsArchive() bool {
+	switch d.Format {
+	case FormatWpress, FormatWpressExtended:
+		return true
+	case FormatEncrypted:
+		return d.Compression == NoCompression
+	}
+	return false
+}
+
+// zipMagic holds the bytes zip files start with, local file header or end
+// of central directory of empty zip files
+var zipMagic = [][]byte{[]byte("PK\x03\x04"), []byte("PK\x05\x06")}
+
+// tarMagicOffset is the offset of the magic in the header of POSIX and GNU
+// tar files
+const tarMagicOffset = 257
+
+// tarMagic is the magic of POSIX and GNU tar files
+const tarMagic = "ustar"
+
+// Detect reads the beginning of src and tells what kind of data it holds,
+// so that tools can tell users they passed e.g. a zip file instead of
+// reporting a corrupt archive. Data compressed by gzip, or zstd in builds
+// with the zstd tag, is decompressed to detect what is inside, the Format
+// is FormatUnknown when the codec is not available. Extensions of archives
+// are detected only when the first file has one, as the rest of the
+// archive is not read.
+func Detect(src io.Reader) (Detection, error) {
+	block := make([]byte, headerSize)
+	n, err := io.ReadFull(src, block)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return Detection{}, err
+	}
+	block = block[:n]
+
+	for compression, prefix := range compressionMagic {
+		if !bytes.HasPrefix(block, prefix) {
+			continue
+		}
+
+		d := Detection{Compression: compression}
+		c, ok := codecs[compression]
+		if !ok {
+			return d, nil
+		}
+		zr, err := c.newReader(io.MultiReader(bytes.NewReader(block), src))
+		if err != nil {
+			// data starting with the magic by chance
+			return Detection{}, nil
+		}
+		defer zr.Close()
+		inner, err := Detect(bufio.NewReader(zr))
+		if err != nil || inner.Compression != NoCompression {
+			// truncated or corrupt streams are not recognized
+			return d, nil
+		}
+		d.Format = inner.Format
+		return d, nil
+	}
+
+	return Detection{Format: detectFormat(block)}, nil
+}
+
+// detectFormat returns format of uncompressed data starting with block
+func detectFormat(block []byte) Format {
+	switch {
+	case bytes.HasPrefix(block, []byte(encryptionMagic)):
+		return FormatEncrypted
+	case bytes.HasPrefix(block, []byte(ageMagic)):
+		return FormatAge
+	case bytes.HasPrefix(block, zipMagic[0]) || bytes.HasPrefix(block, zipMagic[1]):
+		return FormatZip
+	case len(block) > tarMagicOffset+len(tarMagic) && string(block[tarMagicOffset:tarMagicOffset+len(tarMagic)]) == tarMagic:
+		return FormatTar
+	case len(block) < headerSize:
+		return FormatUnknown
+	case bytes.Equal(block, eofBlock):
+		// archive without files
+		return FormatWpress
+	}
+
+	h := &Header{}
+	h.PopulateFromBytes(block)
+	if !validHeaderBlock(h) {
+		return FormatUnknown
+	}
+	if h.GetPrefix() == "." && strings.HasPrefix(h.GetName(), ".wpress-") {
+		return FormatWpressExtended
+	}
+	return FormatWpress
+}
+
+// validHeaderBlock reports whether fields of h hold values written by
+// archivers, values padded by zero bytes, the size is checked by parsing
+// as it may be stored in binary
+func validHeaderBlock(h *Header) bool {
+	for _, field := range [][]byte{h.Name, h.Mtime, h.Prefix} {
+		value := bytes.TrimRight(field, "\x00")
+		if bytes.IndexByte(value, 0) != -1 {
+			return false
+		}
+	}
+	if h.ValidateName() != nil {
+		return false
+	}
+	if _, err := h.GetSize64(); err != nil {
+		return false
+	}
+	_, err := h.GetMtime()
+	return err == nil
+}
@@ -0,0 +1,111 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestDetect tests detection of archives and other formats
+func TestDetect(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	// archive returns content of archive holding name written by the
+	// Writer configured by setup
+	archive := func(name string, setup func(w *Writer)) []byte {
+		content, err := ioutil.ReadFile(_newArchiveWith(t, tempPath, setup, name, "hello"))
+		if err != nil {
+			t.Fatalf("Unable to read archive: %s", err)
+		}
+		return content
+	}
+	plain := archive("a/b.txt", func(w *Writer) {})
+	extended := archive(strings.Repeat("x", filenameSize+1), func(w *Writer) {})
+	gzipped := archive("a/b.txt", func(w *Writer) { w.Compression = GzipCompression })
+	encrypted := archive("a/b.txt", func(w *Writer) {
+		w.Encryption = &Encryption{Key: bytes.Repeat([]byte{7}, 32)}
+	})
+
+	zipped := &bytes.Buffer{}
+	zw := zip.NewWriter(zipped)
+	f, _ := zw.Create("b.txt")
+	f.Write([]byte("hello"))
+	zw.Close()
+
+	tarred := &bytes.Buffer{}
+	tw := tar.NewWriter(tarred)
+	tw.WriteHeader(&tar.Header{Name: "b.txt", Mode: 0644, Size: 5})
+	tw.Write([]byte("hello"))
+	tw.Close()
+
+	tarGzipped := &bytes.Buffer{}
+	gw := gzip.NewWriter(tarGzipped)
+	gw.Write(tarred.Bytes())
+	gw.Close()
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected Detection
+	}{
+		{"plain", plain, Detection{Format: FormatWpress}},
+		{"empty archive", eofBlock, Detection{Format: FormatWpress}},
+		{"extended", extended, Detection{Format: FormatWpressExtended}},
+		{"gzip", gzipped, Detection{Format: FormatWpress, Compression: GzipCompression}},
+		{"encrypted", encrypted, Detection{Format: FormatEncrypted}},
+		{"age", []byte("age-encryption.org/v1\n"), Detection{Format: FormatAge}},
+		{"zip", zipped.Bytes(), Detection{Format: FormatZip}},
+		{"tar", tarred.Bytes(), Detection{Format: FormatTar}},
+		{"tar.gz", tarGzipped.Bytes(), Detection{Format: FormatTar, Compression: GzipCompression}},
+		{"truncated gzip", gzipped[:20], Detection{Compression: GzipCompression}},
+		{"text", bytes.Repeat([]byte("lorem ipsum "), 1000), Detection{}},
+		{"empty", nil, Detection{}},
+	}
+	for _, test := range tests {
+		d, err := Detect(bytes.NewReader(test.data))
+		if err != nil {
+			t.Errorf("%s: Detect failed: %s", test.name, err)
+			continue
+		}
+		if d != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, d)
+		}
+	}
+
+	if !(Detection{Format: FormatEncrypted}).IsArchive() || (Detection{Format: FormatTar, Compression: GzipCompression}).IsArchive() {
+		t.Errorf("IsArchive reports wrong result")
+	}
+	if FormatZip.String() != "zip" || Format(100).String() != "unknown" {
+		t.Errorf("unexpected format names %q, %q", FormatZip, Format(100))
+	}
+}
@@ -0,0 +1,169 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrCorruptPatch is returned when binary difference stored in delta
+// archive can't be applied to its base content
+var ErrCorruptPatch = errors.New("corrupt binary difference")
+
+// diffBlockSize is the length of blocks of base content looked up in target
+// content by diffContent
+const diffBlockSize = 1024
+
+const (
+	// patchCopy is followed by offset and length of base content to copy
+	patchCopy = 'c'
+	// patchInsert is followed by length of the bytes following it to insert
+	patchInsert = 'i'
+)
+
+// diffContent returns patch turning base into target, blocks of base are
+// found in target by rolling checksum like rsync does and copied, other
+// bytes of target are inserted. Offsets and lengths are written as uvarints.
+func diffContent(base []byte, target []byte) []byte {
+	// positions of the blocks of base by their checksum
+	blocks := make(map[uint32][]int)
+	for off := 0; off+diffBlockSize <= len(base); off += diffBlockSize {
+		sum := weakSum(base[off : off+diffBlockSize])
+		blocks[sum] = append(blocks[sum], off)
+	}
+
+	var patch []byte
+	// literal is the start of target bytes not matched yet
+	literal, pos := 0, 0
+	var sum uint32
+	rolling := false
+	for pos+diffBlockSize <= len(target) {
+		if !rolling {
+			sum, rolling = weakSum(target[pos:pos+diffBlockSize]), true
+		}
+
+		match := -1
+		for _, off := range blocks[sum] {
+			if bytes.Equal(base[off:off+diffBlockSize], target[pos:pos+diffBlockSize]) {
+				match = off
+				break
+			}
+		}
+		if match == -1 {
+			// move the window one byte forward
+			if pos+diffBlockSize < len(target) {
+				sum = rollSum(sum, target[pos], target[pos+diffBlockSize])
+			}
+			pos++
+			continue
+		}
+
+		// extend the match as far as the contents are the same
+		n := diffBlockSize
+		for match+n < len(base) && pos+n < len(target) && base[match+n] == target[pos+n] {
+			n++
+		}
+		patch = appendInsert(patch, target[literal:pos])
+		patch = append(patch, patchCopy)
+		patch = appendUvarint(patch, uint64(match))
+		patch = appendUvarint(patch, uint64(n))
+		pos += n
+		literal, rolling = pos, false
+	}
+
+	return appendInsert(patch, target[literal:])
+}
+
+// weakSum returns rolling checksum of block, the lower 16 bits are the sum
+// of the bytes and the upper 16 bits the sum of the bytes weighted by their
+// distance from the end of block
+func weakSum(block []byte) uint32 {
+	var a, b uint32
+	for i, c := range block {
+		a += uint32(c)
+		b += uint32(len(block)-i) * uint32(c)
+	}
+	return a&0xffff | b<<16
+}
+
+// rollSum returns checksum of the block following the one of sum by one
+// byte, out leaves the block and in enters it
+func rollSum(sum uint32, out byte, in byte) uint32 {
+	a := (sum - uint32(out) + uint32(in)) & 0xffff
+	b := (sum>>16 - diffBlockSize*uint32(out) + a) & 0xffff
+	return a | b<<16
+}
+
+// appendInsert appends the operation inserting data to patch
+func appendInsert(patch []byte, data []byte) []byte {
+	if len(data) == 0 {
+		return patch
+	}
+	patch = append(patch, patchInsert)
+	patch = appendUvarint(patch, uint64(len(data)))
+	return append(patch, data...)
+}
+
+// appendUvarint appends v encoded as uvarint to b
+func appendUvarint(b []byte, v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(b, buf[:binary.PutUvarint(buf, v)]...)
+}
+
+// patchContent returns content produced by applying patch made by
+// diffContent to base
+func patchContent(base []byte, patch []byte) ([]byte, error) {
+	var target []byte
+	for len(patch) > 0 {
+		op := patch[0]
+		patch = patch[1:]
+
+		switch op {
+		case patchCopy:
+			off, n := binary.Uvarint(patch)
+			if n <= 0 {
+				return nil, ErrCorruptPatch
+			}
+			length, m := binary.Uvarint(patch[n:])
+			if m <= 0 || off > uint64(len(base)) || length > uint64(len(base))-off {
+				return nil, ErrCorruptPatch
+			}
+			target = append(target, base[off:off+length]...)
+			patch = patch[n+m:]
+		case patchInsert:
+			length, n := binary.Uvarint(patch)
+			if n <= 0 || length > uint64(len(patch)-n) {
+				return nil, ErrCorruptPatch
+			}
+			target = append(target, patch[n:n+int(length)]...)
+			patch = patch[n+int(length):]
+		default:
+			return nil, ErrCorruptPatch
+		}
+	}
+	return target, nil
+}
@@ -0,0 +1,77 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// TestDiffContent tests binary differences between contents
+func TestDiffContent(t *testing.T) {
+	base := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(base)
+
+	// change a byte, insert bytes and drop a block
+	target := append([]byte("header"), base[:1000]...)
+	target = append(target, 'x')
+	target = append(target, base[1001:30000]...)
+	target = append(target, base[40000:]...)
+
+	tests := []struct {
+		name   string
+		base   []byte
+		target []byte
+	}{
+		{"changed", base, target},
+		{"same", base, base},
+		{"empty base", nil, target},
+		{"empty target", base, nil},
+		{"short", []byte("abc"), []byte("abd")},
+	}
+	for _, test := range tests {
+		patch := diffContent(test.base, test.target)
+		patched, err := patchContent(test.base, patch)
+		if err != nil || !bytes.Equal(patched, test.target) {
+			t.Errorf("%s: patched content differs from target: %v", test.name, err)
+		}
+	}
+
+	// unchanged blocks are copied, only the blocks around changes are not
+	patch := diffContent(base, target)
+	if len(patch) > 3*diffBlockSize {
+		t.Errorf("Patch of %d bytes is too large", len(patch))
+	}
+
+	_, err := patchContent(base, []byte{patchCopy, 0, 0xff, 0xff, 0x0f})
+	if err != ErrCorruptPatch {
+		t.Errorf("Expected ErrCorruptPatch, got %v", err)
+	}
+	_, err = patchContent(base, []byte{patchInsert, 10, 'a'})
+	if err != ErrCorruptPatch {
+		t.Errorf("Expected ErrCorruptPatch, got %v", err)
+	}
+}
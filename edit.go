@@ -0,0 +1,594 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// entryFunc is called by rewriteArchive for every file of the source archive
+// with its header and content, it writes whatever should take its place
+type entryFunc func(w *Writer, h *Header, content io.Reader) error
+
+// keepFunc tells whether the file at name is written unchanged by entryFunc,
+// it is called once for every file right before entryFunc. Links to files
+// written unchanged stay links when they are kept too, other links are
+// passed to entryFunc as regular files. All files are kept when it is nil.
+type keepFunc func(name string) bool
+
+// rewriteArchive copies archive filename to dest passing every file through
+// keep and fn, done is called after the last file when not nil and its error aborts
+// the rewrite. Archive is rewritten in place when dest is empty, the
+// original is replaced only after the copy was completed.
+func rewriteArchive(filename string, dest string, keep keepFunc, fn entryFunc, done func() error) error {
+	if dest == "" {
+		dest = filename
+	}
+
+	// the rewritten archive gets a manifest of its own when it had one
+	manifest, err := hasManifest([]string{filename})
+	if err != nil {
+		return err
+	}
+
+	r, err := NewReader(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// write to a temporary file next to the destination, so it can be
+	// renamed over it
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".wpress-")
+	if err != nil {
+		return err
+	}
+	// keep permissions of the original archive
+	fi, err := r.File.Stat()
+	if err == nil {
+		err = tmp.Chmod(fi.Mode())
+	}
+	if err == nil {
+		w := NewWriterTo(tmp)
+		w.PreciseMtime = true
+		w.Manifest = manifest
+		// keep the archive info
+		if info, infoErr := r.Info(); infoErr == nil {
+			w.Info = info
+		}
+		err = copyEntries(r, w, keep, fn, done)
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// copyEntries passes every file read from r through keep and fn and finishes
+// w
+func copyEntries(r *Reader, w *Writer, keep keepFunc, fn entryFunc, done func() error) error {
+	err := passEntries(r, w, keep, fn)
+	if err != nil {
+		return err
+	}
+
+	if done != nil {
+		err := done()
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.Close()
+}
+
+// passEntries passes every file read from r through keep and fn followed by
+// the links of the archive, embedded manifests are left out as they don't
+// describe the new archive
+func passEntries(r *Reader, w *Writer, keep keepFunc, fn entryFunc) error {
+	// whether the files were written unchanged by path
+	copied := make(map[string]bool)
+	var links []hardLink
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch h.GetPath() {
+		case ManifestFileName:
+			continue
+		case LinksFileName:
+			// links are written along with the links of the other sources
+			list, err := parseLinks(r)
+			if err != nil {
+				return err
+			}
+			links = append(links, list...)
+			continue
+		}
+
+		copied[h.GetPath()], err = w.unchanged(h.GetPath(), keep)
+		if err != nil {
+			return err
+		}
+		err = fn(w, h, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return passLinks(r, w, links, copied, keep, fn)
+}
+
+// passLinks keeps the links to files written unchanged which are kept as
+// well, content of the other links is read from archive and passed through
+// fn as a regular file. Links to files missing from archive are dropped as
+// they can't be restored.
+func passLinks(r *Reader, w *Writer, links []hardLink, copied map[string]bool, keep keepFunc, fn entryFunc) error {
+	var src *Reader
+	defer func() {
+		if src != nil {
+			src.Close()
+		}
+	}()
+
+	for _, link := range links {
+		sourceCopied, ok := copied[link.Source]
+		if !ok {
+			continue
+		}
+		unchanged, err := w.unchanged(link.Name, keep)
+		if err != nil {
+			return err
+		}
+		if unchanged && sourceCopied {
+			w.links = append(w.links, link)
+			continue
+		}
+
+		// content is read by a reader of its own, r is at the end already
+		if src == nil {
+			src, err = NewReader(r.Filename)
+			if err != nil {
+				return err
+			}
+		}
+		c, e, err := src.findFile(link.Source)
+		if err != nil {
+			return &os.PathError{Op: "read", Path: link.Source, Err: err}
+		}
+		h, err := linkHeader(link, e)
+		if err != nil {
+			return err
+		}
+		content, err := openEntry(e.Path(), e.Compression, e.OriginalSize, c)
+		if err != nil {
+			return err
+		}
+		err = fn(w, h, content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseLinks reads the list of links from content of LinksFileName
+func parseLinks(content io.Reader) ([]hardLink, error) {
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	var links []hardLink
+	err = json.Unmarshal(b, &links)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: LinksFileName, Err: err}
+	}
+	return links, nil
+}
+
+// linkHeader returns header of link as a regular file with content of the
+// file e it links to
+func linkHeader(link hardLink, e EntryInfo) (*Header, error) {
+	modTime := e.ModTime
+	if link.Mtime != 0 {
+		modTime = time.Unix(link.Mtime, 0)
+	}
+	return NewHeader(link.Name, e.OriginalSize, modTime)
+}
+
+// unchanged tells whether the file at name is written as it is, neither
+// left out or replaced by keep nor changed by a transform
+func (w *Writer) unchanged(name string, keep keepFunc) (bool, error) {
+	if keep != nil && !keep(name) {
+		return false, nil
+	}
+	matching, err := matchingTransforms(w.Transforms, name)
+	return len(matching) == 0, err
+}
+
+// combineArchives writes files of the sources passed through keep and fn to
+// a new archive dest, which appears only when all sources were read successfully
+func combineArchives(dest string, sources []string, transforms []Transform, keep keepFunc, fn entryFunc) error {
+	// the new archive gets a single manifest when any source had one
+	manifest, err := hasManifest(sources)
+	if err != nil {
+		return err
+	}
+
+	w, err := NewAtomicWriter(dest)
+	if err != nil {
+		return err
+	}
+	w.Transforms = transforms
+	w.PreciseMtime = true
+	w.Manifest = manifest
+
+	for _, source := range sources {
+		r, err := NewReader(source)
+		if err == nil {
+			err = passEntries(r, w, keep, fn)
+			r.Close()
+		}
+		if err != nil {
+			w.discard()
+			return err
+		}
+	}
+
+	return w.Close()
+}
+
+// hasManifest tells whether any of the archives has an embedded manifest
+func hasManifest(filenames []string) (bool, error) {
+	for _, filename := range filenames {
+		r, err := NewReader(filename)
+		if err != nil {
+			return false, err
+		}
+		_, err = r.Stat(ManifestFileName)
+		r.Close()
+		if err == nil {
+			return true, nil
+		}
+		if err != ErrFileNotFound {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Concat writes files of all sources in order to a new archive dest with a
+// single EOF block, e.g. to combine partial backups of uploads, plugins and
+// database. Links of the sources are written as a single list. Number of
+// files written is returned, links are not counted.
+func Concat(dest string, sources []string) (int, error) {
+	copied := 0
+	err := combineArchives(dest, sources, nil, nil, func(w *Writer, h *Header, content io.Reader) error {
+		copied++
+		return w.writeEntry(h, content)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return copied, nil
+}
+
+// Remove rewrites archive filename omitting the files matching any of the
+// patterns, e.g. "wp-content/cache" or "wp-config.php". Patterns use the
+// syntax of Glob and a pattern matching a directory removes everything
+// below it. Archive is rewritten in place when dest is empty, otherwise the
+// result is written to dest. Files stored as links to removed files are
+// stored with their content. Number of files removed is returned.
+func Remove(filename string, dest string, patterns []string) (int, error) {
+	// validate the patterns before reading the archive
+	err := Filter{Exclude: patterns}.Validate()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	keep := func(name string) bool {
+		return !matchAny(patterns, name)
+	}
+	err = rewriteArchive(filename, dest, keep, func(w *Writer, h *Header, content io.Reader) error {
+		if matchAny(patterns, h.GetPath()) {
+			removed++
+			return nil
+		}
+		return w.writeEntry(h, content)
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// Update rewrites archive filename replacing content of the files whose
+// paths are keys of files with content of the files on disk the keys map
+// to, e.g. "database.sql" to "/tmp/fixed.sql". Size and modification date
+// are taken from the new content, name and prefix stay the same. Files
+// stored as links to replaced files keep the previous content. Archive is
+// rewritten in place when dest is empty, otherwise the result is written to
+// dest. Nothing is written when any of the paths is not in archive.
+func Update(filename string, dest string, files map[string]string) (int, error) {
+	// normalize the paths the same way header paths are normalized
+	replacements := make(map[string]string)
+	for name, source := range files {
+		replacements[path.Clean("."+string(os.PathSeparator)+name)] = source
+	}
+
+	updated := make(map[string]bool)
+	keep := func(name string) bool {
+		_, ok := replacements[name]
+		return !ok
+	}
+	err := rewriteArchive(filename, dest, keep, func(w *Writer, h *Header, content io.Reader) error {
+		source, ok := replacements[h.GetPath()]
+		if !ok {
+			return w.writeEntry(h, content)
+		}
+		updated[h.GetPath()] = true
+
+		fi, err := os.Stat(source)
+		if err != nil {
+			return err
+		}
+		replaced, err := NewHeader(h.GetName(), fi.Size(), fi.ModTime())
+		if err != nil {
+			return err
+		}
+
+		// keep the name, prefix, attributes and metadata exactly as they were
+		replaced.Name, replaced.Prefix, replaced.longName = h.Name, h.Prefix, h.longName
+		replaced.attrs, replaced.metadata = h.attrs, h.metadata
+		return w.addFile(source, replaced)
+	}, func() error {
+		// refuse to write an archive missing some of the updates
+		for name := range replacements {
+			if !updated[name] {
+				return &os.PathError{Op: "update", Path: name, Err: ErrFileNotFound}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(updated), nil
+}
+
+// RepackOptions are options of RepackWithOptions
+type RepackOptions struct {
+	// Filter selects files to write, all files are selected when empty
+	Filter Filter
+
+	// Transforms change contents of the matching files on their way into
+	// the new archive, e.g. rewriting URLs in database.sql
+	Transforms []Transform
+}
+
+// Repack writes files of archive filename selected by f to a new archive
+// dest without extracting them, e.g. an uploads-only archive of a full site
+// backup with Include "wp-content/uploads/**". Number of files written is
+// returned.
+func Repack(filename string, dest string, f Filter) (int, error) {
+	return RepackWithOptions(filename, dest, RepackOptions{Filter: f})
+}
+
+// RepackWithOptions writes files of archive filename to a new archive dest
+// like Repack applying opts
+func RepackWithOptions(filename string, dest string, opts RepackOptions) (int, error) {
+	// validate the patterns before reading the archive
+	err := opts.Filter.Validate()
+	if err != nil {
+		return 0, err
+	}
+
+	selected := 0
+	err = combineArchives(dest, []string{filename}, opts.Transforms, nil, func(w *Writer, h *Header, content io.Reader) error {
+		if !opts.Filter.Match(h.GetPath()) {
+			return nil
+		}
+		selected++
+		return w.writeEntry(h, content)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return selected, nil
+}
+
+// MergePolicy tells Merge which file to keep when the same path appears more
+// than once in the merged archives
+type MergePolicy int
+
+const (
+	// NewestWins keeps the file with the latest modification date, the
+	// earlier occurrence is kept when the dates are equal, it is the default
+	NewestWins MergePolicy = iota
+	// FirstWins keeps the first occurrence in order of the sources
+	FirstWins
+	// FailOnDuplicate fails with ErrDuplicateEntry before anything is written
+	FailOnDuplicate
+)
+
+// Merge writes files of all sources to a new archive dest like Concat, a path
+// appearing more than once, in the same or in different sources, is written
+// only once as chosen by policy, files stored as links included. Files are
+// written in order of the sources, links of the sources are written as a
+// single list. Number of files written is returned, links are not counted.
+func Merge(dest string, sources []string, policy MergePolicy) (int, error) {
+	// find the occurrence of every path to keep before writing anything
+	keep, err := mergePlan(sources, policy)
+	if err != nil {
+		return 0, err
+	}
+
+	// files are passed in the order they were planned in
+	merged, position, kept := 0, 0, false
+	next := func(name string) bool {
+		position++
+		kept = keep[position-1]
+		return kept
+	}
+	err = combineArchives(dest, sources, nil, next, func(w *Writer, h *Header, content io.Reader) error {
+		if !kept {
+			return nil
+		}
+		merged++
+		return w.writeEntry(h, content)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return merged, nil
+}
+
+// mergePlan lists files of all sources and tells for every file in order of
+// appearance whether it should be kept by Merge
+func mergePlan(sources []string, policy MergePolicy) ([]bool, error) {
+	var all []EntryInfo
+	var keep []bool
+	// position of the occurrence kept so far by path
+	kept := make(map[string]int)
+
+	for _, source := range sources {
+		files, err := archiveFiles(source)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range files {
+			all = append(all, e)
+			keep = append(keep, true)
+
+			i, seen := kept[e.Path()]
+			switch {
+			case !seen:
+				kept[e.Path()] = len(all) - 1
+			case policy == FailOnDuplicate:
+				return nil, &os.PathError{Op: "merge", Path: e.Path(), Err: ErrDuplicateEntry}
+			case policy == NewestWins && e.ModTime.After(all[i].ModTime):
+				// the newer file replaces the one kept so far
+				keep[i] = false
+				kept[e.Path()] = len(all) - 1
+			default:
+				keep[len(keep)-1] = false
+			}
+		}
+	}
+
+	return keep, nil
+}
+
+// archiveFiles lists files of archive filename in the order passEntries
+// passes them, the files stored in archive followed by its links
+func archiveFiles(filename string) ([]EntryInfo, error) {
+	r, err := NewReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var files []EntryInfo
+	var links []hardLink
+	stored := make(map[string]EntryInfo)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch h.GetPath() {
+		case ManifestFileName:
+			continue
+		case LinksFileName:
+			list, err := parseLinks(r)
+			if err != nil {
+				return nil, err
+			}
+			links = append(links, list...)
+			continue
+		}
+
+		e, err := newEntryInfo(h, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, e)
+		if _, ok := stored[e.Path()]; !ok {
+			stored[e.Path()] = e
+		}
+	}
+
+	// links to missing files are dropped by passLinks
+	for _, link := range links {
+		e, ok := stored[link.Source]
+		if !ok {
+			continue
+		}
+		h, err := linkHeader(link, e)
+		if err != nil {
+			return nil, err
+		}
+		e, err = newEntryInfo(h, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, e)
+	}
+
+	return files, nil
+}
@@ -0,0 +1,512 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// _listPaths returns paths of all files in archive filename
+func _listPaths(t *testing.T, filename string) []string {
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+
+	entries, err := r.ListEntries()
+	if err != nil {
+		t.Fatalf("Unable to list entries: %s", err)
+	}
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.Path())
+	}
+	return paths
+}
+
+// _readFiles returns contents of the files extracted from archive filename by
+// path
+func _readFiles(t *testing.T, filename string) map[string]string {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	_, err = r.ExtractTo(dir)
+	if err != nil {
+		t.Fatalf("Unable to extract archive: %s", err)
+	}
+
+	files := make(map[string]string)
+	filepath.Walk(dir, func(pathToFile string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			content, _ := ioutil.ReadFile(pathToFile)
+			name, _ := filepath.Rel(dir, pathToFile)
+			files[filepath.ToSlash(name)] = string(content)
+		}
+		return err
+	})
+	return files
+}
+
+// TestRemove tests removing files from archive
+func TestRemove(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+	filename := _newArchive(t, dir,
+		"wp-config.php", "<?php",
+		"wp-content/cache/page.html", "cached",
+		"wp-content/uploads/logo.png", "png",
+	)
+
+	// write the result to a new file
+	dest := filepath.Join(dir, "shared.wpress")
+	removed, err := Remove(filename, dest, []string{"wp-content/cache", "wp-config.php"})
+	if err != nil || removed != 2 {
+		t.Errorf("Removed %d files instead of 2: %v", removed, err)
+	}
+	paths := _listPaths(t, dest)
+	if !reflect.DeepEqual(paths, []string{"wp-content/uploads/logo.png"}) {
+		t.Errorf("Unexpected files %v", paths)
+	}
+
+	// rewrite in place
+	removed, err = Remove(filename, "", []string{"**/*.png"})
+	if err != nil || removed != 1 {
+		t.Errorf("Removed %d files instead of 1: %v", removed, err)
+	}
+	paths = _listPaths(t, filename)
+	if !reflect.DeepEqual(paths, []string{"wp-config.php", "wp-content/cache/page.html"}) {
+		t.Errorf("Unexpected files %v", paths)
+	}
+
+	// malformed patterns leave the archive alone
+	_, err = Remove(filename, "", []string{"[a-"})
+	if err == nil {
+		t.Errorf("Expected malformed pattern to be refused")
+	}
+}
+
+// TestRemoveLinks tests removing files stored as links and files linked to
+func TestRemoveLinks(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+	filename := _newArchive(t, dir,
+		"a.txt", "shared",
+		"b.txt", "other",
+		LinksFileName, `[{"name":"c.txt","source":"a.txt"},{"name":"d.txt","source":"a.txt"}]`,
+	)
+
+	// links of the removed file are stored with its content
+	dest := filepath.Join(dir, "source.wpress")
+	removed, err := Remove(filename, dest, []string{"a.txt"})
+	if err != nil || removed != 1 {
+		t.Errorf("Removed %d files instead of 1: %v", removed, err)
+	}
+	expected := []string{"b.txt", "c.txt", "d.txt"}
+	paths := _listPaths(t, dest)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Unexpected files %v", paths)
+	}
+	files := _readFiles(t, dest)
+	if !reflect.DeepEqual(files, map[string]string{"b.txt": "other", "c.txt": "shared", "d.txt": "shared"}) {
+		t.Errorf("Unexpected contents %v", files)
+	}
+
+	// removed link is left out of the list
+	dest = filepath.Join(dir, "link.wpress")
+	removed, err = Remove(filename, dest, []string{"c.txt"})
+	if err != nil || removed != 1 {
+		t.Errorf("Removed %d files instead of 1: %v", removed, err)
+	}
+	expected = []string{"a.txt", "b.txt", LinksFileName}
+	paths = _listPaths(t, dest)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Unexpected files %v", paths)
+	}
+	files = _readFiles(t, dest)
+	if !reflect.DeepEqual(files, map[string]string{"a.txt": "shared", "b.txt": "other", "d.txt": "shared"}) {
+		t.Errorf("Unexpected contents %v", files)
+	}
+}
+
+// TestUpdate tests replacing content of files in archive
+func TestUpdate(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+	filename := _newArchive(t, dir,
+		"database.sql", "broken dump",
+		"wp-content/uploads/logo.png", "png",
+	)
+	fixed := filepath.Join(dir, "fixed.sql")
+	err := ioutil.WriteFile(fixed, []byte("CREATE TABLE wp_options;"), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err)
+	}
+
+	// missing files leave the archive alone
+	_, err = Update(filename, "", map[string]string{"database.sql": fixed, "wp-config.php": fixed})
+	if !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+
+	updated, err := Update(filename, "", map[string]string{"./database.sql": fixed})
+	if err != nil || updated != 1 {
+		t.Errorf("Updated %d files instead of 1: %v", updated, err)
+	}
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	content, err := r.ExtractFile("database.sql", ".")
+	if err != nil || string(content) != "CREATE TABLE wp_options;" {
+		t.Errorf("Unexpected content `%s`: %v", content, err)
+	}
+	content, err = r.ExtractFile("logo.png", "wp-content/uploads")
+	if err != nil || string(content) != "png" {
+		t.Errorf("Unexpected content `%s`: %v", content, err)
+	}
+}
+
+// TestUpdateLinks tests replacing files stored as links and files linked to
+func TestUpdateLinks(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+	filename := _newArchive(t, dir,
+		"a.txt", "shared",
+		"b.txt", "other",
+		LinksFileName, `[{"name":"c.txt","source":"a.txt"}]`,
+	)
+	fixed := filepath.Join(dir, "fixed.txt")
+	err := ioutil.WriteFile(fixed, []byte("fixed"), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err)
+	}
+
+	// link keeps the previous content of the replaced file
+	dest := filepath.Join(dir, "source.wpress")
+	updated, err := Update(filename, dest, map[string]string{"a.txt": fixed})
+	if err != nil || updated != 1 {
+		t.Errorf("Updated %d files instead of 1: %v", updated, err)
+	}
+	files := _readFiles(t, dest)
+	if !reflect.DeepEqual(files, map[string]string{"a.txt": "fixed", "b.txt": "other", "c.txt": "shared"}) {
+		t.Errorf("Unexpected contents %v", files)
+	}
+
+	// replaced link is stored as a regular file
+	dest = filepath.Join(dir, "link.wpress")
+	updated, err = Update(filename, dest, map[string]string{"c.txt": fixed})
+	if err != nil || updated != 1 {
+		t.Errorf("Updated %d files instead of 1: %v", updated, err)
+	}
+	expected := []string{"a.txt", "b.txt", "c.txt"}
+	paths := _listPaths(t, dest)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Unexpected files %v", paths)
+	}
+	files = _readFiles(t, dest)
+	if !reflect.DeepEqual(files, map[string]string{"a.txt": "shared", "b.txt": "other", "c.txt": "fixed"}) {
+		t.Errorf("Unexpected contents %v", files)
+	}
+}
+
+// TestConcat tests combining archives
+func TestConcat(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+
+	uploads := filepath.Join(dir, "uploads.wpress")
+	os.Rename(_newArchive(t, dir, "wp-content/uploads/logo.png", "png"), uploads)
+	database := _newArchive(t, dir, "database.sql", "CREATE", "package.json", "{}")
+
+	dest := filepath.Join(dir, "site.wpress")
+	copied, err := Concat(dest, []string{uploads, database})
+	if err != nil || copied != 3 {
+		t.Errorf("Copied %d files instead of 3: %v", copied, err)
+	}
+	expected := []string{"wp-content/uploads/logo.png", "database.sql", "package.json"}
+	paths := _listPaths(t, dest)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Unexpected files %v", paths)
+	}
+
+	// links of the sources are combined
+	linked := filepath.Join(dir, "linked.wpress")
+	os.Rename(_newArchive(t, dir, "a.txt", "a", LinksFileName, `[{"name":"b.txt","source":"a.txt"}]`), linked)
+	other := _newArchive(t, dir, "c.txt", "c", LinksFileName, `[{"name":"d.txt","source":"c.txt"}]`)
+	copied, err = Concat(dest, []string{linked, other})
+	if err != nil || copied != 2 {
+		t.Errorf("Copied %d files instead of 2: %v", copied, err)
+	}
+	expected = []string{"a.txt", "c.txt", LinksFileName}
+	paths = _listPaths(t, dest)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Unexpected files %v", paths)
+	}
+	files := _readFiles(t, dest)
+	if !reflect.DeepEqual(files, map[string]string{"a.txt": "a", "b.txt": "a", "c.txt": "c", "d.txt": "c"}) {
+		t.Errorf("Unexpected contents %v", files)
+	}
+
+	// missing source leaves no archive behind
+	os.Remove(dest)
+	_, err = Concat(dest, []string{uploads, filepath.Join(dir, "missing.wpress")})
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected missing source to fail, got %v", err)
+	}
+	_, err = os.Stat(dest)
+	if !os.IsNotExist(err) {
+		t.Errorf("Archive was created from incomplete sources")
+	}
+}
+
+// TestMerge tests combining archives with duplicate paths
+func TestMerge(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+
+	old := filepath.Join(dir, "old.wpress")
+	os.Rename(_newArchive(t, dir, "database.sql", "old", "wp-config.php", "old"), old)
+
+	// newer archive containing a later database.sql
+	updated := filepath.Join(dir, "updated.wpress")
+	w, err := NewWriter(updated)
+	if err != nil {
+		t.Fatalf("Unable to create archive: %s", err)
+	}
+	for _, name := range []string{"database.sql", "package.json"} {
+		h, _ := NewHeader(name, 3, time.Unix(1420382531, 0).Add(time.Hour))
+		err = w.Add(h, strings.NewReader("new"))
+		if err != nil {
+			t.Fatalf("Unable to add %s: %s", name, err)
+		}
+	}
+	w.Close()
+
+	dest := filepath.Join(dir, "merged.wpress")
+	merged, err := Merge(dest, []string{old, updated}, NewestWins)
+	if err != nil || merged != 3 {
+		t.Errorf("Merged %d files instead of 3: %v", merged, err)
+	}
+	r, _ := NewReader(dest)
+	content, _ := r.ExtractFile("database.sql", ".")
+	r.Close()
+	if string(content) != "new" {
+		t.Errorf("Expected newest database.sql, got %q", content)
+	}
+	expected := []string{"wp-config.php", "database.sql", "package.json"}
+	paths := _listPaths(t, dest)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Unexpected files %v", paths)
+	}
+
+	merged, err = Merge(dest, []string{updated, old}, FirstWins)
+	if err != nil || merged != 3 {
+		t.Errorf("Merged %d files instead of 3: %v", merged, err)
+	}
+	expected = []string{"database.sql", "package.json", "wp-config.php"}
+	paths = _listPaths(t, dest)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Unexpected files %v", paths)
+	}
+
+	// links take part in choosing the files like stored files
+	linked := filepath.Join(dir, "linked.wpress")
+	os.Rename(_newArchive(t, dir, "a.txt", "old", LinksFileName, `[{"name":"b.txt","source":"a.txt"}]`), linked)
+	other := _newArchive(t, dir, "b.txt", "new", "c.txt", "c")
+	for _, test := range []struct {
+		sources  []string
+		merged   int
+		expected []string
+		files    map[string]string
+	}{
+		{[]string{linked, other}, 2, []string{"a.txt", "c.txt", LinksFileName}, map[string]string{"a.txt": "old", "b.txt": "old", "c.txt": "c"}},
+		{[]string{other, linked}, 3, []string{"b.txt", "c.txt", "a.txt"}, map[string]string{"a.txt": "old", "b.txt": "new", "c.txt": "c"}},
+	} {
+		linksDest := filepath.Join(dir, "links.wpress")
+		merged, err = Merge(linksDest, test.sources, FirstWins)
+		if err != nil || merged != test.merged {
+			t.Errorf("Merged %d files instead of %d: %v", merged, test.merged, err)
+		}
+		paths = _listPaths(t, linksDest)
+		if !reflect.DeepEqual(paths, test.expected) {
+			t.Errorf("Unexpected files %v", paths)
+		}
+		files := _readFiles(t, linksDest)
+		if !reflect.DeepEqual(files, test.files) {
+			t.Errorf("Unexpected contents %v", files)
+		}
+	}
+
+	// duplicate fails without replacing the previous result
+	_, err = Merge(dest, []string{old, updated}, FailOnDuplicate)
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrDuplicateEntry {
+		t.Errorf("Expected ErrDuplicateEntry, got %v", err)
+	}
+	paths = _listPaths(t, dest)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Failed merge modified the archive %v", paths)
+	}
+}
+
+// TestEditManifest tests rebuilding embedded manifests of edited archives
+func TestEditManifest(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+
+	// archives with embedded manifests
+	var sources []string
+	for _, name := range []string{"uploads.wpress", "database.wpress"} {
+		filename := filepath.Join(dir, name)
+		os.Rename(_newArchiveWith(t, dir, func(w *Writer) { w.Manifest = true },
+			"a-"+name, "a-"+name, "b-"+name, "b-"+name), filename)
+		sources = append(sources, filename)
+	}
+
+	// verify checks the archive against a manifest listing the new files
+	verify := func(filename string, expected []string) {
+		r, err := NewReader(filename)
+		if err != nil {
+			t.Fatalf("Failed to create a new Reader instance: %s", err)
+		}
+		defer r.Close()
+		err = r.Verify()
+		if err != nil {
+			t.Errorf("Failed to verify %s: %s", filepath.Base(filename), err)
+		}
+		m, err := r.Manifest()
+		var paths []string
+		if err == nil {
+			for _, f := range m.Files {
+				paths = append(paths, f.Path)
+			}
+		}
+		if !reflect.DeepEqual(paths, expected) {
+			t.Errorf("Unexpected manifest of %s %v: %v", filepath.Base(filename), paths, err)
+		}
+	}
+
+	dest := filepath.Join(dir, "removed.wpress")
+	removed, err := Remove(sources[0], dest, []string{"a-*"})
+	if err != nil || removed != 1 {
+		t.Errorf("Removed %d files instead of 1: %v", removed, err)
+	}
+	verify(dest, []string{"b-uploads.wpress"})
+
+	dest = filepath.Join(dir, "site.wpress")
+	copied, err := Concat(dest, sources)
+	if err != nil || copied != 4 {
+		t.Errorf("Copied %d files instead of 4: %v", copied, err)
+	}
+	verify(dest, []string{"a-uploads.wpress", "b-uploads.wpress", "a-database.wpress", "b-database.wpress"})
+}
+
+// TestRepack tests writing a filtered subset of archive
+func TestRepack(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+
+	archive := _newArchive(t, dir,
+		"wp-content/uploads/2023/logo.png", "png",
+		"wp-content/uploads/cache/page.html", "html",
+		"wp-content/plugins/akismet/akismet.php", "php",
+		"database.sql", "CREATE")
+
+	dest := filepath.Join(dir, "uploads.wpress")
+	selected, err := Repack(archive, dest, Filter{
+		Include: []string{"wp-content/uploads/**"},
+		Exclude: []string{"**/cache/**"},
+	})
+	if err != nil || selected != 1 {
+		t.Errorf("Selected %d files instead of 1: %v", selected, err)
+	}
+	expected := []string{"wp-content/uploads/2023/logo.png"}
+	paths := _listPaths(t, dest)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Unexpected files %v", paths)
+	}
+
+	// source is left untouched
+	if len(_listPaths(t, archive)) != 4 {
+		t.Errorf("Source archive was modified")
+	}
+
+	_, err = Repack(archive, dest, Filter{Include: []string{"["}})
+	if err == nil {
+		t.Errorf("Expected malformed pattern to fail")
+	}
+}
+
+// TestRepackWithOptions tests changing contents of files while repacking
+func TestRepackWithOptions(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+
+	archive := _newArchive(t, dir,
+		"database.sql", "INSERT INTO wp_options VALUES ('siteurl', 'http://old.test');",
+		"wp-content/themes/style.css", "body { background: url(http://old.test/bg.png); }",
+		"wp-content/cache/page.html", "http://old.test")
+
+	dest := filepath.Join(dir, "moved.wpress")
+	selected, err := RepackWithOptions(archive, dest, RepackOptions{
+		Filter: Filter{Exclude: []string{"wp-content/cache"}},
+		Transforms: []Transform{{"database.sql", func(name string, content io.Reader) (io.Reader, error) {
+			b, err := ioutil.ReadAll(content)
+			return strings.NewReader(strings.Replace(string(b), "http://old.test", "https://new.example.com", -1)), err
+		}}},
+	})
+	if err != nil || selected != 2 {
+		t.Errorf("Selected %d files instead of 2: %v", selected, err)
+	}
+
+	r, err := NewReader(dest)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	content, _ := r.ExtractFile("database.sql", ".")
+	if string(content) != "INSERT INTO wp_options VALUES ('siteurl', 'https://new.example.com');" {
+		t.Errorf("Unexpected content `%s`", content)
+	}
+	content, _ = r.ExtractFile("style.css", "wp-content/themes")
+	if !strings.Contains(string(content), "http://old.test") {
+		t.Errorf("Transform was applied to unmatched file `%s`", content)
+	}
+}
@@ -0,0 +1,586 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrInvalidKey is returned when encryption has neither a key of 32 bytes
+// nor a passphrase
+var ErrInvalidKey = errors.New("encryption key must be 32 bytes long")
+
+// ErrDecryption is returned when encrypted archive can't be decrypted,
+// because the key is wrong or the archive was modified or truncated
+var ErrDecryption = errors.New("archive can't be decrypted with the key")
+
+// ErrUnsupportedKDF is returned when the key derivation is not available in
+// this build
+var ErrUnsupportedKDF = errors.New("key derivation is not supported by this build")
+
+// ErrEncrypted is returned when reading encrypted archive without a key
+var ErrEncrypted = errors.New("archive is encrypted")
+
+// ErrNotEncrypted is returned when reading plain archive with a key, so an
+// encrypted archive replaced by a plain one isn't trusted
+var ErrNotEncrypted = errors.New("archive is not encrypted")
+
+// Encryption holds the key archive is encrypted with using AES-256-GCM.
+// Key is a random key of 32 bytes, when it is empty the key is derived from
+// Passphrase by KDF. Salt and parameters of the derivation are stored in
+// the archive, so only Passphrase is needed to decrypt it.
+type Encryption struct {
+	Key        []byte
+	Passphrase string
+	KDF        KDF
+}
+
+// KDF tells how the key is derived from passphrase
+type KDF int
+
+const (
+	// PBKDF2 derives the key by PBKDF2-SHA256, it is the default
+	PBKDF2 KDF = iota
+	// Argon2id derives the key by memory-hard argon2id, which resists
+	// guessing passphrases on GPUs far better. It requires building with
+	// the argon2 tag, which adds the dependency on golang.org/x/crypto.
+	Argon2id
+)
+
+// passphraseKDF derives keys from passphrases, id identifies it in the
+// prologue and params returns parameters of new derivations
+type passphraseKDF struct {
+	id     byte
+	params func() []byte
+	derive func(passphrase string, salt []byte, params []byte) ([]byte, error)
+}
+
+// kdfs holds the key derivations available in this build
+var kdfs = map[KDF]passphraseKDF{
+	PBKDF2: {
+		id: kdfPBKDF2,
+		params: func() []byte {
+			return binary.BigEndian.AppendUint32(nil, pbkdf2Iterations)
+		},
+		derive: func(passphrase string, salt []byte, params []byte) ([]byte, error) {
+			if len(params) != 4 {
+				return nil, ErrDecryption
+			}
+			iterations := binary.BigEndian.Uint32(params)
+			if iterations == 0 || iterations > maxPBKDF2Iterations {
+				return nil, ErrDecryption
+			}
+			return pbkdf2.Key(sha256.New, passphrase, salt, int(iterations), 32)
+		},
+	},
+}
+
+// Encrypted archive format
+// Field         Length    Contents
+// Magic             10    encryptionMagic
+// KDF                1    how the key is derived, see kdfNone
+// Params            1+    length and parameters of the key derivation
+// Salt              16    random salt of the key derivation
+// Nonce prefix       7    random prefix of the nonces
+// Chunk size         4    length of plain chunks as big-endian uint32
+// Chunks                  sealed chunks of the plain archive
+// Every chunk is sealed with the prologue as additional data and the nonce
+// made of the prefix, the big-endian uint32 index of the chunk and a byte
+// set to 1 for the last chunk, so chunks can't be reordered, dropped or
+// appended. Archive without files is a single empty last chunk.
+const encryptionMagic = "WPRESSENC\x01"
+
+const (
+	encryptionChunkSize = 64 << 10 // length of plain chunks
+	saltSize            = 16       // length of the key derivation salt
+	noncePrefixSize     = 7        // length of the random nonce prefix
+)
+
+// key derivations stored in the prologue
+const (
+	kdfNone     = 0 // Key is used as it is
+	kdfPBKDF2   = 1 // PBKDF2-SHA256 of Passphrase, params hold iterations
+	kdfArgon2id = 2 // argon2id of Passphrase, params hold time, memory and threads
+)
+
+// pbkdf2Iterations is the number of iterations of PBKDF2-SHA256
+const pbkdf2Iterations = 600000
+
+// maxPBKDF2Iterations guards against prologues making decryption hang
+const maxPBKDF2Iterations = 1 << 26
+
+// prologue describes how archive is encrypted
+type prologue struct {
+	kdf         byte
+	params      []byte
+	salt        []byte
+	noncePrefix []byte
+	chunkSize   int
+}
+
+// newPrologue creates prologue of archive encrypted by enc with random
+// salt and nonce prefix
+func (enc Encryption) newPrologue() (*prologue, error) {
+	p := &prologue{
+		salt:        make([]byte, saltSize),
+		noncePrefix: make([]byte, noncePrefixSize),
+		chunkSize:   encryptionChunkSize,
+	}
+	if len(enc.Key) == 0 {
+		kdf, ok := kdfs[enc.KDF]
+		if !ok {
+			return nil, ErrUnsupportedKDF
+		}
+		p.kdf, p.params = kdf.id, kdf.params()
+	}
+
+	_, err := rand.Read(p.salt)
+	if err == nil {
+		_, err = rand.Read(p.noncePrefix)
+	}
+	return p, err
+}
+
+// bytes encodes the prologue
+func (p *prologue) bytes() []byte {
+	b := append([]byte(encryptionMagic), p.kdf, byte(len(p.params)))
+	b = append(b, p.params...)
+	b = append(b, p.salt...)
+	b = append(b, p.noncePrefix...)
+	return binary.BigEndian.AppendUint32(b, uint32(p.chunkSize))
+}
+
+// readPrologue decodes the prologue from the beginning of src,
+// ErrNotEncrypted is returned when src doesn't start with one
+func readPrologue(src io.Reader) (*prologue, error) {
+	magic := make([]byte, len(encryptionMagic)+2)
+	_, err := io.ReadFull(src, magic)
+	if err != nil || string(magic[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, ErrNotEncrypted
+	}
+
+	p := &prologue{kdf: magic[len(encryptionMagic)]}
+	rest := make([]byte, int(magic[len(encryptionMagic)+1])+saltSize+noncePrefixSize+4)
+	_, err = io.ReadFull(src, rest)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	p.params, rest = rest[:len(rest)-saltSize-noncePrefixSize-4], rest[len(rest)-saltSize-noncePrefixSize-4:]
+	p.salt, p.noncePrefix = rest[:saltSize], rest[saltSize:saltSize+noncePrefixSize]
+	p.chunkSize = int(binary.BigEndian.Uint32(rest[saltSize+noncePrefixSize:]))
+	if p.chunkSize <= 0 || p.chunkSize > 1<<24 {
+		return nil, ErrDecryption
+	}
+	return p, nil
+}
+
+// deriveKey returns the key derived from enc as the prologue tells
+func (p *prologue) deriveKey(enc Encryption) ([]byte, error) {
+	if p.kdf == kdfNone {
+		if len(enc.Key) != 32 {
+			return nil, ErrInvalidKey
+		}
+		return enc.Key, nil
+	}
+	if enc.Passphrase == "" {
+		return nil, ErrDecryption
+	}
+
+	for _, kdf := range kdfs {
+		if kdf.id == p.kdf {
+			return kdf.derive(enc.Passphrase, p.salt, p.params)
+		}
+	}
+	if p.kdf == kdfArgon2id {
+		return nil, ErrUnsupportedKDF
+	}
+	return nil, ErrDecryption
+}
+
+// chunkCipher seals and opens chunks of encrypted archive
+type chunkCipher struct {
+	aead     cipher.AEAD
+	prologue []byte
+	prefix   []byte
+}
+
+// newChunkCipher creates cipher of archive encrypted by enc described by
+// p, the key of every archive is derived from the key and the random salt
+func newChunkCipher(enc Encryption, p *prologue) (*chunkCipher, error) {
+	key, err := p.deriveKey(enc)
+	if err != nil {
+		return nil, err
+	}
+	key, err = hkdf.Key(sha256.New, key, p.salt, "wpress archive", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkCipher{aead, p.bytes(), p.noncePrefix}, nil
+}
+
+// nonce returns nonce of the chunk of index
+func (c *chunkCipher) nonce(index int64, last bool) []byte {
+	nonce := binary.BigEndian.AppendUint32(append([]byte{}, c.prefix...), uint32(index))
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// seal appends the sealed chunk of index to dst
+func (c *chunkCipher) seal(dst []byte, chunk []byte, index int64, last bool) ([]byte, error) {
+	if index > 1<<32-1 {
+		return nil, errors.New("archive is too large to encrypt")
+	}
+	return c.aead.Seal(dst, c.nonce(index, last), chunk, c.prologue), nil
+}
+
+// open appends the plain chunk of index to dst
+func (c *chunkCipher) open(dst []byte, sealed []byte, index int64, last bool) ([]byte, error) {
+	if index > 1<<32-1 {
+		return nil, ErrDecryption
+	}
+	plain, err := c.aead.Open(dst, c.nonce(index, last), sealed, c.prologue)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return plain, nil
+}
+
+// encrypter encrypts the archive written to it in chunks, the last chunk
+// is written on Close
+type encrypter struct {
+	dest   io.Writer
+	cipher *chunkCipher
+	chunk  []byte
+	sealed []byte
+	index  int64
+	size   int
+}
+
+// startEncryption wraps the destination in the encrypter before anything
+// is written, it writes the prologue
+func (w *Writer) startEncryption() error {
+	if w.encrypter != nil {
+		return nil
+	}
+
+	// encryption provided by other packages, see NewAgeWriter
+	if w.encrypt != nil {
+		enc, err := w.encrypt(w.dest)
+		if err != nil {
+			return err
+		}
+		w.encrypter, w.dest = enc, enc
+		return nil
+	}
+	if w.Encryption == nil {
+		return nil
+	}
+
+	p, err := w.Encryption.newPrologue()
+	if err != nil {
+		return err
+	}
+	c, err := newChunkCipher(*w.Encryption, p)
+	if err != nil {
+		return err
+	}
+	_, err = w.dest.Write(c.prologue)
+	if err != nil {
+		return err
+	}
+	enc := &encrypter{dest: w.dest, cipher: c, chunk: make([]byte, 0, p.chunkSize), size: p.chunkSize}
+	w.encrypter, w.dest = enc, enc
+	return nil
+}
+
+// Write buffers b, chunks are sealed once it is known they are not the last
+func (e *encrypter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		if len(e.chunk) == e.size {
+			err := e.flush(false)
+			if err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.chunk[len(e.chunk):e.size], b)
+		e.chunk = e.chunk[:len(e.chunk)+n]
+		b = b[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// flush seals and writes the buffered chunk
+func (e *encrypter) flush(last bool) error {
+	sealed, err := e.cipher.seal(e.sealed[:0], e.chunk, e.index, last)
+	if err != nil {
+		return err
+	}
+	e.sealed = sealed
+	_, err = e.dest.Write(sealed)
+	if err != nil {
+		return err
+	}
+	e.chunk = e.chunk[:0]
+	e.index++
+	return nil
+}
+
+// Close writes the last chunk, the destination is left open
+func (e *encrypter) Close() error {
+	return e.flush(true)
+}
+
+// decrypter decrypts chunks of encrypted archive read at random offsets
+type decrypter struct {
+	src    io.ReaderAt
+	cipher *chunkCipher
+	// position of the first chunk, length of plain chunks, number of chunks
+	// and length of the plain archive
+	start  int64
+	size   int64
+	chunks int64
+	plain  int64
+
+	// the last decrypted chunk
+	mu     sync.Mutex
+	index  int64
+	chunk  []byte
+	sealed []byte
+}
+
+// newDecrypter creates decrypter of archive of size bytes encrypted by enc
+// read from src
+func newDecrypter(src io.ReaderAt, size int64, enc Encryption) (*decrypter, error) {
+	p, err := readPrologue(io.NewSectionReader(src, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	c, err := newChunkCipher(enc, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// the last chunk may be shorter, but holds at least the tag
+	d := &decrypter{src: src, cipher: c, start: int64(len(c.prologue)), size: int64(p.chunkSize), index: -1}
+	sealedSize := d.size + int64(c.aead.Overhead())
+	body := size - d.start
+	d.chunks = (body + sealedSize - 1) / sealedSize
+	if d.chunks == 0 || body-(d.chunks-1)*sealedSize < int64(c.aead.Overhead()) {
+		return nil, ErrDecryption
+	}
+	d.plain = body - d.chunks*int64(c.aead.Overhead())
+
+	// the last chunk tells whether the key is right and archive complete
+	_, err = d.load(d.chunks - 1)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Size returns length of the plain archive
+func (d *decrypter) Size() int64 {
+	return d.plain
+}
+
+// ReadAt reads the plain archive at offset
+func (d *decrypter) ReadAt(b []byte, offset int64) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := 0
+	for n < len(b) {
+		if offset >= d.plain {
+			return n, io.EOF
+		}
+		chunk, err := d.load(offset / d.size)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(b[n:], chunk[offset%d.size:])
+		n += copied
+		offset += int64(copied)
+	}
+	return n, nil
+}
+
+// load decrypts the chunk of index unless it was the last one decrypted
+func (d *decrypter) load(index int64) ([]byte, error) {
+	if index == d.index {
+		return d.chunk, nil
+	}
+
+	overhead := int64(d.cipher.aead.Overhead())
+	sealedSize := d.size + overhead
+	length := sealedSize
+	if index == d.chunks-1 {
+		length = d.plain - index*d.size + overhead
+	}
+	if int64(cap(d.sealed)) < length {
+		d.sealed = make([]byte, sealedSize)
+	}
+	sealed := d.sealed[:length]
+	n, err := d.src.ReadAt(sealed, d.start+index*sealedSize)
+	if n < len(sealed) {
+		return nil, err
+	}
+
+	d.index = -1
+	d.chunk, err = d.cipher.open(d.chunk[:0], sealed, index, index == d.chunks-1)
+	if err != nil {
+		return nil, err
+	}
+	d.index = index
+	return d.chunk, nil
+}
+
+// chunkReader decrypts chunks of encrypted archive read sequentially
+type chunkReader struct {
+	src    *bufio.Reader
+	cipher *chunkCipher
+	size   int
+	index  int64
+	chunk  []byte
+	sealed []byte
+	done   bool
+}
+
+// Decrypt returns reader of the plain archive encrypted by enc read
+// sequentially from src, e.g. to pass it to NewReaderFrom. Reading fails
+// with ErrDecryption when content was modified or the archive is truncated.
+func Decrypt(src io.Reader, enc Encryption) (io.Reader, error) {
+	p, err := readPrologue(src)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newChunkCipher(enc, p)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{
+		src:    bufio.NewReaderSize(src, p.chunkSize+c.aead.Overhead()+1),
+		cipher: c,
+		size:   p.chunkSize,
+	}, nil
+}
+
+// Read reads the plain archive
+func (r *chunkReader) Read(b []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk, it is the last one when the archive ends
+// after it
+func (r *chunkReader) next() error {
+	if r.sealed == nil {
+		r.sealed = make([]byte, r.size+r.cipher.aead.Overhead())
+	}
+	n, err := io.ReadFull(r.src, r.sealed)
+	if err == io.EOF {
+		return ErrDecryption
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if err == nil {
+		_, err = r.src.Peek(1)
+	}
+	last := err != nil
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	r.chunk, err = r.cipher.open(r.chunk[:0], r.sealed[:n], r.index, last)
+	if err != nil {
+		return err
+	}
+	r.index++
+	r.done = last
+	return nil
+}
+
+// ageMagic starts archives encrypted by age, see NewAgeReader
+const ageMagic = "age-encryption.org/"
+
+// decrypt returns source of the plain archive of size bytes read from file
+// name, encrypted archives are decrypted by Encryption of r
+func (r *Reader) decrypt(file archiveSource, size int64, name string) (archiveSource, error) {
+	magic := make([]byte, len(ageMagic))
+	n, _ := file.ReadAt(magic, 0)
+	if bytes.HasPrefix(magic[:n], []byte(ageMagic)) {
+		return nil, &os.PathError{Op: "read", Path: name, Err: ErrEncrypted}
+	}
+	encrypted := bytes.HasPrefix(magic[:n], []byte(encryptionMagic))
+	if !encrypted && r.Encryption == nil {
+		return file, nil
+	}
+	if !encrypted {
+		return nil, &os.PathError{Op: "read", Path: name, Err: ErrNotEncrypted}
+	}
+	if r.Encryption == nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: ErrEncrypted}
+	}
+
+	d, err := newDecrypter(file, size, *r.Encryption)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: err}
+	}
+	return io.NewSectionReader(d, 0, d.Size()), nil
+}
@@ -0,0 +1,206 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEncryption tests writing and reading archives encrypted by a key
+func TestEncryption(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	key := bytes.Repeat([]byte{7}, 32)
+	database := strings.Repeat("INSERT INTO wp_options VALUES ('siteurl');\n", 5000)
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	w.Encryption = &Encryption{Key: key}
+	w.TOC = true
+	err = w.AddDirectoryWithOptions(_getPathToTests(t), AddOptions{})
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	h, _ := NewHeader("database.sql", int64(len(database)), time.Now())
+	err = w.Add(h, strings.NewReader(database))
+	if err != nil {
+		t.Errorf("Failed to add database.sql: %s", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("Failed to close archive: %s", err)
+	}
+
+	archive, _ := ioutil.ReadFile(filename)
+	if bytes.Contains(archive, []byte("wp_options")) {
+		t.Errorf("Archive is not encrypted")
+	}
+
+	// encrypted archive is read at random offsets
+	r, err := NewEncryptedReader(filename, Encryption{Key: key})
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	content, err := r.ExtractFile("database.sql", ".")
+	if err != nil || string(content) != database {
+		t.Errorf("Unexpected content of database.sql: %v", err)
+	}
+	dest := filepath.Join(tempPath, "restored")
+	filesCount, err := r.ExtractWithOptions(ExtractOptions{DestDir: dest, Workers: 4})
+	if err != nil || filesCount != w.FilesAdded {
+		t.Errorf("Extracted %d files instead of %d: %v", filesCount, w.FilesAdded, err)
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(dest, "lipsum.txt"))
+	expected, _ := ioutil.ReadFile(filepath.Join(_getPathToTests(t), "lipsum.txt"))
+	if !bytes.Equal(content, expected) {
+		t.Errorf("Unexpected content `%s`", content)
+	}
+
+	// the key is needed and it has to be the right one
+	_, err = NewReader(filename)
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrEncrypted {
+		t.Errorf("Expected ErrEncrypted, got %v", err)
+	}
+	_, err = NewEncryptedReader(filename, Encryption{Key: bytes.Repeat([]byte{8}, 32)})
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrDecryption {
+		t.Errorf("Expected ErrDecryption, got %v", err)
+	}
+
+	// archive cut at a chunk boundary is detected
+	sealedSize := encryptionChunkSize + 16
+	truncated := filepath.Join(tempPath, "truncated.wpress")
+	ioutil.WriteFile(truncated, archive[:len(archive)-(len(archive)-len(encryptionMagic)-29)%sealedSize], 0644)
+	_, err = NewEncryptedReader(truncated, Encryption{Key: key})
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrDecryption {
+		t.Errorf("Expected ErrDecryption, got %v", err)
+	}
+
+	// archives encrypted by age are recognized too
+	encrypted := filepath.Join(tempPath, "output.wpress.age")
+	ioutil.WriteFile(encrypted, []byte("age-encryption.org/v1\n"), 0644)
+	_, err = NewReader(encrypted)
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrEncrypted {
+		t.Errorf("Expected ErrEncrypted, got %v", err)
+	}
+
+	// plain archive is not trusted when encryption is expected
+	_, err = NewEncryptedReader(_newArchive(t, tempPath, "a.txt", "a"), Encryption{Key: key})
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrNotEncrypted {
+		t.Errorf("Expected ErrNotEncrypted, got %v", err)
+	}
+}
+
+// TestEncryptionPassphrase tests streaming archives encrypted by a key
+// derived from passphrase
+func TestEncryptionPassphrase(t *testing.T) {
+	enc := Encryption{Passphrase: "correct horse battery staple"}
+	var archive bytes.Buffer
+	w := NewWriterTo(&archive)
+	w.Encryption = &enc
+	h, _ := NewHeader("wp-config.php", 9, time.Now())
+	err := w.Add(h, strings.NewReader("<?php // "))
+	if err != nil {
+		t.Errorf("Failed to add wp-config.php: %s", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("Failed to close archive: %s", err)
+	}
+
+	src, err := Decrypt(bytes.NewReader(archive.Bytes()), enc)
+	if err != nil {
+		t.Fatalf("Failed to decrypt archive: %s", err)
+	}
+	r := NewReaderFrom(src)
+	h, err = r.Next()
+	if err != nil || h.GetPath() != "wp-config.php" {
+		t.Fatalf("Unexpected first file: %v", err)
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil || string(content) != "<?php // " {
+		t.Errorf("Unexpected content `%s`: %v", content, err)
+	}
+
+	// modified content fails authentication
+	modified := append([]byte{}, archive.Bytes()...)
+	modified[len(modified)-20] ^= 1
+	src, _ = Decrypt(bytes.NewReader(modified), enc)
+	_, err = ioutil.ReadAll(src)
+	if err != ErrDecryption {
+		t.Errorf("Expected ErrDecryption, got %v", err)
+	}
+
+	// archive without files is encrypted too
+	archive.Reset()
+	w = NewWriterTo(&archive)
+	w.Encryption = &Encryption{Key: bytes.Repeat([]byte{7}, 32)}
+	w.Close()
+	src, err = Decrypt(&archive, *w.Encryption)
+	if err != nil {
+		t.Fatalf("Failed to decrypt archive: %s", err)
+	}
+	content, err = ioutil.ReadAll(src)
+	if err != nil || len(content) != 0 {
+		t.Errorf("Unexpected content of archive without files: %v", err)
+	}
+}
+
+// TestUnsupportedKDF tests key derivations missing from the build
+func TestUnsupportedKDF(t *testing.T) {
+	if _, ok := kdfs[Argon2id]; ok {
+		t.Skip("argon2id is supported by this build")
+	}
+
+	enc := Encryption{Passphrase: "secret", KDF: Argon2id}
+	w := NewWriterTo(ioutil.Discard)
+	w.Encryption = &enc
+	h, _ := NewHeader("wp-config.php", 0, time.Now())
+	err := w.Add(h, bytes.NewReader(nil))
+	if err != ErrUnsupportedKDF {
+		t.Errorf("Expected ErrUnsupportedKDF, got %v", err)
+	}
+
+	// prologue telling the key was derived by argon2id
+	var archive bytes.Buffer
+	w = NewWriterTo(&archive)
+	w.Encryption = &Encryption{Passphrase: "secret"}
+	w.Close()
+	prologue := archive.Bytes()
+	prologue[len(encryptionMagic)] = kdfArgon2id
+	_, err = Decrypt(bytes.NewReader(prologue), enc)
+	if err != ErrUnsupportedKDF {
+		t.Errorf("Expected ErrUnsupportedKDF, got %v", err)
+	}
+}
@@ -0,0 +1,313 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+)
+
+// EntryInfo describes a file stored in archive
+type EntryInfo struct {
+	Name         string            // filename
+	Prefix       string            // path name of the file
+	Size         int64             // length of file contents as stored
+	ModTime      time.Time         // last modification date
+	Offset       int64             // position of file contents in archive
+	Compression  Compression       // compression of file contents
+	OriginalSize int64             // length of file contents once decompressed
+	Metadata     map[string]string // key/value metadata of the file
+}
+
+// ErrDuplicateEntry is returned when the same path appears in archive more
+// than once and DuplicateError policy is used
+var ErrDuplicateEntry = errors.New("file appears in archive more than once")
+
+// DuplicatePolicy tells what to do with a path appearing in archive more than
+// once, which happens when archives are appended to
+type DuplicatePolicy int
+
+const (
+	// KeepLast keeps the last occurrence, it is the default
+	KeepLast DuplicatePolicy = iota
+	// KeepFirst keeps the first occurrence and ignores the others
+	KeepFirst
+	// DuplicateError fails with ErrDuplicateEntry
+	DuplicateError
+	// KeepBoth keeps all occurrences, the later ones are renamed to
+	// "name.N.ext"
+	KeepBoth
+)
+
+// duplicates tracks paths seen while iterating over archive
+type duplicates struct {
+	policy DuplicatePolicy
+	seen   map[string]bool
+}
+
+// check applies the policy to name and returns the name the file should be
+// kept as and whether it should be kept at all
+func (d *duplicates) check(name string) (string, bool, error) {
+	if d.seen == nil {
+		d.seen = make(map[string]bool)
+	}
+	if !d.seen[name] {
+		d.seen[name] = true
+		return name, true, nil
+	}
+
+	switch d.policy {
+	case KeepFirst:
+		return name, false, nil
+	case DuplicateError:
+		return name, false, &os.PathError{Op: "read", Path: name, Err: ErrDuplicateEntry}
+	case KeepBoth:
+		// find the first numbered name which wasn't seen
+		renamed := numberedName(name, 1)
+		for n := 2; d.seen[renamed]; n++ {
+			renamed = numberedName(name, n)
+		}
+		d.seen[renamed] = true
+		return renamed, true, nil
+	}
+
+	return name, true, nil
+}
+
+// newEntryInfo creates EntryInfo from header and position of file contents
+func newEntryInfo(h *Header, offset int64) (EntryInfo, error) {
+	size, err := h.GetSize64()
+	if err != nil {
+		return EntryInfo{}, err
+	}
+
+	// fall back to zero time when mtime can't be parsed
+	var modTime time.Time
+	mtime, err := h.GetModTime()
+	if err == nil {
+		modTime = mtime
+	}
+
+	originalSize, err := h.GetOriginalSize()
+	if err != nil {
+		return EntryInfo{}, err
+	}
+
+	return EntryInfo{h.GetName(), h.GetPrefix(), size, modTime, offset, h.GetCompression(), originalSize, h.GetMetadata()}, nil
+}
+
+// Path returns cleaned relative path of the file, prefix and name joined
+func (e EntryInfo) Path() string {
+	return path.Clean("." + string(os.PathSeparator) + e.Prefix + string(os.PathSeparator) + e.Name)
+}
+
+// WalkFunc is called by Walk for every file in archive
+type WalkFunc func(e EntryInfo) error
+
+// Walk calls fn for every file in archive, content of the files is skipped.
+// Walking stops at the first error returned by fn, which is then returned
+// by Walk, unless the error is fs.SkipAll which stops walking silently.
+func (r *Reader) Walk(fn WalkFunc) error {
+	return r.WalkContext(context.Background(), fn)
+}
+
+// WalkContext is like Walk but stops when ctx is done
+func (r *Reader) WalkContext(ctx context.Context, fn WalkFunc) error {
+	// iterate over the index when it was built
+	entries, _ := r.indexed()
+	if entries != nil {
+		return walkEntries(ctx, entries, fn)
+	}
+
+	// get a cursor at the beginning of the file
+	c, err := r.scan()
+	if err != nil {
+		return err
+	}
+
+	// loop until end of file was reached
+	for {
+		// stop between files when cancelled
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// read next header, content of the file is skipped
+		h, err := c.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// pointer is at the beginning of the file content
+		e, err := newEntryInfo(h, c.offset)
+		if err != nil {
+			return err
+		}
+
+		err = fn(e)
+		if err == fs.SkipAll {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// walkEntries calls fn for every entry like WalkContext
+func walkEntries(ctx context.Context, entries []EntryInfo, fn WalkFunc) error {
+	for _, e := range entries {
+		// stop between files when cancelled
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := fn(e)
+		if err == fs.SkipAll {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListEntries returns metadata of all files in archive without extracting
+// them
+func (r *Reader) ListEntries() ([]EntryInfo, error) {
+	var entries []EntryInfo
+
+	err := r.Walk(func(e EntryInfo) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return entries, err
+	}
+
+	// we have enumerated the whole archive
+	r.setFilesCount(len(entries))
+
+	return entries, nil
+}
+
+// Glob returns metadata of all files in archive whose path matches pattern,
+// see path.Match for the pattern syntax, additionally "**" matches any number
+// of directories, e.g. "wp-content/uploads/2023/**"
+func (r *Reader) Glob(pattern string) ([]EntryInfo, error) {
+	// validate the pattern before scanning the archive
+	_, err := matchPattern(pattern, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []EntryInfo
+	err = r.Walk(func(e EntryInfo) error {
+		matched, _ := matchPattern(pattern, e.Path())
+		if matched {
+			entries = append(entries, e)
+		}
+		return nil
+	})
+
+	return entries, err
+}
+
+// ListMatching returns metadata of all files in archive selected by f
+func (r *Reader) ListMatching(f Filter) ([]EntryInfo, error) {
+	// validate the patterns before scanning the archive
+	err := f.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []EntryInfo
+	err = r.Walk(func(e EntryInfo) error {
+		if f.Match(e.Path()) {
+			entries = append(entries, e)
+		}
+		return nil
+	})
+
+	return entries, err
+}
+
+// ListDistinct returns metadata of all files in archive like ListEntries and
+// applies policy to paths appearing more than once. Entries renamed by
+// KeepBoth policy have the number inserted in Name.
+func (r *Reader) ListDistinct(policy DuplicatePolicy) ([]EntryInfo, error) {
+	entries, err := r.ListEntries()
+	if err != nil {
+		return entries, err
+	}
+
+	d := &duplicates{policy: policy}
+	if policy == KeepLast {
+		// iterate backwards, so the last occurrence is seen first
+		d.policy = KeepFirst
+		var distinct []EntryInfo
+		for i := len(entries) - 1; i >= 0; i-- {
+			_, keep, _ := d.check(entries[i].Path())
+			if keep {
+				distinct = append(distinct, entries[i])
+			}
+		}
+
+		// restore the archive order
+		for i, j := 0, len(distinct)-1; i < j; i, j = i+1, j-1 {
+			distinct[i], distinct[j] = distinct[j], distinct[i]
+		}
+		return distinct, nil
+	}
+
+	var distinct []EntryInfo
+	for _, e := range entries {
+		name, keep, err := d.check(e.Path())
+		if err != nil {
+			return distinct, err
+		}
+		if keep {
+			e.Name = path.Base(name)
+			distinct = append(distinct, e)
+		}
+	}
+	return distinct, nil
+}
+
+// Stat returns metadata of the file matching name (prefix and filename
+// joined), ErrFileNotFound is returned when there is no such file
+func (r *Reader) Stat(name string) (EntryInfo, error) {
+	_, e, err := r.findFile(name)
+	return e, err
+}
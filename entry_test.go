@@ -0,0 +1,184 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"regexp"
+	"testing"
+)
+
+// TestListEntries tests listing metadata of files in archive
+func TestListEntries(t *testing.T) {
+	path := _getPathToTests(t)
+	// create a new reader instace with the test archive
+	r, err := NewReader(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+
+	entries, err := r.ListEntries()
+	if err != nil {
+		t.Fatalf("Unable to list entries: %s", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("The archive contains %d files instead of 3", len(entries))
+	}
+
+	e := entries[1]
+	if e.Name != "lipsum.txt" || e.Prefix != "/repos/wpress/testdata" {
+		t.Errorf("Unexpected name `%s` and prefix `%s`", e.Name, e.Prefix)
+	}
+	if e.Path() != "repos/wpress/testdata/lipsum.txt" {
+		t.Errorf("Unexpected path `%s`", e.Path())
+	}
+	if e.Size != 1478 || e.ModTime.Unix() != 1420382531 {
+		t.Errorf("Unexpected size %d and mtime %s", e.Size, e.ModTime)
+	}
+
+	// second file contents start after two headers and the first file
+	if e.Offset != 2*headerSize+2313 {
+		t.Errorf("Unexpected offset %d", e.Offset)
+	}
+}
+
+// TestWalk tests walking over files in archive
+func TestWalk(t *testing.T) {
+	path := _getPathToTests(t)
+	// create a new reader instace with the test archive
+	r, err := NewReader(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+
+	// stop walking after the second file
+	var names []string
+	err = r.Walk(func(e EntryInfo) error {
+		names = append(names, e.Name)
+		if len(names) == 2 {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil || len(names) != 2 {
+		t.Errorf("Walked %d files instead of 2: %v", len(names), err)
+	}
+
+	// errors returned by the callback are passed to the caller
+	stop := errors.New("stop")
+	err = r.Walk(func(e EntryInfo) error {
+		return stop
+	})
+	if err != stop {
+		t.Errorf("Expected callback error, got %v", err)
+	}
+}
+
+// TestGlob tests looking up files matching a pattern
+func TestGlob(t *testing.T) {
+	path := _getPathToTests(t)
+	// create a new reader instace with the test archive
+	r, err := NewReader(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+
+	patterns := map[string]int{
+		"repos/wpress/testdata/*.svg": 1,
+		"repos/wpress/*":              0,
+		"repos/**":                    3,
+		"**/logo*":                    2,
+		"/repos/**/testdata/*.txt":    1,
+	}
+	for pattern, expected := range patterns {
+		entries, err := r.Glob(pattern)
+		if err != nil {
+			t.Errorf("Unable to glob `%s`: %s", pattern, err)
+		}
+		if len(entries) != expected {
+			t.Errorf("`%s` matched %d files instead of %d", pattern, len(entries), expected)
+		}
+	}
+
+	// invalid pattern returns an error
+	_, err = r.Glob("repos/[")
+	if err == nil {
+		t.Errorf("Invalid pattern didn't return an error")
+	}
+}
+
+// TestListMatching tests listing files selected by regular expressions
+func TestListMatching(t *testing.T) {
+	path := _getPathToTests(t)
+	// create a new reader instace with the test archive
+	r, err := NewReader(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+
+	f := Filter{
+		IncludeRegexp: []*regexp.Regexp{regexp.MustCompile(`/logo\d*\.(svg|png)$`)},
+		ExcludeRegexp: []*regexp.Regexp{regexp.MustCompile(`\d\.png$`)},
+	}
+	entries, err := r.ListMatching(f)
+	if err != nil {
+		t.Fatalf("Unable to list entries: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "logo.svg" {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+
+	// regular expressions are combined with patterns
+	f.Include = []string{"**/*.txt"}
+	entries, err = r.ListMatching(f)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("Listed %d files instead of 2: %v", len(entries), err)
+	}
+}
+
+// TestStat tests getting metadata of a single file
+func TestStat(t *testing.T) {
+	path := _getPathToTests(t)
+	// create a new reader instace with the test archive
+	r, err := NewReader(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+
+	e, err := r.Stat("repos/wpress/testdata/logo3.png")
+	if err != nil {
+		t.Fatalf("Unable to stat file: %s", err)
+	}
+	if e.Name != "logo3.png" || e.Size != 6855 || e.ModTime.Unix() != 1420382427 {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+
+	_, err = r.Stat("wp-config.php")
+	if err != ErrFileNotFound {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// CompressionFileName is the name of the record in the root of archive
+// telling that content of the file which follows it is compressed, it holds
+// the compression and the length of the content once decompressed, e.g.
+// "gzip:1048576". Other tools extract the compressed content under the name
+// of the file and the record as a regular file.
+const CompressionFileName = ".wpress-compression"
+
+// ErrCorruptContent is returned when compressed content of a file doesn't
+// decompress to the length recorded for it
+var ErrCorruptContent = errors.New("compressed content is corrupt")
+
+// maxCompressionRecord is the maximum length of compression record content
+const maxCompressionRecord = 64
+
+// minCompressedSize is the length of the smallest content worth compressing
+const minCompressedSize = 512
+
+// compressionNames maps compressions to their names in compression records
+var compressionNames = map[Compression]string{
+	GzipCompression: "gzip",
+	ZstdCompression: "zstd",
+}
+
+// incompressibleExts lists extensions of files whose contents are
+// compressed already, they are stored as they are
+var incompressibleExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".avif": true, ".heic": true, ".ico": true, ".mp3": true, ".mp4": true,
+	".m4a": true, ".m4v": true, ".mov": true, ".webm": true, ".ogg": true,
+	".ogv": true, ".avi": true, ".mkv": true, ".zip": true, ".gz": true,
+	".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true,
+	".rar": true, ".wpress": true, ".woff": true, ".woff2": true,
+	".pdf": true, ".docx": true, ".xlsx": true, ".pptx": true,
+}
+
+// compressible reports whether content of size bytes of the file name is
+// worth compressing
+func compressible(name string, size int64) bool {
+	return size >= minCompressedSize && !incompressibleExts[strings.ToLower(path.Ext(name))]
+}
+
+// compressionRecord returns the header block and content of the record
+// describing compression of the file described by h
+func compressionRecord(h *Header) []byte {
+	return newRecord(CompressionFileName, h, compressionNames[h.compression]+":"+strconv.FormatInt(h.originalSize, 10))
+}
+
+// parseCompressionRecord returns compression and original length of content
+// stored in compression record
+func parseCompressionRecord(content []byte) (Compression, int64, error) {
+	parts := strings.SplitN(string(content), ":", 2)
+	if len(parts) == 2 {
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		for compression, name := range compressionNames {
+			if name == parts[0] && err == nil && size >= 0 {
+				return compression, size, nil
+			}
+		}
+	}
+	return NoCompression, 0, ErrCorruptHeader
+}
+
+// isCompressionRecord reports whether h describes a compression record
+func isCompressionRecord(h *Header) bool {
+	return h.longName == "" && h.GetName() == CompressionFileName && h.GetPrefix() == "."
+}
+
+// compressEntry compresses content of the file described by h with
+// EntryCompression into a temporary file, the compressed content is kept
+// only when it is smaller unless content can't be read again. The returned
+// function removes the temporary file.
+func (w *Writer) compressEntry(h *Header, content io.Reader) (*Header, io.Reader, func(), error) {
+	size, err := h.GetSize64()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if w.EntryCompression == NoCompression || h.compression != NoCompression || !compressible(h.GetPath(), size) {
+		return h, content, func() {}, nil
+	}
+	c, ok := codecs[w.EntryCompression]
+	if !ok {
+		return nil, nil, nil, ErrUnsupportedCompression
+	}
+
+	// remember where content starts to store it as it is
+	seeker, _ := content.(io.Seeker)
+	var start int64
+	if seeker != nil {
+		start, err = seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			seeker = nil
+		}
+	}
+
+	spool, err := ioutil.TempFile("", "wpress-compress")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cleanup := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+
+	buf := getBuffer(defaultBufferSize)
+	defer putBuffer(buf)
+	zw, err := c.newWriter(spool, w.CompressionLevel)
+	if err == nil {
+		var read int64
+		read, err = io.CopyBuffer(zw, io.LimitReader(content, size), buf)
+		if err == nil && read != size {
+			err = io.ErrUnexpectedEOF
+		}
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	var stored int64
+	if err == nil {
+		stored, err = spool.Seek(0, io.SeekCurrent)
+	}
+	if err == nil && stored >= size && seeker != nil {
+		cleanup()
+		_, err = seeker.Seek(start, io.SeekStart)
+		if err != nil {
+			return nil, nil, nil, &os.PathError{Op: "add", Path: h.GetPath(), Err: err}
+		}
+		return h, content, func() {}, nil
+	}
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, nil, &os.PathError{Op: "add", Path: h.GetPath(), Err: err}
+	}
+
+	// record length of the compressed content, the rest stays the same
+	compressed := *h
+	compressed.Size, err = formatSize(stored)
+	if err != nil {
+		cleanup()
+		return nil, nil, nil, err
+	}
+	compressed.compression, compressed.originalSize = w.EntryCompression, size
+
+	return &compressed, spool, cleanup, nil
+}
+
+// entryReader decompresses content of a file and checks it has the
+// recorded length
+type entryReader struct {
+	name      string
+	raw       *sourceReader
+	zr        io.ReadCloser
+	remaining int64
+}
+
+// sourceReader remembers the error of reading stored content, so it is
+// told apart from corrupt compressed data
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+// Read reads stored content
+func (s *sourceReader) Read(b []byte) (int, error) {
+	n, err := s.r.Read(b)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// openEntry returns reader of the decompressed content of the file name
+// whose stored content is read from raw, raw itself is returned for
+// uncompressed files
+func openEntry(name string, compression Compression, size int64, raw io.Reader) (io.ReadCloser, error) {
+	if compression == NoCompression {
+		return ioutil.NopCloser(raw), nil
+	}
+
+	c, ok := codecs[compression]
+	if !ok {
+		return nil, &os.PathError{Op: "read", Path: name, Err: ErrUnsupportedCompression}
+	}
+	r := &entryReader{name: name, raw: &sourceReader{r: raw}, remaining: size}
+	zr, err := c.newReader(r.raw)
+	if err != nil {
+		return nil, r.error(err)
+	}
+	r.zr = zr
+	return r, nil
+}
+
+// Read reads decompressed content, once it ends the rest of the stored
+// content is read, so its checksum is verified
+func (r *entryReader) Read(b []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, r.finish()
+	}
+	if int64(len(b)) > r.remaining {
+		b = b[:r.remaining]
+	}
+
+	n, err := r.zr.Read(b)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		err = ErrCorruptContent
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	if err != nil {
+		return n, r.error(err)
+	}
+	return n, nil
+}
+
+// finish checks nothing follows the recorded length and reads the rest of
+// the stored content
+func (r *entryReader) finish() error {
+	n, err := r.zr.Read(make([]byte, 1))
+	if n > 0 {
+		err = ErrCorruptContent
+	}
+	if err != nil && err != io.EOF {
+		return r.error(err)
+	}
+	_, err = io.Copy(ioutil.Discard, r.raw)
+	if err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// error returns the error of reading stored content when there was one,
+// errors of the decompressor mean the content is corrupt
+func (r *entryReader) error(err error) error {
+	if r.raw.err != nil {
+		return r.raw.err
+	}
+	return &os.PathError{Op: "read", Path: r.name, Err: ErrCorruptContent}
+}
+
+// Close releases the decompressor
+func (r *entryReader) Close() error {
+	return r.zr.Close()
+}
@@ -0,0 +1,174 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestEntryCompression tests compressing contents of individual files
+func TestEntryCompression(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	files := map[string]string{
+		"database.sql": strings.Repeat("INSERT INTO wp_posts VALUES (1, 'hello');\n", 200),
+		"photo.jpg":    strings.Repeat("jpeg", 500),
+		"small.txt":    "too small to compress",
+	}
+	filename := _newArchiveWith(t, tempPath, func(w *Writer) {
+		w.EntryCompression = GzipCompression
+		w.Checksum = SHA256Checksum
+	}, "database.sql", files["database.sql"], "photo.jpg", files["photo.jpg"], "small.txt", files["small.txt"])
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+
+	// only compressible files are compressed
+	for name, compression := range map[string]Compression{
+		"database.sql": GzipCompression,
+		"photo.jpg":    NoCompression,
+		"small.txt":    NoCompression,
+	} {
+		e, err := r.Stat(name)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %s", name, err)
+		}
+		if e.Compression != compression || e.OriginalSize != int64(len(files[name])) {
+			t.Errorf("Unexpected compression %d and size %d of %s", e.Compression, e.OriginalSize, name)
+		}
+		if compression != NoCompression && e.Size >= e.OriginalSize {
+			t.Errorf("Content of %s was not compressed, %d bytes stored", name, e.Size)
+		}
+	}
+
+	// contents are decompressed by every way of reading them
+	content, err := r.ExtractFile("database.sql", ".")
+	if err != nil || string(content) != files["database.sql"] {
+		t.Errorf("Unexpected content of database.sql: %v", err)
+	}
+	var buf bytes.Buffer
+	n, err := r.ExtractFileTo("database.sql", &buf)
+	if err != nil || n != int64(len(files["database.sql"])) || buf.String() != files["database.sql"] {
+		t.Errorf("Unexpected content of database.sql: %v", err)
+	}
+	rc, err := r.Open("database.sql")
+	if err != nil {
+		t.Fatalf("Failed to open database.sql: %s", err)
+	}
+	content, err = ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(content) != files["database.sql"] {
+		t.Errorf("Unexpected content of database.sql: %v", err)
+	}
+	fsys, err := NewFS(r)
+	if err != nil {
+		t.Fatalf("Failed to create FS: %s", err)
+	}
+	content, err = fs.ReadFile(fsys, "database.sql")
+	if err != nil || string(content) != files["database.sql"] {
+		t.Errorf("Unexpected content of database.sql: %v", err)
+	}
+
+	dest := filepath.Join(tempPath, "restored")
+	filesCount, err := r.ExtractWithOptions(ExtractOptions{DestDir: dest, Workers: 2})
+	if err != nil || filesCount != len(files) {
+		t.Errorf("Extracted %d files instead of %d: %v", filesCount, len(files), err)
+	}
+	for name, expected := range files {
+		content, _ := ioutil.ReadFile(filepath.Join(dest, name))
+		if string(content) != expected {
+			t.Errorf("Unexpected content of %s", name)
+		}
+	}
+
+	// contents copied to another archive stay compressed, transformed
+	// contents are compressed again
+	concat := filepath.Join(tempPath, "concat.wpress")
+	_, err = Concat(concat, []string{filename})
+	if err != nil {
+		t.Fatalf("Failed to concat archive: %s", err)
+	}
+	repacked := filepath.Join(tempPath, "repacked.wpress")
+	_, err = RepackWithOptions(concat, repacked, RepackOptions{Transforms: []Transform{{
+		Pattern: "*.sql",
+		Func: func(name string, content io.Reader) (io.Reader, error) {
+			return io.MultiReader(content, strings.NewReader("-- done\n")), nil
+		},
+	}}})
+	if err != nil {
+		t.Fatalf("Failed to repack archive: %s", err)
+	}
+	for filename, expected := range map[string]string{
+		concat:   files["database.sql"],
+		repacked: files["database.sql"] + "-- done\n",
+	} {
+		r, _ := NewReader(filename)
+		e, _ := r.Stat("database.sql")
+		content, err := r.ExtractFile("database.sql", ".")
+		r.Close()
+		if err != nil || string(content) != expected {
+			t.Errorf("Unexpected content of database.sql in %s: %v", filename, err)
+		}
+		if filename == concat && e.Compression != GzipCompression {
+			t.Errorf("Content of database.sql was decompressed in %s", filename)
+		}
+	}
+}
+
+// TestCorruptEntryCompression tests compressed contents which can't be
+// decompressed
+func TestCorruptEntryCompression(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	// archive without checksums, so the decompressor finds the damage
+	filename := _newArchiveWith(t, tempPath, func(w *Writer) { w.EntryCompression = GzipCompression },
+		"database.sql", strings.Repeat("SELECT 1;\n", 200))
+
+	r, _ := NewReader(filename)
+	e, _ := r.Stat("database.sql")
+	r.Close()
+	archive, _ := ioutil.ReadFile(filename)
+	archive[e.Offset+e.Size-6] ^= 0xff
+	ioutil.WriteFile(filename, archive, 0644)
+
+	r, _ = NewReader(filename)
+	defer r.Close()
+	_, err := r.ExtractFile("database.sql", ".")
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrCorruptContent {
+		t.Errorf("Expected ErrCorruptContent, got %v", err)
+	}
+}
@@ -0,0 +1,1041 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrUnsafePath is returned when path of a file would escape the destination
+// directory, e.g. "../wp-config.php"
+var ErrUnsafePath = errors.New("file path escapes destination directory")
+
+// ErrTooManyFiles is returned when extraction would exceed MaxFiles
+var ErrTooManyFiles = errors.New("too many files to extract")
+
+// ErrFileTooLarge is returned when a file is larger than MaxFileSize
+var ErrFileTooLarge = errors.New("file is larger than max allowed")
+
+// ErrTotalTooLarge is returned when extraction would exceed MaxTotalSize
+var ErrTotalTooLarge = errors.New("total size of files is larger than max allowed")
+
+// ErrCaseCollision is returned when two files differ only in letter case
+var ErrCaseCollision = errors.New("file name collides with another file differing only in case")
+
+// CollisionPolicy tells what to do with files whose paths differ only in
+// letter case, which collide on case-insensitive file systems
+type CollisionPolicy int
+
+const (
+	// IgnoreCollisions doesn't detect collisions, it is the default
+	IgnoreCollisions CollisionPolicy = iota
+	// FailCollisions stops extraction with ErrCaseCollision
+	FailCollisions
+	// RenameCollisions extracts the colliding file as "name.N.ext"
+	RenameCollisions
+	// WarnCollisions adds ErrCaseCollision to Reader.Warnings and extracts
+	// the colliding file anyway
+	WarnCollisions
+)
+
+// OverwritePolicy tells what to do when extracted file already exists
+type OverwritePolicy int
+
+const (
+	// OverwriteExisting truncates the existing file, it is the default
+	OverwriteExisting OverwritePolicy = iota
+	// FailExisting stops extraction with an error
+	FailExisting
+	// SkipExisting keeps the existing file and skips the extracted one
+	SkipExisting
+	// RenameExisting moves the existing file aside, "name.ext" is renamed
+	// to the first free "name.N.ext"
+	RenameExisting
+)
+
+// ExtractOptions controls extraction of files from archive
+type ExtractOptions struct {
+	// DestDir is the directory files are extracted to, it is created when
+	// missing. Current working directory is used when empty.
+	DestDir string
+
+	// Overwrite tells what to do with files that already exist
+	Overwrite OverwritePolicy
+
+	// SkipUnchanged skips existing files whose size and modification date
+	// match the header, so an interrupted extraction can be resumed quickly
+	SkipUnchanged bool
+
+	// CompareContents makes SkipUnchanged compare contents of existing
+	// files of the same size with the archived ones instead of their
+	// modification dates, the archive has to support random access
+	CompareContents bool
+
+	// Delete removes files and symbolic links under DestDir which are not
+	// in archive and directories left empty, like rsync --delete, so an
+	// existing site ends up matching the archive. Only paths selected by
+	// Filter are removed, they are matched as paths in archive the files
+	// would be extracted from, StripPrefix and Remap reversed. With
+	// StripComponents paths are removed only when Filter selects all.
+	Delete bool
+
+	// StripComponents drops that many leading directories from paths of
+	// extracted files, files with fewer directories are skipped
+	StripComponents int
+
+	// StripPrefix drops the leading directory prefix from paths of
+	// extracted files, e.g. "wp-content", files outside it are skipped
+	StripPrefix string
+
+	// Remap rewrites path prefixes of extracted files after stripping, the
+	// first matching rule is applied
+	Remap []RemapRule
+
+	// SanitizePaths drops ".." elements escaping the destination directory
+	// instead of failing with ErrUnsafePath
+	SanitizePaths bool
+
+	// SanitizeNames replaces control characters and zero bytes in paths and
+	// renames empty filenames instead of failing with ErrUnsafeName
+	SanitizeNames bool
+
+	// MaxFiles limits the number of files extracted, zero means no limit
+	MaxFiles int
+
+	// MaxFileSize limits size of a single extracted file in bytes, zero
+	// means no limit
+	MaxFileSize int64
+
+	// MaxTotalSize limits size of all extracted files in bytes, zero means
+	// no limit
+	MaxTotalSize int64
+
+	// Collisions tells what to do with files whose paths differ only in
+	// letter case
+	Collisions CollisionPolicy
+
+	// Duplicates tells what to do with paths appearing in archive more than
+	// once
+	Duplicates DuplicatePolicy
+
+	// RemovePartial removes the file being written when extraction fails,
+	// it is always removed when extraction is cancelled
+	RemovePartial bool
+
+	// Workers is the number of files written concurrently, contents are
+	// read concurrently only from archives supporting random access
+	Workers int
+
+	// BufferSize is the size of the buffer used to copy file contents,
+	// 1 MiB is used when zero
+	BufferSize int
+
+	// IgnoreMtime leaves extracted files with the current time instead of
+	// restoring the last modification date stored in the header
+	IgnoreMtime bool
+
+	// RestoreOwner sets owner and group of extracted files to those stored
+	// in attributes records, which usually requires root. Permissions are
+	// always restored when recorded.
+	RestoreOwner bool
+
+	// Progress is called when extraction of a file starts and finishes and
+	// after every ProgressInterval bytes written
+	Progress func(ProgressEvent)
+
+	// ProgressInterval is the number of bytes between progress reports,
+	// 1 MiB is used when zero
+	ProgressInterval int64
+
+	// DryRun walks archive and decides what would be extracted without
+	// touching the file system, see Reader.DryRun for a detailed report
+	DryRun bool
+
+	// NestedDepth extracts archives stored in archive, .wpress and .zip
+	// files whose contents are archives, into directories named after them
+	// without the extension, e.g. "staging.wpress" into "staging", up to
+	// that many levels deep. Files of nested archives count against the
+	// limits. Nested archives are not extracted when zero.
+	NestedDepth int
+
+	// Transforms change contents of the matching files on their way to
+	// the destination, e.g. SearchReplace on database.sql
+	Transforms []Transform
+
+	// Filter selects files to extract, e.g. Include "wp-content/**" and
+	// Exclude "wp-content/cache/**" restores wp-content without the cache
+	Filter
+}
+
+// RemapRule rewrites path prefix From to To, prefixes are matched by whole
+// directories, e.g. "wp-content/uploads" to "shared/uploads"
+type RemapRule struct {
+	From string
+	To   string
+}
+
+// apply returns name rewritten by the rule, false is returned when the rule
+// doesn't match
+func (rule RemapRule) apply(name string) (string, bool) {
+	from := path.Clean("." + string(os.PathSeparator) + rule.From)
+	to := path.Clean("." + string(os.PathSeparator) + rule.To)
+	if from == "." {
+		return path.Join(to, name), true
+	}
+	if name == from {
+		return to, true
+	}
+	if strings.HasPrefix(name, from+"/") {
+		return path.Join(to, strings.TrimPrefix(name, from+"/")), true
+	}
+	return name, false
+}
+
+// ExtractTo extracts all files from archive into destDir
+func (r *Reader) ExtractTo(destDir string) (int, error) {
+	return r.ExtractWithOptions(ExtractOptions{DestDir: destDir})
+}
+
+// ExtractWithOptions extracts all files from archive according to opts and
+// returns the number of files extracted
+func (r *Reader) ExtractWithOptions(opts ExtractOptions) (int, error) {
+	return r.ExtractContext(context.Background(), opts)
+}
+
+// ExtractContext is like ExtractWithOptions but aborts when ctx is done, the
+// file being written at that moment is removed
+func (r *Reader) ExtractContext(ctx context.Context, opts ExtractOptions) (int, error) {
+	e := &extraction{r: r, ctx: ctx, opts: opts}
+	return e.run()
+}
+
+// DryRunReport describes what extraction would do to the file system
+type DryRunReport struct {
+	Create    []string // paths which would be created
+	Overwrite []string // existing paths which would be overwritten
+	Rename    []string // existing paths which would be moved aside
+	Skip      []string // existing paths which would be kept
+	Delete    []string // existing paths which would be removed, see Delete
+	Bytes     int64    // total bytes which would be written
+}
+
+// DryRun reports what extracting files according to opts would do without
+// touching the file system
+func (r *Reader) DryRun(opts ExtractOptions) (*DryRunReport, error) {
+	opts.DryRun = true
+	e := &extraction{r: r, ctx: context.Background(), opts: opts, report: &DryRunReport{}}
+	_, err := e.run()
+	if err != nil {
+		return nil, err
+	}
+	return e.report, nil
+}
+
+// ExtractFiles extracts files matching names (prefix and filename joined) in
+// a single pass over archive and returns the names which were found and the
+// names which are missing from archive, opts.Delete has no effect
+func (r *Reader) ExtractFiles(names []string, opts ExtractOptions) ([]string, []string, error) {
+	// normalize the names the same way header paths are normalized
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[path.Clean("."+string(os.PathSeparator)+name)] = false
+	}
+
+	e := &extraction{r: r, ctx: context.Background(), opts: opts}
+	e.selected = func(name string) bool {
+		_, ok := wanted[name]
+		return ok
+	}
+	e.extracted = func(name string) {
+		wanted[name] = true
+	}
+	_, err := e.run()
+
+	// split the names preserving the order they were requested in
+	var found, missing []string
+	for _, name := range names {
+		if wanted[path.Clean("."+string(os.PathSeparator)+name)] {
+			found = append(found, name)
+		} else {
+			missing = append(missing, name)
+		}
+	}
+
+	return found, missing, err
+}
+
+// extraction holds the state of a single extraction run
+type extraction struct {
+	r    *Reader
+	ctx  context.Context
+	opts ExtractOptions
+
+	// selected further narrows files selected by opts when not nil
+	selected func(name string) bool
+	// extracted is called after a file was extracted when not nil
+	extracted func(name string)
+
+	// report collects actions in dry run when not nil
+	report *DryRunReport
+
+	// total size of the files extracted so far
+	totalSize int64
+
+	// lower cased names of the files extracted so far
+	folded map[string]string
+
+	// paths seen so far, for the duplicate policy
+	seen *duplicates
+
+	// destination paths written in this run
+	written map[string]bool
+
+	// destination paths of all files of archive, see Delete
+	present map[string]bool
+
+	// real path of the destination directory, files are written only
+	// inside it, see resolvePath
+	root string
+
+	// cursor the archive is read with
+	c *cursor
+
+	// tw receives the files instead of the file system when not nil
+	tw *tar.Writer
+
+	// number of files extracted so far
+	extractedCount int
+	// number of files admitted by the limits so far, workers extract them
+	// after all are planned
+	planned int
+
+	// non fatal problems published as Reader.Warnings
+	warnings []error
+
+	// hard links to restore as copies and destination paths of the files
+	// extracted so far by their paths in archive
+	links   []hardLink
+	targets map[string]string
+
+	// extracted files which may be archives, see NestedDepth
+	nested []string
+
+	progress *progressTracker
+
+	// guards extractedCount and extracted calls made by workers
+	mu sync.Mutex
+}
+
+// match reports whether the file should be extracted
+func (e *extraction) match(name string) bool {
+	if !e.opts.Filter.Match(name) {
+		return false
+	}
+	return e.selected == nil || e.selected(name)
+}
+
+// extractJob is a file planned to be written
+type extractJob struct {
+	h          *Header
+	pathToFile string
+	act        action
+	offset     int64
+	size       int64
+}
+
+// run extracts the selected files and returns the number of files extracted
+func (e *extraction) run() (int, error) {
+	r := e.r
+
+	// validate the patterns before touching the file system
+	err := e.opts.Filter.Validate()
+	if err != nil {
+		return 0, err
+	}
+
+	// set up progress reporting, totals are known only for seekable archives
+	if e.opts.Progress != nil {
+		e.progress = newProgressTracker(e.opts.Progress, e.opts.ProgressInterval)
+		if _, ok := r.source.(io.Seeker); ok {
+			files, bytes := 0, int64(0)
+			err = r.WalkContext(e.ctx, func(entry EntryInfo) error {
+				if e.match(entry.Path()) {
+					files++
+					bytes += entry.OriginalSize
+				}
+				return nil
+			})
+			if err != nil {
+				return 0, err
+			}
+			e.progress.setTotals(files, bytes)
+		}
+	}
+
+	// get a cursor at the beginning of the file
+	e.c, err = r.scan()
+	if err != nil {
+		return 0, err
+	}
+	e.seen = &duplicates{policy: e.opts.Duplicates}
+	e.written = make(map[string]bool)
+	e.present = make(map[string]bool)
+	e.targets = make(map[string]string)
+	if e.tw == nil {
+		e.root, err = resolvePath(e.opts.DestDir)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// publish the warnings however the extraction ends
+	defer func() {
+		r.mu.Lock()
+		r.Warnings = append(r.Warnings, e.warnings...)
+		r.mu.Unlock()
+	}()
+
+	// files are written by workers when contents can be read independently
+	ra, parallel := r.source.(io.ReaderAt)
+	parallel = parallel && e.opts.Workers > 1 && !e.opts.DryRun && e.tw == nil
+	var jobs []*extractJob
+
+	// loop until end of file was reached
+	filesCount := 0
+	for {
+		// stop between files when cancelled
+		err = e.ctx.Err()
+		if err != nil {
+			return e.extractedCount, err
+		}
+
+		// read next header
+		h, err := e.c.next()
+		if err == io.EOF {
+			// EOF reached, stop the loop
+			break
+		}
+		if err != nil {
+			return e.extractedCount, err
+		}
+		filesCount++
+
+		job, err := e.plan(h)
+		if err != nil {
+			// files planned before the failure are written, like they
+			// are by sequential extraction
+			if parallel {
+				parallelErr := e.executeParallel(ra, jobs)
+				if parallelErr != nil {
+					return e.extractedCount, parallelErr
+				}
+			}
+			return e.extractedCount, err
+		}
+		if job == nil {
+			continue
+		}
+
+		// defer writing to the workers, links are created right away so
+		// the following links are checked against them
+		if parallel && (h.attrs == nil || h.attrs.Link == "") {
+			jobs = append(jobs, job)
+			continue
+		}
+
+		// write the file while the cursor points at its content
+		err = e.execute(e.ctx, job, e.c)
+		if err != nil {
+			return e.extractedCount, err
+		}
+	}
+
+	if parallel {
+		err = e.executeParallel(ra, jobs)
+		if err != nil {
+			return e.extractedCount, err
+		}
+	}
+
+	// copy files which were stored only once
+	if !e.opts.DryRun {
+		err = e.restoreLinks()
+		if err != nil {
+			return e.extractedCount, err
+		}
+	}
+
+	// remove files which are not in archive before nested archives add
+	// their files, nothing is removed when only some files are extracted
+	// or files are not written to the file system
+	if e.opts.Delete && e.selected == nil && e.tw == nil {
+		err = e.deleteExtraneous()
+		if err != nil {
+			return e.extractedCount, err
+		}
+	}
+
+	if !e.opts.DryRun {
+		err = e.extractNested()
+		if err != nil {
+			return e.extractedCount, err
+		}
+	}
+
+	// we have enumerated the whole archive
+	r.setFilesCount(filesCount)
+
+	return e.extractedCount, nil
+}
+
+// plan applies the options to the current file described by h and returns
+// the job writing it, nil is returned when the file is not written. Content
+// of skipped files is skipped by the next call to the cursor.
+func (e *extraction) plan(h *Header) (*extractJob, error) {
+	// list of hard links is not extracted
+	if h.GetPath() == LinksFileName {
+		return nil, e.readLinks()
+	}
+
+	// manifest is checked by Reader.Verify, it is not extracted
+	if h.GetPath() == ManifestFileName {
+		return nil, nil
+	}
+
+	if !e.match(h.GetPath()) {
+		return nil, nil
+	}
+
+	// refuse or sanitize names which could create confusing paths
+	name := h.GetPath()
+	err := h.ValidateName()
+	if err != nil {
+		if !e.opts.SanitizeNames {
+			return nil, &os.PathError{Op: "extract", Path: name, Err: err}
+		}
+		name = h.GetSanitizedPath()
+	}
+
+	// apply the policy to paths appearing more than once
+	name, keep, err := e.seen.check(name)
+	if err != nil || !keep {
+		return nil, err
+	}
+
+	// root the file under the destination directory
+	name, ok, err := e.targetName(name)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	// detect names colliding on case-insensitive file systems
+	name, err = e.checkCollision(name)
+	if err != nil {
+		return nil, err
+	}
+	pathToFile := filepath.Join(e.opts.DestDir, filepath.FromSlash(name))
+	e.present[pathToFile] = true
+
+	// links pointing outside of the destination are not extracted
+	if h.attrs != nil && h.attrs.Link != "" && !e.safeLink(pathToFile, h.attrs.Link) {
+		e.warnings = append(e.warnings, &os.PathError{Op: "extract", Path: name, Err: ErrUnsafeLink})
+		return nil, nil
+	}
+
+	// limits apply to the content once decompressed
+	size, err := h.GetOriginalSize()
+	if err != nil {
+		return nil, err
+	}
+
+	// apply the overwrite policy to existing files
+	act, err := e.decide(pathToFile, h, e.openCurrent(h))
+	if err != nil || act == actionSkip {
+		if e.report != nil && err == nil {
+			e.record(pathToFile, act, size)
+		}
+		return nil, err
+	}
+
+	// enforce the limits before writing anything
+	err = e.checkLimits(pathToFile, size)
+	if err != nil {
+		return nil, err
+	}
+	e.written[pathToFile] = true
+	e.targets[h.GetPath()] = pathToFile
+
+	// only record what would happen in dry run
+	if e.opts.DryRun {
+		if e.report != nil {
+			e.record(pathToFile, act, size)
+		}
+		e.extractedCount++
+		return nil, nil
+	}
+
+	// archives are extracted once written
+	if e.opts.NestedDepth > 0 && e.tw == nil && nestedArchive(name) && (h.attrs == nil || h.attrs.Link == "") {
+		e.nested = append(e.nested, pathToFile)
+	}
+
+	return &extractJob{h, pathToFile, act, e.c.offset, e.c.remaining}, nil
+}
+
+// execute writes the file of job reading its content from src
+func (e *extraction) execute(ctx context.Context, job *extractJob, src io.Reader) error {
+	if e.tw != nil {
+		return e.executeTar(ctx, job, src)
+	}
+
+	// names were checked, the file may still lead outside through
+	// symbolic links in the destination
+	link := job.h.attrs != nil && job.h.attrs.Link != ""
+	err := e.checkInside(job.pathToFile, !link)
+	if err != nil {
+		return err
+	}
+
+	if job.act == actionRename {
+		err := os.Rename(job.pathToFile, availableName(job.pathToFile))
+		if err != nil {
+			return err
+		}
+	}
+
+	// symbolic links have no content
+	if link {
+		return e.executeSymlink(job)
+	}
+
+	size, err := job.h.GetOriginalSize()
+	if err != nil {
+		return err
+	}
+	content, err := openEntry(job.h.GetPath(), job.h.GetCompression(), size, src)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	transformed, err := e.transform(job.h, content)
+	if err != nil {
+		return err
+	}
+
+	fp := e.progress.startFile(job.h.GetPath(), size)
+	err = e.extractCurrent(ctx, job.pathToFile, transformed, fp)
+	if err != nil {
+		return err
+	}
+	err = e.restoreMtime(job.pathToFile, job.h)
+	if err == nil {
+		err = e.restoreAttributes(job.pathToFile, job.h)
+	}
+	if err != nil {
+		return err
+	}
+	fp.end()
+
+	// increment file counter
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.extractedCount++
+	if e.extracted != nil {
+		e.extracted(job.h.GetPath())
+	}
+
+	return nil
+}
+
+// executeParallel writes files of jobs by a pool of workers reading the
+// contents from ra
+func (e *extraction) executeParallel(ra io.ReaderAt, jobs []*extractJob) error {
+	// a later job writing the same path replaces the earlier one
+	last := make(map[string]int)
+	for i, job := range jobs {
+		last[job.pathToFile] = i
+	}
+
+	// renames have to happen before any file is written
+	for i, job := range jobs {
+		if job.act == actionRename && last[job.pathToFile] == i {
+			err := os.Rename(job.pathToFile, availableName(job.pathToFile))
+			if err != nil {
+				return err
+			}
+		}
+		job.act = actionOverwrite
+	}
+
+	// the first failure stops the other workers
+	ctx, cancel := context.WithCancel(e.ctx)
+	defer cancel()
+
+	queue := make(chan *extractJob)
+	errs := make(chan error, e.opts.Workers)
+	for i := 0; i < e.opts.Workers; i++ {
+		go func() {
+			var err error
+			for job := range queue {
+				if err != nil {
+					continue
+				}
+				// cursor reports content cut short by truncated archive
+				// and verifies its checksum
+				c := newEntryCursor(ra, job.offset, job.size, job.h.GetPath(), &e.r.Layout)
+				err = e.execute(ctx, job, c)
+				if err != nil {
+					cancel()
+				}
+			}
+			errs <- err
+		}()
+	}
+
+	for i, job := range jobs {
+		if last[job.pathToFile] != i {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- job
+	}
+	close(queue)
+
+	// return the first error, not the cancellation it caused
+	var firstErr error
+	for i := 0; i < e.opts.Workers; i++ {
+		err := <-errs
+		if err != nil && (firstErr == nil || firstErr == context.Canceled) {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = e.ctx.Err()
+	}
+	return firstErr
+}
+
+// targetName returns path of the file relative to the destination directory,
+// false is returned when nothing remains of the path after stripping
+func (e *extraction) targetName(name string) (string, bool, error) {
+	// drop the fixed prefix
+	if e.opts.StripPrefix != "" {
+		prefix := path.Clean("." + string(os.PathSeparator) + e.opts.StripPrefix)
+		if prefix != "." {
+			if !strings.HasPrefix(name, prefix+"/") {
+				return "", false, nil
+			}
+			name = strings.TrimPrefix(name, prefix+"/")
+		}
+	}
+
+	// drop the leading directories
+	if e.opts.StripComponents > 0 {
+		components := strings.Split(name, "/")
+		if len(components) <= e.opts.StripComponents {
+			return "", false, nil
+		}
+		name = strings.Join(components[e.opts.StripComponents:], "/")
+	}
+
+	// rewrite the path by the first matching rule
+	for _, rule := range e.opts.Remap {
+		remapped, ok := rule.apply(name)
+		if ok {
+			name = remapped
+			break
+		}
+	}
+
+	// make sure the file stays inside the destination directory
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		if !e.opts.SanitizePaths {
+			return "", false, &os.PathError{Op: "extract", Path: name, Err: ErrUnsafePath}
+		}
+
+		// resolve ".." elements against the root of the destination
+		name = strings.TrimPrefix(path.Clean("/"+name), "/")
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return "", false, nil
+		}
+	}
+
+	return name, true, nil
+}
+
+// checkInside returns ErrUnsafePath when the real path of pathToFile is
+// outside of the destination directory, symbolic link at pathToFile is
+// followed when follow is true
+func (e *extraction) checkInside(pathToFile string, follow bool) error {
+	resolved, err := resolvePath(filepath.Dir(pathToFile))
+	if err == nil {
+		resolved += string(filepath.Separator) + filepath.Base(pathToFile)
+		if follow {
+			resolved, err = resolvePath(resolved)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !isWithin(e.root, resolved) {
+		return &os.PathError{Op: "extract", Path: pathToFile, Err: ErrUnsafePath}
+	}
+	return nil
+}
+
+// checkCollision applies the collision policy to name and returns the name
+// the file should be extracted as
+func (e *extraction) checkCollision(name string) (string, error) {
+	if e.opts.Collisions == IgnoreCollisions {
+		return name, nil
+	}
+	if e.folded == nil {
+		e.folded = make(map[string]string)
+	}
+
+	// the same file appearing again is not a collision
+	existing, ok := e.folded[strings.ToLower(name)]
+	if !ok || existing == name {
+		e.folded[strings.ToLower(name)] = name
+		return name, nil
+	}
+
+	err := &os.PathError{Op: "extract", Path: name, Err: ErrCaseCollision}
+	switch e.opts.Collisions {
+	case FailCollisions:
+		return "", err
+	case WarnCollisions:
+		e.warnings = append(e.warnings, err)
+	case RenameCollisions:
+		// find the first numbered name which doesn't collide
+		renamed := name
+		for n := 1; ok; n++ {
+			renamed = numberedName(name, n)
+			_, ok = e.folded[strings.ToLower(renamed)]
+		}
+		name = renamed
+	}
+
+	e.folded[strings.ToLower(name)] = name
+	return name, nil
+}
+
+// checkLimits returns an error if extracting one more file of size bytes
+// would exceed the limits
+func (e *extraction) checkLimits(pathToFile string, size int64) error {
+	if e.opts.MaxFiles > 0 && e.planned >= e.opts.MaxFiles {
+		return ErrTooManyFiles
+	}
+	if e.opts.MaxFileSize > 0 && size > e.opts.MaxFileSize {
+		return &os.PathError{Op: "extract", Path: pathToFile, Err: ErrFileTooLarge}
+	}
+	if e.opts.MaxTotalSize > 0 && e.totalSize+size > e.opts.MaxTotalSize {
+		return ErrTotalTooLarge
+	}
+	e.totalSize += size
+	e.planned++
+	return nil
+}
+
+// action is what happens to a destination path during extraction
+type action int
+
+const (
+	actionCreate action = iota
+	actionOverwrite
+	actionRename
+	actionSkip
+)
+
+// decide applies the overwrite policy to pathToFile and returns the action
+// to take for the file described by h, open opens its content
+func (e *extraction) decide(pathToFile string, h *Header, open func() (io.ReadCloser, error)) (action, error) {
+	// file written earlier in this run is replaced by its later occurrence
+	if e.written[pathToFile] {
+		return actionOverwrite, nil
+	}
+
+	// tar stream has no existing files
+	if e.tw != nil {
+		return actionCreate, nil
+	}
+
+	fi, err := os.Lstat(pathToFile)
+	if os.IsNotExist(err) {
+		return actionCreate, nil
+	}
+	if err != nil {
+		return actionSkip, err
+	}
+
+	// existing file is the same as the archived one
+	if e.opts.SkipUnchanged && fi.Mode().IsRegular() {
+		unchanged, err := e.unchanged(pathToFile, fi, h, open)
+		if err != nil || unchanged {
+			return actionSkip, err
+		}
+	}
+
+	switch e.opts.Overwrite {
+	case FailExisting:
+		return actionSkip, &os.PathError{Op: "extract", Path: pathToFile, Err: os.ErrExist}
+	case SkipExisting:
+		return actionSkip, nil
+	case RenameExisting:
+		return actionRename, nil
+	}
+
+	return actionOverwrite, nil
+}
+
+// record adds the action taken for pathToFile to the dry run report
+func (e *extraction) record(pathToFile string, act action, size int64) {
+	report := e.report
+	switch act {
+	case actionCreate:
+		report.Create = append(report.Create, pathToFile)
+	case actionOverwrite:
+		report.Overwrite = append(report.Overwrite, pathToFile)
+	case actionRename:
+		report.Rename = append(report.Rename, pathToFile)
+	case actionSkip:
+		report.Skip = append(report.Skip, pathToFile)
+		return
+	}
+	report.Bytes += size
+}
+
+// availableName returns the first numbered variant of name which doesn't
+// exist yet
+func availableName(name string) string {
+	for n := 1; ; n++ {
+		candidate := numberedName(name, n)
+		_, err := os.Lstat(candidate)
+		if os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// numberedName inserts n before the extension of name, "name.ext" becomes
+// "name.N.ext"
+func numberedName(name string, n int) string {
+	ext := path.Ext(name)
+	if ext == name || strings.HasSuffix(name, "/"+ext) {
+		ext = ""
+	}
+	return strings.TrimSuffix(name, ext) + "." + strconv.Itoa(n) + ext
+}
+
+// extractCurrent writes content read from src to pathToFile
+func (e *extraction) extractCurrent(ctx context.Context, pathToFile string, src io.Reader, fp *fileProgress) (err error) {
+	// create parent directories of the file
+	err = os.MkdirAll(filepath.Dir(pathToFile), 0755)
+	if err != nil {
+		return err
+	}
+
+	// try to open the file
+	file, err := os.Create(pathToFile)
+	if err != nil {
+		return err
+	}
+
+	// close the file, remove it when it was not fully written
+	defer func() {
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil && (e.opts.RemovePartial || ctx.Err() != nil) {
+			os.Remove(pathToFile)
+		}
+	}()
+
+	// copy the content in large chunks, reporting progress as we go
+	buf := getBuffer(e.bufferSize())
+	defer putBuffer(buf)
+	_, err = io.CopyBuffer(&progressWriter{ctx, file, fp}, src, buf)
+	return err
+}
+
+// bufferSize returns size of the buffer used to copy file contents
+func (e *extraction) bufferSize() int {
+	if e.opts.BufferSize > 0 {
+		return e.opts.BufferSize
+	}
+	return defaultBufferSize
+}
+
+// progressWriter writes to w, reports written bytes to fp and stops
+// writing when ctx is done
+type progressWriter struct {
+	ctx context.Context
+	w   io.Writer
+	fp  *fileProgress
+}
+
+// Write writes b to the underlying writer
+func (w *progressWriter) Write(b []byte) (int, error) {
+	// stop writing when cancelled
+	if w.ctx.Err() != nil {
+		return 0, w.ctx.Err()
+	}
+
+	n, err := w.w.Write(b)
+	w.fp.add(int64(n))
+	return n, err
+}
+
+// restoreMtime sets the last modification date of pathToFile to the one
+// stored in h
+func (e *extraction) restoreMtime(pathToFile string, h *Header) error {
+	modTime, err := h.GetModTime()
+	if e.opts.IgnoreMtime || err != nil {
+		return nil
+	}
+	return os.Chtimes(pathToFile, modTime, modTime)
+}
+
+// transform passes content of the file described by h through the matching
+// transforms
+func (e *extraction) transform(h *Header, content io.Reader) (io.Reader, error) {
+	matching, err := matchingTransforms(e.opts.Transforms, h.GetPath())
+	if err != nil {
+		return nil, err
+	}
+	return applyTransforms(matching, h.GetPath(), content, "extract")
+}
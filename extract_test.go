@@ -0,0 +1,682 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// _newTestReader returns reader of the test archive
+func _newTestReader(t *testing.T) *Reader {
+	path := _getPathToTests(t)
+	r, err := NewReader(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	return r
+}
+
+// _newArchive writes an archive with files of the given names and contents
+func _newArchive(t *testing.T, dir string, files ...string) string {
+	return _newArchiveWith(t, dir, func(w *Writer) {}, files...)
+}
+
+// _newArchiveWith writes an archive like _newArchive by the Writer
+// configured by setup, e.g. with checksums or compression. Headers of names
+// refused by NewHeader are crafted, so they can be written as well.
+func _newArchiveWith(t *testing.T, dir string, setup func(w *Writer), files ...string) string {
+	filename := filepath.Join(dir, "crafted.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	setup(w)
+	for i := 0; i+1 < len(files); i += 2 {
+		h, err := NewHeader(files[i], int64(len(files[i+1])), time.Unix(1420382531, 0))
+		if err != nil {
+			h = &Header{
+				Name:   make([]byte, filenameSize),
+				Size:   make([]byte, contentSize),
+				Mtime:  make([]byte, mtimeSize),
+				Prefix: make([]byte, prefixSize),
+			}
+			copy(h.Name, path.Base(files[i]))
+			copy(h.Size, strconv.Itoa(len(files[i+1])))
+			copy(h.Mtime, "1420382531")
+			copy(h.Prefix, path.Dir(files[i]))
+		}
+		err = w.writeEntry(h, strings.NewReader(files[i+1]))
+		if err != nil {
+			t.Fatalf("Failed to add %s: %s", files[i], err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Failed to close archive: %s", err)
+	}
+	return filename
+}
+
+// _newTempDir creates a temporary folder for extraction tests
+func _newTempDir(t *testing.T) string {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	return tempPath
+}
+
+// TestExtractTo tests extracting files into a destination directory
+func TestExtractTo(t *testing.T) {
+	r := _newTestReader(t)
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	// destination directory is created when missing
+	destDir := filepath.Join(tempPath, "site")
+	filesCount, err := r.ExtractTo(destDir)
+	if err != nil {
+		t.Errorf("Unable to extract files: %s", err)
+	}
+	if filesCount != 3 {
+		t.Errorf("Extracted %d files instead of 3", filesCount)
+	}
+
+	fi, err := os.Stat(filepath.Join(destDir, "repos", "wpress", "testdata", "logo3.png"))
+	if err != nil {
+		t.Fatalf("File was not extracted: %s", err)
+	}
+	if fi.Size() != 6855 {
+		t.Errorf("Extracted file is %d bytes long instead of 6855", fi.Size())
+	}
+
+	// modification date is restored from the header
+	if fi.ModTime().Unix() != 1420382427 {
+		t.Errorf("Extracted file was modified at %s", fi.ModTime())
+	}
+}
+
+// TestExtractFilter tests extracting files selected by patterns
+func TestExtractFilter(t *testing.T) {
+	r := _newTestReader(t)
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	opts := ExtractOptions{DestDir: tempPath}
+	opts.Include = []string{"repos/wpress/testdata"}
+	opts.Exclude = []string{"**/*.svg"}
+	filesCount, err := r.ExtractWithOptions(opts)
+	if err != nil {
+		t.Errorf("Unable to extract files: %s", err)
+	}
+	if filesCount != 2 {
+		t.Errorf("Extracted %d files instead of 2", filesCount)
+	}
+
+	_, err = os.Stat(filepath.Join(tempPath, "repos", "wpress", "testdata", "logo.svg"))
+	if !os.IsNotExist(err) {
+		t.Errorf("Excluded file was extracted")
+	}
+
+	// malformed pattern is reported before extracting anything
+	opts.Exclude = []string{"["}
+	_, err = r.ExtractWithOptions(opts)
+	if err == nil {
+		t.Errorf("Malformed pattern didn't return an error")
+	}
+}
+
+// TestExtractFiles tests extracting a list of files
+func TestExtractFiles(t *testing.T) {
+	r := _newTestReader(t)
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	names := []string{
+		"repos/wpress/testdata/logo3.png",
+		"wp-config.php",
+		"/repos/wpress/testdata/lipsum.txt",
+	}
+	found, missing, err := r.ExtractFiles(names, ExtractOptions{DestDir: tempPath})
+	if err != nil {
+		t.Errorf("Unable to extract files: %s", err)
+	}
+	if len(found) != 2 || found[0] != names[0] || found[1] != names[2] {
+		t.Errorf("Unexpected files found %v", found)
+	}
+	if len(missing) != 1 || missing[0] != "wp-config.php" {
+		t.Errorf("Unexpected files missing %v", missing)
+	}
+
+	_, err = os.Stat(filepath.Join(tempPath, "repos", "wpress", "testdata", "lipsum.txt"))
+	if err != nil {
+		t.Errorf("File was not extracted: %s", err)
+	}
+	_, err = os.Stat(filepath.Join(tempPath, "repos", "wpress", "testdata", "logo.svg"))
+	if !os.IsNotExist(err) {
+		t.Errorf("File not requested was extracted")
+	}
+}
+
+// TestExtractContext tests cancelling extraction
+func TestExtractContext(t *testing.T) {
+	r := _newTestReader(t)
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	filesCount, err := r.ExtractContext(ctx, ExtractOptions{DestDir: tempPath})
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if filesCount != 0 {
+		t.Errorf("Extracted %d files after cancellation", filesCount)
+	}
+
+	_, err = r.ListContext(ctx)
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+// TestExtractProgress tests reporting extraction progress
+func TestExtractProgress(t *testing.T) {
+	r := _newTestReader(t)
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	var events []ProgressEvent
+	opts := ExtractOptions{
+		DestDir:          tempPath,
+		ProgressInterval: 1024,
+		Progress: func(e ProgressEvent) {
+			events = append(events, e)
+		},
+	}
+	_, err := r.ExtractWithOptions(opts)
+	if err != nil {
+		t.Errorf("Unable to extract files: %s", err)
+	}
+
+	// each file reports start and end, plus reports in between
+	done := 0
+	for _, e := range events {
+		if e.FileDone {
+			done++
+		}
+	}
+	if done != 3 || len(events) <= 2*3 {
+		t.Errorf("Received %d progress events, %d files done", len(events), done)
+	}
+	last := events[len(events)-1]
+	if !last.FileDone || last.Files != 3 || last.TotalFiles != 3 {
+		t.Errorf("Unexpected last event %+v", last)
+	}
+	if last.Bytes != 2313+1478+6855 || last.TotalBytes != last.Bytes {
+		t.Errorf("Unexpected byte counts in %+v", last)
+	}
+}
+
+// TestExtractOverwrite tests overwrite policies
+func TestExtractOverwrite(t *testing.T) {
+	r := _newTestReader(t)
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	// create a file in place of an archived one
+	dir := filepath.Join(tempPath, "repos", "wpress", "testdata")
+	os.MkdirAll(dir, 0755)
+	existing := filepath.Join(dir, "lipsum.txt")
+	ioutil.WriteFile(existing, []byte("live site"), 0644)
+
+	opts := ExtractOptions{DestDir: tempPath, Overwrite: FailExisting}
+	_, err := r.ExtractWithOptions(opts)
+	if !os.IsExist(err) {
+		t.Errorf("Expected file exists error, got %v", err)
+	}
+
+	// logo.svg was extracted before the failure, only logo3.png is missing
+	opts.Overwrite = SkipExisting
+	filesCount, err := r.ExtractWithOptions(opts)
+	if err != nil || filesCount != 1 {
+		t.Errorf("Extracted %d files instead of 1: %v", filesCount, err)
+	}
+	content, _ := ioutil.ReadFile(existing)
+	if string(content) != "live site" {
+		t.Errorf("Existing file was overwritten")
+	}
+
+	opts.Overwrite = RenameExisting
+	filesCount, err = r.ExtractWithOptions(opts)
+	if err != nil || filesCount != 3 {
+		t.Errorf("Extracted %d files instead of 3: %v", filesCount, err)
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "lipsum.1.txt"))
+	if string(content) != "live site" {
+		t.Errorf("Existing file was not renamed")
+	}
+	fi, _ := os.Stat(existing)
+	if fi == nil || fi.Size() != 1478 {
+		t.Errorf("File was not extracted")
+	}
+}
+
+// TestExtractSkipUnchanged tests skipping files matching the header
+func TestExtractSkipUnchanged(t *testing.T) {
+	r := _newTestReader(t)
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	// create a file matching the archived one in size and mtime
+	dir := filepath.Join(tempPath, "repos", "wpress", "testdata")
+	os.MkdirAll(dir, 0755)
+	unchanged := filepath.Join(dir, "lipsum.txt")
+	ioutil.WriteFile(unchanged, make([]byte, 1478), 0644)
+	mtime := time.Unix(1420382531, 0)
+	os.Chtimes(unchanged, mtime, mtime)
+
+	// create a file differing in size
+	ioutil.WriteFile(filepath.Join(dir, "logo.svg"), []byte("changed"), 0644)
+
+	opts := ExtractOptions{DestDir: tempPath, SkipUnchanged: true}
+	filesCount, err := r.ExtractWithOptions(opts)
+	if err != nil || filesCount != 2 {
+		t.Errorf("Extracted %d files instead of 2: %v", filesCount, err)
+	}
+
+	content, _ := ioutil.ReadFile(unchanged)
+	if !bytes.Equal(content, make([]byte, 1478)) {
+		t.Errorf("Unchanged file was overwritten")
+	}
+}
+
+// TestDryRun tests reporting extraction without touching the file system
+func TestDryRun(t *testing.T) {
+	r := _newTestReader(t)
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	// create a file in place of an archived one
+	dir := filepath.Join(tempPath, "repos", "wpress", "testdata")
+	os.MkdirAll(dir, 0755)
+	existing := filepath.Join(dir, "lipsum.txt")
+	ioutil.WriteFile(existing, []byte("live site"), 0644)
+
+	report, err := r.DryRun(ExtractOptions{DestDir: tempPath})
+	if err != nil {
+		t.Fatalf("Unable to dry run: %s", err)
+	}
+	if len(report.Create) != 2 || len(report.Overwrite) != 1 || report.Overwrite[0] != existing {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if report.Bytes != 2313+1478+6855 {
+		t.Errorf("Unexpected total of %d bytes", report.Bytes)
+	}
+
+	// nothing was written
+	content, _ := ioutil.ReadFile(existing)
+	if string(content) != "live site" {
+		t.Errorf("Existing file was overwritten")
+	}
+	_, err = os.Stat(filepath.Join(dir, "logo.svg"))
+	if !os.IsNotExist(err) {
+		t.Errorf("File was created in dry run")
+	}
+}
+
+// TestExtractStrip tests dropping leading directories
+func TestExtractStrip(t *testing.T) {
+	r := _newTestReader(t)
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	opts := ExtractOptions{DestDir: tempPath, StripComponents: 2}
+	filesCount, err := r.ExtractWithOptions(opts)
+	if err != nil || filesCount != 3 {
+		t.Errorf("Extracted %d files instead of 3: %v", filesCount, err)
+	}
+	_, err = os.Stat(filepath.Join(tempPath, "testdata", "lipsum.txt"))
+	if err != nil {
+		t.Errorf("File was not extracted into stripped path: %s", err)
+	}
+
+	opts = ExtractOptions{DestDir: tempPath, StripPrefix: "/repos/wpress/testdata/"}
+	filesCount, err = r.ExtractWithOptions(opts)
+	if err != nil || filesCount != 3 {
+		t.Errorf("Extracted %d files instead of 3: %v", filesCount, err)
+	}
+	_, err = os.Stat(filepath.Join(tempPath, "logo.svg"))
+	if err != nil {
+		t.Errorf("File was not extracted into stripped path: %s", err)
+	}
+
+	// files with nothing left after stripping are skipped
+	opts = ExtractOptions{DestDir: tempPath, StripComponents: 4, DryRun: true}
+	filesCount, err = r.ExtractWithOptions(opts)
+	if err != nil || filesCount != 0 {
+		t.Errorf("Extracted %d files instead of 0: %v", filesCount, err)
+	}
+}
+
+// TestExtractRemap tests rewriting path prefixes
+func TestExtractRemap(t *testing.T) {
+	r := _newTestReader(t)
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	opts := ExtractOptions{
+		DestDir: tempPath,
+		Remap: []RemapRule{
+			{"repos/wpress/testdata/logo.svg", "images/logo.svg"},
+			{"repos/wpress", "shared"},
+			{"repos", "never"},
+		},
+	}
+	report, err := r.DryRun(opts)
+	if err != nil {
+		t.Fatalf("Unable to dry run: %s", err)
+	}
+
+	expected := []string{
+		filepath.Join(tempPath, "images", "logo.svg"),
+		filepath.Join(tempPath, "shared", "testdata", "lipsum.txt"),
+		filepath.Join(tempPath, "shared", "testdata", "logo3.png"),
+	}
+	if !reflect.DeepEqual(report.Create, expected) {
+		t.Errorf("Unexpected paths %v", report.Create)
+	}
+}
+
+// TestExtractUnsafePath tests refusing paths escaping the destination
+func TestExtractUnsafePath(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := _newArchive(t, tempPath,
+		"site/index.php", "<?php",
+		"../../escaped.php", "<?php")
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+
+	destDir := filepath.Join(tempPath, "dest", "root")
+	_, err = r.ExtractWithOptions(ExtractOptions{DestDir: destDir})
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrUnsafePath {
+		t.Errorf("Expected ErrUnsafePath, got %v", err)
+	}
+	_, err = os.Stat(filepath.Join(tempPath, "escaped.php"))
+	if !os.IsNotExist(err) {
+		t.Errorf("File was written outside the destination directory")
+	}
+
+	// sanitized path is kept inside the destination directory
+	filesCount, err := r.ExtractWithOptions(ExtractOptions{DestDir: destDir, SanitizePaths: true})
+	if err != nil || filesCount != 2 {
+		t.Errorf("Extracted %d files instead of 2: %v", filesCount, err)
+	}
+	_, err = os.Stat(filepath.Join(destDir, "escaped.php"))
+	if err != nil {
+		t.Errorf("Sanitized file was not extracted: %s", err)
+	}
+
+	// symbolic links in the destination are not followed outside of it
+	os.RemoveAll(destDir)
+	os.MkdirAll(destDir, 0755)
+	err = os.Symlink(tempPath, filepath.Join(destDir, "site"))
+	if err != nil {
+		t.Skipf("Unable to create symbolic link: %s", err)
+	}
+	for _, workers := range []int{1, 4} {
+		_, err = r.ExtractWithOptions(ExtractOptions{DestDir: destDir, SanitizePaths: true, Workers: workers})
+		if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrUnsafePath {
+			t.Errorf("Expected ErrUnsafePath, got %v", err)
+		}
+		_, err = os.Stat(filepath.Join(tempPath, "index.php"))
+		if !os.IsNotExist(err) {
+			t.Errorf("File was written outside the destination directory")
+		}
+	}
+}
+
+// TestExtractUnsafeName tests refusing names with control characters
+func TestExtractUnsafeName(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := _newArchive(t, tempPath,
+		"site/index.php", "<?php",
+		"site/evil\x00.php\n", "<?php")
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+
+	destDir := filepath.Join(tempPath, "dest")
+	_, err = r.ExtractWithOptions(ExtractOptions{DestDir: destDir})
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrUnsafeName {
+		t.Errorf("Expected ErrUnsafeName, got %v", err)
+	}
+
+	// control characters are replaced when sanitizing
+	filesCount, err := r.ExtractWithOptions(ExtractOptions{DestDir: destDir, SanitizeNames: true})
+	if err != nil || filesCount != 2 {
+		t.Errorf("Extracted %d files instead of 2: %v", filesCount, err)
+	}
+	_, err = os.Stat(filepath.Join(destDir, "site", "evil_.php_"))
+	if err != nil {
+		t.Errorf("Sanitized file was not extracted: %s", err)
+	}
+}
+
+// TestExtractLimits tests enforcing extraction limits
+func TestExtractLimits(t *testing.T) {
+	r := _newTestReader(t)
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	limits := map[error]ExtractOptions{
+		ErrTooManyFiles:  {MaxFiles: 2},
+		ErrFileTooLarge:  {MaxFileSize: 6854},
+		ErrTotalTooLarge: {MaxTotalSize: 2313 + 1478 + 6854},
+	}
+	for expected, opts := range limits {
+		// workers write files only after all of them are planned
+		for _, workers := range []int{1, 4} {
+			opts.DestDir, opts.Workers = tempPath, workers
+			filesCount, err := r.ExtractWithOptions(opts)
+			if pathErr, ok := err.(*os.PathError); ok {
+				err = pathErr.Err
+			}
+			if err != expected {
+				t.Errorf("Expected %v with %d workers, got %v", expected, workers, err)
+			}
+			if filesCount != 2 {
+				t.Errorf("Extracted %d files instead of 2 with %d workers", filesCount, workers)
+			}
+		}
+	}
+
+	// limits which are not exceeded don't fail
+	opts := ExtractOptions{DestDir: tempPath, MaxFiles: 3, MaxFileSize: 6855, MaxTotalSize: 2313 + 1478 + 6855}
+	_, err := r.ExtractWithOptions(opts)
+	if err != nil {
+		t.Errorf("Unable to extract files: %s", err)
+	}
+}
+
+// TestExtractCollisions tests detecting names differing only in case
+func TestExtractCollisions(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := _newArchive(t, tempPath,
+		"uploads/Image.JPG", "first",
+		"uploads/image.jpg", "second")
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+
+	destDir := filepath.Join(tempPath, "dest")
+	_, err = r.ExtractWithOptions(ExtractOptions{DestDir: destDir, Collisions: FailCollisions})
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrCaseCollision {
+		t.Errorf("Expected ErrCaseCollision, got %v", err)
+	}
+
+	// the first file was extracted before the failure, use a clean directory
+	destDir = filepath.Join(tempPath, "clean")
+	report, err := r.DryRun(ExtractOptions{DestDir: destDir, Collisions: WarnCollisions})
+	if err != nil || len(r.Warnings) != 1 || len(report.Create) != 2 {
+		t.Errorf("Expected one warning, got %v: %v", r.Warnings, err)
+	}
+
+	report, err = r.DryRun(ExtractOptions{DestDir: destDir, Collisions: RenameCollisions})
+	if err != nil {
+		t.Fatalf("Unable to dry run: %s", err)
+	}
+	if len(report.Create) != 2 || report.Create[1] != filepath.Join(destDir, "uploads", "image.1.jpg") {
+		t.Errorf("Unexpected report %+v", report)
+	}
+}
+
+// TestExtractDuplicates tests policies for paths appearing more than once
+func TestExtractDuplicates(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := _newArchive(t, tempPath,
+		"db/database.sql", "first",
+		"db/database.sql", "second")
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+
+	contents := map[DuplicatePolicy]string{KeepFirst: "first", KeepLast: "second"}
+	for policy, expected := range contents {
+		// existing files are skipped, but not the ones written in this run
+		destDir := filepath.Join(tempPath, "dest"+strconv.Itoa(int(policy)))
+		opts := ExtractOptions{DestDir: destDir, Duplicates: policy, Overwrite: SkipExisting}
+		_, err = r.ExtractWithOptions(opts)
+		if err != nil {
+			t.Errorf("Unable to extract files: %s", err)
+		}
+		content, _ := ioutil.ReadFile(filepath.Join(destDir, "db", "database.sql"))
+		if string(content) != expected {
+			t.Errorf("Extracted `%s` instead of `%s`", content, expected)
+		}
+	}
+
+	report, err := r.DryRun(ExtractOptions{DestDir: tempPath, Duplicates: KeepBoth})
+	if err != nil || len(report.Create) != 2 || report.Create[1] != filepath.Join(tempPath, "db", "database.1.sql") {
+		t.Errorf("Unexpected report %+v: %v", report, err)
+	}
+
+	_, err = r.DryRun(ExtractOptions{DestDir: tempPath, Duplicates: DuplicateError})
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrDuplicateEntry {
+		t.Errorf("Expected ErrDuplicateEntry, got %v", err)
+	}
+
+	// listing applies the same policies
+	entries, err := r.ListDistinct(KeepLast)
+	if err != nil || len(entries) != 1 || entries[0].Size != int64(len("second")) {
+		t.Errorf("Unexpected entries %+v: %v", entries, err)
+	}
+	entries, err = r.ListDistinct(KeepBoth)
+	if err != nil || len(entries) != 2 || entries[1].Path() != "db/database.1.sql" {
+		t.Errorf("Unexpected entries %+v: %v", entries, err)
+	}
+}
+
+// TestExtractRemovePartial tests removing partially written files
+func TestExtractRemovePartial(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	// cut the archive in the middle of the second file
+	data, err := ioutil.ReadFile(filepath.Join(_getPathToTests(t), TestArchiveName))
+	if err != nil {
+		t.Fatalf("Unable to read test archive: %s", err)
+	}
+	data = data[0 : 2*headerSize+2313+1000]
+	r := NewReaderAt(bytes.NewReader(data), int64(len(data)))
+
+	opts := ExtractOptions{DestDir: tempPath, RemovePartial: true}
+	filesCount, err := r.ExtractWithOptions(opts)
+	if err != ErrTruncated || filesCount != 1 {
+		t.Errorf("Extracted %d files instead of 1: %v", filesCount, err)
+	}
+	_, err = os.Stat(filepath.Join(tempPath, "repos", "wpress", "testdata", "lipsum.txt"))
+	if !os.IsNotExist(err) {
+		t.Errorf("Partially written file was not removed")
+	}
+}
+
+// TestExtractWorkers tests extracting files concurrently
+func TestExtractWorkers(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	// create an archive with many files, one of them appearing twice
+	var files []string
+	for i := 0; i < 50; i++ {
+		files = append(files, "uploads/"+strconv.Itoa(i)+".txt", strings.Repeat("x", i*100))
+	}
+	files = append(files, "uploads/7.txt", "last")
+	r, err := NewReader(_newArchive(t, tempPath, files...))
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+
+	destDir := filepath.Join(tempPath, "dest")
+	filesCount, err := r.ExtractWithOptions(ExtractOptions{DestDir: destDir, Workers: 4})
+	if err != nil || filesCount != 50 {
+		t.Errorf("Extracted %d files instead of 50: %v", filesCount, err)
+	}
+
+	for i := 0; i < 50; i++ {
+		content, _ := ioutil.ReadFile(filepath.Join(destDir, "uploads", strconv.Itoa(i)+".txt"))
+		expected := strings.Repeat("x", i*100)
+		if i == 7 {
+			expected = "last"
+		}
+		if string(content) != expected {
+			t.Errorf("File %d.txt has unexpected content", i)
+		}
+	}
+}
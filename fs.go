@@ -0,0 +1,299 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"sort"
+	"time"
+)
+
+// FS exposes files of an archive as a read-only file system, it implements
+// fs.FS, fs.ReadDirFS and fs.StatFS
+type FS struct {
+	source io.ReaderAt
+	nodes  map[string]*fsNode
+}
+
+// fsNode is a file or a directory of the file system
+type fsNode struct {
+	name     string
+	size     int64
+	modTime  time.Time
+	offset   int64
+	dir      bool
+	children []*fsNode
+	// compression and length of the content as stored
+	compression Compression
+	stored      int64
+}
+
+// NewFS creates a new FS instance and builds the directory index from the
+// headers of the archive
+func NewFS(r *Reader) (*FS, error) {
+	// random access is needed to read files independently
+	ra, ok := r.source.(io.ReaderAt)
+	if !ok {
+		return nil, ErrNotSeekable
+	}
+
+	// create a new instance of FS with the root directory
+	f := &FS{ra, map[string]*fsNode{".": {name: ".", dir: true}}}
+
+	// get a cursor at the beginning of the file
+	c, err := r.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	// loop until end of file was reached
+	for {
+		h, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// skip paths that can't be represented in the file system
+		name := h.GetPath()
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+
+		size, err := h.GetOriginalSize()
+		if err != nil {
+			return nil, err
+		}
+		modTime, _ := h.GetModTime()
+		f.addFile(name, &fsNode{
+			name:        path.Base(name),
+			size:        size,
+			modTime:     modTime,
+			offset:      c.offset,
+			compression: h.GetCompression(),
+			stored:      c.remaining,
+		})
+	}
+
+	// sort directory entries by name
+	for _, n := range f.nodes {
+		sort.Slice(n.children, func(i, j int) bool {
+			return n.children[i].name < n.children[j].name
+		})
+	}
+
+	return f, nil
+}
+
+// addFile adds file node to the index creating its parent directories
+func (f *FS) addFile(name string, node *fsNode) {
+	// file appearing more than once in archive, last one wins
+	if existing, ok := f.nodes[name]; ok {
+		if !existing.dir {
+			*existing = *node
+		}
+		return
+	}
+
+	// skip the file if another file is in place of its parent directory
+	parent := f.mkdirAll(path.Dir(name))
+	if parent == nil {
+		return
+	}
+
+	f.nodes[name] = node
+	parent.children = append(parent.children, node)
+}
+
+// mkdirAll returns directory node of name creating it and its parents when
+// missing, nil is returned when a file is in place of the directory
+func (f *FS) mkdirAll(name string) *fsNode {
+	if n, ok := f.nodes[name]; ok {
+		if !n.dir {
+			return nil
+		}
+		return n
+	}
+
+	parent := f.mkdirAll(path.Dir(name))
+	if parent == nil {
+		return nil
+	}
+
+	n := &fsNode{name: path.Base(name), dir: true}
+	f.nodes[name] = n
+	parent.children = append(parent.children, n)
+	return n
+}
+
+// lookup returns the node of name or a path error
+func (f *FS) lookup(op string, name string) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	n, ok := f.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+// Open opens the named file or directory
+func (f *FS) Open(name string) (fs.File, error) {
+	n, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.dir {
+		return &fsDir{node: n}, nil
+	}
+	if n.compression == NoCompression {
+		return &fsFile{n, io.NewSectionReader(f.source, n.offset, n.size)}, nil
+	}
+
+	// compressed content is decompressed whole, so it can be read at any
+	// offset
+	src, err := openEntry(name, n.compression, n.size, io.NewSectionReader(f.source, n.offset, n.stored))
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	content, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	return &fsFile{n, io.NewSectionReader(bytes.NewReader(content), 0, n.size)}, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := f.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return n.entries(), nil
+}
+
+// Stat returns file info of the named file or directory
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	n, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// entries returns children of the node as directory entries
+func (n *fsNode) entries() []fs.DirEntry {
+	entries := make([]fs.DirEntry, len(n.children))
+	for i, child := range n.children {
+		entries[i] = child
+	}
+	return entries
+}
+
+// Name returns base name of the file
+func (n *fsNode) Name() string { return n.name }
+
+// Size returns length of the file content
+func (n *fsNode) Size() int64 { return n.size }
+
+// Mode returns file mode bits, archive doesn't store permissions
+func (n *fsNode) Mode() fs.FileMode {
+	if n.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// ModTime returns last modification date
+func (n *fsNode) ModTime() time.Time { return n.modTime }
+
+// IsDir reports whether node is a directory
+func (n *fsNode) IsDir() bool { return n.dir }
+
+// Sys returns nil, there is no underlying data source
+func (n *fsNode) Sys() interface{} { return nil }
+
+// Type returns type bits of the file mode
+func (n *fsNode) Type() fs.FileMode { return n.Mode().Type() }
+
+// Info returns file info of the node
+func (n *fsNode) Info() (fs.FileInfo, error) { return n, nil }
+
+// String returns human readable representation of the node
+func (n *fsNode) String() string { return fs.FormatFileInfo(n) }
+
+// fsFile is an open file of the file system
+type fsFile struct {
+	node *fsNode
+	*io.SectionReader
+}
+
+// Stat returns file info of the file
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.node, nil }
+
+// Close closes the file, the archive itself stays open
+func (f *fsFile) Close() error { return nil }
+
+// fsDir is an open directory of the file system
+type fsDir struct {
+	node   *fsNode
+	offset int
+}
+
+// Stat returns file info of the directory
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.node, nil }
+
+// Read fails, directories have no content
+func (d *fsDir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.name, Err: fs.ErrInvalid}
+}
+
+// Close closes the directory
+func (d *fsDir) Close() error { return nil }
+
+// ReadDir reads up to count directory entries, see fs.ReadDirFile
+func (d *fsDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	entries := d.node.entries()[d.offset:]
+	if count > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > 0 && count < len(entries) {
+		entries = entries[0:count]
+	}
+	d.offset += len(entries)
+	return entries, nil
+}
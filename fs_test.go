@@ -0,0 +1,77 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+// TestFS tests exposing archive as a file system
+func TestFS(t *testing.T) {
+	path := _getPathToTests(t)
+	// create a new reader instace with the test archive
+	r, err := NewReader(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+
+	fsys, err := NewFS(r)
+	if err != nil {
+		t.Fatalf("Failed to create a new FS instance: %s", err)
+	}
+
+	// run the standard file system conformance tests
+	err = fstest.TestFS(fsys,
+		"repos/wpress/testdata/logo.svg",
+		"repos/wpress/testdata/lipsum.txt",
+		"repos/wpress/testdata/logo3.png")
+	if err != nil {
+		t.Errorf("File system doesn't conform: %s", err)
+	}
+
+	// check that file info is populated from the header
+	fi, err := fs.Stat(fsys, "repos/wpress/testdata/lipsum.txt")
+	if err != nil {
+		t.Fatalf("Unable to stat file: %s", err)
+	}
+	if fi.Size() != 1478 || fi.ModTime().Unix() != 1420382531 {
+		t.Errorf("Unexpected file info: %s", fs.FormatFileInfo(fi))
+	}
+
+	// walk the whole tree
+	files := 0
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files++
+		}
+		return err
+	})
+	if err != nil || files != 3 {
+		t.Errorf("Walked %d files instead of 3: %v", files, err)
+	}
+}
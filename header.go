@@ -0,0 +1,141 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// HeaderSize is the length of header block in bytes, see Header.Marshal
+const HeaderSize = headerSize
+
+// SetName sets filename of the file, it must not contain slashes or control
+// characters. Names longer than the Name field are written with a long
+// name record, like those of NewHeader.
+func (h *Header) SetName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') ||
+		strings.IndexFunc(name, unicode.IsControl) != -1 {
+		return ErrUnsafeName
+	}
+	return h.setPath(name, h.GetPrefix())
+}
+
+// SetPrefix sets path name of the file, "." when it is in the root of
+// archive, it must not contain control characters
+func (h *Header) SetPrefix(prefix string) error {
+	if strings.IndexFunc(prefix, unicode.IsControl) != -1 {
+		return ErrUnsafeName
+	}
+	return h.setPath(h.GetName(), path.Clean(prefix))
+}
+
+// setPath sets Name and Prefix fields, the full path is kept for a long
+// name record when the parts have to be truncated
+func (h *Header) setPath(filename string, prefix string) error {
+	longName := ""
+	if len(filename) > filenameSize || len(prefix) > prefixSize {
+		longName = path.Join(prefix, filename)
+		if len(longName) > longNameSize {
+			return ErrLongNameTooLong
+		}
+		filename, prefix = truncateName(filename, filenameSize), truncateName(prefix, prefixSize)
+	}
+
+	h.Name, h.Prefix, h.longName = newField(filename, filenameSize), newField(prefix, prefixSize), longName
+	return nil
+}
+
+// SetSize sets length of file contents, sizes of more digits than the Size
+// field holds are stored in binary
+func (h *Header) SetSize(size int64) error {
+	field, err := formatSize(size)
+	if err != nil {
+		return err
+	}
+	h.Size = field
+	return nil
+}
+
+// SetMtime sets last modified date, nanoseconds are written in mtime
+// record when Writer.PreciseMtime is set
+func (h *Header) SetMtime(modTime time.Time) error {
+	unixTime := strconv.FormatInt(modTime.Unix(), 10)
+	if len(unixTime) > mtimeSize {
+		return errors.New("last modified date is after than max allowed")
+	}
+	h.Mtime, h.mtimeNsec = newField(unixTime, mtimeSize), modTime.Nanosecond()
+	return nil
+}
+
+// newField returns field of size bytes holding value padded by zero bytes
+func newField(value string, size int) []byte {
+	field := make([]byte, size)
+	copy(field, value)
+	return field
+}
+
+// Marshal returns header block of h after checking its fields have the
+// widths of DefaultLayout and hold valid values, ErrCorruptHeader is
+// returned otherwise. Records of extensions, e.g. the long name record,
+// are not part of the block.
+func (h Header) Marshal() ([]byte, error) {
+	if len(h.Name) != filenameSize || len(h.Size) != contentSize || len(h.Mtime) != mtimeSize ||
+		len(h.Prefix) != prefixSize || !validHeaderBlock(&h) {
+		return nil, ErrCorruptHeader
+	}
+
+	block := make([]byte, 0, headerSize)
+	block = append(block, h.Name...)
+	block = append(block, h.Size...)
+	block = append(block, h.Mtime...)
+	return append(block, h.Prefix...), nil
+}
+
+// Unmarshal populates h from copy of header block, io.EOF is returned for
+// the EOF block and ErrCorruptHeader for blocks of other length or holding
+// invalid values
+func (h *Header) Unmarshal(block []byte) error {
+	if len(block) != headerSize {
+		return ErrCorruptHeader
+	}
+	if bytes.Equal(block, eofBlock) {
+		return io.EOF
+	}
+
+	parsed := &Header{}
+	parsed.PopulateFromBytes(append([]byte{}, block...))
+	if !validHeaderBlock(parsed) {
+		return ErrCorruptHeader
+	}
+	*h = *parsed
+	return nil
+}
@@ -0,0 +1,117 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHeaderMarshal tests building header by setters and reading it back
+func TestHeaderMarshal(t *testing.T) {
+	h := &Header{}
+	err := h.SetName("index.php")
+	if err == nil {
+		err = h.SetPrefix("wp-content/themes/twentyfifteen/")
+	}
+	if err == nil {
+		err = h.SetSize(1048576)
+	}
+	if err == nil {
+		err = h.SetMtime(time.Unix(1420382531, 0))
+	}
+	if err != nil {
+		t.Fatalf("Failed to set header fields: %s", err)
+	}
+
+	block, err := h.Marshal()
+	if err != nil || len(block) != HeaderSize {
+		t.Fatalf("Marshalled %d bytes instead of %d: %v", len(block), HeaderSize, err)
+	}
+
+	parsed := &Header{}
+	err = parsed.Unmarshal(block)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal header: %s", err)
+	}
+	size, _ := parsed.GetSize64()
+	mtime, _ := parsed.GetMtime()
+	if parsed.GetPath() != "wp-content/themes/twentyfifteen/index.php" || size != 1048576 || mtime != 1420382531 {
+		t.Errorf("Unmarshalled %s of %d bytes at %d", parsed.GetPath(), size, mtime)
+	}
+
+	// the parsed header doesn't share the block
+	block[0] = 'X'
+	if parsed.GetName() != "index.php" {
+		t.Errorf("Unmarshalled header shares the block")
+	}
+}
+
+// TestHeaderSetters tests setters reject invalid values and keep long
+// paths
+func TestHeaderSetters(t *testing.T) {
+	h, _ := NewHeader("wp-content/index.php", 5, time.Now())
+	for _, name := range []string{"", "..", "a/b", "a\x00b"} {
+		if h.SetName(name) != ErrUnsafeName {
+			t.Errorf("Name %q was accepted", name)
+		}
+	}
+	if h.SetPrefix("wp-content\n") != ErrUnsafeName {
+		t.Errorf("Prefix with control character was accepted")
+	}
+	if h.SetSize(-1) == nil {
+		t.Errorf("Negative size was accepted")
+	}
+
+	long := strings.Repeat("x", filenameSize+1)
+	err := h.SetName(long)
+	if err != nil || h.GetPath() != "wp-content/"+long {
+		t.Errorf("Long name was set to %s: %v", h.GetPath(), err)
+	}
+	err = h.SetName("index.php")
+	if err != nil || h.GetPath() != "wp-content/index.php" || h.longName != "" {
+		t.Errorf("Name was set to %s: %v", h.GetPath(), err)
+	}
+}
+
+// TestHeaderUnmarshalErrors tests unmarshalling invalid blocks
+func TestHeaderUnmarshalErrors(t *testing.T) {
+	h := &Header{}
+	if h.Unmarshal(eofBlock) != io.EOF {
+		t.Errorf("EOF block was not reported")
+	}
+	if h.Unmarshal(make([]byte, 10)) != ErrCorruptHeader {
+		t.Errorf("Short block was accepted")
+	}
+	garbage := []byte(strings.Repeat("lorem ipsum ", HeaderSize/12+1))[:HeaderSize]
+	if h.Unmarshal(garbage) != ErrCorruptHeader {
+		t.Errorf("Garbage was accepted")
+	}
+	if _, err := (Header{}).Marshal(); err != ErrCorruptHeader {
+		t.Errorf("Empty header was marshalled")
+	}
+}
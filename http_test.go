@@ -0,0 +1,71 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewHTTPFileSystem tests serving archive over HTTP
+func TestNewHTTPFileSystem(t *testing.T) {
+	path := _getPathToTests(t)
+	// create a new reader instace with the test archive
+	r, err := NewReader(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+
+	httpfs, err := NewHTTPFileSystem(r)
+	if err != nil {
+		t.Fatalf("Failed to create a new http.FileSystem: %s", err)
+	}
+	handler := http.FileServer(httpfs)
+
+	// request a file
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/repos/wpress/testdata/lipsum.txt", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d", rec.Code)
+	}
+	if rec.Body.Len() != 1478 {
+		t.Errorf("Served %d bytes instead of 1478", rec.Body.Len())
+	}
+	modTime := time.Unix(1420382531, 0).UTC().Format(http.TimeFormat)
+	if rec.Header().Get("Last-Modified") != modTime {
+		t.Errorf("Last-Modified is %s instead of %s", rec.Header().Get("Last-Modified"), modTime)
+	}
+
+	// request a directory listing
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/repos/wpress/testdata/", nil))
+	if !strings.Contains(rec.Body.String(), "logo3.png") {
+		t.Errorf("Directory listing doesn't contain logo3.png")
+	}
+}
@@ -0,0 +1,127 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// IgnoreFileName is the name of the file in the root of added directories
+// listing files to leave out of the archive, with gitignore syntax
+const IgnoreFileName = ".wpressignore"
+
+// ignoreRule is a single pattern of an ignore file
+type ignoreRule struct {
+	pattern  string
+	negate   bool // pattern starts with "!", it re-includes files
+	dirOnly  bool // pattern ends with "/", it matches only directories
+	anchored bool // pattern contains "/", it is matched from the root
+}
+
+// parseIgnore reads rules from src with gitignore syntax: blank lines and
+// lines starting with "#" are skipped, "!" negates the pattern, trailing
+// "/" matches only directories and patterns containing "/" are relative to
+// the root while the others match the name at any depth
+func parseIgnore(src io.Reader) ([]ignoreRule, error) {
+	var rules []ignoreRule
+
+	scanner := bufio.NewScanner(src)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		// escaped leading characters are literal
+		line = strings.TrimPrefix(line, "\\")
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		// validate the pattern, so errors are reported before archiving
+		_, err := matchPattern(line, "")
+		if err != nil {
+			return nil, err
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+
+	return rules, scanner.Err()
+}
+
+// readIgnoreFile returns the rules of the ignore file in root, no rules are
+// returned when there is no such file
+func readIgnoreFile(root string) ([]ignoreRule, error) {
+	file, err := os.Open(root + string(os.PathSeparator) + IgnoreFileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseIgnore(file)
+}
+
+// ignored reports whether the file or directory at name, relative to the
+// root, is left out by rules, the last matching rule wins
+func ignored(rules []ignoreRule, name string, dir bool) bool {
+	result := false
+	for _, rule := range rules {
+		if rule.dirOnly && !dir {
+			continue
+		}
+
+		var matched bool
+		if rule.anchored {
+			matched, _ = matchPattern(rule.pattern, name)
+		} else {
+			matched, _ = path.Match(rule.pattern, path.Base(name))
+		}
+		if matched {
+			result = !rule.negate
+		}
+	}
+	return result
+}
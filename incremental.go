@@ -0,0 +1,208 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// unchanged reports whether existing file pathToFile described by fi is the
+// same as the archived file described by h whose content is opened by open
+func (e *extraction) unchanged(pathToFile string, fi os.FileInfo, h *Header, open func() (io.ReadCloser, error)) (bool, error) {
+	size, _ := h.GetOriginalSize()
+	if fi.Size() != size {
+		return false, nil
+	}
+	if !e.opts.CompareContents {
+		mtime, _ := h.GetMtime()
+		return fi.ModTime().Unix() == mtime, nil
+	}
+
+	archived, err := open()
+	if err != nil {
+		return false, err
+	}
+	defer archived.Close()
+	existing, err := os.Open(pathToFile)
+	if err != nil {
+		return false, err
+	}
+	defer existing.Close()
+
+	archivedSum, err := readerSum(archived)
+	if err != nil {
+		return false, err
+	}
+	existingSum, err := readerSum(existing)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(archivedSum, existingSum), nil
+}
+
+// readerSum returns SHA-256 hash of everything read from src
+func readerSum(src io.Reader) ([]byte, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, src)
+	if err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// openCurrent returns function opening content of the current file
+// described by h independently of the cursor, ErrNotSeekable is returned
+// by it unless archive supports random access
+func (e *extraction) openCurrent(h *Header) func() (io.ReadCloser, error) {
+	offset, remaining := e.c.offset, e.c.remaining
+	return func() (io.ReadCloser, error) {
+		ra, ok := e.r.source.(io.ReaderAt)
+		if !ok {
+			return nil, ErrNotSeekable
+		}
+		size, err := h.GetOriginalSize()
+		if err != nil {
+			return nil, err
+		}
+		return openEntry(h.GetPath(), h.GetCompression(), size, io.NewSectionReader(ra, offset, remaining))
+	}
+}
+
+// deleteExtraneous removes files and symbolic links under the destination
+// directory which are not in archive and directories left empty, in dry run
+// they are only reported
+func (e *extraction) deleteExtraneous() error {
+	root := e.opts.DestDir
+	if root == "" {
+		root = "."
+	}
+
+	var files, dirs []string
+	err := filepath.Walk(root, func(pathToFile string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) && pathToFile == root {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, pathToFile)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		// filter selects paths in archive, paths which can't be traced
+		// back to them are kept unless everything is selected
+		if !e.opts.Filter.all() && !e.selectedSource(filepath.ToSlash(rel)) {
+			return nil
+		}
+
+		if fi.IsDir() {
+			dirs = append(dirs, pathToFile)
+		} else if !e.present[filepath.Join(e.opts.DestDir, rel)] {
+			files = append(files, pathToFile)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if e.opts.DryRun {
+		if e.report != nil {
+			e.report.Delete = append(e.report.Delete, files...)
+		}
+		return nil
+	}
+
+	for _, pathToFile := range files {
+		err = os.Remove(pathToFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	// remove the deepest directories first
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			err = os.Remove(dir)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// selectedSource reports whether Filter selects all paths in archive of
+// files which would be extracted to name relative to the destination
+// directory, false is returned when they can't be told, e.g. with
+// StripComponents
+func (e *extraction) selectedSource(name string) bool {
+	if e.opts.StripComponents > 0 {
+		return false
+	}
+
+	// undo the remapping by the rule which produced name, if any
+	candidates := []string{name}
+	for _, rule := range e.opts.Remap {
+		reversed, ok := RemapRule{From: rule.To, To: rule.From}.apply(name)
+		if ok {
+			candidates = append(candidates, reversed)
+		}
+	}
+
+	selected := false
+	for _, candidate := range candidates {
+		if e.opts.StripPrefix != "" {
+			prefix := path.Clean("." + string(os.PathSeparator) + e.opts.StripPrefix)
+			if prefix != "." {
+				candidate = prefix + "/" + candidate
+			}
+		}
+
+		// the archive path has to lead back to name
+		target, ok, err := e.targetName(candidate)
+		if err != nil || !ok || target != name {
+			continue
+		}
+		if !e.opts.Filter.Match(candidate) {
+			return false
+		}
+		selected = true
+	}
+	return selected
+}
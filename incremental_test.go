@@ -0,0 +1,150 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestExtractIncremental tests restoring archive over an existing site
+func TestExtractIncremental(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := _newArchive(t, tempPath, "index.php", "<?php", "wp-content/a.txt", "abc", "wp-content/b.txt", "xyz")
+	dest := filepath.Join(tempPath, "site")
+	os.MkdirAll(filepath.Join(dest, "wp-content", "old"), 0755)
+	os.MkdirAll(filepath.Join(dest, "wp-content", "cache"), 0755)
+	ioutil.WriteFile(filepath.Join(dest, "index.php"), []byte("<?php"), 0644)
+	ioutil.WriteFile(filepath.Join(dest, "wp-content", "a.txt"), []byte("abd"), 0644)
+	ioutil.WriteFile(filepath.Join(dest, "wp-content", "old", "c.txt"), []byte("c"), 0644)
+	ioutil.WriteFile(filepath.Join(dest, "wp-content", "cache", "d.txt"), []byte("d"), 0644)
+	// contents differ only in a.txt, modification dates everywhere
+	modTime := time.Unix(1420382531, 0)
+	os.Chtimes(filepath.Join(dest, "index.php"), time.Now(), time.Now())
+	os.Chtimes(filepath.Join(dest, "wp-content", "a.txt"), modTime, modTime)
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %s", err)
+	}
+	defer r.Close()
+
+	opts := ExtractOptions{
+		DestDir:         dest,
+		SkipUnchanged:   true,
+		CompareContents: true,
+		Delete:          true,
+		Filter:          Filter{Exclude: []string{"wp-content/cache"}},
+	}
+	report, err := r.DryRun(opts)
+	if err != nil {
+		t.Fatalf("Failed dry run: %s", err)
+	}
+	if len(report.Delete) != 1 || report.Delete[0] != filepath.Join(dest, "wp-content", "old", "c.txt") {
+		t.Errorf("Unexpected files to delete %v", report.Delete)
+	}
+	if len(report.Skip) != 1 || report.Skip[0] != filepath.Join(dest, "index.php") {
+		t.Errorf("Unexpected skipped files %v", report.Skip)
+	}
+
+	n, err := r.ExtractWithOptions(opts)
+	if err != nil || n != 2 {
+		t.Fatalf("Extracted %d files instead of 2: %v", n, err)
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(dest, "wp-content", "a.txt"))
+	if string(content) != "abc" {
+		t.Errorf("Changed file was not restored: %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "wp-content", "old")); !os.IsNotExist(err) {
+		t.Errorf("File which is not in archive was kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "wp-content", "cache", "d.txt")); err != nil {
+		t.Errorf("Excluded file was removed: %v", err)
+	}
+
+	// modification dates are compared without CompareContents, only
+	// index.php has a different one
+	opts.CompareContents = false
+	n, err = r.ExtractWithOptions(opts)
+	if err != nil || n != 1 {
+		t.Errorf("Extracted %d files instead of 1: %v", n, err)
+	}
+}
+
+// TestExtractDeleteStrip tests excluding files from deletion by their paths
+// in archive when paths are rewritten
+func TestExtractDeleteStrip(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := _newArchive(t, tempPath, "wp-content/a.txt", "abc")
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %s", err)
+	}
+	defer r.Close()
+
+	filter := Filter{Exclude: []string{"wp-content/cache/**"}}
+	tests := []struct {
+		opts    ExtractOptions
+		deleted bool
+	}{
+		{ExtractOptions{StripPrefix: "wp-content", Filter: filter}, true},
+		{ExtractOptions{Remap: []RemapRule{{From: "wp-content", To: "."}}, Filter: filter}, true},
+		// paths in archive can't be told
+		{ExtractOptions{StripComponents: 1, Filter: filter}, false},
+		{ExtractOptions{StripComponents: 1}, true},
+	}
+	for _, test := range tests {
+		dest := filepath.Join(tempPath, "site")
+		os.RemoveAll(dest)
+		os.MkdirAll(filepath.Join(dest, "cache"), 0755)
+		ioutil.WriteFile(filepath.Join(dest, "cache", "d.txt"), []byte("d"), 0644)
+		ioutil.WriteFile(filepath.Join(dest, "old.txt"), []byte("old"), 0644)
+
+		opts := test.opts
+		opts.DestDir, opts.Delete = dest, true
+		_, err = r.ExtractWithOptions(opts)
+		if err != nil {
+			t.Fatalf("Failed to extract archive: %s", err)
+		}
+		_, err = os.Stat(filepath.Join(dest, "cache", "d.txt"))
+		if opts.Filter.all() == !os.IsNotExist(err) {
+			t.Errorf("File in cache was kept %v with %+v", err == nil, test.opts)
+		}
+		_, err = os.Stat(filepath.Join(dest, "old.txt"))
+		if test.deleted != os.IsNotExist(err) {
+			t.Errorf("File which is not in archive was deleted %v with %+v", !test.deleted, test.opts)
+		}
+		if _, err := os.Stat(filepath.Join(dest, "a.txt")); err != nil {
+			t.Errorf("File was not extracted: %v", err)
+		}
+	}
+}
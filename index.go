@@ -0,0 +1,332 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// ErrStaleIndex is returned when loading an index saved for an archive of
+// different size or modification date
+var ErrStaleIndex = errors.New("index doesn't match archive")
+
+// ErrInvalidIndex is returned when loading a file which is not an index
+var ErrInvalidIndex = errors.New("invalid index file")
+
+// indexMagic identifies index files and the version of their format
+const indexMagic = "WPIDX\x01"
+
+// IndexExt is the extension of sidecar index files, appended to the archive
+// filename, e.g. "backup.wpress.idx"
+const IndexExt = ".idx"
+
+// zeroUnix is the unix time of the zero time.Time, used for dates that are
+// unknown
+var zeroUnix = time.Time{}.Unix()
+
+// Index reads all headers in one pass and records the metadata of files, so
+// that subsequent Stat, Open, ExtractFile and ExtractFileTo calls don't scan
+// the archive and listing iterates over memory. Like the scanning lookups,
+// the index keeps the first occurrence of a path appearing more than once.
+func (r *Reader) Index() error {
+	// random access is needed to jump to indexed files
+	if _, ok := r.source.(io.ReaderAt); !ok {
+		return ErrNotSeekable
+	}
+
+	// drop the previous index, so the archive is actually scanned, the
+	// table of contents isn't read afterwards
+	r.tocOnce.Do(func() {})
+	r.setIndex(nil)
+
+	entries := []EntryInfo{}
+	err := r.Walk(func(e EntryInfo) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.setIndex(entries)
+
+	return nil
+}
+
+// indexed returns the index, reading the table of contents first when the
+// archive may have one, entries are nil without the index
+func (r *Reader) indexed() ([]EntryInfo, map[string]EntryInfo) {
+	if r.hasTOC {
+		r.tocOnce.Do(r.readTOC)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.entries, r.index
+}
+
+// setIndex replaces the index with entries, nil removes the index
+func (r *Reader) setIndex(entries []EntryInfo) {
+	var index map[string]EntryInfo
+	if entries != nil {
+		index = make(map[string]EntryInfo, len(entries))
+		for _, e := range entries {
+			if _, ok := index[e.Path()]; !ok {
+				index[e.Path()] = e
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.entries = entries
+	r.index = index
+	if entries != nil {
+		r.NumberOfFiles = len(entries)
+	}
+	r.mu.Unlock()
+}
+
+// archiveStat returns size and modification date of the archive, the date
+// is zero for archives not opened by NewReader
+func (r *Reader) archiveStat() (int64, time.Time, error) {
+	var modTime time.Time
+	if r.File != nil {
+		fi, err := r.File.Stat()
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		modTime = fi.ModTime()
+		if r.source == r.File {
+			return fi.Size(), modTime, nil
+		}
+	}
+
+	// NewReaderAt and decryption wrap the source in a section reader
+	if s, ok := r.source.(interface{ Size() int64 }); ok {
+		return s.Size(), modTime, nil
+	}
+
+	return 0, time.Time{}, ErrNotSeekable
+}
+
+// SaveIndex writes the index to filename, building it first when Index was
+// not called. Size and modification date of the archive are recorded, so
+// LoadIndex can detect that the archive has changed.
+func (r *Reader) SaveIndex(filename string) error {
+	size, modTime, err := r.archiveStat()
+	if err != nil {
+		return err
+	}
+
+	// build the index unless it is present
+	entries, _ := r.indexed()
+	if entries == nil {
+		err = r.Index()
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		entries = r.entries
+		r.mu.Unlock()
+	}
+
+	// try to create the file
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	err = writeIndex(w, size, modTime, entries)
+	if err != nil {
+		file.Close()
+		os.Remove(filename)
+		return err
+	}
+
+	return file.Close()
+}
+
+// LoadIndex reads the index written by SaveIndex from filename.
+// ErrStaleIndex is returned when the archive has changed since the index was
+// saved, the index is then left unchanged.
+func (r *Reader) LoadIndex(filename string) error {
+	size, modTime, err := r.archiveStat()
+	if err != nil {
+		return err
+	}
+
+	// try to open the file
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	indexSize, indexModTime, entries, err := readIndex(bufio.NewReader(file))
+	if err != nil {
+		return &os.PathError{Op: "read", Path: filename, Err: err}
+	}
+
+	// compare the archive with the one the index was saved for
+	if indexSize != size || !indexModTime.Equal(modTime) {
+		return &os.PathError{Op: "read", Path: filename, Err: ErrStaleIndex}
+	}
+
+	// guard against indexes pointing outside of the archive
+	if !validEntries(entries, size, r.Layout.get()) {
+		return &os.PathError{Op: "read", Path: filename, Err: ErrInvalidIndex}
+	}
+
+	r.setIndex(entries)
+
+	return nil
+}
+
+// validEntries reports whether contents of all entries lie within archive
+// of size bytes whose headers are laid out by layout
+func validEntries(entries []EntryInfo, size int64, layout Layout) bool {
+	for _, e := range entries {
+		if e.Offset < int64(layout.HeaderSize()) || e.Size < 0 || e.Offset+e.Size > size || e.OriginalSize < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// writeIndex encodes the archive size and date followed by the entries
+func writeIndex(w *bufio.Writer, size int64, modTime time.Time, entries []EntryInfo) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	putInt := func(n int64) {
+		w.Write(buf[:binary.PutVarint(buf, n)])
+	}
+	putString := func(s string) {
+		putInt(int64(len(s)))
+		w.WriteString(s)
+	}
+
+	w.WriteString(indexMagic)
+	putInt(size)
+	putInt(modTime.Unix())
+	putInt(int64(modTime.Nanosecond()))
+	putInt(int64(len(entries)))
+	for _, e := range entries {
+		putString(e.Name)
+		putString(e.Prefix)
+		putInt(e.Size)
+		putInt(e.ModTime.Unix())
+		putInt(int64(e.ModTime.Nanosecond()))
+		putInt(e.Offset)
+		putInt(int64(e.Compression))
+		putInt(e.OriginalSize)
+		putInt(int64(len(e.Metadata)))
+		keys := make([]string, 0, len(e.Metadata))
+		for key := range e.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			putString(key)
+			putString(e.Metadata[key])
+		}
+	}
+
+	// bufio.Writer keeps the first error, so it is checked only once
+	return w.Flush()
+}
+
+// readIndex decodes the index written by writeIndex
+func readIndex(r *bufio.Reader) (int64, time.Time, []EntryInfo, error) {
+	magic := make([]byte, len(indexMagic))
+	_, err := io.ReadFull(r, magic)
+	if err != nil || string(magic) != indexMagic {
+		return 0, time.Time{}, nil, ErrInvalidIndex
+	}
+
+	// remember the first error, so the values can be read in sequence
+	getInt := func() int64 {
+		if err != nil {
+			return 0
+		}
+		var n int64
+		n, err = binary.ReadVarint(r)
+		return n
+	}
+	getString := func() string {
+		n := getInt()
+		if err != nil || n < 0 || n > longNameSize {
+			if err == nil {
+				err = ErrInvalidIndex
+			}
+			return ""
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return string(b)
+	}
+
+	size := getInt()
+	var modTime time.Time
+	if sec, nsec := getInt(), getInt(); sec != zeroUnix {
+		modTime = time.Unix(sec, nsec)
+	}
+	count := getInt()
+	if err != nil || count < 0 {
+		return 0, time.Time{}, nil, ErrInvalidIndex
+	}
+
+	// grow the slice while reading, count is not trusted for allocation
+	entries := []EntryInfo{}
+	for i := int64(0); i < count; i++ {
+		e := EntryInfo{Name: getString(), Prefix: getString(), Size: getInt()}
+		if mtime, nsec := getInt(), getInt(); mtime != zeroUnix {
+			e.ModTime = time.Unix(mtime, nsec)
+		}
+		e.Offset = getInt()
+		e.Compression, e.OriginalSize = Compression(getInt()), getInt()
+		metadata := getInt()
+		if metadata < 0 || metadata > maxMetadataRecord {
+			err = ErrInvalidIndex
+		}
+		for j := int64(0); j < metadata && err == nil; j++ {
+			if e.Metadata == nil {
+				e.Metadata = make(map[string]string)
+			}
+			key := getString()
+			e.Metadata[key] = getString()
+		}
+		if err != nil {
+			return 0, time.Time{}, nil, ErrInvalidIndex
+		}
+		entries = append(entries, e)
+	}
+
+	return size, modTime, entries, nil
+}
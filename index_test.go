@@ -0,0 +1,148 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestIndex tests looking files up in the index
+func TestIndex(t *testing.T) {
+	path := _getPathToTests(t)
+	// create a new reader instace with the test archive
+	r, err := NewReader(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+
+	scanned, err := r.Stat("repos/wpress/testdata/lipsum.txt")
+	if err != nil {
+		t.Fatalf("Unable to stat file: %s", err)
+	}
+
+	err = r.Index()
+	if err != nil {
+		t.Fatalf("Unable to index archive: %s", err)
+	}
+
+	// indexed lookup returns the same metadata as scanning
+	indexed, err := r.Stat("repos/wpress/testdata/lipsum.txt")
+	if err != nil || !reflect.DeepEqual(indexed, scanned) {
+		t.Errorf("Indexed entry %+v differs from %+v: %v", indexed, scanned, err)
+	}
+
+	content, err := r.ExtractFile("logo3.png", "/repos/wpress/testdata")
+	if err != nil || len(content) != 6855 {
+		t.Errorf("Extracted %d bytes instead of 6855: %v", len(content), err)
+	}
+
+	_, err = r.Stat("wp-config.php")
+	if err != ErrFileNotFound {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+}
+
+// TestSaveLoadIndex tests persisting the index to a sidecar file
+func TestSaveLoadIndex(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+
+	// copy the test archive, so its mtime can be changed
+	content, err := ioutil.ReadFile(_getPathToTests(t) + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Unable to read test archive: %s", err)
+	}
+	archive := dir + string(os.PathSeparator) + TestArchiveName
+	err = ioutil.WriteFile(archive, content, 0644)
+	if err != nil {
+		t.Fatalf("Unable to copy test archive: %s", err)
+	}
+
+	r, err := NewReader(archive)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	expected, err := r.ListEntries()
+	if err != nil {
+		t.Fatalf("Unable to list entries: %s", err)
+	}
+	err = r.SaveIndex(archive + IndexExt)
+	if err != nil {
+		t.Fatalf("Unable to save index: %s", err)
+	}
+
+	// load the index into a new reader
+	r2, err := NewReader(archive)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r2.Close()
+	err = r2.LoadIndex(archive + IndexExt)
+	if err != nil {
+		t.Fatalf("Unable to load index: %s", err)
+	}
+	entries, err := r2.ListEntries()
+	if err != nil || len(entries) != len(expected) {
+		t.Fatalf("Loaded %d entries instead of %d: %v", len(entries), len(expected), err)
+	}
+	for i := range entries {
+		if entries[i].Path() != expected[i].Path() || entries[i].Offset != expected[i].Offset ||
+			entries[i].Size != expected[i].Size || !entries[i].ModTime.Equal(expected[i].ModTime) {
+			t.Errorf("Loaded entry %+v differs from %+v", entries[i], expected[i])
+		}
+	}
+	content, err = r2.ExtractFile("lipsum.txt", "/repos/wpress/testdata")
+	if err != nil || len(content) != 1478 {
+		t.Errorf("Extracted %d bytes instead of 1478: %v", len(content), err)
+	}
+
+	// touching the archive makes the index stale
+	later := time.Now().Add(time.Hour)
+	err = os.Chtimes(archive, later, later)
+	if err != nil {
+		t.Fatalf("Unable to change mtime: %s", err)
+	}
+	r3, err := NewReader(archive)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r3.Close()
+	err = r3.LoadIndex(archive + IndexExt)
+	if !errors.Is(err, ErrStaleIndex) {
+		t.Errorf("Expected ErrStaleIndex, got %v", err)
+	}
+
+	// loading a file which is not an index fails
+	err = r3.LoadIndex(archive)
+	if !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("Expected ErrInvalidIndex, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// InfoFileName is the name of the record at the beginning of archive
+// holding ArchiveInfo as JSON, see Writer.Info. Other tools extract it as a
+// regular file.
+const InfoFileName = ".wpress-info"
+
+// maxInfoRecord is the maximum length of info record content
+const maxInfoRecord = 1 << 16
+
+// ErrNoInfo is returned when archive doesn't start with an info record
+var ErrNoInfo = errors.New("archive has no info")
+
+// ArchiveInfo describes the whole archive, e.g. for backup catalogs
+// listing archives without reading their files
+type ArchiveInfo struct {
+	Creator string            `json:"creator,omitempty"`
+	Created time.Time         `json:"created"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// infoRecord returns the header block and content of the info record
+func infoRecord(info *ArchiveInfo) ([]byte, error) {
+	created := info.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+	content, err := json.Marshal(&ArchiveInfo{info.Creator, created.UTC(), info.Labels})
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxInfoRecord {
+		return nil, ErrMetadataTooLarge
+	}
+
+	h, err := NewHeader(InfoFileName, 0, created)
+	if err != nil {
+		return nil, err
+	}
+	return newRecord(InfoFileName, h, string(content)), nil
+}
+
+// parseInfoRecord returns archive info stored in info record
+func parseInfoRecord(content []byte) (*ArchiveInfo, error) {
+	info := &ArchiveInfo{}
+	err := json.Unmarshal(content, info)
+	if err != nil {
+		return nil, ErrCorruptHeader
+	}
+	return info, nil
+}
+
+// isInfoRecord reports whether h describes an info record
+func isInfoRecord(h *Header) bool {
+	return h.longName == "" && h.GetName() == InfoFileName && h.GetPrefix() == "."
+}
+
+// writeInfo writes the info record ahead of the first file
+func (w *Writer) writeInfo(out io.Writer) error {
+	if w.Info == nil || w.infoWritten {
+		return nil
+	}
+	w.infoWritten = true
+
+	record, err := infoRecord(w.Info)
+	if err == nil {
+		record, err = w.Layout.get().record(record)
+	}
+	if err != nil {
+		return err
+	}
+	n, err := out.Write(record)
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	// the archive is kept with the info when adding the first file fails
+	w.complete = w.size
+	return nil
+}
+
+// Info returns the info record the archive starts with, ErrNoInfo is
+// returned when there is none. Archives read sequentially return it only
+// before reading any file, e.g. compressed archives.
+func (r *Reader) Info() (*ArchiveInfo, error) {
+	if ra, ok := r.source.(io.ReaderAt); ok {
+		return readInfo(newCursor(ra, &r.Layout), r.Filename)
+	}
+	if r.cur.offset != 0 {
+		return nil, ErrNotSeekable
+	}
+	return readInfo(r.cur, r.Filename)
+}
+
+// readInfo reads the info record at the beginning of the archive read by
+// c, other headers are kept for the next call to headerBlock
+func readInfo(c *cursor, name string) (*ArchiveInfo, error) {
+	block, err := c.headerBlock()
+	if err == io.EOF {
+		return nil, ErrNoInfo
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h := c.layout.get().populate(block)
+	if !isInfoRecord(h) {
+		c.pending = block
+		return nil, ErrNoInfo
+	}
+	size, err := h.GetSize64()
+	if err != nil || size < 0 || size > maxInfoRecord {
+		return nil, &os.PathError{Op: "read", Path: name, Err: ErrCorruptHeader}
+	}
+	c.remaining = size
+	content, err := c.readRecord(size)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseInfoRecord(content)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: err}
+	}
+	return info, nil
+}
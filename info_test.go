@@ -0,0 +1,104 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestInfo tests writing archive info and reading it without scanning files
+func TestInfo(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	created := time.Date(2015, 1, 4, 14, 42, 11, 0, time.UTC)
+	info := &ArchiveInfo{Creator: "wpress-cli 1.2", Created: created, Labels: map[string]string{"site": "example.com"}}
+	for _, compression := range []Compression{NoCompression, GzipCompression} {
+		filename := filepath.Join(tempPath, "output.wpress")
+		w, err := NewWriter(filename)
+		if err != nil {
+			t.Fatalf("Failed to create a new Writer because %s", err)
+		}
+		w.Info = info
+		w.Compression = compression
+		err = w.AddDirectoryWithOptions(_getPathToTests(t), AddOptions{})
+		if err != nil {
+			t.Errorf("Failed to add directory: %s", err)
+		}
+		w.Close()
+
+		r, err := NewReader(filename)
+		if err != nil {
+			t.Fatalf("Failed to create a new Reader instance: %s", err)
+		}
+		read, err := r.Info()
+		if err != nil || !reflect.DeepEqual(read, info) {
+			t.Errorf("Read info %+v instead of %+v: %v", read, info, err)
+		}
+
+		// files follow the info
+		paths := _listPaths(t, filename)
+		if len(paths) == 0 || paths[0] == InfoFileName {
+			t.Errorf("Unexpected files %v", paths)
+		}
+		h, err := r.Next()
+		if err != nil || h.GetPath() != paths[0] {
+			t.Errorf("Read %v after info: %v", h, err)
+		}
+		r.Close()
+	}
+
+	// rewriting keeps the info
+	filename := filepath.Join(tempPath, "output.wpress")
+	_, err := Remove(filename, "", []string{"*.png"})
+	if err != nil {
+		t.Fatalf("Failed to remove files: %s", err)
+	}
+	r, _ := NewReader(filename)
+	defer r.Close()
+	read, err := r.Info()
+	if err != nil || read.Creator != info.Creator {
+		t.Errorf("Info was not kept: %+v, %v", read, err)
+	}
+}
+
+// TestNoInfo tests reading info of archive without it
+func TestNoInfo(t *testing.T) {
+	path := _getPathToTests(t)
+	r, err := NewReader(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+
+	_, err = r.Info()
+	if err != ErrNoInfo {
+		t.Errorf("Expected ErrNoInfo, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+// ErrInvalidLayout is returned when a field of the header layout is not
+// positive or the header is larger than maxHeaderSize
+var ErrInvalidLayout = errors.New("invalid header layout")
+
+// ErrLayoutOverflow is returned when a value of the header doesn't fit its
+// field in the layout of the Writer
+var ErrLayoutOverflow = errors.New("header value doesn't fit layout")
+
+// maxHeaderSize is the maximum length of header block of a layout
+const maxHeaderSize = 1 << 20
+
+// Layout tells the widths in bytes of header fields, which follow each
+// other in the order Name, Size, Mtime and Prefix. Forks of the format
+// producing archives with different widths are read and written by setting
+// Reader.Layout and Writer.Layout. The zero Layout is DefaultLayout.
+type Layout struct {
+	Name   int
+	Size   int
+	Mtime  int
+	Prefix int
+}
+
+// DefaultLayout is the layout of archives written by All-in-One WP
+// Migration, it is the layout of Header
+var DefaultLayout = Layout{filenameSize, contentSize, mtimeSize, prefixSize}
+
+// HeaderSize returns length of header block of the layout
+func (l Layout) HeaderSize() int {
+	return l.Name + l.Size + l.Mtime + l.Prefix
+}
+
+// Validate returns ErrInvalidLayout when a field is not positive or the
+// header is too large
+func (l Layout) Validate() error {
+	if l.Name <= 0 || l.Size <= 0 || l.Mtime <= 0 || l.Prefix <= 0 || l.HeaderSize() > maxHeaderSize {
+		return ErrInvalidLayout
+	}
+	return nil
+}
+
+// get returns the layout, DefaultLayout for nil or the zero Layout
+func (l *Layout) get() Layout {
+	if l == nil || *l == (Layout{}) {
+		return DefaultLayout
+	}
+	return *l
+}
+
+// populate returns header whose fields are slices of block
+func (l Layout) populate(block []byte) *Header {
+	if l == DefaultLayout {
+		h := &Header{}
+		h.PopulateFromBytes(block)
+		return h
+	}
+
+	size := l.Name + l.Size
+	mtime := size + l.Mtime
+	return &Header{
+		Name:   block[0:l.Name],
+		Size:   block[l.Name:size],
+		Mtime:  block[size:mtime],
+		Prefix: block[mtime:l.HeaderSize()],
+	}
+}
+
+// block returns header block of h laid out by l, values are padded by zero
+// bytes to the widths of the fields
+func (l Layout) block(h *Header) ([]byte, error) {
+	if l == DefaultLayout {
+		return h.GetHeaderBlock(), nil
+	}
+
+	// sizes stored in binary don't fit other layouts
+	size, err := h.GetSize64()
+	if err != nil {
+		return nil, err
+	}
+
+	block := make([]byte, 0, l.HeaderSize())
+	values := [][]byte{h.Name, []byte(strconv.FormatInt(size, 10)), h.Mtime, h.Prefix}
+	for i, width := range []int{l.Name, l.Size, l.Mtime, l.Prefix} {
+		value := bytes.TrimRight(values[i], "\x00")
+		if len(value) > width {
+			return nil, ErrLayoutOverflow
+		}
+		block = append(block, value...)
+		block = append(block, make([]byte, width-len(value))...)
+	}
+	return block, nil
+}
+
+// record returns record of header block in DefaultLayout followed by
+// content, see newRecord, with the header laid out by l
+func (l Layout) record(record []byte) ([]byte, error) {
+	if l == DefaultLayout {
+		return record, nil
+	}
+
+	h := &Header{}
+	h.PopulateFromBytes(record[:headerSize])
+	block, err := l.block(h)
+	if err != nil {
+		return nil, err
+	}
+	return append(block, record[headerSize:]...), nil
+}
+
+// eofBlock returns the byte sequence describing EOF in the layout, it must
+// not be modified
+func (l Layout) eofBlock() []byte {
+	if l == DefaultLayout {
+		return eofBlock
+	}
+	return make([]byte, l.HeaderSize())
+}
@@ -0,0 +1,123 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLayout tests writing and reading archives of a fork with different
+// widths of header fields
+func TestLayout(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	layout := Layout{Name: 100, Size: 12, Mtime: 12, Prefix: 1024}
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	w.Layout = layout
+	w.Checksum = SHA256Checksum
+	w.TOC = true
+	err = w.AddDirectoryWithOptions(_getPathToTests(t), AddOptions{})
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Failed to close archive: %s", err)
+	}
+
+	r := &Reader{Filename: filename, Layout: layout}
+	err = r.Init()
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	content, err := r.ExtractFile("lipsum.txt", ".")
+	expected, _ := ioutil.ReadFile(filepath.Join(_getPathToTests(t), "lipsum.txt"))
+	if err != nil || string(content) != string(expected) {
+		t.Errorf("Extracted %d bytes instead of %d: %v", len(content), len(expected), err)
+	}
+
+	destDir := filepath.Join(tempPath, "extracted")
+	n, err := r.ExtractTo(destDir)
+	if err != nil || n != w.FilesAdded {
+		t.Errorf("Extracted %d files instead of %d: %v", n, w.FilesAdded, err)
+	}
+
+	// table of contents is read by the layout set after opening
+	file, _ := os.Open(filename)
+	fi, _ := file.Stat()
+	ra := NewReaderAt(file, fi.Size())
+	ra.Layout = layout
+	content, err = ra.ExtractFile("lipsum.txt", ".")
+	if entries, _ := ra.indexed(); entries == nil || err != nil || string(content) != string(expected) {
+		t.Errorf("Archive wasn't indexed by its table of contents: %v", err)
+	}
+	file.Close()
+
+	// the default layout doesn't read it
+	d, err := NewReader(filename)
+	if err == nil {
+		_, err = d.ExtractTo(filepath.Join(tempPath, "default"))
+		d.Close()
+	}
+	if err == nil {
+		t.Errorf("Archive of other layout was read by the default layout")
+	}
+}
+
+// TestLayoutErrors tests invalid layouts and values not fitting them
+func TestLayoutErrors(t *testing.T) {
+	if (Layout{}).Validate() != ErrInvalidLayout || (Layout{Name: 1, Size: 1, Mtime: 1, Prefix: maxHeaderSize}).Validate() != ErrInvalidLayout {
+		t.Errorf("Invalid layouts were accepted")
+	}
+	if DefaultLayout.HeaderSize() != headerSize {
+		t.Errorf("Default layout has %d bytes instead of %d", DefaultLayout.HeaderSize(), headerSize)
+	}
+
+	w := NewWriterTo(&strings.Builder{})
+	w.Layout = Layout{Name: 8, Size: 12, Mtime: 12, Prefix: 8}
+	h, _ := NewHeader("wp-content/index.php", 5, time.Now())
+	err := w.Add(h, strings.NewReader("hello"))
+	if !errors.Is(err, ErrLayoutOverflow) {
+		t.Errorf("Expected ErrLayoutOverflow, got %v", err)
+	}
+
+	w.Layout = Layout{Name: -1, Size: 12, Mtime: 12, Prefix: 8}
+	err = w.Add(h, strings.NewReader("hello"))
+	if err != ErrInvalidLayout {
+		t.Errorf("Expected ErrInvalidLayout, got %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LinksFileName is the name of the file in the root of archive listing files
+// stored only once because they were hard links to the same content or had
+// the same content, see AddOptions.DedupHardLinks and DedupContent.
+// Extraction restores them as copies, other tools extract the list as a
+// regular file.
+const LinksFileName = ".wpress-links"
+
+// hardLink is a file whose content is stored in archive under Source, Mtime
+// is set for files which only have the same content as Source
+type hardLink struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Mtime  int64  `json:"mtime,omitempty"`
+}
+
+// storedContent is the hash of content written to archive as name
+type storedContent struct {
+	sum  [sha256.Size]byte
+	name string
+}
+
+// seenFile is a file added to the archive, used to detect hard links
+type seenFile struct {
+	fi   os.FileInfo
+	name string
+}
+
+// dedup returns the name the file described by fi was already added as,
+// false is returned and the file is remembered as name when it wasn't
+func (t *tree) dedup(fi os.FileInfo, name string) (string, bool) {
+	if t.seen == nil {
+		t.seen = make(map[int64][]seenFile)
+	}
+
+	// only files of the same size can be the same file
+	for _, seen := range t.seen[fi.Size()] {
+		if os.SameFile(fi, seen.fi) {
+			return seen.name, true
+		}
+	}
+	t.seen[fi.Size()] = append(t.seen[fi.Size()], seenFile{fi, name})
+	return "", false
+}
+
+// writeLinks adds the list of hard links to the archive
+func (w *Writer) writeLinks() error {
+	content, err := json.Marshal(w.links)
+	if err != nil {
+		return err
+	}
+	h, err := NewHeader(LinksFileName, int64(len(content)), time.Now())
+	if err != nil {
+		return err
+	}
+	return w.copyEntry(h, bytes.NewReader(content))
+}
+
+// addFileDeduped adds a file to the archive stored under name like
+// AddFileAs unless its content was already written, see writeDeduped
+func (w *Writer) addFileDeduped(filename string, name string) error {
+	input, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	fi, err := input.Stat()
+	if err != nil {
+		return err
+	}
+	h, err := NewHeader(name, fi.Size(), fi.ModTime())
+	if err != nil {
+		return err
+	}
+	if w.Attributes {
+		h.attrs = fileAttributes(fi, "")
+	}
+
+	return w.writeDeduped(h, input)
+}
+
+// writeDeduped writes header h and content read from src to the archive
+// unless a file of the same content was already written, then the file is
+// listed as its link
+func (w *Writer) writeDeduped(h *Header, src io.ReadSeeker) error {
+	size, err := h.GetSize64()
+	if err != nil {
+		return err
+	}
+
+	// empty files take just a header either way
+	if size == 0 {
+		return w.writeEntry(h, src)
+	}
+
+	// only files of the same size can have the same content
+	if len(w.contents[size]) > 0 {
+		hash := sha256.New()
+		_, err = io.Copy(hash, io.LimitReader(src, size))
+		if err != nil {
+			return err
+		}
+		_, err = src.Seek(0, 0)
+		if err != nil {
+			return err
+		}
+
+		var sum [sha256.Size]byte
+		copy(sum[:], hash.Sum(nil))
+		for _, stored := range w.contents[size] {
+			if stored.sum == sum {
+				mtime, _ := h.GetMtime()
+				w.links = append(w.links, hardLink{Name: h.GetPath(), Source: stored.name, Mtime: mtime})
+				return nil
+			}
+		}
+	}
+
+	// hash the content while writing it
+	hash := sha256.New()
+	err = w.writeEntry(h, io.TeeReader(src, hash))
+	if err != nil {
+		return err
+	}
+
+	if w.contents == nil {
+		w.contents = make(map[int64][]storedContent)
+	}
+	stored := storedContent{name: h.GetPath()}
+	copy(stored.sum[:], hash.Sum(nil))
+	w.contents[size] = append(w.contents[size], stored)
+	return nil
+}
+
+// readLinks reads the list of hard links from the content of the current
+// file
+func (e *extraction) readLinks() error {
+	content, err := ioutil.ReadAll(e.c)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, &e.links)
+}
+
+// restoreLinks copies extracted files to the paths of their hard links
+func (e *extraction) restoreLinks() error {
+	for _, link := range e.links {
+		src, ok := e.targets[link.Source]
+		if !ok || !e.match(link.Name) {
+			continue
+		}
+
+		// root the copy under the destination directory
+		name, ok, err := e.targetName(link.Name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		pathToFile := filepath.Join(e.opts.DestDir, filepath.FromSlash(name))
+		e.present[pathToFile] = true
+
+		// tar stream links the path to the file written earlier
+		if e.tw != nil {
+			err = e.executeTarLink(link, src, pathToFile)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = e.checkInside(pathToFile, true)
+		if err != nil {
+			return err
+		}
+		fi, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		modTime := fi.ModTime()
+		if link.Mtime != 0 {
+			modTime = time.Unix(link.Mtime, 0)
+		}
+		h, err := NewHeader(link.Name, fi.Size(), modTime)
+		if err != nil {
+			return err
+		}
+
+		// apply the overwrite policy and limits like to any other file
+		act, err := e.decide(pathToFile, h, func() (io.ReadCloser, error) {
+			return os.Open(src)
+		})
+		if err != nil {
+			return err
+		}
+		if act == actionSkip {
+			continue
+		}
+		err = e.checkLimits(pathToFile, fi.Size())
+		if err != nil {
+			return err
+		}
+		if act == actionRename {
+			err = os.Rename(pathToFile, availableName(pathToFile))
+			if err != nil {
+				return err
+			}
+		}
+
+		err = copyFile(src, pathToFile)
+		if err != nil {
+			return err
+		}
+		err = e.restoreMtime(pathToFile, h)
+		if err != nil {
+			return err
+		}
+
+		e.extractedCount++
+		if e.extracted != nil {
+			e.extracted(link.Name)
+		}
+	}
+
+	return nil
+}
+
+// copyFile copies content of src to dest creating its parent directories
+func copyFile(src string, dest string) error {
+	input, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+	output, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+
+	buf := getBuffer(defaultBufferSize)
+	defer putBuffer(buf)
+	_, err = io.CopyBuffer(output, input, buf)
+	if err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
+}
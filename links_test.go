@@ -0,0 +1,153 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestDedupHardLinks tests storing hard linked files once
+func TestDedupHardLinks(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	// snapshot with the same upload linked twice
+	root := filepath.Join(tempPath, "site")
+	os.MkdirAll(filepath.Join(root, "uploads"), 0755)
+	original := filepath.Join(root, "uploads", "logo.png")
+	ioutil.WriteFile(original, []byte("png"), 0644)
+	err := os.Link(original, filepath.Join(root, "uploads", "logo-copy.png"))
+	if err != nil {
+		t.Skipf("Unable to create hard link: %s", err)
+	}
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	err = w.AddDirectoryWithOptions(root, AddOptions{DedupHardLinks: true})
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	w.Close()
+
+	// content is stored once, followed by the list of links
+	paths := _listPaths(t, filename)
+	expected := []string{"uploads/logo-copy.png", LinksFileName}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Added %v instead of %v", paths, expected)
+	}
+
+	// extraction restores both files
+	dest := filepath.Join(tempPath, "restored")
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	filesCount, err := r.ExtractTo(dest)
+	if err != nil || filesCount != 2 {
+		t.Errorf("Extracted %d files instead of 2: %v", filesCount, err)
+	}
+	for _, name := range []string{"logo.png", "logo-copy.png"} {
+		content, err := ioutil.ReadFile(filepath.Join(dest, "uploads", name))
+		if err != nil || string(content) != "png" {
+			t.Errorf("Unexpected content of %s `%s`: %v", name, content, err)
+		}
+	}
+	_, err = os.Stat(filepath.Join(dest, LinksFileName))
+	if !os.IsNotExist(err) {
+		t.Errorf("List of links was extracted")
+	}
+}
+
+// TestDedupContent tests storing repeated content once
+func TestDedupContent(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	// theme copied under another name and an image of the same size
+	root := filepath.Join(tempPath, "site")
+	for name, content := range map[string]string{
+		"themes/child/style.css":  "body {}",
+		"themes/parent/style.css": "body {}",
+		"uploads/a.png":           "png-aaa",
+		"uploads/empty.txt":       "",
+		"uploads/empty2.txt":      "",
+	} {
+		pathToFile := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(pathToFile), 0755)
+		ioutil.WriteFile(pathToFile, []byte(content), 0644)
+	}
+	copied := time.Unix(1420382531, 0)
+	os.Chtimes(filepath.Join(root, "themes", "parent", "style.css"), copied, copied)
+
+	for _, workers := range []int{1, 4} {
+		filename := filepath.Join(tempPath, "output.wpress")
+		w, err := NewWriter(filename)
+		if err != nil {
+			t.Fatalf("Failed to create a new Writer because %s", err)
+		}
+		err = w.AddDirectoryWithOptions(root, AddOptions{DedupContent: true, Workers: workers})
+		if err != nil {
+			t.Errorf("Failed to add directory: %s", err)
+		}
+		w.Close()
+
+		paths := _listPaths(t, filename)
+		expected := []string{"themes/child/style.css", "uploads/a.png", "uploads/empty.txt", "uploads/empty2.txt", LinksFileName}
+		if !reflect.DeepEqual(paths, expected) {
+			t.Errorf("Added %v instead of %v", paths, expected)
+		}
+
+		// extraction restores the copy with its own modification date
+		dest := filepath.Join(tempPath, "restored")
+		r, err := NewReader(filename)
+		if err != nil {
+			t.Fatalf("Failed to create a new Reader instance: %s", err)
+		}
+		filesCount, err := r.ExtractTo(dest)
+		r.Close()
+		if err != nil || filesCount != 5 {
+			t.Errorf("Extracted %d files instead of 5: %v", filesCount, err)
+		}
+		pathToCopy := filepath.Join(dest, "themes", "parent", "style.css")
+		content, err := ioutil.ReadFile(pathToCopy)
+		if err != nil || string(content) != "body {}" {
+			t.Errorf("Unexpected content `%s`: %v", content, err)
+		}
+		fi, err := os.Stat(pathToCopy)
+		if err != nil || !fi.ModTime().Equal(copied) {
+			t.Errorf("Copy wasn't restored with its modification date: %v", err)
+		}
+		os.RemoveAll(dest)
+	}
+}
@@ -0,0 +1,96 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"strconv"
+	"unicode/utf8"
+)
+
+// LongNameFileName is the name of the record in the root of archive holding
+// the full path of the file which follows it, written when filename doesn't
+// fit the Name field or the directory doesn't fit the Prefix field. The
+// header of the file itself holds the truncated values, so other tools
+// extract the file under a shortened name and the path as a regular file.
+const LongNameFileName = ".wpress-longname"
+
+// longNameSize is the maximum number of bytes allowed for a long path
+const longNameSize = 1 << 16
+
+// ErrLongNameTooLong is returned when path of a file is longer than
+// supported by the long name extension
+var ErrLongNameTooLong = errors.New("path is longer than max allowed")
+
+// truncateName returns the longest prefix of name of at most size bytes
+// which doesn't split a UTF-8 sequence
+func truncateName(name string, size int) string {
+	if len(name) <= size {
+		return name
+	}
+	for size > 0 && !utf8.RuneStart(name[size]) {
+		size--
+	}
+	return name[:size]
+}
+
+// longNameRecord returns the header block and content of the record
+// holding the full path of the file described by h
+func longNameRecord(h *Header) []byte {
+	return newRecord(LongNameFileName, h, h.longName)
+}
+
+// newRecord returns the header block and content of the record name in the
+// root of archive belonging to the file described by h
+func newRecord(name string, h *Header, content string) []byte {
+	record := &Header{
+		Name:   make([]byte, filenameSize),
+		Size:   make([]byte, contentSize),
+		Mtime:  h.Mtime,
+		Prefix: make([]byte, prefixSize),
+	}
+	copy(record.Name, name)
+	copy(record.Size, strconv.Itoa(len(content)))
+	copy(record.Prefix, ".")
+
+	return append(record.GetHeaderBlock(), content...)
+}
+
+// longNameOverhead returns the number of bytes the long name record of the
+// file stored as name takes, zero when the record isn't needed
+func longNameOverhead(name string) int64 {
+	name = path.Clean(filepath.ToSlash(name))
+	if len(path.Base(name)) <= filenameSize && len(path.Dir(name)) <= prefixSize {
+		return 0
+	}
+	return headerSize + int64(len(name))
+}
+
+// isLongNameRecord reports whether h describes a long name record
+func isLongNameRecord(h *Header) bool {
+	return h.longName == "" && h.GetName() == LongNameFileName && h.GetPrefix() == "."
+}
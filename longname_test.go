@@ -0,0 +1,128 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// TestLongNames tests writing and reading paths which don't fit the header
+func TestLongNames(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+
+	longName := "wp-content/uploads/" + strings.Repeat("снимка", 30) + ".png"
+	longPrefix := strings.Repeat("nested-directory/", 300) + "file.txt"
+	filename := filepath.Join(dir, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	for _, name := range []string{longName, longPrefix, "index.php"} {
+		h, err := NewHeader(name, int64(len(name)), time.Unix(1420382531, 0))
+		if err != nil {
+			t.Fatalf("Failed to create header of %s: %s", name, err)
+		}
+		err = w.Add(h, strings.NewReader(name))
+		if err != nil {
+			t.Errorf("Failed to add %s: %s", name, err)
+		}
+	}
+	w.Close()
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	entries, err := r.ListEntries()
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("Listed %d files instead of 3: %v", len(entries), err)
+	}
+	for i, name := range []string{longName, longPrefix, "index.php"} {
+		if entries[i].Path() != name {
+			t.Errorf("Listed %s instead of %s", entries[i].Path(), name)
+		}
+		content, err := r.ExtractFile(path.Base(name), path.Dir(name))
+		if err != nil || string(content) != name {
+			t.Errorf("Unexpected content of %s: %v", name, err)
+		}
+	}
+
+	// other tools see the record and the truncated name
+	r, err = NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	h, err := r.Next()
+	if err != nil {
+		t.Fatalf("Unable to read header: %s", err)
+	}
+	raw := bytes.Trim(h.Name, "\x00")
+	if len(raw) > filenameSize || !utf8.Valid(raw) || !strings.HasPrefix(path.Base(longName), string(raw)) {
+		t.Errorf("Unexpected truncated name %s", raw)
+	}
+
+	_, err = NewHeader(strings.Repeat("a/", longNameSize), 0, time.Now())
+	if err != ErrLongNameTooLong {
+		t.Errorf("Expected ErrLongNameTooLong, got %v", err)
+	}
+}
+
+// TestLongNamesTruncated tests reading archive ending in a long name record
+func TestLongNamesTruncated(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+
+	name := strings.Repeat("x", 300)
+	filename := filepath.Join(dir, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	h, _ := NewHeader(name, 1, time.Now())
+	w.Add(h, strings.NewReader("x"))
+	w.Close()
+
+	// cut the archive in the middle of the path
+	os.Truncate(filename, headerSize+100)
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	_, err = r.ListEntries()
+	if err != ErrTruncated {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// ManifestFileName is the name of the file in the root of archive holding
+// the manifest of all files before it, see Writer.Manifest. Extraction
+// skips it, other tools extract it as a regular file.
+const ManifestFileName = ".wpress-manifest"
+
+// ErrNoManifest is returned when archive has no embedded manifest
+var ErrNoManifest = errors.New("archive has no manifest")
+
+// ErrManifestMismatch is returned when files of archive don't match the
+// files listed in manifest or the manifest doesn't match its own hash
+var ErrManifestMismatch = errors.New("archive doesn't match manifest")
+
+// ManifestEntry describes a file listed in manifest, SHA256 is empty when
+// contents were not hashed, see ManifestOptions
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Mtime  int64  `json:"mtime"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Manifest lists all files of archive in order with hashes of their
+// contents, SHA256 is the hash of the list itself, see Sum
+type Manifest struct {
+	Files  []ManifestEntry `json:"files"`
+	SHA256 string          `json:"sha256"`
+}
+
+// Sum returns hash of the files listed in manifest
+func (m *Manifest) Sum() string {
+	hash := sha256.New()
+	for _, f := range m.Files {
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\x00%s\n", f.Path, f.Size, f.Mtime, f.SHA256)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// add lists the file described by h whose content has hash sum
+func (m *Manifest) add(h *Header, sum []byte) error {
+	size, err := h.GetSize64()
+	if err != nil {
+		return err
+	}
+	mtime, _ := h.GetMtime()
+	m.Files = append(m.Files, ManifestEntry{h.GetPath(), size, mtime, hex.EncodeToString(sum)})
+	return nil
+}
+
+// writeManifest adds the manifest of the files written so far to the
+// archive
+func (w *Writer) writeManifest() error {
+	m := w.manifest
+	m.SHA256 = m.Sum()
+	content, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	h, err := NewHeader(ManifestFileName, int64(len(content)), time.Now())
+	if err != nil {
+		return err
+	}
+	return w.copyEntry(h, bytes.NewReader(content))
+}
+
+// BuildManifest reads all files of archive and returns their manifest, e.g.
+// to store it alongside archive. Embedded manifests are not listed.
+func (r *Reader) BuildManifest() (*Manifest, error) {
+	return r.BuildManifestWithOptions(ManifestOptions{Hashes: true})
+}
+
+// ManifestOptions are options of BuildManifestWithOptions and WriteManifest
+type ManifestOptions struct {
+	// Hashes adds SHA-256 hashes of contents to the listed files, contents
+	// are read whole then. Only manifests with hashes can be checked by
+	// VerifyManifest.
+	Hashes bool
+
+	// Indent indents the JSON written by WriteManifest by that string per
+	// level, it is written on a single line when empty
+	Indent string
+}
+
+// BuildManifestWithOptions reads all files of archive and returns their
+// manifest listing path, size and last modified date of every file, with
+// content hashes when requested by opts. Without hashes only the headers
+// are read. Embedded manifests are not listed.
+func (r *Reader) BuildManifestWithOptions(opts ManifestOptions) (*Manifest, error) {
+	m := &Manifest{}
+	err := r.hashFiles(opts.Hashes, func(h *Header, sum []byte) error {
+		return m.add(h, sum)
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.SHA256 = m.Sum()
+	return m, nil
+}
+
+// WriteManifest writes the manifest of all files of archive built
+// according to opts to dest as JSON, e.g. for backup catalogs or auditing
+// systems, see BuildManifestWithOptions
+func (r *Reader) WriteManifest(dest io.Writer, opts ManifestOptions) error {
+	m, err := r.BuildManifestWithOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(dest)
+	enc.SetIndent("", opts.Indent)
+	return enc.Encode(m)
+}
+
+// Manifest returns the manifest embedded in archive, ErrNoManifest is
+// returned when there is none
+func (r *Reader) Manifest() (*Manifest, error) {
+	c, err := r.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	// the last manifest describes the files before it
+	var content []byte
+	for {
+		h, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.GetPath() == ManifestFileName {
+			content, err = ioutil.ReadAll(c)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if content == nil {
+		return nil, ErrNoManifest
+	}
+
+	m := &Manifest{}
+	err = json.Unmarshal(content, m)
+	if err != nil {
+		return nil, &os.PathError{Op: "verify", Path: ManifestFileName, Err: err}
+	}
+	return m, nil
+}
+
+// Verify checks contents of all files of archive against the embedded
+// manifest without extracting them, see VerifyManifest
+func (r *Reader) Verify() error {
+	m, err := r.Manifest()
+	if err != nil {
+		return err
+	}
+	return r.VerifyManifest(m)
+}
+
+// VerifyManifest checks contents of all files of archive against m without
+// extracting them. ErrManifestMismatch is returned when m doesn't match its
+// hash or files differ from those listed, ErrChecksumMismatch when content
+// of a file differs.
+func (r *Reader) VerifyManifest(m *Manifest) error {
+	if m.Sum() != m.SHA256 {
+		return &os.PathError{Op: "verify", Path: ManifestFileName, Err: ErrManifestMismatch}
+	}
+
+	i := 0
+	err := r.hashFiles(true, func(h *Header, sum []byte) error {
+		if i >= len(m.Files) || m.Files[i].Path != h.GetPath() {
+			return &os.PathError{Op: "verify", Path: h.GetPath(), Err: ErrManifestMismatch}
+		}
+		expected := &Manifest{}
+		err := expected.add(h, sum)
+		if err != nil {
+			return err
+		}
+		if expected.Files[0] != m.Files[i] {
+			return &os.PathError{Op: "verify", Path: h.GetPath(), Err: ErrChecksumMismatch}
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// files listed in manifest are missing from archive
+	if i < len(m.Files) {
+		return &os.PathError{Op: "verify", Path: m.Files[i].Path, Err: ErrManifestMismatch}
+	}
+	return nil
+}
+
+// hashFiles calls fn with header and SHA-256 hash of content of every file
+// of archive except embedded manifests, contents are skipped and sum is nil
+// unless hashed
+func (r *Reader) hashFiles(hashed bool, fn func(h *Header, sum []byte) error) error {
+	c, err := r.scan()
+	if err != nil {
+		return err
+	}
+
+	buf := getBuffer(defaultBufferSize)
+	defer putBuffer(buf)
+	for {
+		h, err := c.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.GetPath() == ManifestFileName {
+			continue
+		}
+		if !hashed {
+			err = fn(h, nil)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		hash := sha256.New()
+		_, err = io.CopyBuffer(hash, c, buf)
+		if err != nil {
+			return err
+		}
+		err = fn(h, hash.Sum(nil))
+		if err != nil {
+			return err
+		}
+	}
+}
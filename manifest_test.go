@@ -0,0 +1,158 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestManifest tests embedding manifest and verifying archive against it
+func TestManifest(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	w.Manifest = true
+	err = w.AddDirectoryWithOptions(_getPathToTests(t), AddOptions{})
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	w.Close()
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	m, err := r.Manifest()
+	if err != nil || len(m.Files) != w.FilesAdded-1 || m.SHA256 != m.Sum() {
+		t.Fatalf("Unexpected manifest %+v: %v", m, err)
+	}
+	err = r.Verify()
+	if err != nil {
+		t.Errorf("Failed to verify archive: %s", err)
+	}
+
+	// manifest built by reading the archive matches the embedded one
+	built, err := r.BuildManifest()
+	if err != nil || built.SHA256 != m.SHA256 {
+		t.Errorf("Built manifest %s instead of %s: %v", built.SHA256, m.SHA256, err)
+	}
+
+	// manifest is not extracted
+	dest := filepath.Join(tempPath, "restored")
+	filesCount, err := r.ExtractTo(dest)
+	if err != nil || filesCount != len(m.Files) {
+		t.Errorf("Extracted %d files instead of %d: %v", filesCount, len(m.Files), err)
+	}
+	_, err = os.Stat(filepath.Join(dest, ManifestFileName))
+	if !os.IsNotExist(err) {
+		t.Errorf("Manifest was extracted")
+	}
+	r.Close()
+
+	// tampered content is detected
+	archive, _ := ioutil.ReadFile(filename)
+	lipsum, _ := ioutil.ReadFile(filepath.Join(_getPathToTests(t), "lipsum.txt"))
+	i := bytes.Index(archive, lipsum)
+	if i == -1 {
+		t.Fatalf("Content not found in archive")
+	}
+	archive[i] ^= 1
+	ioutil.WriteFile(filename, archive, 0644)
+	r, _ = NewReader(filename)
+	err = r.Verify()
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrChecksumMismatch {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+
+	// tampered manifest is detected
+	m.Files[0].Size++
+	err = r.VerifyManifest(m)
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrManifestMismatch {
+		t.Errorf("Expected ErrManifestMismatch, got %v", err)
+	}
+	r.Close()
+
+	// archives without manifest
+	r, _ = NewReader(_newArchive(t, tempPath, "database.sql", "CREATE"))
+	defer r.Close()
+	_, err = r.Manifest()
+	if err != ErrNoManifest {
+		t.Errorf("Expected ErrNoManifest, got %v", err)
+	}
+}
+
+// TestWriteManifest tests writing manifest of archive as JSON
+func TestWriteManifest(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	r, err := NewReader(_newArchive(t, tempPath, "database.sql", "CREATE", "wp-content/a.txt", "abc"))
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+
+	// listing without hashes
+	var buf bytes.Buffer
+	err = r.WriteManifest(&buf, ManifestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to write manifest: %s", err)
+	}
+	expected := `{"files":[{"path":"database.sql","size":6,"mtime":1420382531},` +
+		`{"path":"wp-content/a.txt","size":3,"mtime":1420382531}],"sha256":"`
+	if !strings.HasPrefix(buf.String(), expected) {
+		t.Errorf("Unexpected manifest %s", buf.String())
+	}
+
+	// listing with hashes can be verified
+	buf.Reset()
+	err = r.WriteManifest(&buf, ManifestOptions{Hashes: true, Indent: "  "})
+	if err != nil {
+		t.Fatalf("Failed to write manifest: %s", err)
+	}
+	m := &Manifest{}
+	err = json.Unmarshal(buf.Bytes(), m)
+	if err != nil || len(m.Files) != 2 || m.Files[1].SHA256 != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Fatalf("Unexpected manifest %+v: %v", m, err)
+	}
+	if !strings.Contains(buf.String(), "\n  \"files\"") {
+		t.Errorf("Manifest is not indented: %s", buf.String())
+	}
+	err = r.VerifyManifest(m)
+	if err != nil {
+		t.Errorf("Failed to verify manifest: %s", err)
+	}
+}
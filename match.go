@@ -0,0 +1,149 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Filter selects files by their cleaned path, patterns use the syntax of
+// Glob and a pattern matching a directory selects everything below it
+type Filter struct {
+	// Include lists patterns of files to select, all files are selected
+	// when empty
+	Include []string
+
+	// Exclude lists patterns of files to leave out, it takes precedence
+	// over Include
+	Exclude []string
+
+	// IncludeRegexp lists regular expressions of files to select in
+	// addition to Include, they are matched against the whole path
+	IncludeRegexp []*regexp.Regexp
+
+	// ExcludeRegexp lists regular expressions of files to leave out in
+	// addition to Exclude, they are matched against the whole path
+	ExcludeRegexp []*regexp.Regexp
+}
+
+// Validate returns an error if any of the patterns is malformed
+func (f Filter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		_, err := matchPattern(pattern, "")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match reports whether name is selected by the filter
+func (f Filter) Match(name string) bool {
+	// with no include rules everything is selected
+	if len(f.Include) > 0 || len(f.IncludeRegexp) > 0 {
+		if !matchAny(f.Include, name) && !matchAnyRegexp(f.IncludeRegexp, name) {
+			return false
+		}
+	}
+	return !matchAny(f.Exclude, name) && !matchAnyRegexp(f.ExcludeRegexp, name)
+}
+
+// all reports whether the filter selects every file
+func (f Filter) all() bool {
+	return len(f.Include) == 0 && len(f.IncludeRegexp) == 0 && len(f.Exclude) == 0 && len(f.ExcludeRegexp) == 0
+}
+
+// matchAnyRegexp reports whether name matches one of the expressions
+func matchAnyRegexp(expressions []*regexp.Regexp, name string) bool {
+	for _, re := range expressions {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether name or any of its parent directories matches
+// one of the patterns
+func matchAny(patterns []string, name string) bool {
+	for ; name != "." && name != "/" && name != ""; name = path.Dir(name) {
+		for _, pattern := range patterns {
+			matched, _ := matchPattern(pattern, name)
+			if matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchPattern reports whether name matches the shell pattern, patterns use
+// path.Match syntax per path segment and "**" matches any number of segments
+func matchPattern(pattern string, name string) (bool, error) {
+	// patterns are matched against cleaned relative paths
+	pattern = strings.TrimPrefix(path.Clean("/"+pattern), "/")
+	segments := strings.Split(pattern, "/")
+
+	// validate the pattern, so errors don't depend on the name matched
+	for _, segment := range segments {
+		_, err := path.Match(segment, "")
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return matchSegments(segments, strings.Split(name, "/")), nil
+}
+
+// matchSegments matches name segments against pattern segments
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		// double star consumes any number of name segments
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		// pattern was validated, so the error can be ignored
+		matched, _ := path.Match(pattern[0], name[0])
+		if !matched {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
@@ -0,0 +1,125 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// MetadataFileName is the name of the record in the root of archive
+// holding metadata of the file which follows it as a JSON object of
+// strings, e.g. content hash, source host or MIME type, see
+// Header.SetMetadata and Writer.Metadata. Other tools extract the record as
+// a regular file.
+const MetadataFileName = ".wpress-metadata"
+
+// maxMetadataRecord is the maximum length of metadata record content
+const maxMetadataRecord = 1 << 16
+
+// ErrMetadataTooLarge is returned when metadata of a file don't fit the
+// metadata record
+var ErrMetadataTooLarge = errors.New("metadata is larger than max allowed")
+
+// GetMetadata returns copy of the metadata of the file, nil when it has
+// none
+func (h Header) GetMetadata() map[string]string {
+	return copyMetadata(h.metadata)
+}
+
+// SetMetadata sets metadata key of the file to value, the metadata are
+// written in a record preceding the header
+func (h *Header) SetMetadata(key string, value string) {
+	// headers copied from other archives may share the map
+	metadata := copyMetadata(h.metadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	metadata[key] = value
+	h.metadata = metadata
+}
+
+// copyMetadata returns copy of metadata, nil when it is empty
+func copyMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	return copied
+}
+
+// withMetadata returns h with metadata returned by Metadata of the Writer
+// added to its own
+func (w *Writer) withMetadata(h *Header) *Header {
+	if w.Metadata == nil {
+		return h
+	}
+	added := w.Metadata(h.GetPath())
+	if len(added) == 0 {
+		return h
+	}
+
+	// the header belongs to the caller
+	with := *h
+	with.metadata = copyMetadata(h.metadata)
+	if with.metadata == nil {
+		with.metadata = make(map[string]string, len(added))
+	}
+	for key, value := range added {
+		with.metadata[key] = value
+	}
+	return &with
+}
+
+// metadataRecord returns the header block and content of the record
+// holding metadata of the file described by h
+func metadataRecord(h *Header) ([]byte, error) {
+	content, err := json.Marshal(h.metadata)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxMetadataRecord {
+		return nil, ErrMetadataTooLarge
+	}
+	return newRecord(MetadataFileName, h, string(content)), nil
+}
+
+// parseMetadataRecord returns metadata stored in metadata record
+func parseMetadataRecord(content []byte) (map[string]string, error) {
+	metadata := map[string]string{}
+	err := json.Unmarshal(content, &metadata)
+	if err != nil {
+		return nil, ErrCorruptHeader
+	}
+	return copyMetadata(metadata), nil
+}
+
+// isMetadataRecord reports whether h describes a metadata record
+func isMetadataRecord(h *Header) bool {
+	return h.longName == "" && h.GetName() == MetadataFileName && h.GetPrefix() == "."
+}
@@ -0,0 +1,120 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMetadata tests writing metadata of files and reading them back
+func TestMetadata(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	w.Metadata = func(name string) map[string]string {
+		return map[string]string{"host": "example.com"}
+	}
+	for _, name := range []string{"style.css", "index.php"} {
+		h, _ := NewHeader(name, 5, time.Unix(1420382531, 0))
+		if name == "style.css" {
+			h.SetMetadata("mime", "text/css")
+		}
+		err = w.Add(h, strings.NewReader("hello"))
+		if err != nil {
+			t.Fatalf("Failed to add %s: %s", name, err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Failed to close archive: %s", err)
+	}
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	e, err := r.Stat("style.css")
+	if err != nil || e.Metadata["mime"] != "text/css" || e.Metadata["host"] != "example.com" {
+		t.Errorf("Unexpected metadata %v: %v", e.Metadata, err)
+	}
+	e, err = r.Stat("index.php")
+	if err != nil || len(e.Metadata) != 1 || e.Metadata["host"] != "example.com" {
+		t.Errorf("Unexpected metadata %v: %v", e.Metadata, err)
+	}
+
+	// the index keeps metadata
+	indexFile := filename + IndexExt
+	err = r.SaveIndex(indexFile)
+	if err != nil {
+		t.Fatalf("Failed to save index: %s", err)
+	}
+	r, _ = NewReader(filename)
+	err = r.LoadIndex(indexFile)
+	if err != nil {
+		t.Fatalf("Failed to load index: %s", err)
+	}
+	e, err = r.Stat("style.css")
+	if err != nil || e.Metadata["mime"] != "text/css" {
+		t.Errorf("Unexpected indexed metadata %v: %v", e.Metadata, err)
+	}
+
+	// records are not extracted
+	destDir := filepath.Join(tempPath, "extracted")
+	r, _ = NewReader(filename)
+	n, err := r.ExtractTo(destDir)
+	if err != nil || n != 2 {
+		t.Errorf("Extracted %d files: %v", n, err)
+	}
+	if _, err = os.Stat(filepath.Join(destDir, MetadataFileName)); !os.IsNotExist(err) {
+		t.Errorf("Metadata record was extracted")
+	}
+}
+
+// TestSetMetadata tests metadata of copied headers are not shared
+func TestSetMetadata(t *testing.T) {
+	h, _ := NewHeader("style.css", 5, time.Now())
+	h.SetMetadata("mime", "text/css")
+	copied := *h
+	copied.SetMetadata("mime", "text/plain")
+	if h.GetMetadata()["mime"] != "text/css" || copied.GetMetadata()["mime"] != "text/plain" {
+		t.Errorf("Metadata of copied header are shared")
+	}
+
+	h.SetMetadata("large", strings.Repeat("x", maxMetadataRecord))
+	err := NewWriterTo(&strings.Builder{}).Add(h, strings.NewReader("hello"))
+	if !errors.Is(err, ErrMetadataTooLarge) {
+		t.Errorf("Expected ErrMetadataTooLarge, got %v", err)
+	}
+}
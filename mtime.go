@@ -0,0 +1,70 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"strconv"
+	"time"
+)
+
+// MtimeFileName is the name of the record in the root of archive holding
+// the nanoseconds of the last modification date of the file which follows
+// it, see Writer.PreciseMtime. The header holds the date in whole seconds,
+// so other tools restore it truncated and extract the record as a regular
+// file.
+const MtimeFileName = ".wpress-mtime"
+
+// maxMtimeRecord is the maximum length of mtime record content
+const maxMtimeRecord = 9
+
+// GetModTime returns last modified date including nanoseconds when they
+// were recorded
+func (h Header) GetModTime() (time.Time, error) {
+	mtime, err := h.GetMtime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(mtime, int64(h.mtimeNsec)), nil
+}
+
+// mtimeRecord returns the header block and content of the record holding
+// nanoseconds of the last modified date of the file described by h
+func mtimeRecord(h *Header) []byte {
+	return newRecord(MtimeFileName, h, strconv.Itoa(h.mtimeNsec))
+}
+
+// parseMtimeRecord returns nanoseconds stored in mtime record
+func parseMtimeRecord(content []byte) (int, error) {
+	nsec, err := strconv.Atoi(string(content))
+	if err != nil || nsec < 0 || nsec >= int(time.Second) {
+		return 0, ErrCorruptHeader
+	}
+	return nsec, nil
+}
+
+// isMtimeRecord reports whether h describes an mtime record
+func isMtimeRecord(h *Header) bool {
+	return h.longName == "" && h.GetName() == MtimeFileName && h.GetPrefix() == "."
+}
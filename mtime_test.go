@@ -0,0 +1,104 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPreciseMtime tests recording and restoring nanoseconds of
+// modification dates
+func TestPreciseMtime(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	root := filepath.Join(tempPath, "site")
+	os.MkdirAll(root, 0755)
+	source := filepath.Join(root, "index.php")
+	ioutil.WriteFile(source, []byte("<?php"), 0644)
+	modTime := time.Unix(1420382531, 123456789)
+	err := os.Chtimes(source, modTime, modTime)
+	if err != nil {
+		t.Fatalf("Unable to set modification date: %s", err)
+	}
+	fi, _ := os.Stat(source)
+	if !fi.ModTime().Equal(modTime) {
+		t.Skipf("File system doesn't keep nanoseconds")
+	}
+
+	for _, precise := range []bool{true, false} {
+		filename := filepath.Join(tempPath, "output.wpress")
+		w, err := NewWriter(filename)
+		if err != nil {
+			t.Fatalf("Failed to create a new Writer because %s", err)
+		}
+		w.PreciseMtime = precise
+		err = w.AddDirectoryWithOptions(root, AddOptions{})
+		if err != nil {
+			t.Errorf("Failed to add directory: %s", err)
+		}
+		w.Close()
+
+		expected := modTime
+		if !precise {
+			expected = time.Unix(modTime.Unix(), 0)
+		}
+
+		r, err := NewReader(filename)
+		if err != nil {
+			t.Fatalf("Failed to create a new Reader instance: %s", err)
+		}
+		err = r.Index()
+		if err == nil {
+			err = r.SaveIndex(filename + IndexExt)
+		}
+		if err == nil {
+			err = r.LoadIndex(filename + IndexExt)
+		}
+		e, statErr := r.Stat("index.php")
+		if err != nil || statErr != nil || !e.ModTime.Equal(expected) {
+			t.Errorf("Indexed date %s instead of %s: %v, %v", e.ModTime, expected, err, statErr)
+		}
+
+		destDir := filepath.Join(tempPath, "extracted")
+		_, err = r.ExtractTo(destDir)
+		if err != nil {
+			t.Errorf("Failed to extract archive: %s", err)
+		}
+		fi, err := os.Stat(filepath.Join(destDir, "index.php"))
+		if err != nil || !fi.ModTime().Equal(expected) {
+			t.Errorf("Restored date %v instead of %s: %v", fi, expected, err)
+		}
+		if _, err = os.Stat(filepath.Join(destDir, MtimeFileName)); !os.IsNotExist(err) {
+			t.Errorf("Mtime record was extracted")
+		}
+		r.Close()
+		os.RemoveAll(destDir)
+	}
+}
@@ -0,0 +1,135 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// nestedArchive reports whether extracted file name may be an archive to
+// extract, see ExtractOptions.NestedDepth
+func nestedArchive(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".wpress" || ext == ".zip"
+}
+
+// extractNested extracts the extracted files which are archives
+func (e *extraction) extractNested() error {
+	done := make(map[string]bool)
+	for _, pathToFile := range e.nested {
+		// file written more than once is extracted once
+		if done[pathToFile] {
+			continue
+		}
+		done[pathToFile] = true
+
+		err := e.extractArchive(pathToFile)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractArchive extracts archive pathToFile into the directory named after
+// it without the extension, other files are left alone
+func (e *extraction) extractArchive(pathToFile string) error {
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		return err
+	}
+	d, err := Detect(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	dir := strings.TrimSuffix(pathToFile, filepath.Ext(pathToFile))
+	switch {
+	case d.Format == FormatWpress || d.Format == FormatWpressExtended:
+		r, err := NewReader(pathToFile)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return e.extractChild(r, dir)
+	case d.Format == FormatZip && d.Compression == NoCompression:
+		return e.extractZip(pathToFile, dir)
+	}
+	return nil
+}
+
+// extractZip extracts zip file pathToFile into dir converting it to archive
+// on the fly, so paths and limits are checked like for any archive
+func (e *extraction) extractZip(pathToFile string, dir string) error {
+	zr, err := zip.OpenReader(pathToFile)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		w := NewWriterTo(pw)
+		_, err := w.AddZip(&zr.Reader)
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	err = e.extractChild(NewReaderFrom(pr), dir)
+	// stop the conversion when extraction failed
+	pr.CloseWithError(err)
+	convertErr := <-done
+	if err == nil {
+		err = convertErr
+	}
+	return err
+}
+
+// extractChild extracts nested archive read by r into dir with the options
+// of e one level deeper, its files count against the limits of e
+func (e *extraction) extractChild(r *Reader, dir string) error {
+	opts := e.opts
+	opts.DestDir = dir
+	opts.NestedDepth--
+	// paths of the outer archive don't apply to the nested one
+	opts.StripComponents, opts.StripPrefix, opts.Remap, opts.Filter = 0, "", nil, Filter{}
+	opts.Progress = nil
+
+	child := &extraction{r: r, ctx: e.ctx, opts: opts, extractedCount: e.extractedCount, planned: e.planned, totalSize: e.totalSize}
+	_, err := child.run()
+	e.extractedCount, e.planned, e.totalSize = child.extractedCount, child.planned, child.totalSize
+	e.warnings = append(e.warnings, r.Warnings...)
+	return err
+}
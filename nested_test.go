@@ -0,0 +1,102 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestExtractNested tests extracting archives stored in archive
+func TestExtractNested(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	fw, _ := zw.Create("b.txt")
+	fw.Write([]byte("b"))
+	zw.Close()
+
+	// archive returns content of archive holding files
+	archive := func(files ...string) string {
+		content, err := ioutil.ReadFile(_newArchive(t, tempPath, files...))
+		if err != nil {
+			t.Fatalf("Unable to read archive: %s", err)
+		}
+		return string(content)
+	}
+	deeper := archive("c.txt", "c")
+	staging := archive("a.txt", "a", "deeper.wpress", deeper)
+	filename := _newArchive(t, tempPath,
+		"backups/staging.wpress", staging, "export.zip", zipped.String(), "notes.zip", "not a zip")
+
+	tests := []struct {
+		depth int
+		files int
+		paths []string
+	}{
+		{0, 3, []string{"backups/staging.wpress", "export.zip", "notes.zip"}},
+		{1, 6, []string{"backups/staging/a.txt", "backups/staging/deeper.wpress", "export/b.txt"}},
+		{2, 7, []string{"backups/staging/deeper/c.txt"}},
+	}
+	for _, test := range tests {
+		dest := filepath.Join(tempPath, "site")
+		os.RemoveAll(dest)
+
+		r, err := NewReader(filename)
+		if err != nil {
+			t.Fatalf("Failed to open archive: %s", err)
+		}
+		n, err := r.ExtractWithOptions(ExtractOptions{DestDir: dest, NestedDepth: test.depth})
+		r.Close()
+		if err != nil || n != test.files {
+			t.Errorf("Depth %d: extracted %d files instead of %d: %v", test.depth, n, test.files, err)
+		}
+		for _, name := range test.paths {
+			if _, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name))); err != nil {
+				t.Errorf("Depth %d: %s", test.depth, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dest, "notes")); !os.IsNotExist(err) {
+			t.Errorf("Depth %d: file which is not archive was extracted", test.depth)
+		}
+	}
+
+	// nested files count against the limits
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %s", err)
+	}
+	defer r.Close()
+	_, err = r.ExtractWithOptions(ExtractOptions{DestDir: filepath.Join(tempPath, "limited"), NestedDepth: 2, MaxFiles: 5})
+	if err != ErrTooManyFiles {
+		t.Errorf("Expected ErrTooManyFiles, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// PackageFileName is the name of the file in the root of archives exported
+// by All-in-One WP Migration describing the exported site
+const PackageFileName = "package.json"
+
+// maxPackageSize is the largest package.json read by Inspect
+const maxPackageSize = 16 << 20
+
+// ErrNoPackage is returned when archive has no package.json
+var ErrNoPackage = errors.New("archive has no package.json")
+
+// Package is the description of the exported site as recorded by the
+// exporter in package.json, fields missing from it are left empty
+type Package struct {
+	// Version is the version of the exporter
+	Version string `json:"Version"`
+
+	SiteURL         string `json:"SiteURL"`
+	HomeURL         string `json:"HomeURL"`
+	InternalSiteURL string `json:"InternalSiteURL"`
+	InternalHomeURL string `json:"InternalHomeURL"`
+
+	WordPress PackageWordPress `json:"WordPress"`
+	Database  PackageDatabase  `json:"Database"`
+	PHP       PackagePHP       `json:"PHP"`
+
+	// Plugins lists the active plugins, e.g. "akismet/akismet.php"
+	Plugins    []string `json:"Plugins"`
+	Template   string   `json:"Template"`
+	Stylesheet string   `json:"Stylesheet"`
+	Uploads    string   `json:"Uploads"`
+	UploadsURL string   `json:"UploadsURL"`
+
+	// Raw holds all keys of package.json, including those not described
+	// by the other fields
+	Raw map[string]json.RawMessage `json:"-"`
+}
+
+// PackageWordPress describes WordPress of the exported site
+type PackageWordPress struct {
+	Version    string `json:"Version"`
+	Content    string `json:"Content"`
+	Plugins    string `json:"Plugins"`
+	Themes     string `json:"Themes"`
+	Uploads    string `json:"Uploads"`
+	UploadsURL string `json:"UploadsURL"`
+}
+
+// PackageDatabase describes database of the exported site
+type PackageDatabase struct {
+	Version string `json:"Version"`
+	Charset string `json:"Charset"`
+	Collate string `json:"Collate"`
+	// Prefix is the table prefix, e.g. "wp_"
+	Prefix         string   `json:"Prefix"`
+	ExcludedTables []string `json:"ExcludedTables"`
+}
+
+// PackagePHP describes PHP of the exporting server
+type PackagePHP struct {
+	Version string `json:"Version"`
+	System  string `json:"System"`
+}
+
+// Inspect reads package.json from the root of archive and returns the
+// description of the exported site without extracting anything else,
+// ErrNoPackage is returned when there is none
+func (r *Reader) Inspect() (*Package, error) {
+	c, e, err := r.findFile(PackageFileName)
+	if err == ErrFileNotFound {
+		return nil, ErrNoPackage
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e.OriginalSize > maxPackageSize {
+		return nil, &os.PathError{Op: "read", Path: PackageFileName, Err: ErrFileTooLarge}
+	}
+
+	data, err := readHead(e.Path(), e.Compression, e.OriginalSize, c, e.OriginalSize)
+	if err != nil {
+		return nil, err
+	}
+	return parsePackage(data)
+}
+
+// readHead returns up to limit bytes of content of size bytes of file name
+// read from src, decompressed when stored compressed
+func readHead(name string, compression Compression, size int64, src io.Reader, limit int64) ([]byte, error) {
+	content, err := openEntry(name, compression, size, src)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	return ioutil.ReadAll(io.LimitReader(content, limit))
+}
+
+// parsePackage parses content of package.json
+func parsePackage(data []byte) (*Package, error) {
+	p := &Package{}
+	err := json.Unmarshal(data, p)
+	if err == nil {
+		err = json.Unmarshal(data, &p.Raw)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: PackageFileName, Err: err}
+	}
+	return p, nil
+}
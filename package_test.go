@@ -0,0 +1,74 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"os"
+	"testing"
+)
+
+// TestInspect tests reading package.json of archive
+func TestInspect(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	content := `{"Version":"7.79","SiteURL":"https://example.com","HomeURL":"https://example.com",` +
+		`"Plugins":["akismet/akismet.php"],"Template":"twentytwenty","Stylesheet":"twentytwenty",` +
+		`"WordPress":{"Version":"6.4.2","Content":"/var/www/html/wp-content"},` +
+		`"Database":{"Version":"8.0.35","Charset":"utf8mb4","Prefix":"wp_"},` +
+		`"PHP":{"Version":"8.1.2","System":"Linux","Integer":8},"NoSpamComments":true}`
+	r, err := NewReader(_newArchive(t, tempPath, "database.sql", "CREATE", "package.json", content))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %s", err)
+	}
+	p, err := r.Inspect()
+	r.Close()
+	if err != nil {
+		t.Fatalf("Failed to inspect archive: %s", err)
+	}
+	if p.Version != "7.79" || p.SiteURL != "https://example.com" || len(p.Plugins) != 1 ||
+		p.WordPress.Version != "6.4.2" || p.Database.Prefix != "wp_" || p.PHP.Version != "8.1.2" {
+		t.Errorf("Unexpected package %+v", p)
+	}
+	if string(p.Raw["NoSpamComments"]) != "true" {
+		t.Errorf("Unexpected raw package %v", p.Raw)
+	}
+
+	// archives without package.json
+	r, _ = NewReader(_newArchive(t, tempPath, "database.sql", "CREATE"))
+	_, err = r.Inspect()
+	r.Close()
+	if err != ErrNoPackage {
+		t.Errorf("Expected ErrNoPackage, got %v", err)
+	}
+
+	// invalid package.json
+	r, _ = NewReader(_newArchive(t, tempPath, "package.json", "{"))
+	_, err = r.Inspect()
+	r.Close()
+	if _, ok := err.(*os.PathError); !ok {
+		t.Errorf("Expected *os.PathError, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// smallFileSize is the size up to which read-ahead workers read the whole
+// content of a file into memory, larger files are streamed when written
+const smallFileSize = 64 << 10
+
+// prefetch is a file prepared by a read-ahead worker
+type prefetch struct {
+	pathToFile string
+	name       string
+	source     string
+	// link is set for symbolic links stored as links, attributes for
+	// files whose attributes are recorded
+	link       os.FileInfo
+	attributes bool
+
+	h       *Header
+	content io.ReadSeeker
+	file    *os.File
+	err     error
+
+	// ready is closed when the file was prepared
+	ready chan struct{}
+}
+
+// prepare reads the header and opens the file, content of small files is
+// read into memory
+func (p *prefetch) prepare() {
+	fi, err := os.Stat(p.pathToFile)
+	if err != nil {
+		p.err = err
+		return
+	}
+	p.h, err = NewHeader(p.name, fi.Size(), fi.ModTime())
+	if err != nil {
+		p.err = err
+		return
+	}
+	if p.attributes {
+		p.h.attrs = fileAttributes(fi, "")
+	}
+
+	file, err := os.Open(p.pathToFile)
+	if err != nil {
+		p.err = err
+		return
+	}
+	if fi.Size() > smallFileSize {
+		p.file, p.content = file, file
+		return
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(file, fi.Size()))
+	file.Close()
+	if err != nil {
+		p.err = err
+		return
+	}
+	p.content = bytes.NewReader(content)
+}
+
+// addPipelined adds files of t while workers stat, open and read the files
+// ahead, files are written in the order of the walk
+func (w *Writer) addPipelined(t *tree, workers int) error {
+	ctx, cancel := context.WithCancel(w.ctx)
+	defer cancel()
+
+	// ordered limits the number of files prepared ahead
+	ordered := make(chan *prefetch, 2*workers)
+	jobs := make(chan *prefetch)
+	walked := make(chan error, 1)
+
+	// walk the tree, in the order files are written
+	go func() {
+		walked <- t.walk(func(pathToFile string, name string, fi os.FileInfo, source string) error {
+			p := &prefetch{pathToFile: pathToFile, name: name, source: source, attributes: w.Attributes, ready: make(chan struct{})}
+			if fi.Mode()&fs.ModeSymlink != 0 {
+				p.link = fi
+			}
+			select {
+			case ordered <- p:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			// hard and symbolic links have no content to prepare
+			if source != "" || p.link != nil {
+				close(p.ready)
+				return nil
+			}
+			select {
+			case jobs <- p:
+			case <-ctx.Done():
+				close(p.ready)
+				return ctx.Err()
+			}
+			return nil
+		})
+		close(jobs)
+		close(ordered)
+	}()
+
+	// prepare the files
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				if ctx.Err() == nil {
+					p.prepare()
+				}
+				close(p.ready)
+			}
+		}()
+	}
+
+	// write the files in order, after a failure only close the prepared
+	// files
+	var err error
+	for p := range ordered {
+		<-p.ready
+		if err == nil {
+			err = w.ctx.Err()
+		}
+		if err == nil {
+			err = w.writePrefetched(p, t.opts.DedupContent)
+		}
+		if p.file != nil {
+			p.file.Close()
+		}
+		if err != nil {
+			cancel()
+		}
+	}
+	wg.Wait()
+
+	walkErr := <-walked
+	if err == nil {
+		err = walkErr
+	}
+	return err
+}
+
+// writePrefetched writes the file prepared by a worker
+func (w *Writer) writePrefetched(p *prefetch, dedup bool) error {
+	if p.source != "" {
+		w.links = append(w.links, hardLink{Name: p.name, Source: p.source})
+		return nil
+	}
+	if p.link != nil {
+		return w.addSymlink(p.pathToFile, p.name, p.link)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	if dedup {
+		return w.writeDeduped(p.h, p.content)
+	}
+	return w.writeEntry(p.h, p.content)
+}
@@ -0,0 +1,285 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// optionsTableRegexp matches names of options tables without the prefix,
+// tables of sites of multisite included
+var optionsTableRegexp = regexp.MustCompile(`^(?:[0-9]+_)?options$`)
+
+// RewritePrefix returns transform of SQL dumps replacing table prefix old by
+// new, e.g. "wp_" by "site2_". Quoted names of tables, indexes and
+// constraints beginning with old are renamed in all statements, as are the
+// keys of options and user meta WordPress looks up by the prefix, e.g.
+// "wp_user_roles" and "wp_capabilities", in option_name and meta_key
+// columns. Dumps exported by All-in-One WP Migration have the prefix
+// replaced by "SERVMASK_PREFIX_", pass it as old to rewrite them.
+func RewritePrefix(old, new string) TransformFunc {
+	return func(name string, content io.Reader) (io.Reader, error) {
+		if old == "" || old == new {
+			return content, nil
+		}
+		p := &prefixRewriter{old: old, new: new, column: -1}
+		return &statementReader{src: bufio.NewReader(content), replace: p.rewrite}, nil
+	}
+}
+
+// prefixRewriter replaces table prefix in statements of SQL dump
+type prefixRewriter struct {
+	old string
+	new string
+	// position of the column holding keys in rows inserted by the current
+	// statement, -1 when there's none, rows of extended inserts may
+	// continue on the following lines
+	column int
+}
+
+// rewrite returns statement with table prefix replaced
+func (p *prefixRewriter) rewrite(statement []byte) []byte {
+	if isSQLComment(statement) {
+		return statement
+	}
+
+	statement = p.renameIdentifiers(statement)
+
+	// rows follow VALUES of the insert, or continue it
+	start := 0
+	trimmed := bytes.TrimLeft(statement, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] != '(' && trimmed[0] != ',' {
+		p.column = -1
+		if !hasKeyword(trimmed, "INSERT") && !hasKeyword(trimmed, "REPLACE") {
+			return statement
+		}
+		start = valuesEnd(statement)
+		if start == -1 {
+			return statement
+		}
+		p.column = p.keyColumn(statement[:start])
+	}
+	if p.column == -1 {
+		return statement
+	}
+	return p.rewriteKeys(statement, start)
+}
+
+// keyColumn returns position of the column holding keys beginning with the
+// prefix in rows inserted by statement up to its VALUES, -1 is returned for
+// other tables
+func (p *prefixRewriter) keyColumn(statement []byte) int {
+	identifiers := quotedIdentifiers(statement)
+	if len(identifiers) == 0 || !strings.HasPrefix(identifiers[0], p.new) {
+		return -1
+	}
+
+	// position in tables created by WordPress
+	table := identifiers[0][len(p.new):]
+	name, position := "", -1
+	switch {
+	case optionsTableRegexp.MatchString(table):
+		name, position = "option_name", 1
+	case table == "usermeta":
+		name, position = "meta_key", 2
+	default:
+		return -1
+	}
+
+	// columns listed by the statement
+	if len(identifiers) > 1 {
+		for i, column := range identifiers[1:] {
+			if column == name {
+				return i
+			}
+		}
+		return -1
+	}
+	return position
+}
+
+// rewriteKeys returns statement with the prefix replaced in values of the
+// key column of rows following start
+func (p *prefixRewriter) rewriteKeys(statement []byte, start int) []byte {
+	var out []byte
+	copied := 0
+	depth, column := 0, 0
+	for i := start; i < len(statement); i++ {
+		switch statement[i] {
+		case '(':
+			if depth == 0 {
+				column = 0
+			}
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 1 {
+				column++
+			}
+		case '\'':
+			end := literalEnd(statement, i)
+			if end == -1 {
+				i = len(statement)
+				break
+			}
+			if depth == 1 && column == p.column {
+				value := unescapeSQL(statement[i+1 : end])
+				rewritten := p.rewriteKey(value)
+				if rewritten != value {
+					out = append(out, statement[copied:i+1]...)
+					out = append(out, escapeSQL(rewritten)...)
+					copied = end
+				}
+			}
+			i = end
+		}
+	}
+
+	if out == nil {
+		return statement
+	}
+	return append(out, statement[copied:]...)
+}
+
+// renameIdentifiers returns statement with the prefix replaced in quoted
+// names beginning with it, string literals are left alone
+func (p *prefixRewriter) renameIdentifiers(statement []byte) []byte {
+	var out []byte
+	copied := 0
+	for i := 0; i < len(statement); i++ {
+		switch statement[i] {
+		case '\'':
+			end := literalEnd(statement, i)
+			if end == -1 {
+				i = len(statement)
+				break
+			}
+			i = end
+		case '`':
+			end := bytes.IndexByte(statement[i+1:], '`')
+			if end == -1 {
+				i = len(statement)
+				break
+			}
+			end += i + 1
+			if bytes.HasPrefix(statement[i+1:end], []byte(p.old)) {
+				out = append(out, statement[copied:i+1]...)
+				out = append(out, p.new...)
+				copied = i + 1 + len(p.old)
+			}
+			i = end
+		}
+	}
+
+	if out == nil {
+		return statement
+	}
+	return append(out, statement[copied:]...)
+}
+
+// rewriteKey returns value with the prefix replaced when it is a key
+// beginning with the prefix, e.g. "wp_user-settings"
+func (p *prefixRewriter) rewriteKey(value string) string {
+	if !strings.HasPrefix(value, p.old) || !isKey(value[len(p.old):]) {
+		return value
+	}
+	return p.new + value[len(p.old):]
+}
+
+// isKey reports whether s is a non empty name made of letters, digits,
+// underscores and dashes
+func isKey(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isWordByte(s[i]) && s[i] != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// isWordByte reports whether c is a letter, a digit or an underscore
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// hasKeyword reports whether statement begins with keyword in any case
+// followed by a word boundary
+func hasKeyword(statement []byte, keyword string) bool {
+	return len(statement) >= len(keyword) && strings.EqualFold(string(statement[:len(keyword)]), keyword) &&
+		(len(statement) == len(keyword) || !isWordByte(statement[len(keyword)]))
+}
+
+// valuesEnd returns position following the VALUES keyword of statement
+// outside of names and literals, -1 is returned when there's none
+func valuesEnd(statement []byte) int {
+	for i := 0; i < len(statement); i++ {
+		switch c := statement[i]; {
+		case c == '\'':
+			i = literalEnd(statement, i)
+			if i == -1 {
+				return -1
+			}
+		case c == '`':
+			end := bytes.IndexByte(statement[i+1:], '`')
+			if end == -1 {
+				return -1
+			}
+			i += end + 1
+		case (i == 0 || !isWordByte(statement[i-1])) && hasKeyword(statement[i:], "VALUES"):
+			return i + len("VALUES")
+		}
+	}
+	return -1
+}
+
+// quotedIdentifiers returns the quoted names of statement in order
+func quotedIdentifiers(statement []byte) []string {
+	var identifiers []string
+	for i := 0; i < len(statement); i++ {
+		switch statement[i] {
+		case '\'':
+			i = literalEnd(statement, i)
+			if i == -1 {
+				return identifiers
+			}
+		case '`':
+			end := bytes.IndexByte(statement[i+1:], '`')
+			if end == -1 {
+				return identifiers
+			}
+			identifiers = append(identifiers, string(statement[i+1:i+1+end]))
+			i += end + 1
+		}
+	}
+	return identifiers
+}
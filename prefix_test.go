@@ -0,0 +1,130 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRewritePrefix tests replacing table prefix in SQL dump
+func TestRewritePrefix(t *testing.T) {
+	tests := []struct {
+		old      string
+		dump     string
+		expected string
+	}{
+		{
+			"wp_",
+			"DROP TABLE IF EXISTS `wp_options`;\nCREATE TABLE `wp_usermeta` (\n  KEY `wp_key` (`meta_key`)\n);\n",
+			"DROP TABLE IF EXISTS `site_options`;\nCREATE TABLE `site_usermeta` (\n  KEY `site_key` (`meta_key`)\n);\n",
+		},
+		// keys of options and user meta
+		{
+			"wp_",
+			"INSERT INTO `wp_options` VALUES (1,'wp_user_roles','a:0:{}','yes'),(2,'blogname','wp_user_roles is here','yes');\n",
+			"INSERT INTO `site_options` VALUES (1,'site_user_roles','a:0:{}','yes'),(2,'blogname','wp_user_roles is here','yes');\n",
+		},
+		{
+			"wp_",
+			"INSERT INTO `wp_usermeta` (`umeta_id`, `meta_key`) VALUES\n(1,'wp_capabilities'),\n(2,'wp_user-settings');\n",
+			"INSERT INTO `site_usermeta` (`umeta_id`, `meta_key`) VALUES\n(1,'site_capabilities'),\n(2,'site_user-settings');\n",
+		},
+		{
+			"wp_",
+			"INSERT INTO `wp_2_options` VALUES (1,'wp_2_user_roles','`wp_x`');\n",
+			"INSERT INTO `site_2_options` VALUES (1,'site_2_user_roles','`wp_x`');\n",
+		},
+		// only the key column is rewritten
+		{
+			"wp_",
+			"INSERT INTO `wp_options` VALUES (1,'template','wp_theme','yes');\nINSERT INTO `wp_usermeta` VALUES (1,1,'wp_capabilities','wp_user_level');\n",
+			"INSERT INTO `site_options` VALUES (1,'template','wp_theme','yes');\nINSERT INTO `site_usermeta` VALUES (1,1,'site_capabilities','wp_user_level');\n",
+		},
+		{
+			"wp_",
+			"INSERT INTO `wp_options` (`option_value`, `option_name`) VALUES ('wp_x','wp_user_roles');\n",
+			"INSERT INTO `site_options` (`option_value`, `option_name`) VALUES ('wp_x','site_user_roles');\n",
+		},
+		// keywords end at word boundaries
+		{
+			"wp_",
+			"INSERTX INTO `wp_options` VALUES (1,'wp_user_roles');\n",
+			"INSERTX INTO `site_options` VALUES (1,'wp_user_roles');\n",
+		},
+		// values of other tables, other names and comments are left alone
+		{
+			"wp_",
+			"-- `wp_posts`\nINSERT INTO `wp_posts` VALUES (1,'wp_capabilities');\nSELECT `other_wp_x`;\n",
+			"-- `wp_posts`\nINSERT INTO `site_posts` VALUES (1,'wp_capabilities');\nSELECT `other_wp_x`;\n",
+		},
+		// placeholder of All-in-One WP Migration
+		{
+			placeholderPrefix,
+			"INSERT INTO `SERVMASK_PREFIX_options` VALUES (1,'SERVMASK_PREFIX_user_roles');\n",
+			"INSERT INTO `site_options` VALUES (1,'site_user_roles');\n",
+		},
+	}
+	for _, test := range tests {
+		content, err := RewritePrefix(test.old, "site_")("database.sql", strings.NewReader(test.dump))
+		var rewritten []byte
+		if err == nil {
+			rewritten, err = ioutil.ReadAll(content)
+		}
+		if err != nil || string(rewritten) != test.expected {
+			t.Errorf("Rewrote %q to %q instead of %q: %v", test.dump, rewritten, test.expected, err)
+		}
+	}
+}
+
+// TestExtractRewritePrefix tests rewriting table prefix during extraction
+func TestExtractRewritePrefix(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	dump := "INSERT INTO `wp_options` VALUES (1,'wp_user_roles','http://old.test');\n"
+	r, err := NewReader(_newArchive(t, tempPath, "database.sql", dump))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %s", err)
+	}
+	defer r.Close()
+
+	dest := filepath.Join(tempPath, "site")
+	_, err = r.ExtractWithOptions(ExtractOptions{DestDir: dest, Transforms: []Transform{
+		{Pattern: DatabaseFileName, Func: RewritePrefix("wp_", "new_")},
+		{Pattern: DatabaseFileName, Func: SearchReplace(Replacement{Old: "http://old.test", New: "https://new.test"})},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to extract archive: %s", err)
+	}
+	expected := "INSERT INTO `new_options` VALUES (1,'new_user_roles','https://new.test');\n"
+	content, _ := ioutil.ReadFile(filepath.Join(dest, DatabaseFileName))
+	if string(content) != expected {
+		t.Errorf("Extracted %q instead of %q", content, expected)
+	}
+}
@@ -0,0 +1,89 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+// ExcludePreset is a named list of exclusion patterns for files WordPress
+// sites accumulate which don't belong in a backup. Presets expect the root
+// to be the WordPress installation directory or one of its parents.
+type ExcludePreset int
+
+const (
+	// ExcludeCaches leaves out directories of caching plugins
+	ExcludeCaches ExcludePreset = iota
+	// ExcludeUpgrade leaves out temporary files of core, plugin and theme
+	// updates
+	ExcludeUpgrade
+	// ExcludeBackups leaves out output of backup plugins, including other
+	// .wpress archives
+	ExcludeBackups
+	// ExcludeLogs leaves out error and debug logs
+	ExcludeLogs
+	// ExcludeVCS leaves out version control directories
+	ExcludeVCS
+)
+
+// WordPressPresets lists all exclusion presets
+var WordPressPresets = []ExcludePreset{ExcludeCaches, ExcludeUpgrade, ExcludeBackups, ExcludeLogs, ExcludeVCS}
+
+// presetPatterns maps presets to patterns in the syntax of AddOptions.Exclude
+var presetPatterns = map[ExcludePreset][]string{
+	ExcludeCaches: {
+		"**/wp-content/cache",
+		"**/wp-content/et-cache",
+		"**/wp-content/litespeed",
+		"**/wp-content/endurance-page-cache",
+		"**/wp-content/uploads/wpo-cache",
+	},
+	ExcludeUpgrade: {
+		"**/wp-content/upgrade",
+		"**/wp-content/upgrade-temp-backup",
+	},
+	ExcludeBackups: {
+		"**/wp-content/ai1wm-backups",
+		"**/wp-content/updraft",
+		"**/wp-content/backups-dup-lite",
+		"**/wp-content/backups-dup-pro",
+		"**/wp-content/wpvividbackups",
+		"**/wp-content/uploads/backwpup-*",
+		"**/wp-content/uploads/backup-guard",
+		"*.wpress",
+	},
+	ExcludeLogs: {
+		"error_log",
+		"debug.log",
+		"*.log",
+		"**/wp-content/wflogs",
+	},
+	ExcludeVCS: {
+		".git",
+		".svn",
+		".hg",
+	},
+}
+
+// Patterns returns exclusion patterns of the preset
+func (p ExcludePreset) Patterns() []string {
+	return append([]string{}, presetPatterns[p]...)
+}
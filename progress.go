@@ -0,0 +1,135 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"sync"
+)
+
+// defaultProgressInterval is the number of bytes between progress reports
+const defaultProgressInterval = 1 << 20
+
+// ProgressEvent describes progress of reading or writing an archive
+type ProgressEvent struct {
+	Path       string // path of the current file
+	FileSize   int64  // length of the current file contents
+	FileBytes  int64  // bytes of the current file processed so far
+	FileDone   bool   // whether the current file has been fully processed
+	Files      int    // number of files fully processed so far
+	Bytes      int64  // bytes of all files processed so far
+	TotalFiles int    // number of files to process, zero when unknown
+	TotalBytes int64  // bytes of all files to process, zero when unknown
+}
+
+// progressTracker accumulates progress and reports it to the callback, nil
+// tracker ignores all calls so callers don't have to check. Files may be
+// processed concurrently, reports are serialized.
+type progressTracker struct {
+	fn       func(ProgressEvent)
+	interval int64
+
+	mu    sync.Mutex
+	event ProgressEvent
+}
+
+// fileProgress tracks progress of a single file
+type fileProgress struct {
+	p        *progressTracker
+	path     string
+	size     int64
+	bytes    int64
+	reported int64
+}
+
+// newProgressTracker creates a tracker reporting every interval bytes
+func newProgressTracker(fn func(ProgressEvent), interval int64) *progressTracker {
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	return &progressTracker{fn: fn, interval: interval}
+}
+
+// setTotals sets the number of files and bytes to process
+func (p *progressTracker) setTotals(files int, bytes int64) {
+	if p == nil {
+		return
+	}
+	p.event.TotalFiles = files
+	p.event.TotalBytes = bytes
+}
+
+// startFile reports the beginning of a file
+func (p *progressTracker) startFile(path string, size int64) *fileProgress {
+	if p == nil {
+		return nil
+	}
+	f := &fileProgress{p: p, path: path, size: size}
+	p.report(f, 0, false)
+	return f
+}
+
+// report accounts n processed bytes of f and calls the callback
+func (p *progressTracker) report(f *fileProgress, n int64, done bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.event.Bytes += n
+	if done {
+		p.event.Files++
+	}
+
+	event := p.event
+	event.Path = f.path
+	event.FileSize = f.size
+	event.FileBytes = f.bytes
+	event.FileDone = done
+	p.fn(event)
+}
+
+// add accounts n processed bytes of the file
+func (f *fileProgress) add(n int64) {
+	if f == nil {
+		return
+	}
+	f.bytes += n
+
+	// account the bytes, but report only every interval bytes
+	if f.bytes-f.reported < f.p.interval {
+		f.p.mu.Lock()
+		f.p.event.Bytes += n
+		f.p.mu.Unlock()
+		return
+	}
+	f.reported = f.bytes
+	f.p.report(f, n, false)
+}
+
+// end reports the end of the file
+func (f *fileProgress) end() {
+	if f == nil {
+		return
+	}
+	f.p.report(f, 0, true)
+}
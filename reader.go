@@ -26,25 +26,77 @@ package wpress
 
 import (
 	"bytes"
+	"context"
 	"errors"
-	"fmt"
+	"io"
 	"os"
 	"path"
 	"strconv"
+	"sync"
 	"time"
 )
 
+// ErrFileNotFound is returned when requested file is not present in archive
+var ErrFileNotFound = errors.New("file not found in archive")
+
+// ErrNotSeekable is returned when operation needs to move backwards or
+// randomly access an archive that can only be read sequentially
+var ErrNotSeekable = errors.New("archive can only be read sequentially")
+
 // Reader structure
 type Reader struct {
 	Filename      string
 	File          *os.File
 	NumberOfFiles int
+
+	// Warnings collects non fatal problems found by the last operation
+	Warnings []error
+
+	// Lenient skips headers whose size can't be parsed instead of failing,
+	// the skipped headers and unparsable dates are added to Warnings. It
+	// maximizes what can be recovered from damaged archives.
+	Lenient bool
+
+	// Salvage is like Lenient but searches forward for the next block that
+	// looks like a valid header, so files after a corrupted region are
+	// recovered
+	Salvage bool
+
+	// Encryption is the key encrypted archives are decrypted with, it has
+	// to be set before calling Init, see NewEncryptedReader
+	Encryption *Encryption
+
+	// Layout is the layout of headers of archives written by forks of the
+	// format, DefaultLayout is used when zero. It has to be set before
+	// reading files.
+	Layout Layout
+
+	// source the archive is read from, File unless created by NewReaderFrom
+	source io.Reader
+	// closes source when the archive is not read from File, see
+	// NewVolumeReader
+	closer io.Closer
+	// cursor used by Next and Read, and by all operations when the source
+	// doesn't support random access
+	cur *cursor
+	// metadata of all files in archive order and the first occurrence of
+	// every path, see Index
+	entries []EntryInfo
+	index   map[string]EntryInfo
+	// archive may end with table of contents, it is read by indexed once
+	// Layout is final
+	hasTOC  bool
+	tocOnce sync.Once
+	// releases the decompressor of compressed archives
+	decompressor io.Closer
+	// guards NumberOfFiles, Warnings and the index
+	mu sync.Mutex
 }
 
 // NewReader creates a new Reader instance and calls its constructor
 func NewReader(filename string) (*Reader, error) {
 	// create a new instance of Reader
-	r := &Reader{filename, nil, 0}
+	r := &Reader{Filename: filename}
 
 	// call the constructor
 	err := r.Init()
@@ -56,6 +108,38 @@ func NewReader(filename string) (*Reader, error) {
 	return r, nil
 }
 
+// NewEncryptedReader creates a new Reader instance of archive encrypted by
+// enc, ErrNotEncrypted is returned for plain archives
+func NewEncryptedReader(filename string, enc Encryption) (*Reader, error) {
+	r := &Reader{Filename: filename, Encryption: &enc}
+
+	err := r.Init()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewReaderFrom creates a new Reader instance reading archive from src. If
+// src doesn't implement io.Seeker the archive is parsed purely sequentially,
+// so it can be read only once and random access operations are unavailable.
+func NewReaderFrom(src io.Reader) *Reader {
+	r := &Reader{source: src}
+	r.cur = &cursor{source: src, layout: &r.Layout}
+	return r
+}
+
+// NewReaderAt creates a new Reader instance reading archive of size bytes
+// from ra, e.g. a memory mapped file, a byte slice or a remote blob
+func NewReaderAt(ra io.ReaderAt, size int64) *Reader {
+	// section reader provides seeking and random access over ra
+	r := NewReaderFrom(io.NewSectionReader(ra, 0, size))
+
+	// list files by the table of contents when archive has one
+	r.hasTOC = true
+	return r
+}
+
 // Init is the constructor of Reader struct
 func (r *Reader) Init() error {
 	// try to open the file
@@ -64,188 +148,308 @@ func (r *Reader) Init() error {
 		return err
 	}
 
-	// file was openned, assign the handle to the holding variable
+	// signature trailer is not part of the archive
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	var archive archiveSource = file
+	size, signature := signedSize(file, fi.Size())
+	if signature != nil {
+		archive = io.NewSectionReader(file, 0, size)
+	}
+
+	// encrypted archives are decrypted, then compressed archives are read
+	// through the decompressor
+	plain, err := r.decrypt(archive, size, file.Name())
+	if err != nil {
+		file.Close()
+		return err
+	}
+	source, decompressor, err := decompress(plain, file.Name())
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	// file was openned, assign the handle to the holding variables
 	r.File = file
+	r.decompressor = decompressor
+	r.source = source
+	r.cur = &cursor{source: source, layout: &r.Layout}
+
+	// list files by the table of contents when archive has one
+	r.hasTOC = true
 
 	return nil
 }
 
-// ExtractFile extracts file that matches tha filename and path from archive
-func (r Reader) ExtractFile(filename string, path string) ([]byte, error) {
-	// TODO: implement
-	return nil, nil
+// Close closes the archive files opened by NewReader or NewVolumeReader,
+// sources passed to NewReaderFrom and NewReaderAt are left for the caller to
+// close
+func (r *Reader) Close() error {
+	if r.decompressor != nil {
+		r.decompressor.Close()
+	}
+	if r.File != nil {
+		return r.File.Close()
+	}
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
 }
 
-// Extract all files from archive
-func (r Reader) Extract() (int, error) {
-	// put pointer at the beginning of the file
-	r.File.Seek(0, 0)
+// ExtractFile extracts file that matches tha filename and prefix from archive
+// and returns its content
+func (r *Reader) ExtractFile(filename string, prefix string) ([]byte, error) {
+	// find the header and put pointer at the beginning of its content
+	c, e, err := r.findFile(prefix + string(os.PathSeparator) + filename)
+	if err != nil {
+		return nil, err
+	}
+	src, err := openEntry(e.Path(), e.Compression, e.OriginalSize, c)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
 
-	// loop until end of file was reached
-	for {
-		// read header block
-		block, err := r.GetHeaderBlock()
-		if err != nil {
-			return 0, err
-		}
+	// read the content of the file
+	content := make([]byte, e.OriginalSize)
+	_, err = io.ReadFull(src, content)
+	if err != nil {
+		return nil, err
+	}
 
-		// initialize new header
-		h := &Header{}
+	// reading past the end reports checksum mismatch
+	_, err = src.Read(nil)
+	if err != io.EOF {
+		return nil, err
+	}
 
-		// check if block equals EOF sequence
-		if bytes.Compare(block, h.GetEOFBlock()) == 0 {
-			// EOF reached, stop the loop
-			break
-		}
+	return content, nil
+}
 
-		// populate header from our block bytes
-		h.PopulateFromBytes(block)
+// ExtractFileTo streams content of the file matching name (prefix and
+// filename joined) from archive into w and returns number of bytes written
+func (r *Reader) ExtractFileTo(name string, w io.Writer) (int64, error) {
+	// find the header and put pointer at the beginning of its content
+	c, e, err := r.findFile(name)
+	if err != nil {
+		return 0, err
+	}
+	src, err := openEntry(e.Path(), e.Compression, e.OriginalSize, c)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
 
-		pathToFile := path.Clean("." + string(os.PathSeparator) + string(bytes.Trim(h.Prefix, "\x00")) + string(os.PathSeparator) + string(bytes.Trim(h.Name, "\x00")))
+	// copy only the content of the file, without buffering it in memory
+	return io.CopyN(w, src, e.OriginalSize)
+}
 
-		err = os.MkdirAll(path.Dir(pathToFile), 0755)
-		if err != nil {
-			fmt.Println(err)
-			return r.NumberOfFiles, err
+// Open returns a reader limited to the content of the file matching name,
+// content is read on demand directly from archive and decompressed when it
+// was stored compressed
+func (r *Reader) Open(name string) (io.ReadCloser, error) {
+	// random access is needed to read the content independently
+	ra, ok := r.source.(io.ReaderAt)
+	if !ok {
+		return nil, ErrNotSeekable
+	}
+
+	// find the header and put pointer at the beginning of its content
+	c, e, err := r.findFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// section reader reads at absolute offsets, so it is not affected by
+	// other reader operations moving the pointer
+	return openEntry(e.Path(), e.Compression, e.OriginalSize, io.NewSectionReader(ra, c.offset, c.remaining))
+}
+
+// findFile looks up the file matching name and returns its metadata with a
+// cursor pointing at the beginning of the file content
+func (r *Reader) findFile(name string) (*cursor, EntryInfo, error) {
+	// normalize the name the same way header paths are normalized
+	wanted := path.Clean("." + string(os.PathSeparator) + name)
+
+	// look the file up in the index when it was built
+	_, index := r.indexed()
+	if index != nil {
+		e, ok := index[wanted]
+		if !ok {
+			return nil, EntryInfo{}, ErrFileNotFound
 		}
+		return newEntryCursor(r.source.(io.ReaderAt), e.Offset, e.Size, e.Path(), &r.Layout), e, nil
+	}
+
+	// get a cursor at the beginning of the file
+	c, err := r.scan()
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
 
-		// try to open the file
-		file, err := os.Create(pathToFile)
+	// loop until end of file was reached
+	for {
+		h, err := c.next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return r.NumberOfFiles, err
+			return nil, EntryInfo{}, err
 		}
 
-		totalBytesToRead, _ := h.GetSize()
-		for {
-			bytesToRead := 512
-			if bytesToRead > totalBytesToRead {
-				bytesToRead = totalBytesToRead
-			}
-
-			if bytesToRead == 0 {
-				break
-			}
-
-			content := make([]byte, bytesToRead)
-			bytesRead, err := r.File.Read(content)
-			if err != nil {
-				return r.NumberOfFiles, err
-			}
-
-			totalBytesToRead -= bytesRead
-			contentRead := content[0:bytesRead]
-
-			_, err = file.Write(contentRead)
-			if err != nil {
-				return r.NumberOfFiles, err
-			}
+		// we found the file we are looking for
+		if h.GetPath() == wanted {
+			e, err := newEntryInfo(h, c.offset)
+			return c, e, err
 		}
+	}
 
-		file.Close()
+	return nil, EntryInfo{}, ErrFileNotFound
+}
 
-		// increment file counter
-		r.NumberOfFiles++
-	}
+// Extract all files from archive into the current working directory
+func (r *Reader) Extract() (int, error) {
+	return r.ExtractWithOptions(ExtractOptions{})
+}
+
+// Next advances to the next file in archive and returns its header, content
+// of the file can then be read by calling Read. io.EOF is returned when the
+// end of archive is reached. Iteration starts at the beginning of archive,
+// other Reader methods don't move it unless the archive can only be read
+// sequentially.
+func (r *Reader) Next() (*Header, error) {
+	r.cur.warn, r.cur.salvage = r.warnFunc(), r.Salvage
+	return r.cur.next()
+}
 
-	return r.NumberOfFiles, nil
+// Read reads from the content of the current file as stored, compressed
+// when the header tells so, it returns io.EOF when the end of the file is
+// reached
+func (r *Reader) Read(b []byte) (int, error) {
+	return r.cur.Read(b)
 }
 
 // GetHeaderBlock reads and returns header block from archive
-func (r Reader) GetHeaderBlock() ([]byte, error) {
-	// create buffer to keep the header block
-	block := make([]byte, headerSize)
+func (r *Reader) GetHeaderBlock() ([]byte, error) {
+	return r.cur.headerBlock()
+}
+
+// scan returns a cursor at the beginning of the archive, the cursor is
+// independent of other operations when the source supports random access
+func (r *Reader) scan() (*cursor, error) {
+	// the operation starts without warnings
+	r.mu.Lock()
+	r.Warnings = nil
+	r.mu.Unlock()
+
+	if ra, ok := r.source.(io.ReaderAt); ok {
+		c := newCursor(ra, &r.Layout)
+		c.warn, c.salvage = r.warnFunc(), r.Salvage
+		return c, nil
+	}
 
-	// read the header block
-	bytesRead, err := r.File.Read(block)
+	// sequential archive shares the single cursor
+	err := r.cur.rewind()
 	if err != nil {
 		return nil, err
 	}
+	r.cur.warn, r.cur.salvage = r.warnFunc(), r.Salvage
+	return r.cur, nil
+}
 
-	if bytesRead != headerSize {
-		return nil, errors.New("unable to read header block size")
+// warnFunc returns the function cursors report skipped headers to, nil is
+// returned unless the reader is lenient
+func (r *Reader) warnFunc() func(error) {
+	if !r.Lenient && !r.Salvage {
+		return nil
+	}
+	return func(err error) {
+		r.mu.Lock()
+		r.Warnings = append(r.Warnings, err)
+		r.mu.Unlock()
 	}
-
-	return block, nil
 }
 
 // GetFilesCount returns the number of files in archive
-func (r Reader) GetFilesCount() (int, error) {
+func (r *Reader) GetFilesCount() (int, error) {
 	// test if we have enumerated the archive already
-	if r.NumberOfFiles != 0 {
-		return r.NumberOfFiles, nil
+	r.mu.Lock()
+	filesCount := r.NumberOfFiles
+	r.mu.Unlock()
+	if filesCount != 0 {
+		return filesCount, nil
 	}
 
-	// put pointer at the beginning of the file
-	r.File.Seek(0, 0)
+	// get a cursor at the beginning of the file
+	c, err := r.scan()
+	if err != nil {
+		return 0, err
+	}
 
 	// loop until end of file was reached
 	for {
-		// read header block
-		block, err := r.GetHeaderBlock()
-		if err != nil {
-			return 0, err
-		}
-
-		// initialize new header
-		h := &Header{}
-
-		// check if block equals EOF sequence
-		if bytes.Compare(block, h.GetEOFBlock()) == 0 {
+		// read next header, content of the file is skipped
+		_, err := c.next()
+		if err == io.EOF {
 			// EOF reached, stop the loop
 			break
 		}
-
-		// populate header from our block bytes
-		h.PopulateFromBytes(block)
-
-		// set pointer after file content, to the next header block
-		size, err := h.GetSize()
+		if err == ErrTruncated {
+			// files counted before the archive ended
+			return filesCount, err
+		}
 		if err != nil {
 			return 0, err
 		}
-		r.File.Seek(int64(size), 1)
 
 		// increment file counter
-		r.NumberOfFiles++
+		filesCount++
 	}
 
-	return r.NumberOfFiles, nil
+	r.setFilesCount(filesCount)
+
+	return filesCount, nil
 }
 
 // Header and other necessary imports and structs should be defined above this.
 // Added by Slavi Marinov so no need to extract to view files.
 // List lists all files in the archive without extracting them.
 func (r *Reader) List() ([]string, error) {
-	var fileList []string
+	return r.ListContext(context.Background())
+}
 
-	// Reset the file counter as we'll be re-iterating the archive.
-	r.NumberOfFiles = 0
+// ListContext is like List but stops when ctx is done.
+func (r *Reader) ListContext(ctx context.Context) ([]string, error) {
+	var fileList []string
 
 	// Ensure we start from the beginning of the file.
-	_, err := r.File.Seek(0, 0)
+	c, err := r.scan()
 	if err != nil {
 		return nil, err
 	}
 
 	for {
-		// Read the header block.
-		block, err := r.GetHeaderBlock()
-		if err != nil {
-			// If an error occurs (e.g., EOF), break the loop.
-			break
+		// Stop between files when cancelled.
+		if ctx.Err() != nil {
+			return fileList, ctx.Err()
 		}
 
-		// Initialize a new header to hold the data.
-		h := &Header{}
-
-		// Check if the block is an EOF marker.
-		if bytes.Compare(block, h.GetEOFBlock()) == 0 {
+		// Read the next header, its content is skipped on the following call.
+		h, err := c.next()
+		if err == ErrTruncated {
+			// Return what was listed before the archive ended.
+			return fileList, err
+		}
+		if err != nil {
+			// If an error occurs (e.g., EOF), break the loop.
 			break
 		}
 
-		// Populate the header with data from the block.
-		h.PopulateFromBytes(block)
-
 		// Step 1 & 2: Convert the string to an integer
 		timestampStr := string(bytes.Trim(h.Mtime, "\x00"))
 		unixTimestamp, errTs := strconv.ParseInt(timestampStr, 10, 64)
@@ -260,21 +464,21 @@ func (r *Reader) List() ([]string, error) {
 		}
 
 		// Create a line SIZE Mtime path
-		filePath := string(bytes.Trim(h.Size, "\x00")) + " " + formattedDate + " " + path.Clean("."+string(os.PathSeparator)+string(bytes.Trim(h.Prefix, "\x00"))+string(os.PathSeparator)+string(bytes.Trim(h.Name, "\x00")))
+		filePath := string(bytes.Trim(h.Size, "\x00")) + " " + formattedDate + " " + h.GetPath()
 
 		// Add the file path to the list of files.
 		fileList = append(fileList, filePath)
-
-		// Calculate the size of the content and skip over it to the next header.
-		size, _ := h.GetSize()
-		_, err = r.File.Seek(int64(size), 1)
-		if err != nil {
-			return fileList, err
-		}
-
-		// Increment the file counter.
-		r.NumberOfFiles++
 	}
 
+	// Set the file counter as we have iterated the archive.
+	r.setFilesCount(len(fileList))
+
 	return fileList, nil
 }
+
+// setFilesCount sets NumberOfFiles after enumerating the whole archive
+func (r *Reader) setFilesCount(filesCount int) {
+	r.mu.Lock()
+	r.NumberOfFiles = filesCount
+	r.mu.Unlock()
+}
@@ -25,7 +25,13 @@
 package wpress
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -68,6 +74,26 @@ func TestNewReader(t *testing.T) {
 	}
 }
 
+// TestReaderClose tests closing the archive
+func TestReaderClose(t *testing.T) {
+	path := _getPathToTests(t)
+	r, err := NewReader(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Unable to create a new Reader instance: %s", err)
+	}
+
+	var closer io.Closer = r
+	err = closer.Close()
+	if err != nil {
+		t.Errorf("Unable to close the reader: %s", err)
+	}
+
+	_, err = r.GetFilesCount()
+	if err == nil {
+		t.Errorf("Closed reader is still readable")
+	}
+}
+
 // TestReaderInit tests Reader contrustor
 func TestReaderInit(t *testing.T) {
 	path := _getPathToTests(t)
@@ -90,6 +116,234 @@ func TestExtractFile(t *testing.T) {
 		t.Errorf("Failed to create a new Reader instance: %s", err)
 	}
 
+	// extract a single file
+	content, err := r.ExtractFile("lipsum.txt", "/repos/wpress/testdata")
+	if err != nil {
+		t.Errorf("Unable to extract file: %s", err)
+	}
+
+	// the archived file is 1478 bytes long
+	if len(content) != 1478 {
+		t.Errorf("Extracted file is %d bytes long instead of 1478", len(content))
+	}
+
+	// prefix is normalized before comparing
+	_, err = r.ExtractFile("logo.svg", "repos/wpress/testdata/")
+	if err != nil {
+		t.Errorf("Unable to extract file with normalized prefix: %s", err)
+	}
+
+	// requesting missing file returns ErrFileNotFound
+	_, err = r.ExtractFile("missing.txt", "/repos/wpress/testdata")
+	if err != ErrFileNotFound {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+}
+
+// TestExtractFileTo tests streaming a file from archive into a writer
+func TestExtractFileTo(t *testing.T) {
+	path := _getPathToTests(t)
+	// create a new reader instace with the test archive
+	r, err := NewReader(path + string(os.PathSeparator) + "test_archive.wpress")
+	if err != nil {
+		t.Errorf("Failed to create a new Reader instance: %s", err)
+	}
+
+	// stream the file into a buffer
+	buf := &bytes.Buffer{}
+	n, err := r.ExtractFileTo("repos/wpress/testdata/logo3.png", buf)
+	if err != nil {
+		t.Errorf("Unable to extract file: %s", err)
+	}
+
+	// the archived file is 6855 bytes long
+	if n != 6855 || buf.Len() != 6855 {
+		t.Errorf("Extracted %d bytes instead of 6855", n)
+	}
+
+	// requesting missing file returns ErrFileNotFound
+	_, err = r.ExtractFileTo("logo3.png", buf)
+	if err != ErrFileNotFound {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+}
+
+// TestOpen tests reading a file from archive on demand
+func TestOpen(t *testing.T) {
+	path := _getPathToTests(t)
+	// create a new reader instace with the test archive
+	r, err := NewReader(path + string(os.PathSeparator) + "test_archive.wpress")
+	if err != nil {
+		t.Errorf("Failed to create a new Reader instance: %s", err)
+	}
+
+	rc, err := r.Open("repos/wpress/testdata/lipsum.txt")
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err)
+	}
+	defer rc.Close()
+
+	// move the archive pointer, entry reader should not be affected
+	_, err = r.GetFilesCount()
+	if err != nil {
+		t.Errorf("Unable to get files count: %s", err)
+	}
+
+	content, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Errorf("Unable to read file: %s", err)
+	}
+
+	expected, _ := ioutil.ReadFile(path + string(os.PathSeparator) + "lipsum.txt")
+	if !bytes.Equal(content, expected) {
+		t.Errorf("Content read does not match the original file")
+	}
+}
+
+// TestNext tests iterating over files in archive
+func TestNext(t *testing.T) {
+	path := _getPathToTests(t)
+	// create a new reader instace with the test archive
+	r, err := NewReader(path + string(os.PathSeparator) + "test_archive.wpress")
+	if err != nil {
+		t.Errorf("Failed to create a new Reader instance: %s", err)
+	}
+
+	// read content of every other file, the rest should be skipped
+	sizes := map[string]int{}
+	for i := 0; ; i++ {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unable to read next header: %s", err)
+		}
+
+		if i%2 == 0 {
+			content, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Errorf("Unable to read file content: %s", err)
+			}
+			sizes[h.GetName()] = len(content)
+		}
+	}
+
+	if len(sizes) != 2 || sizes["logo.svg"] != 2313 || sizes["logo3.png"] != 6855 {
+		t.Errorf("Unexpected file contents read: %v", sizes)
+	}
+}
+
+// TestNewReaderFrom tests reading archive sequentially from a stream
+func TestNewReaderFrom(t *testing.T) {
+	path := _getPathToTests(t)
+	file, err := os.Open(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Unable to open test archive: %s", err)
+	}
+	defer file.Close()
+
+	// multi reader hides Seek and ReadAt methods of the file
+	r := NewReaderFrom(io.MultiReader(file))
+
+	// list the files while streaming
+	list, err := r.List()
+	if err != nil || len(list) != 3 {
+		t.Errorf("Listed %d files instead of 3: %v", len(list), err)
+	}
+
+	// the stream can't be read again
+	_, err = r.ExtractFile("lipsum.txt", "/repos/wpress/testdata")
+	if err != ErrNotSeekable {
+		t.Errorf("Expected ErrNotSeekable, got %v", err)
+	}
+
+	// stream the archive again from the beginning
+	file.Seek(0, 0)
+	r = NewReaderFrom(io.MultiReader(file))
+
+	// read the content of the second file while streaming
+	content, err := r.ExtractFile("lipsum.txt", "/repos/wpress/testdata")
+	if err != nil {
+		t.Errorf("Unable to extract file: %s", err)
+	}
+	if len(content) != 1478 {
+		t.Errorf("Extracted file is %d bytes long instead of 1478", len(content))
+	}
+
+	// random access is not available
+	_, err = r.Open("repos/wpress/testdata/lipsum.txt")
+	if err != ErrNotSeekable {
+		t.Errorf("Expected ErrNotSeekable, got %v", err)
+	}
+}
+
+// TestNewReaderAt tests reading archive from a byte slice
+func TestNewReaderAt(t *testing.T) {
+	path := _getPathToTests(t)
+	data, err := ioutil.ReadFile(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Unable to read test archive: %s", err)
+	}
+
+	r := NewReaderAt(bytes.NewReader(data), int64(len(data)))
+
+	filesCount, err := r.GetFilesCount()
+	if err != nil || filesCount != 3 {
+		t.Errorf("The archive contains %d files instead of 3: %v", filesCount, err)
+	}
+
+	// random access works over the byte slice
+	rc, err := r.Open("repos/wpress/testdata/logo.svg")
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err)
+	}
+	content, err := ioutil.ReadAll(rc)
+	if err != nil || len(content) != 2313 {
+		t.Errorf("Read %d bytes instead of 2313: %v", len(content), err)
+	}
+}
+
+// TestReaderConcurrent tests reading files from one reader concurrently
+func TestReaderConcurrent(t *testing.T) {
+	path := _getPathToTests(t)
+	r, err := NewReader(path + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Unable to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+
+	sizes := map[string]int{"logo.svg": 2313, "lipsum.txt": 1478, "logo3.png": 6855}
+	errs := make(chan error, 10*len(sizes))
+	for i := 0; i < 10; i++ {
+		for name, size := range sizes {
+			go func(name string, size int) {
+				content, err := r.ExtractFile(name, "/repos/wpress/testdata")
+				if err == nil && len(content) != size {
+					err = fmt.Errorf("read %d bytes of %s instead of %d", len(content), name, size)
+				}
+				errs <- err
+			}(name, size)
+		}
+	}
+
+	for i := 0; i < 10*len(sizes); i++ {
+		err := <-errs
+		if err != nil {
+			t.Errorf("Concurrent read failed: %s", err)
+		}
+	}
+}
+
+// TestExtract tests extracting all files from archive
+func TestExtract(t *testing.T) {
+	path := _getPathToTests(t)
+	// create a new reader instace with the test archive
+	r, err := NewReader(path + string(os.PathSeparator) + "test_archive.wpress")
+	if err != nil {
+		t.Errorf("Failed to create a new Reader instance: %s", err)
+	}
+
 	// extract files
 	filesCount, err := r.Extract()
 
@@ -106,11 +360,6 @@ func TestExtractFile(t *testing.T) {
 
 }
 
-// TestExtract tests extracting all files from archive
-func TestExtract(t *testing.T) {
-	// TODO: add test
-}
-
 // TestGetFilesCount tests enumerating the files in archive
 func TestGetFilesCount(t *testing.T) {
 	path := _getPathToTests(t)
@@ -132,3 +381,183 @@ func TestGetFilesCount(t *testing.T) {
 			filesCount)
 	}
 }
+
+// TestLenient tests skipping headers with corrupt size
+func TestLenient(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+	filename := _newArchive(t, dir, "wp-content/empty.txt", "", "wp-content/hello.txt", "hello")
+
+	// damage size of the first file
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Unable to read archive: %s", err)
+	}
+	copy(content[filenameSize:], "12x\x00")
+	reader := NewReaderAt(bytes.NewReader(content), int64(len(content)))
+
+	_, err = reader.ListEntries()
+	if err == nil {
+		t.Errorf("Expected corrupt header to fail listing")
+	}
+
+	reader.Lenient = true
+	entries, err := reader.ListEntries()
+	if err != nil {
+		t.Fatalf("Unable to list entries: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Path() != "wp-content/hello.txt" {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+	if len(reader.Warnings) != 1 || !errors.Is(reader.Warnings[0], ErrCorruptHeader) {
+		t.Errorf("Unexpected warnings %v", reader.Warnings)
+	}
+}
+
+// TestTruncated tests reading archives cut short
+func TestTruncated(t *testing.T) {
+	data, err := ioutil.ReadFile(_getPathToTests(t) + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Unable to read test archive: %s", err)
+	}
+
+	// archive without the EOF block still lists all files
+	data = data[0 : len(data)-headerSize]
+	reader := NewReaderAt(bytes.NewReader(data), int64(len(data)))
+	entries, err := reader.ListEntries()
+	if err != ErrTruncated || len(entries) != 3 {
+		t.Errorf("Listed %d entries instead of 3: %v", len(entries), err)
+	}
+
+	// archive cut in the middle of the third file
+	data = data[0 : len(data)-1000]
+	reader = NewReaderAt(bytes.NewReader(data), int64(len(data)))
+	list, err := reader.List()
+	if err != ErrTruncated || len(list) != 3 {
+		t.Errorf("Listed %d files instead of 3: %v", len(list), err)
+	}
+	_, err = reader.ExtractFileTo("repos/wpress/testdata/logo3.png", ioutil.Discard)
+	if err != ErrTruncated {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+
+	// archive cut in the middle of a header
+	data = data[0 : headerSize+2313+100]
+	reader = NewReaderAt(bytes.NewReader(data), int64(len(data)))
+	filesCount, err := reader.GetFilesCount()
+	if err != ErrTruncated || filesCount != 1 {
+		t.Errorf("Counted %d files instead of 1: %v", filesCount, err)
+	}
+}
+
+// TestSalvage tests recovering files after a corrupted region
+func TestSalvage(t *testing.T) {
+	data, err := ioutil.ReadFile(_getPathToTests(t) + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Unable to read test archive: %s", err)
+	}
+
+	// damage size of the second file
+	copy(data[headerSize+2313+filenameSize:], "x")
+
+	expected := []string{
+		"repos/wpress/testdata/logo.svg",
+		"repos/wpress/testdata/logo3.png",
+	}
+	readers := []*Reader{
+		NewReaderAt(bytes.NewReader(data), int64(len(data))),
+		// hide Seek, so the archive is read sequentially
+		NewReaderFrom(struct{ io.Reader }{bytes.NewReader(data)}),
+	}
+	for _, reader := range readers {
+		reader.Salvage = true
+		entries, err := reader.ListEntries()
+		if err != nil {
+			t.Fatalf("Unable to list entries: %s", err)
+		}
+		var paths []string
+		for _, e := range entries {
+			paths = append(paths, e.Path())
+		}
+		if !reflect.DeepEqual(paths, expected) {
+			t.Errorf("Recovered %v instead of %v", paths, expected)
+		}
+		if len(reader.Warnings) != 1 || !errors.Is(reader.Warnings[0], ErrCorruptHeader) {
+			t.Errorf("Unexpected warnings %v", reader.Warnings)
+		}
+	}
+
+	// recovered file is read from the right position
+	content, err := readers[0].ExtractFile("logo3.png", "/repos/wpress/testdata")
+	if err != nil || len(content) != 6855 {
+		t.Errorf("Extracted %d bytes instead of 6855: %v", len(content), err)
+	}
+}
+
+// TestNewVolumeReader tests reading archive split into volumes
+func TestNewVolumeReader(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+
+	// split the test archive into volumes of 4000 bytes
+	data, err := ioutil.ReadFile(_getPathToTests(t) + string(os.PathSeparator) + TestArchiveName)
+	if err != nil {
+		t.Fatalf("Unable to read test archive: %s", err)
+	}
+	filename := dir + string(os.PathSeparator) + "site.wpress"
+	var names []string
+	for n := 1; len(data) > 0; n++ {
+		size := 4000
+		if size > len(data) {
+			size = len(data)
+		}
+		names = append(names, VolumeName(filename, n))
+		ioutil.WriteFile(VolumeName(filename, n), data[0:size], 0644)
+		data = data[size:]
+	}
+
+	r, err := NewVolumeReaderGlob(filename + ".*")
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	entries, err := r.ListEntries()
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("Listed %d entries instead of 3: %v", len(entries), err)
+	}
+
+	// file spanning several volumes
+	expected, _ := ioutil.ReadFile(_getPathToTests(t) + string(os.PathSeparator) + "logo3.png")
+	content, err := r.ExtractFile("logo3.png", "/repos/wpress/testdata")
+	if err != nil || !bytes.Equal(content, expected) {
+		t.Errorf("Extracted content differs from the original: %v", err)
+	}
+
+	// missing volume
+	_, err = NewVolumeReader(append(names, VolumeName(filename, 99)))
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected missing volume to fail, got %v", err)
+	}
+}
+
+// TestCursorAt tests offsets reported by cursor starting in the middle of
+// archive
+func TestCursorAt(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join(_getPathToTests(t), TestArchiveName))
+	if err != nil {
+		t.Fatalf("Unable to read test archive: %s", err)
+	}
+	r := NewReaderAt(bytes.NewReader(data), int64(len(data)))
+	entries, err := r.ListEntries()
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("Listed %d entries instead of 3: %v", len(entries), err)
+	}
+
+	c := newCursorAt(bytes.NewReader(data), entries[0].Offset+entries[0].Size, nil)
+	for _, e := range entries[1:] {
+		h, err := c.next()
+		if err != nil || h.GetPath() != e.Path() || c.offset != e.Offset {
+			t.Errorf("Cursor at %d instead of %d for %s: %v", c.offset, e.Offset, e.Path(), err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"io"
+	"os"
+)
+
+// Repair makes an archive interrupted during creation readable again. It
+// keeps files up to the last complete one, drops whatever follows them and
+// appends the EOF block. The archive is repaired in place when dest is
+// empty, otherwise the repaired copy is written to dest. Archive ending in
+// the EOF block is valid, it is left unchanged or copied as it is with the
+// table of contents or signature following the block. Number of files kept
+// is returned.
+func Repair(filename string, dest string) (int, error) {
+	// try to open the file
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// find the end of the last complete file
+	filesCount, end, complete, err := lastCompleteEntry(file, fi.Size())
+	if err != nil {
+		return 0, err
+	}
+
+	// nothing to do for a valid archive, trailers are kept
+	if complete {
+		if dest == "" {
+			return filesCount, nil
+		}
+		return filesCount, repairCopy(file, dest, fi.Size(), nil)
+	}
+
+	if dest == "" {
+		return filesCount, repairInPlace(filename, end)
+	}
+	return filesCount, repairCopy(file, dest, end, eofBlock)
+}
+
+// lastCompleteEntry returns the number of complete files in archive of size
+// bytes and the position right after the last of them, complete reports
+// whether they are followed by the EOF block
+func lastCompleteEntry(ra io.ReaderAt, size int64) (int, int64, bool, error) {
+	c := newCursor(ra, nil)
+	filesCount, end := 0, int64(0)
+
+	for {
+		_, err := c.next()
+		if err == io.EOF {
+			return filesCount, end, true, nil
+		}
+		if err == ErrTruncated || (err != nil && filesCount > 0) {
+			// garbage or a partial header follows the last file
+			return filesCount, end, false, nil
+		}
+		if err != nil {
+			return 0, 0, false, err
+		}
+
+		// content of the file was cut short
+		if c.offset+c.remaining > size {
+			return filesCount, end, false, nil
+		}
+
+		filesCount++
+		end = c.offset + c.remaining
+	}
+}
+
+// repairInPlace truncates filename to end bytes and appends the EOF block
+func repairInPlace(filename string, end int64) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	err = file.Truncate(end)
+	if err == nil {
+		_, err = file.WriteAt(eofBlock, end)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// repairCopy writes first end bytes of src followed by trailer to dest
+func repairCopy(src io.ReaderAt, dest string, end int64, trailer []byte) error {
+	// try to create the file
+	file, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+
+	buf := getBuffer(defaultBufferSize)
+	defer putBuffer(buf)
+	_, err = io.CopyBuffer(file, io.NewSectionReader(src, 0, end), buf)
+	if err == nil {
+		_, err = file.Write(trailer)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(dest)
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,128 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRepair tests appending EOF block to interrupted archives
+func TestRepair(t *testing.T) {
+	dir := _newTempDir(t)
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadFile(filepath.Join(_getPathToTests(t), TestArchiveName))
+	if err != nil {
+		t.Fatalf("Unable to read test archive: %s", err)
+	}
+
+	// valid archive is left untouched
+	valid := filepath.Join(dir, "valid.wpress")
+	err = ioutil.WriteFile(valid, data, 0644)
+	if err != nil {
+		t.Fatalf("Unable to write archive: %s", err)
+	}
+	filesCount, err := Repair(valid, "")
+	if err != nil || filesCount != 3 {
+		t.Errorf("Kept %d files instead of 3: %v", filesCount, err)
+	}
+	fi, err := os.Stat(valid)
+	if err != nil || fi.Size() != int64(len(data)) {
+		t.Errorf("Valid archive was modified")
+	}
+
+	// table of contents and signature following the EOF block are kept
+	trailers := filepath.Join(dir, "trailers.wpress")
+	w, err := NewWriter(trailers)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	publicKey, key, _ := ed25519.GenerateKey(nil)
+	w.TOC = true
+	w.SigningKey = key
+	err = w.AddDirectoryWithOptions(_getPathToTests(t), AddOptions{})
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		t.Fatalf("Failed to write archive: %s", err)
+	}
+	expected, _ := ioutil.ReadFile(trailers)
+	for _, dest := range []string{"", filepath.Join(dir, "copy.wpress")} {
+		_, err = Repair(trailers, dest)
+		if err != nil {
+			t.Errorf("Failed to repair valid archive: %s", err)
+		}
+		if dest == "" {
+			dest = trailers
+		}
+		content, _ := ioutil.ReadFile(dest)
+		if !bytes.Equal(content, expected) {
+			t.Errorf("Valid archive %s was modified", dest)
+		}
+		err = VerifySignature(dest, publicKey, nil)
+		if err != nil {
+			t.Errorf("Failed to verify signature of %s: %s", dest, err)
+		}
+	}
+
+	// archive interrupted in the middle of the third file
+	broken := filepath.Join(dir, "broken.wpress")
+	err = ioutil.WriteFile(broken, data[0:3*headerSize+2313+1478+100], 0644)
+	if err != nil {
+		t.Fatalf("Unable to write archive: %s", err)
+	}
+
+	// repair to a new file
+	repaired := filepath.Join(dir, "repaired.wpress")
+	filesCount, err = Repair(broken, repaired)
+	if err != nil || filesCount != 2 {
+		t.Errorf("Kept %d files instead of 2: %v", filesCount, err)
+	}
+	fi, err = os.Stat(repaired)
+	if err != nil || fi.Size() != 3*headerSize+2313+1478 {
+		t.Errorf("Unexpected size of repaired archive: %v", err)
+	}
+
+	// repair in place
+	filesCount, err = Repair(broken, "")
+	if err != nil || filesCount != 2 {
+		t.Errorf("Kept %d files instead of 2: %v", filesCount, err)
+	}
+	r, err := NewReader(broken)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	entries, err := r.ListEntries()
+	if err != nil || len(entries) != 2 {
+		t.Errorf("Listed %d entries instead of 2: %v", len(entries), err)
+	}
+}
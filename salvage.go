@@ -0,0 +1,150 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"time"
+)
+
+// resyncChunkSize is the number of bytes read at once while searching for a
+// header
+const resyncChunkSize = 64 << 10
+
+// resync searches the archive for the next block that looks like a valid
+// header, starting one byte after the corrupt block, and returns it with the
+// pointer right after it. io.EOF is returned when archive ends with the EOF
+// block, ErrTruncated when it ends without it.
+func (c *cursor) resync(block []byte) ([]byte, error) {
+	// absolute position of the first byte in buf
+	start := c.offset - int64(len(block)) + 1
+	buf := append([]byte{}, block[1:]...)
+	chunk := make([]byte, resyncChunkSize)
+	eof := false
+	layout := c.layout.get()
+	headerSize := layout.HeaderSize()
+
+	for {
+		// test every position which has a whole block available
+		for i := 0; i+headerSize <= len(buf); i++ {
+			if !plausibleHeader(layout.populate(buf[i : i+headerSize])) {
+				continue
+			}
+
+			// put back the bytes read after the header
+			err := c.unread(buf[i+headerSize:])
+			if err != nil {
+				return nil, err
+			}
+			c.offset = start + int64(i+headerSize)
+			return append([]byte{}, buf[i:i+headerSize]...), nil
+		}
+
+		if eof {
+			// archive ends with the EOF block
+			if len(buf) >= headerSize && bytes.Equal(buf[len(buf)-headerSize:], layout.eofBlock()) {
+				return nil, io.EOF
+			}
+			return nil, ErrTruncated
+		}
+
+		// keep only the bytes which may begin a header
+		if len(buf) >= headerSize {
+			drop := len(buf) - headerSize + 1
+			start += int64(drop)
+			buf = append(buf[:0], buf[drop:]...)
+		}
+
+		// read the next chunk
+		n, err := io.ReadFull(c.source, chunk)
+		c.offset += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			eof = true
+		} else if err != nil {
+			return nil, err
+		}
+		buf = append(buf, chunk[0:n]...)
+	}
+}
+
+// unread moves the pointer back by the length of b, bytes of sequential
+// archives are put in front of the source
+func (c *cursor) unread(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if seeker, ok := c.source.(io.Seeker); ok {
+		_, err := seeker.Seek(-int64(len(b)), io.SeekCurrent)
+		return err
+	}
+	c.source = io.MultiReader(bytes.NewReader(append([]byte{}, b...)), c.source)
+	return nil
+}
+
+// plausibleHeader reports whether h looks like a valid header: name is
+// present, size is a number, mtime is a date no later than a year from now
+// and all fields are padded by zero bytes
+func plausibleHeader(h *Header) bool {
+	if !(numericField(h.Size) || binarySizeField(h.Size)) || !numericField(h.Mtime) {
+		return false
+	}
+	mtime, err := h.GetMtime()
+	if err != nil || mtime > time.Now().AddDate(1, 0, 0).Unix() {
+		return false
+	}
+
+	if !textField(h.Name) || !textField(h.Prefix) || bytes.IndexByte(h.Name, '/') != -1 {
+		return false
+	}
+	return h.ValidateName() == nil
+}
+
+// numericField reports whether field holds decimal digits padded by zero
+// bytes
+func numericField(field []byte) bool {
+	digits := bytes.TrimRight(field, "\x00")
+	if len(digits) == 0 {
+		return false
+	}
+	_, err := strconv.ParseUint(string(digits), 10, 64)
+	return err == nil
+}
+
+// binarySizeField reports whether field holds a valid size stored in binary
+func binarySizeField(field []byte) bool {
+	if len(field) != contentSize {
+		return false
+	}
+	_, err := parseBinarySize(field)
+	return err == nil
+}
+
+// textField reports whether field holds text padded by zero bytes, without
+// zero bytes inside the text
+func textField(field []byte) bool {
+	return bytes.IndexByte(bytes.TrimRight(field, "\x00"), 0) == -1
+}
@@ -0,0 +1,422 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Replacement replaces Old by New, see SearchReplace
+type Replacement struct {
+	Old string
+	New string
+}
+
+// SearchReplace returns transform of SQL dumps replacing strings in values
+// of the statements, e.g. URL of the site when moving it to another domain.
+// Values holding PHP serialized data have lengths of the replaced strings
+// fixed, so WordPress can still read them, serialized data nested in
+// serialized strings included. Strings escaped as in JSON, "http:\/\/", are
+// replaced too. Names of tables and columns and comments are left alone.
+func SearchReplace(replacements ...Replacement) TransformFunc {
+	var pairs []string
+	for _, r := range replacements {
+		if r.Old == "" {
+			continue
+		}
+		pairs = append(pairs, r.Old, r.New)
+
+		// slashes are escaped in JSON encoded values
+		escaped := strings.Replace(r.Old, "/", `\/`, -1)
+		if escaped != r.Old {
+			pairs = append(pairs, escaped, strings.Replace(r.New, "/", `\/`, -1))
+		}
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	return func(name string, content io.Reader) (io.Reader, error) {
+		replace := func(statement []byte) []byte {
+			return replaceLiterals(statement, func(value string) string {
+				return replaceValue(replacer, value)
+			})
+		}
+		return &statementReader{src: bufio.NewReader(content), replace: replace}, nil
+	}
+}
+
+// statementReader reads SQL dump from src passing it statement by statement
+// through replace
+type statementReader struct {
+	src     *bufio.Reader
+	replace func(statement []byte) []byte
+	// replaced statements not read yet
+	pending []byte
+	err     error
+}
+
+// Read reads the dump with the statements replaced
+func (s *statementReader) Read(b []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		var statement []byte
+		statement, s.err = s.readStatement()
+		s.pending = s.replace(statement)
+	}
+
+	n := copy(b, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// readStatement reads lines of the dump until none of its string literals
+// is left open, so values spanning lines are replaced whole
+func (s *statementReader) readStatement() ([]byte, error) {
+	var statement []byte
+	open := false
+	for {
+		line, err := s.src.ReadBytes('\n')
+		statement = append(statement, line...)
+		if err != nil {
+			return statement, err
+		}
+		if !open && isSQLComment(line) {
+			return statement, nil
+		}
+		open = scanLiterals(line, open)
+		if !open {
+			return statement, nil
+		}
+	}
+}
+
+// isSQLComment reports whether line of the dump is a comment
+func isSQLComment(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("--")) || bytes.HasPrefix(line, []byte("#"))
+}
+
+// scanLiterals returns whether a string literal is open at the end of line
+// when it was open at its beginning
+func scanLiterals(line []byte, open bool) bool {
+	for i := 0; i < len(line); i++ {
+		switch {
+		case !open && line[i] == '\'':
+			open = true
+		case open && line[i] == '\\':
+			i++
+		case open && line[i] == '\'':
+			// quotes are escaped by doubling them too
+			if i+1 < len(line) && line[i+1] == '\'' {
+				i++
+			} else {
+				open = false
+			}
+		}
+	}
+	return open
+}
+
+// replaceLiterals returns statement with values of its string literals
+// passed through replace, literals which don't change are kept byte for byte
+func replaceLiterals(statement []byte, replace func(string) string) []byte {
+	if isSQLComment(statement) {
+		return statement
+	}
+
+	var out []byte
+	copied := 0
+	for i := 0; i < len(statement); i++ {
+		if statement[i] != '\'' {
+			continue
+		}
+
+		end := literalEnd(statement, i)
+		if end == -1 {
+			// literal left open by the end of the dump
+			break
+		}
+
+		value := unescapeSQL(statement[i+1 : end])
+		replaced := replace(value)
+		if replaced != value {
+			out = append(out, statement[copied:i+1]...)
+			out = append(out, escapeSQL(replaced)...)
+			copied = end
+		}
+		i = end
+	}
+
+	if out == nil {
+		return statement
+	}
+	return append(out, statement[copied:]...)
+}
+
+// literalEnd returns position of the quote closing string literal opened at
+// start of statement, -1 is returned when it isn't closed
+func literalEnd(statement []byte, start int) int {
+	for end := start + 1; end < len(statement); end++ {
+		if statement[end] == '\\' {
+			end++
+		} else if statement[end] == '\'' {
+			if end+1 < len(statement) && statement[end+1] == '\'' {
+				end++
+			} else {
+				return end
+			}
+		}
+	}
+	return -1
+}
+
+// replaceValue returns value with strings replaced, lengths of strings of
+// serialized values are fixed
+func replaceValue(replacer *strings.Replacer, value string) string {
+	p := &serializedParser{data: value, replace: func(s string) string {
+		return replaceValue(replacer, s)
+	}}
+	if p.value() && p.pos == len(p.data) {
+		return p.out.String()
+	}
+	return replacer.Replace(value)
+}
+
+// unescapeSQL returns value of string literal of SQL dump without quotes
+func unescapeSQL(literal []byte) string {
+	if bytes.IndexByte(literal, '\\') == -1 && bytes.IndexByte(literal, '\'') == -1 {
+		return string(literal)
+	}
+
+	value := make([]byte, 0, len(literal))
+	for i := 0; i < len(literal); i++ {
+		c := literal[i]
+		if c == '\'' && i+1 < len(literal) && literal[i+1] == '\'' {
+			i++
+		} else if c == '\\' && i+1 < len(literal) {
+			i++
+			c = literal[i]
+			switch c {
+			case '0':
+				c = 0
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'Z':
+				c = 0x1a
+			case '%', '_':
+				// kept escaped for LIKE patterns
+				value = append(value, '\\')
+			}
+		}
+		value = append(value, c)
+	}
+	return string(value)
+}
+
+// escapeSQL returns value escaped for string literal of SQL dump the way
+// mysqli_real_escape_string does
+func escapeSQL(value string) []byte {
+	literal := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case 0:
+			literal = append(literal, '\\', '0')
+		case '\n':
+			literal = append(literal, '\\', 'n')
+		case '\r':
+			literal = append(literal, '\\', 'r')
+		case 0x1a:
+			literal = append(literal, '\\', 'Z')
+		case '\\', '\'', '"':
+			literal = append(literal, '\\', c)
+		default:
+			literal = append(literal, c)
+		}
+	}
+	return literal
+}
+
+// serializedParser copies PHP serialized value data to out passing its
+// strings through replace and writing their new lengths
+type serializedParser struct {
+	data    string
+	pos     int
+	out     strings.Builder
+	replace func(string) string
+}
+
+// value copies the value at the current position, false is returned when
+// it isn't a valid serialized value
+func (p *serializedParser) value() bool {
+	if len(p.data)-p.pos < 2 {
+		return false
+	}
+	kind := p.data[p.pos]
+	if kind == 'N' {
+		return p.copy(2) == "N;"
+	}
+	if p.data[p.pos+1] != ':' {
+		return false
+	}
+
+	switch kind {
+	case 'b', 'i', 'd', 'r', 'R':
+		end := strings.IndexByte(p.data[p.pos:], ';')
+		if end == -1 {
+			return false
+		}
+		p.copy(end + 1)
+		return true
+	case 's':
+		p.pos += 2
+		content, ok := p.quoted()
+		if !ok || !p.expect(";") {
+			return false
+		}
+		replaced := p.replace(content)
+		p.out.WriteString("s:" + strconv.Itoa(len(replaced)) + `:"` + replaced + `";`)
+		return true
+	case 'E':
+		// enum cases keep their names
+		start := p.pos
+		p.pos += 2
+		_, ok := p.quoted()
+		if !ok || !p.expect(";") {
+			return false
+		}
+		p.out.WriteString(p.data[start:p.pos])
+		return true
+	case 'a':
+		p.copy(2)
+		return p.members()
+	case 'O':
+		// class names are not replaced
+		start := p.pos
+		p.pos += 2
+		_, ok := p.quoted()
+		if !ok || !p.expect(":") {
+			return false
+		}
+		p.out.WriteString(p.data[start:p.pos])
+		return p.members()
+	case 'C':
+		// custom serialization can't be parsed, it is kept as it is
+		start := p.pos
+		p.pos += 2
+		_, ok := p.quoted()
+		if !ok || !p.expect(":") {
+			return false
+		}
+		n, ok := p.number(':')
+		if !ok || !p.expect("{") || len(p.data)-p.pos < n+1 {
+			return false
+		}
+		p.pos += n
+		if !p.expect("}") {
+			return false
+		}
+		p.out.WriteString(p.data[start:p.pos])
+		return true
+	}
+	return false
+}
+
+// members copies count of members and the keys and values of array or
+// object, "N:{key;value;...}"
+func (p *serializedParser) members() bool {
+	start := p.pos
+	n, ok := p.number(':')
+	if !ok || !p.expect("{") {
+		return false
+	}
+	p.out.WriteString(p.data[start:p.pos])
+	for i := 0; i < 2*n; i++ {
+		if !p.value() {
+			return false
+		}
+	}
+	if !p.expect("}") {
+		return false
+	}
+	p.out.WriteByte('}')
+	return true
+}
+
+// quoted consumes string of known length, `N:"content"`, and returns its
+// content
+func (p *serializedParser) quoted() (string, bool) {
+	n, ok := p.number(':')
+	if !ok || !p.expect(`"`) || len(p.data)-p.pos < n {
+		return "", false
+	}
+	content := p.data[p.pos : p.pos+n]
+	p.pos += n
+	return content, p.expect(`"`)
+}
+
+// number consumes decimal number followed by terminator
+func (p *serializedParser) number(terminator byte) (int, bool) {
+	end := strings.IndexByte(p.data[p.pos:], terminator)
+	if end <= 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(p.data[p.pos : p.pos+end])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	p.pos += end + 1
+	return n, true
+}
+
+// expect consumes s when data continues with it
+func (p *serializedParser) expect(s string) bool {
+	if !strings.HasPrefix(p.data[p.pos:], s) {
+		return false
+	}
+	p.pos += len(s)
+	return true
+}
+
+// copy copies the next n bytes of data to out and returns them
+func (p *serializedParser) copy(n int) string {
+	if n > len(p.data)-p.pos {
+		n = len(p.data) - p.pos
+	}
+	s := p.data[p.pos : p.pos+n]
+	p.pos += n
+	p.out.WriteString(s)
+	return s
+}
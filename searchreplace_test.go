@@ -0,0 +1,164 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSearchReplace tests replacing strings in SQL dump
+func TestSearchReplace(t *testing.T) {
+	tests := []struct {
+		dump     string
+		expected string
+	}{
+		{
+			"INSERT INTO `wp_options` VALUES (1,'siteurl','http://old.test','yes');\n",
+			"INSERT INTO `wp_options` VALUES (1,'siteurl','https://new.example','yes');\n",
+		},
+		// lengths of serialized strings are fixed
+		{
+			`INSERT INTO wp_options VALUES (2,'widget','a:2:{s:3:\"url\";s:15:\"http://old.test\";i:0;b:1;}','yes');` + "\n",
+			`INSERT INTO wp_options VALUES (2,'widget','a:2:{s:3:\"url\";s:19:\"https://new.example\";i:0;b:1;}','yes');` + "\n",
+		},
+		// serialized data in serialized strings
+		{
+			`('s:29:\"a:1:{i:0;s:11:\"old.test/me\";}\";')` + "\n",
+			`('s:32:\"a:1:{i:0;s:14:\"new.example/me\";}\";')` + "\n",
+		},
+		// objects keep their class names
+		{
+			`('O:8:\"old.test\":1:{s:4:\"home\";s:8:\"old.test\";}')` + "\n",
+			`('O:8:\"old.test\":1:{s:4:\"home\";s:11:\"new.example\";}')` + "\n",
+		},
+		// invalid serialized data is replaced as plain text
+		{
+			`('s:99:\"old.test\";')` + "\n",
+			`('s:99:\"new.example\";')` + "\n",
+		},
+		// JSON escaped values
+		{
+			`('{\"url\":\"http:\\/\\/old.test\\/\"}')` + "\n",
+			`('{\"url\":\"https:\\/\\/new.example\\/\"}')` + "\n",
+		},
+		// values spanning lines and quotes escaped by doubling them, changed
+		// values are escaped again
+		{
+			"('it''s\nhttp://old.test\n');\n",
+			"('it\\'s\\nhttps://new.example\\n');\n",
+		},
+		// names and comments are left alone, unchanged values byte for byte
+		{
+			"-- old.test dump\nCREATE TABLE `old.test` (a int);\n('it''s', 'old')\n",
+			"-- old.test dump\nCREATE TABLE `old.test` (a int);\n('it''s', 'old')\n",
+		},
+	}
+	transform := SearchReplace(
+		Replacement{Old: "http://old.test", New: "https://new.example"},
+		Replacement{Old: "old.test", New: "new.example"},
+		Replacement{},
+	)
+	for _, test := range tests {
+		content, err := transform("database.sql", strings.NewReader(test.dump))
+		var replaced []byte
+		if err == nil {
+			replaced, err = ioutil.ReadAll(content)
+		}
+		if err != nil || string(replaced) != test.expected {
+			t.Errorf("Replaced %q to %q instead of %q: %v", test.dump, replaced, test.expected, err)
+		}
+	}
+}
+
+// TestExtractSearchReplace tests transforming database dump during
+// extraction and repacking
+func TestExtractSearchReplace(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	dump := `INSERT INTO wp_options VALUES (2,'widget','a:1:{i:0;s:15:\"http://old.test\";}','yes');` + "\n"
+	expected := `INSERT INTO wp_options VALUES (2,'widget','a:1:{i:0;s:19:\"https://new.example\";}','yes');` + "\n"
+	filename := _newArchive(t, tempPath, "database.sql", dump, "index.php", "http://old.test")
+	transforms := []Transform{{
+		Pattern: "database.sql",
+		Func:    SearchReplace(Replacement{Old: "http://old.test", New: "https://new.example"}),
+	}}
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %s", err)
+	}
+	defer r.Close()
+
+	dest := filepath.Join(tempPath, "site")
+	n, err := r.ExtractWithOptions(ExtractOptions{DestDir: dest, Transforms: transforms})
+	if err != nil || n != 2 {
+		t.Fatalf("Extracted %d files instead of 2: %v", n, err)
+	}
+	for name, content := range map[string]string{"database.sql": expected, "index.php": "http://old.test"} {
+		extracted, _ := ioutil.ReadFile(filepath.Join(dest, name))
+		if string(extracted) != content {
+			t.Errorf("Extracted %q to %s instead of %q", extracted, name, content)
+		}
+	}
+
+	// sizes of transformed files in tar stream
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	_, err = r.ExtractToTar(tw, ExtractOptions{Transforms: transforms})
+	tw.Close()
+	if err != nil {
+		t.Fatalf("Failed to extract to tar: %s", err)
+	}
+	tr := tar.NewReader(&buf)
+	for {
+		th, err := tr.Next()
+		if err != nil {
+			break
+		}
+		content, _ := ioutil.ReadAll(tr)
+		if th.Name == "database.sql" && (string(content) != expected || th.Size != int64(len(expected))) {
+			t.Errorf("Unexpected %s of size %d in tar: %q", th.Name, th.Size, content)
+		}
+	}
+
+	repacked := filepath.Join(tempPath, "repacked.wpress")
+	_, err = RepackWithOptions(filename, repacked, RepackOptions{Transforms: transforms})
+	if err != nil {
+		t.Fatalf("Failed to repack archive: %s", err)
+	}
+	rr, _ := NewReader(repacked)
+	content, err := rr.ExtractFile("database.sql", ".")
+	rr.Close()
+	if err != nil || string(content) != expected {
+		t.Errorf("Repacked %q instead of %q: %v", content, expected, err)
+	}
+}
@@ -0,0 +1,159 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"io"
+	"os"
+)
+
+// ErrNotSigned is returned when verifying signature of archive without one
+var ErrNotSigned = errors.New("archive is not signed")
+
+// ErrSignatureMismatch is returned when signature doesn't match archive or
+// the public key, the archive was modified or signed by someone else
+var ErrSignatureMismatch = errors.New("signature doesn't match archive")
+
+// signatureMagic ends signed archives, it is preceded by the Ed25519
+// signature of all bytes before the signature. Readers not aware of it stop
+// at the EOF block.
+const signatureMagic = "WPRESSSIG\x01"
+
+// signatureTrailerSize is the length of the signature trailer
+const signatureTrailerSize = ed25519.SignatureSize + len(signatureMagic)
+
+// signatureOptions select Ed25519ph, archives are signed by their SHA-512
+// hash, so they are hashed once while written
+var signatureOptions = &ed25519.Options{Hash: crypto.SHA512, Context: "wpress archive"}
+
+// startSigning hashes everything written to the destination, it is called
+// before the destination is wrapped by encryption or compression, so the
+// signature covers the file as stored
+func (w *Writer) startSigning() {
+	if w.SigningKey == nil || w.digest != nil {
+		return
+	}
+	w.digest = sha512.New()
+	w.unsigned = w.dest
+	w.dest = io.MultiWriter(w.dest, w.digest)
+}
+
+// writeSignature appends the signature trailer
+func (w *Writer) writeSignature() error {
+	if w.digest == nil {
+		return nil
+	}
+	signature, err := w.SigningKey.Sign(nil, w.digest.Sum(nil), signatureOptions)
+	if err != nil {
+		return err
+	}
+	_, err = w.unsigned.Write(append(signature, signatureMagic...))
+	return err
+}
+
+// signedSize returns the length of archive in file of size bytes without
+// its signature trailer and the signature, which is nil for unsigned
+// archives
+func signedSize(file io.ReaderAt, size int64) (int64, []byte) {
+	if size < int64(signatureTrailerSize) {
+		return size, nil
+	}
+	trailer := make([]byte, signatureTrailerSize)
+	_, err := file.ReadAt(trailer, size-int64(signatureTrailerSize))
+	if err != nil || string(trailer[ed25519.SignatureSize:]) != signatureMagic {
+		return size, nil
+	}
+	return size - int64(signatureTrailerSize), trailer[:ed25519.SignatureSize]
+}
+
+// Sign returns detached signature of archive filename by key, it covers the
+// whole file, see VerifySignature
+func Sign(filename string, key ed25519.PrivateKey) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	digest := sha512.New()
+	err = hashSection(digest, file, -1)
+	if err != nil {
+		return nil, err
+	}
+	return key.Sign(nil, digest.Sum(nil), signatureOptions)
+}
+
+// VerifySignature checks archive filename was signed by the owner of
+// publicKey, e.g. before extracting a backup. Signature is the detached
+// signature returned by Sign, the signature written by Writer.SigningKey
+// is checked when it is nil.
+func VerifySignature(filename string, publicKey ed25519.PublicKey, signature []byte) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	if signature == nil {
+		size, signature = signedSize(file, size)
+		if signature == nil {
+			return &os.PathError{Op: "verify", Path: filename, Err: ErrNotSigned}
+		}
+	}
+
+	digest := sha512.New()
+	err = hashSection(digest, file, size)
+	if err != nil {
+		return err
+	}
+	err = ed25519.VerifyWithOptions(publicKey, digest.Sum(nil), signature, signatureOptions)
+	if err != nil {
+		return &os.PathError{Op: "verify", Path: filename, Err: ErrSignatureMismatch}
+	}
+	return nil
+}
+
+// hashSection writes the first size bytes of file to digest, the whole file
+// when size is negative
+func hashSection(digest hash.Hash, file *os.File, size int64) error {
+	var src io.Reader = file
+	if size >= 0 {
+		src = io.NewSectionReader(file, 0, size)
+	}
+	buf := getBuffer(defaultBufferSize)
+	defer putBuffer(buf)
+	_, err := io.CopyBuffer(digest, src, buf)
+	return err
+}
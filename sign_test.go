@@ -0,0 +1,106 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSignature tests signing archives and verifying their signatures
+func TestSignature(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	publicKey, key, _ := ed25519.GenerateKey(nil)
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	w.SigningKey = key
+	w.TOC = true
+	w.Encryption = &Encryption{Key: bytes.Repeat([]byte{7}, 32)}
+	err = w.AddDirectoryWithOptions(_getPathToTests(t), AddOptions{})
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("Failed to close archive: %s", err)
+	}
+
+	err = VerifySignature(filename, publicKey, nil)
+	if err != nil {
+		t.Errorf("Failed to verify signature: %s", err)
+	}
+	err = VerifySignature(filename, otherKey, nil)
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrSignatureMismatch {
+		t.Errorf("Expected ErrSignatureMismatch, got %v", err)
+	}
+
+	// signed archive is read like any other
+	r, err := NewEncryptedReader(filename, *w.Encryption)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	entries, err := r.ListEntries()
+	r.Close()
+	if err != nil || len(entries) != w.FilesAdded {
+		t.Errorf("Listed %d files instead of %d: %v", len(entries), w.FilesAdded, err)
+	}
+
+	// detached signature covers the whole file
+	signature, err := Sign(filename, key)
+	if err != nil {
+		t.Fatalf("Failed to sign archive: %s", err)
+	}
+	err = VerifySignature(filename, publicKey, signature)
+	if err != nil {
+		t.Errorf("Failed to verify detached signature: %s", err)
+	}
+
+	// any modification is detected
+	archive, _ := ioutil.ReadFile(filename)
+	archive[len(archive)/2] ^= 1
+	ioutil.WriteFile(filename, archive, 0644)
+	for _, signature := range [][]byte{nil, signature} {
+		err = VerifySignature(filename, publicKey, signature)
+		if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrSignatureMismatch {
+			t.Errorf("Expected ErrSignatureMismatch, got %v", err)
+		}
+	}
+
+	err = VerifySignature(_newArchive(t, tempPath, "a.txt", "a"), publicKey, nil)
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrNotSigned {
+		t.Errorf("Expected ErrNotSigned, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MultisiteFileName is the name of the file in the root of archives of
+// multisite networks exported by All-in-One WP Migration
+const MultisiteFileName = "multisite.json"
+
+// maxSummaryRead is the number of bytes of database dump and version.php
+// read by InspectArchive
+const maxSummaryRead = 1 << 20
+
+var (
+	// wpVersionPattern finds WordPress version in wp-includes/version.php
+	wpVersionPattern = regexp.MustCompile(`\$wp_version\s*=\s*['"]([^'"]+)['"]`)
+	// createTablePattern finds names of tables created by database dump
+	createTablePattern = regexp.MustCompile("^CREATE TABLE (?:IF NOT EXISTS )?`([^`]*)`")
+	// siteURLPattern and homeURLPattern find the options in database dump
+	siteURLPattern = regexp.MustCompile(`'siteurl',\s*'([^']*)'`)
+	homeURLPattern = regexp.MustCompile(`'home',\s*'([^']*)'`)
+)
+
+// placeholderPrefix replaces table prefix in database dumps exported by
+// All-in-One WP Migration
+const placeholderPrefix = "SERVMASK_PREFIX_"
+
+// SiteSummary describes the WordPress site stored in archive, see
+// InspectArchive. Values which couldn't be found are left empty.
+type SiteSummary struct {
+	WordPressVersion string
+	Multisite        bool
+	TablePrefix      string
+	SiteURL          string
+	HomeURL          string
+
+	// Package is the content of package.json, nil when there is none
+	Package *Package
+
+	Files        int   // number of files
+	Size         int64 // length of all contents once decompressed
+	StoredSize   int64 // length of all contents as stored
+	DatabaseSize int64 // length of database dump once decompressed
+}
+
+// InspectArchive reads archive in a single pass and returns the summary of
+// the stored site every migration needs up front, without extracting
+// anything. Values recorded in package.json are completed by those found
+// in wp-includes/version.php and in the beginning of database.sql, the dump
+// is read further only until its options and users tables confirm the
+// table prefix.
+func (r *Reader) InspectArchive() (*SiteSummary, error) {
+	c, err := r.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SiteSummary{}
+	var version, database []byte
+	var tables *dumpTables
+	for {
+		h, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := h.GetOriginalSize()
+		if err != nil {
+			return nil, err
+		}
+		stored, err := h.GetSize64()
+		if err != nil {
+			return nil, err
+		}
+		s.Files++
+		s.Size += size
+		s.StoredSize += stored
+
+		name := h.GetPath()
+		switch {
+		case name == PackageFileName:
+			if size > maxPackageSize {
+				return nil, &os.PathError{Op: "read", Path: name, Err: ErrFileTooLarge}
+			}
+			data, err := readHead(name, h.GetCompression(), size, c, size)
+			if err == nil {
+				s.Package, err = parsePackage(data)
+			}
+			if err != nil {
+				return nil, err
+			}
+		case name == MultisiteFileName:
+			s.Multisite = true
+		case name == DatabaseFileName:
+			s.DatabaseSize = size
+			database, tables, err = readDatabase(name, h.GetCompression(), size, c)
+		case path.Base(name) == "version.php" && path.Base(path.Dir(name)) == "wp-includes":
+			version, err = readHead(name, h.GetCompression(), size, c, maxSummaryRead)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.complete(version, database, tables)
+	return s, nil
+}
+
+// complete fills the summary from package.json, then from content of
+// version.php, the beginning of database dump and tables it creates
+func (s *SiteSummary) complete(version []byte, database []byte, tables *dumpTables) {
+	if p := s.Package; p != nil {
+		s.WordPressVersion, s.TablePrefix = p.WordPress.Version, p.Database.Prefix
+		s.SiteURL, s.HomeURL = p.SiteURL, p.HomeURL
+	}
+
+	if m := wpVersionPattern.FindSubmatch(version); m != nil && s.WordPressVersion == "" {
+		s.WordPressVersion = string(m[1])
+	}
+	if tables != nil && tables.prefix != "" {
+		if s.TablePrefix == "" && tables.prefix != placeholderPrefix {
+			s.TablePrefix = tables.prefix
+		}
+		// only multisite networks have the table of sites
+		if tables.names[tables.prefix+"blogs"] {
+			s.Multisite = true
+		}
+	}
+	if m := siteURLPattern.FindSubmatch(database); m != nil && s.SiteURL == "" {
+		s.SiteURL = string(m[1])
+	}
+	if m := homeURLPattern.FindSubmatch(database); m != nil && s.HomeURL == "" {
+		s.HomeURL = string(m[1])
+	}
+}
+
+// dumpTables are the tables created by database dump read so far
+type dumpTables struct {
+	names map[string]bool
+	// prefix of both options and users tables, empty until they are found
+	prefix string
+}
+
+// add records the table created by line of the dump and reports whether
+// the prefix is known
+func (d *dumpTables) add(line []byte) bool {
+	m := createTablePattern.FindSubmatch(line)
+	if m == nil || d.prefix != "" {
+		return d.prefix != ""
+	}
+	name := string(m[1])
+	d.names[name] = true
+
+	// plugin tables such as wp_foo_options have no users table
+	for _, suffix := range []string{"options", "users"} {
+		prefix := strings.TrimSuffix(name, suffix)
+		if prefix != name && d.names[prefix+"options"] && d.names[prefix+"users"] {
+			d.prefix = prefix
+		}
+	}
+	return d.prefix != ""
+}
+
+// readDatabase returns the beginning of database dump read from src and
+// the tables it creates, the dump is read further until the table prefix
+// is known
+func readDatabase(name string, compression Compression, size int64, src io.Reader) ([]byte, *dumpTables, error) {
+	content, err := openEntry(name, compression, size, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer content.Close()
+	head, err := ioutil.ReadAll(io.LimitReader(content, maxSummaryRead))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// complete lines of the beginning, the last one is read again
+	tables := &dumpTables{names: make(map[string]bool)}
+	last := bytes.LastIndexByte(head, '\n') + 1
+	for _, line := range bytes.Split(head[:last], []byte("\n")) {
+		if tables.add(line) {
+			return head, tables, nil
+		}
+	}
+
+	// long lines are inserts, only their beginnings are looked at
+	br := bufio.NewReader(io.MultiReader(bytes.NewReader(head[last:]), content))
+	lineStart := true
+	for {
+		line, err := br.ReadSlice('\n')
+		if lineStart && tables.add(line) {
+			return head, tables, nil
+		}
+		lineStart = err != bufio.ErrBufferFull
+		if err == io.EOF {
+			return head, tables, nil
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return nil, nil, err
+		}
+	}
+}
@@ -0,0 +1,95 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestInspectArchive tests summarizing site stored in archive
+func TestInspectArchive(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	// prefix is confirmed by the users table following long inserts, not
+	// by options table of plugin
+	dump := "-- MySQL dump\nCREATE TABLE `site_foo_options` (`id` int);\n" +
+		"CREATE TABLE `site_options` (`option_id` int);\n" +
+		"CREATE TABLE `site_myblogs` (`id` int);\n" +
+		"INSERT INTO `site_options` VALUES (1,'siteurl','https://example.com'),(2,'home','https://example.com/blog');\n" +
+		"INSERT INTO `site_posts` VALUES ('" + strings.Repeat("x", maxSummaryRead) + "');\n" +
+		"CREATE TABLE `site_users` (`ID` int);\n"
+	version := "<?php\n$wp_version = '6.4.2';\n$wp_db_version = 56657;\n"
+
+	tests := []struct {
+		name     string
+		files    []string
+		expected SiteSummary
+	}{
+		{
+			"site",
+			[]string{"public_html/wp-includes/version.php", version, "database.sql", dump},
+			SiteSummary{"6.4.2", false, "site_", "https://example.com", "https://example.com/blog", nil,
+				2, int64(len(version) + len(dump)), int64(len(version) + len(dump)), int64(len(dump))},
+		},
+		{
+			"export",
+			[]string{"package.json", `{"SiteURL":"https://example.org","WordPress":{"Version":"6.5"},"Database":{"Prefix":"wp_"}}`,
+				"multisite.json", "{}", "database.sql", "CREATE TABLE `SERVMASK_PREFIX_options` (`option_id` int);\n" +
+					"CREATE TABLE `SERVMASK_PREFIX_blogs` (`blog_id` int);\n" +
+					"CREATE TABLE `SERVMASK_PREFIX_users` (`ID` int);\n"},
+			SiteSummary{WordPressVersion: "6.5", Multisite: true, TablePrefix: "wp_", SiteURL: "https://example.org", Files: 3},
+		},
+		{
+			"empty",
+			nil,
+			SiteSummary{},
+		},
+	}
+	for _, test := range tests {
+		r, err := NewReader(_newArchive(t, tempPath, test.files...))
+		if err != nil {
+			t.Fatalf("Failed to open archive: %s", err)
+		}
+		s, err := r.InspectArchive()
+		r.Close()
+		if err != nil {
+			t.Errorf("%s: failed to inspect archive: %s", test.name, err)
+			continue
+		}
+		if (s.Package != nil) != (test.name == "export") {
+			t.Errorf("%s: unexpected package %+v", test.name, s.Package)
+		}
+		s.Package = nil
+		if test.name == "export" {
+			s.Size, s.StoredSize, s.DatabaseSize = 0, 0, 0
+		}
+		if *s != test.expected {
+			t.Errorf("%s: unexpected summary %+v", test.name, s)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ExtractToTar writes the files selected by opts to tw instead of the file
+// system, e.g. to pipe them to "docker cp" or to tar extracting them on a
+// remote host. Paths are rooted under DestDir and transformed by the
+// filter, strip, remap, sanitize, duplicate and collision options like on
+// extraction, limits apply too. Overwrite, SkipUnchanged, Delete, Workers
+// and RestoreOwner have no effect, nothing is written in DryRun. Files stored
+// once as hard links are written as tar hard links. Number of files written
+// is returned, tw is not closed.
+func (r *Reader) ExtractToTar(tw *tar.Writer, opts ExtractOptions) (int, error) {
+	return r.ExtractToTarContext(context.Background(), tw, opts)
+}
+
+// ExtractToTarContext is like ExtractToTar but aborts when ctx is done
+func (r *Reader) ExtractToTarContext(ctx context.Context, tw *tar.Writer, opts ExtractOptions) (int, error) {
+	e := &extraction{r: r, ctx: ctx, opts: opts, tw: tw}
+	return e.run()
+}
+
+// tarName returns name of pathToFile in tar stream, slash separated and
+// relative
+func tarName(pathToFile string) string {
+	return strings.TrimLeft(filepath.ToSlash(pathToFile), "/")
+}
+
+// executeTar writes the file of job to the tar stream reading its content
+// from src
+func (e *extraction) executeTar(ctx context.Context, job *extractJob, src io.Reader) error {
+	th := tarHeader(job.h, tarName(job.pathToFile))
+	if e.opts.IgnoreMtime {
+		th.ModTime = time.Now()
+	}
+
+	// symbolic links have no content
+	var content io.Reader
+	if th.Typeflag == tar.TypeReg {
+		entry, err := openEntry(job.h.GetPath(), job.h.GetCompression(), th.Size, src)
+		if err != nil {
+			return err
+		}
+		defer entry.Close()
+		content = entry
+
+		// size of transformed content is known once it is spooled
+		matching, err := matchingTransforms(e.opts.Transforms, job.h.GetPath())
+		if err != nil {
+			return err
+		}
+		if len(matching) > 0 {
+			transformed, err := applyTransforms(matching, job.h.GetPath(), entry, "extract")
+			if err != nil {
+				return err
+			}
+			spooled, size, cleanup, err := spool(transformed)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			content, th.Size = spooled, size
+		}
+	}
+
+	fp := e.progress.startFile(job.h.GetPath(), th.Size)
+	err := e.tw.WriteHeader(th)
+	if err != nil {
+		return err
+	}
+	if content != nil {
+		buf := getBuffer(e.bufferSize())
+		defer putBuffer(buf)
+		_, err = io.CopyBuffer(&progressWriter{ctx, e.tw, fp}, content, buf)
+		if err != nil {
+			return err
+		}
+	}
+	fp.end()
+
+	e.extractedCount++
+	if e.extracted != nil {
+		e.extracted(job.h.GetPath())
+	}
+
+	return nil
+}
+
+// executeTarLink writes link stored once as src to the tar stream as hard
+// link at pathToFile
+func (e *extraction) executeTarLink(link hardLink, src string, pathToFile string) error {
+	err := e.checkLimits(pathToFile, 0)
+	if err != nil {
+		return err
+	}
+
+	th := &tar.Header{
+		Typeflag: tar.TypeLink,
+		Name:     tarName(pathToFile),
+		Linkname: tarName(src),
+		ModTime:  time.Now(),
+	}
+	if link.Mtime != 0 && !e.opts.IgnoreMtime {
+		th.ModTime = time.Unix(link.Mtime, 0)
+	}
+	err = e.tw.WriteHeader(th)
+	if err != nil {
+		return err
+	}
+
+	e.extractedCount++
+	if e.extracted != nil {
+		e.extracted(link.Name)
+	}
+	return nil
+}
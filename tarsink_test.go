@@ -0,0 +1,126 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestExtractToTar tests writing extracted files to tar stream
+func TestExtractToTar(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	root := filepath.Join(tempPath, "site")
+	os.MkdirAll(filepath.Join(root, "wp-content", "uploads"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "wp-content", "a.txt"), []byte("same"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "wp-content", "uploads", "b.txt"), []byte("same"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "wp-config.php"), []byte("<?php"), 0644)
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	err = w.AddDirectoryWithOptions(root, AddOptions{DedupContent: true})
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	w.Close()
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %s", err)
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	n, err := r.ExtractToTar(tw, ExtractOptions{DestDir: "app", StripPrefix: "wp-content"})
+	if err != nil || n != 2 {
+		t.Fatalf("Extracted %d files instead of 2: %v", n, err)
+	}
+	tw.Close()
+
+	headers := make(map[string]*tar.Header)
+	tr := tar.NewReader(&buf)
+	for {
+		th, err := tr.Next()
+		if err != nil {
+			break
+		}
+		headers[th.Name] = th
+		if th.Typeflag == tar.TypeReg {
+			content, _ := ioutil.ReadAll(tr)
+			if string(content) != "same" {
+				t.Errorf("Entry %s has content %q", th.Name, content)
+			}
+		}
+	}
+	if len(headers) != 2 {
+		t.Errorf("Unexpected entries %v", headers)
+	}
+
+	// one of the files is written as link to the other
+	file, link := headers["app/a.txt"], headers["app/uploads/b.txt"]
+	if file == nil || link == nil {
+		t.Fatalf("Unexpected entries %v", headers)
+	}
+	if file.Typeflag == tar.TypeLink {
+		file, link = link, file
+	}
+	if file.Typeflag != tar.TypeReg || link.Typeflag != tar.TypeLink || link.Linkname != file.Name {
+		t.Errorf("Unexpected link %+v of %+v", link, file)
+	}
+
+	// nothing is written to the file system
+	if _, err := os.Stat("app"); !os.IsNotExist(err) {
+		t.Errorf("Destination directory was created: %v", err)
+	}
+}
+
+// TestExtractToTarLimits tests limits of extraction to tar stream
+func TestExtractToTarLimits(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := _newArchive(t, tempPath, "a.txt", "a", "b.txt", "b")
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %s", err)
+	}
+	defer r.Close()
+
+	tw := tar.NewWriter(ioutil.Discard)
+	n, err := r.ExtractToTar(tw, ExtractOptions{MaxFiles: 1})
+	if err != ErrTooManyFiles || n != 1 {
+		t.Errorf("Extracted %d files and returned %v instead of ErrTooManyFiles", n, err)
+	}
+}
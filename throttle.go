@@ -0,0 +1,115 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"context"
+	"io"
+	"math"
+	"time"
+)
+
+// tokenBucket limits the rate of writing, tokens accumulate at rate per
+// second up to burst and writing a byte takes one. Burst is a tenth of a
+// second worth of tokens, so writing proceeds in small steps instead of
+// pausing for long after writing a large block.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full token bucket allowing rate bytes per second
+func newTokenBucket(rate int64) *tokenBucket {
+	burst := math.Max(float64(rate)/10, 1)
+	return &tokenBucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take takes n tokens, waiting until they are available
+func (b *tokenBucket) take(ctx context.Context, n int) error {
+	// refill the tokens accumulated since the last take
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	// tokens taken in advance are repaid by waiting
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(-b.tokens / b.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledWriter writes to w at the rate allowed by bucket
+type throttledWriter struct {
+	ctx    context.Context
+	w      io.Writer
+	bucket *tokenBucket
+}
+
+// Write writes b to the underlying writer in steps of at most burst bytes
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		step := len(b)
+		if float64(step) > w.bucket.burst {
+			step = int(w.bucket.burst)
+		}
+
+		err := w.bucket.take(w.ctx, step)
+		if err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(b[:step])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[step:]
+	}
+	return written, nil
+}
+
+// output returns the writer archive is written to, throttled when RateLimit
+// is set
+func (w *Writer) output(ctx context.Context) io.Writer {
+	if w.RateLimit <= 0 {
+		return w.dest
+	}
+
+	// the bucket is kept between files, so the limit holds across them
+	if w.bucket == nil || w.bucket.rate != float64(w.RateLimit) {
+		w.bucket = newTokenBucket(w.RateLimit)
+	}
+	return &throttledWriter{ctx, w.dest, w.bucket}
+}
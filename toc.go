@@ -0,0 +1,130 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// tocMagic ends archives with a table of contents after the EOF block, it
+// is preceded by the length of the table as big-endian uint64. The table
+// uses the format of index files, the archive size recorded in it is the
+// offset the table starts at.
+const tocMagic = "WPRESSTOC\x01"
+
+// tocTrailerSize is the length of the trailer following the table
+const tocTrailerSize = 8 + len(tocMagic)
+
+// writeTOC appends the table of contents of the files written so far
+func (w *Writer) writeTOC() error {
+	var toc bytes.Buffer
+	err := writeIndex(bufio.NewWriter(&toc), w.size, time.Time{}, w.toc)
+	if err != nil {
+		return err
+	}
+
+	trailer := make([]byte, 8, tocTrailerSize)
+	binary.BigEndian.PutUint64(trailer, uint64(toc.Len()))
+	toc.Write(append(trailer, tocMagic...))
+
+	n, err := w.dest.Write(toc.Bytes())
+	w.size += int64(n)
+	return err
+}
+
+// readTOC indexes the archive by the table of contents at its end, archives
+// without a valid table or with an index loaded already are left alone
+func (r *Reader) readTOC() {
+	ra, ok := r.source.(io.ReaderAt)
+	if !ok {
+		return
+	}
+	headerSize := int64(r.Layout.get().HeaderSize())
+	size, _, err := r.archiveStat()
+	if err != nil || size < headerSize+int64(tocTrailerSize) {
+		return
+	}
+
+	// the trailer tells where the table starts
+	trailer := make([]byte, tocTrailerSize)
+	_, err = ra.ReadAt(trailer, size-int64(tocTrailerSize))
+	if err != nil || string(trailer[8:]) != tocMagic {
+		return
+	}
+	tocSize := binary.BigEndian.Uint64(trailer)
+	start := size - int64(tocTrailerSize) - int64(tocSize)
+	if tocSize > uint64(size) || start < headerSize {
+		return
+	}
+
+	// the table is preceded by the EOF block
+	eof := make([]byte, headerSize)
+	_, err = ra.ReadAt(eof, start-headerSize)
+	if err != nil || !bytes.Equal(eof, r.Layout.get().eofBlock()) {
+		return
+	}
+
+	section := io.NewSectionReader(ra, start, int64(tocSize))
+	tocStart, _, entries, err := readIndex(bufio.NewReader(section))
+	if err != nil || tocStart != start || !validEntries(entries, start, r.Layout.get()) {
+		return
+	}
+
+	// the table has to list the files scanning the archive finds
+	if !validTOC(ra, entries, r.Layout.get()) {
+		return
+	}
+
+	r.mu.Lock()
+	loaded := r.entries != nil
+	r.mu.Unlock()
+	if !loaded {
+		r.setIndex(entries)
+	}
+}
+
+// validTOC reports whether every entry of the table of contents is preceded
+// by header block of the same file, names are truncated in headers of files
+// with long names
+func validTOC(ra io.ReaderAt, entries []EntryInfo, layout Layout) bool {
+	block := make([]byte, layout.HeaderSize())
+	for _, e := range entries {
+		_, err := ra.ReadAt(block, e.Offset-int64(len(block)))
+		if err != nil {
+			return false
+		}
+		h := layout.populate(block)
+		size, err := h.GetSize64()
+		if err != nil || size != e.Size || h.GetName() != truncateName(e.Name, filenameSize) ||
+			h.GetPrefix() != truncateName(e.Prefix, prefixSize) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestTOC tests listing archive by its table of contents
+func TestTOC(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	w.TOC = true
+	err = w.AddDirectoryWithOptions(_getPathToTests(t), AddOptions{})
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	w.Close()
+
+	// archive is indexed by the first operation needing it
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instance: %s", err)
+	}
+	defer r.Close()
+	if entries, _ := r.indexed(); entries == nil || r.NumberOfFiles != w.FilesAdded {
+		t.Fatalf("Archive wasn't indexed by its table of contents")
+	}
+	content, err := r.ExtractFile("lipsum.txt", ".")
+	expected, _ := ioutil.ReadFile(filepath.Join(_getPathToTests(t), "lipsum.txt"))
+	if err != nil || !bytes.Equal(content, expected) {
+		t.Errorf("Unexpected content `%s`: %v", content, err)
+	}
+
+	// readers not aware of the table see the same files and stop at EOF
+	file, _ := os.Open(filename)
+	defer file.Close()
+	sequential := NewReaderFrom(struct{ io.Reader }{file})
+	var scanned []EntryInfo
+	for {
+		h, err := sequential.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unable to read sequentially: %s", err)
+		}
+		e, _ := newEntryInfo(h, sequential.cur.offset)
+		scanned = append(scanned, e)
+	}
+	listed, err := r.ListEntries()
+	if err != nil || !reflect.DeepEqual(listed, scanned) {
+		t.Errorf("Listed %v instead of %v: %v", listed, scanned, err)
+	}
+
+	// damaged table is ignored and archive is scanned
+	archive, _ := ioutil.ReadFile(filename)
+	archive[len(archive)-tocTrailerSize] ^= 0xff
+	damaged := NewReaderAt(bytes.NewReader(archive), int64(len(archive)))
+	if entries, _ := damaged.indexed(); entries != nil {
+		t.Errorf("Damaged table of contents was used")
+	}
+	listed, err = damaged.ListEntries()
+	if err != nil || !reflect.DeepEqual(listed, scanned) {
+		t.Errorf("Listed %v instead of %v: %v", listed, scanned, err)
+	}
+
+	// table listing other files than the archive holds is ignored
+	archive, _ = ioutil.ReadFile(filename)
+	var toc bytes.Buffer
+	start := int64(len(archive)) - int64(tocTrailerSize) - int64(binary.BigEndian.Uint64(archive[len(archive)-tocTrailerSize:]))
+	crafted := append([]EntryInfo{}, scanned...)
+	crafted[0].Name = "evil.php"
+	buf := bufio.NewWriter(&toc)
+	writeIndex(buf, start, time.Time{}, crafted)
+	trailer := make([]byte, 8, tocTrailerSize)
+	binary.BigEndian.PutUint64(trailer, uint64(toc.Len()))
+	toc.Write(append(trailer, tocMagic...))
+	forged := append(archive[:start:start], toc.Bytes()...)
+	r = NewReaderAt(bytes.NewReader(forged), int64(len(forged)))
+	if entries, _ := r.indexed(); entries != nil {
+		t.Errorf("Forged table of contents was used")
+	}
+	listed, err = r.ListEntries()
+	if err != nil || !reflect.DeepEqual(listed, scanned) {
+		t.Errorf("Listed %v instead of %v: %v", listed, scanned, err)
+	}
+}
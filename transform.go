@@ -0,0 +1,170 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// TransformFunc returns content to be written to archive in place of
+// content of the file name, e.g. wp-config.php with secrets scrubbed
+type TransformFunc func(name string, content io.Reader) (io.Reader, error)
+
+// Transform changes contents of the files matching Pattern on their way
+// into archive, the pattern uses the syntax of Filter
+type Transform struct {
+	Pattern string
+	Func    TransformFunc
+}
+
+// transform passes content of the file described by h through the
+// transforms matching its path. Transformed content is spooled to a
+// temporary file, so its size is known before the header is written. The
+// returned function removes the temporary file.
+func (w *Writer) transform(h *Header, content io.Reader) (*Header, io.Reader, func(), error) {
+	name := h.GetPath()
+	matching, err := matchingTransforms(w.Transforms, name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(matching) == 0 {
+		return h, content, func() {}, nil
+	}
+
+	size, err := h.GetSize64()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	transformed, err := applyTransforms(matching, name, io.LimitReader(content, size), "add")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	spooled, written, cleanup, err := spool(transformed)
+	if err != nil {
+		return nil, nil, nil, &os.PathError{Op: "add", Path: name, Err: err}
+	}
+
+	// record size of the transformed content, the rest stays the same
+	transformedHeader := *h
+	transformedHeader.Size, err = formatSize(written)
+	if err != nil {
+		cleanup()
+		return nil, nil, nil, err
+	}
+
+	return &transformedHeader, spooled, cleanup, nil
+}
+
+// applyTransforms chains transforms in order they were registered, errors
+// are reported for the file name as failures of op
+func applyTransforms(transforms []Transform, name string, content io.Reader, op string) (io.Reader, error) {
+	var err error
+	for _, t := range transforms {
+		content, err = t.Func(name, content)
+		if err != nil {
+			return nil, &os.PathError{Op: op, Path: name, Err: err}
+		}
+	}
+	return content, nil
+}
+
+// spool copies content to a temporary file and returns it rewound with the
+// length of the content, the returned function removes the file
+func spool(content io.Reader) (*os.File, int64, func(), error) {
+	file, err := ioutil.TempFile("", "wpress-transform")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup := func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+
+	buf := getBuffer(defaultBufferSize)
+	defer putBuffer(buf)
+	written, err := io.CopyBuffer(file, content, buf)
+	if err == nil {
+		_, err = file.Seek(0, 0)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return file, written, cleanup, nil
+}
+
+// matchingTransforms returns the transforms whose pattern matches name
+func matchingTransforms(transforms []Transform, name string) ([]Transform, error) {
+	var matching []Transform
+	for _, t := range transforms {
+		_, err := matchPattern(t.Pattern, "")
+		if err != nil {
+			return nil, err
+		}
+		if matchAny([]string{t.Pattern}, name) {
+			matching = append(matching, t)
+		}
+	}
+	return matching, nil
+}
+
+// decompressTransformed returns header and decompressed content of the
+// compressed file described by h when it has to be transformed, h and
+// content are returned unchanged otherwise. The returned function releases
+// the decompressor.
+func (w *Writer) decompressTransformed(h *Header, content io.Reader) (*Header, io.Reader, func(), error) {
+	if h.compression == NoCompression {
+		return h, content, func() {}, nil
+	}
+	matching, err := matchingTransforms(w.Transforms, h.GetPath())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(matching) == 0 {
+		return h, content, func() {}, nil
+	}
+
+	size, err := h.GetSize64()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	decompressed, err := openEntry(h.GetPath(), h.compression, h.originalSize, io.LimitReader(content, size))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// the decompressed content is written like any other file
+	plain := *h
+	plain.Size, err = formatSize(h.originalSize)
+	if err != nil {
+		decompressed.Close()
+		return nil, nil, nil, err
+	}
+	plain.compression, plain.originalSize = NoCompression, 0
+	return &plain, decompressed, func() { decompressed.Close() }, nil
+}
@@ -0,0 +1,210 @@
+/**
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2014 Yani Iliev <yani@iliev.me>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package wpress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// VolumeName returns name of the nth volume of a multi-volume archive, e.g.
+// "site.wpress.001" for the first one
+func VolumeName(filename string, n int) string {
+	return fmt.Sprintf("%s.%03d", filename, n)
+}
+
+// volumeWriter writes to a sequence of files of at most size bytes
+type volumeWriter struct {
+	filename string
+	size     int64
+
+	// current volume, its number and bytes written to it
+	file    *os.File
+	n       int
+	written int64
+}
+
+// Write writes b to the volumes, starting a new one when the current one is
+// full
+func (v *volumeWriter) Write(b []byte) (int, error) {
+	total := 0
+	for len(b) > 0 {
+		if v.file == nil || v.written == v.size {
+			err := v.next()
+			if err != nil {
+				return total, err
+			}
+		}
+
+		// fill the current volume
+		chunk := b
+		if int64(len(chunk)) > v.size-v.written {
+			chunk = chunk[0 : v.size-v.written]
+		}
+		n, err := v.file.Write(chunk)
+		total += n
+		v.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		b = b[n:]
+	}
+	return total, nil
+}
+
+// next closes the current volume and creates the next one
+func (v *volumeWriter) next() error {
+	if v.file != nil {
+		err := v.file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	v.n++
+	file, err := os.Create(VolumeName(v.filename, v.n))
+	if err != nil {
+		v.file = nil
+		return err
+	}
+	v.file, v.written = file, 0
+	return nil
+}
+
+// Close closes the current volume
+func (v *volumeWriter) Close() error {
+	if v.file == nil {
+		return nil
+	}
+	return v.file.Close()
+}
+
+// volumeReader reads a sequence of files as one
+type volumeReader struct {
+	files []*os.File
+	// offsets of the volumes in the whole archive, the last one is the size
+	offsets []int64
+}
+
+// openVolumes opens the volumes and records their sizes
+func openVolumes(names []string) (*volumeReader, error) {
+	v := &volumeReader{offsets: []int64{0}}
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			v.Close()
+			return nil, err
+		}
+		v.files = append(v.files, file)
+
+		fi, err := file.Stat()
+		if err != nil {
+			v.Close()
+			return nil, err
+		}
+		v.offsets = append(v.offsets, v.offsets[len(v.offsets)-1]+fi.Size())
+	}
+	return v, nil
+}
+
+// size returns the size of all volumes together
+func (v *volumeReader) size() int64 {
+	return v.offsets[len(v.offsets)-1]
+}
+
+// ReadAt reads len(b) bytes at offset off of the whole archive
+func (v *volumeReader) ReadAt(b []byte, off int64) (int, error) {
+	total := 0
+	for len(b) > 0 {
+		// find the volume holding off
+		i := sort.Search(len(v.files), func(i int) bool {
+			return v.offsets[i+1] > off
+		})
+		if i == len(v.files) {
+			return total, io.EOF
+		}
+
+		// read at most to the end of the volume
+		chunk := b
+		if end := v.offsets[i+1] - off; int64(len(chunk)) > end {
+			chunk = chunk[0:end]
+		}
+		n, err := v.files[i].ReadAt(chunk, off-v.offsets[i])
+		total += n
+		off += int64(n)
+		b = b[n:]
+		if err == io.EOF && n == len(chunk) {
+			err = nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Close closes all volumes
+func (v *volumeReader) Close() error {
+	var err error
+	for _, file := range v.files {
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// NewVolumeReader creates a new Reader instance reading archive split into
+// volumes, names lists the volumes in order
+func NewVolumeReader(names []string) (*Reader, error) {
+	if len(names) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	v, err := openVolumes(names)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewReaderAt(v, v.size())
+	r.Filename = names[0]
+	r.closer = v
+	return r, nil
+}
+
+// NewVolumeReaderGlob is like NewVolumeReader but reads volumes matching
+// pattern in lexical order, e.g. "site.wpress.*"
+func NewVolumeReaderGlob(pattern string) (*Reader, error) {
+	names, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return NewVolumeReader(names)
+}
@@ -25,8 +25,20 @@
 package wpress
 
 import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 )
 
 // Writer structure
@@ -34,12 +46,133 @@ type Writer struct {
 	Filename   string
 	File       *os.File
 	FilesAdded int
+
+	// Atomic writes the archive to a temporary file in the same directory
+	// which Close renames to Filename once the archive is complete and
+	// removes otherwise, so incomplete archives never appear under
+	// Filename. It has to be set before calling Init.
+	Atomic bool
+
+	// Transforms change contents of the matching files before they are
+	// written, sizes recorded in headers are those of the transformed
+	// contents. All transforms matching a file are applied in order.
+	Transforms []Transform
+
+	// RateLimit is the maximum number of bytes written per second, e.g. to
+	// keep backups on shared hosts from starving the live site of disk
+	// bandwidth, writing is not limited when zero
+	RateLimit int64
+
+	// Checksum adds a record with the checksum of every file after it,
+	// which the Reader verifies once content of the file was read whole,
+	// see ChecksumFileName
+	Checksum ChecksumAlgorithm
+
+	// Manifest appends a manifest of all files with hashes of their
+	// contents on Close, see ManifestFileName and Reader.Verify
+	Manifest bool
+
+	// TOC appends a table of contents after the EOF block on Close, so
+	// readers list files and jump to them without scanning the archive.
+	// Readers not aware of it stop at the EOF block.
+	TOC bool
+
+	// Compression compresses the whole archive as it is written, it has to
+	// be set before adding files. Compressed archives are read
+	// sequentially, Reader detects them by their magic bytes.
+	Compression Compression
+
+	// CompressionLevel is the level passed to the compressor, e.g. 1 to 9
+	// for gzip and 1 to 22 for zstd, the default level of the compressor is
+	// used when zero. It applies to EntryCompression too.
+	CompressionLevel int
+
+	// Encryption encrypts the archive with AES-256-GCM as it is written, in
+	// chunks, so it is still written and read as a stream, see
+	// NewEncryptedReader and Decrypt. It has to be set before adding files,
+	// the archive is compressed before it is encrypted.
+	Encryption *Encryption
+
+	// Attributes records permissions and ownership of files added from disk
+	// in attributes records, which the Reader restores, see
+	// AttributesFileName
+	Attributes bool
+
+	// Layout is the layout of headers for forks of the format,
+	// DefaultLayout is used when zero. It has to be set before adding
+	// files. Records of extensions are laid out the same, paths longer than
+	// the fields fail with ErrLayoutOverflow.
+	Layout Layout
+
+	// Info is written at the beginning of the archive, so it is read
+	// without scanning files, see Reader.Info. It has to be set before
+	// adding files, Created is the time of the first file when zero.
+	Info *ArchiveInfo
+
+	// PreciseMtime records nanoseconds of modification dates of files in
+	// mtime records, which the Reader restores, see MtimeFileName
+	PreciseMtime bool
+
+	// Metadata returns metadata added to those of the file stored as name,
+	// e.g. the host the backup was made on, see MetadataFileName
+	Metadata func(name string) map[string]string
+
+	// SigningKey signs the archive by Ed25519, the signature is appended on
+	// Close and covers the archive as stored, after compression and
+	// encryption, see VerifySignature. It has to be set before adding files.
+	SigningKey ed25519.PrivateKey
+
+	// EntryCompression compresses contents of individual files, e.g. php,
+	// css and sql files, while media and archives are stored as they are,
+	// see CompressionFileName. Unlike Compression it keeps the archive
+	// seekable and the Reader decompresses the files on extraction.
+	// Checksums and the manifest cover the stored content.
+	EntryCompression Compression
+
+	// dest the archive is written to, File unless created by NewWriterTo
+	dest io.Writer
+	// closes dest when the archive is not written to File, see
+	// NewVolumeWriter
+	closer io.Closer
+	// files stored only once, written to LinksFileName on Close
+	links []hardLink
+	// hashes of contents written by size, see AddOptions.DedupContent
+	contents map[int64][]storedContent
+	// files written so far, see Manifest and TOC
+	manifest *Manifest
+	toc      []EntryInfo
+	// reports progress of the directory being added
+	progress *progressTracker
+	// paces writing when RateLimit is set
+	bucket *tokenBucket
+	// stops writing contents when done, nil means never
+	ctx context.Context
+	// bytes written so far and position right after the last complete file
+	size     int64
+	complete int64
+	// closed is set when the archive was closed
+	closed bool
+	// infoWritten is set once Info was written
+	infoWritten bool
+	// compresses the archive before it is written to dest, see Compression
+	compressor io.WriteCloser
+	// encrypts the archive before it is written to dest, see Encryption,
+	// encrypt creates it when set
+	encrypter io.WriteCloser
+	encrypt   func(dest io.Writer) (io.WriteCloser, error)
+	// hashes everything written to unsigned, see SigningKey
+	digest   hash.Hash
+	unsigned io.Writer
 }
 
+// ErrIncompleteArchive is returned when closing atomic archive which ends in
+// the middle of a file
+var ErrIncompleteArchive = errors.New("archive ends in the middle of a file")
+
 // NewWriter creates new Writer instance
 func NewWriter(filename string) (*Writer, error) {
 	// create a new instance of Writer
-	w := &Writer{filename, nil, 0}
+	w := &Writer{Filename: filename}
 
 	// call the constructor
 	err := w.Init()
@@ -51,20 +184,75 @@ func NewWriter(filename string) (*Writer, error) {
 	return w, nil
 }
 
+// NewAtomicWriter creates new Writer instance with Atomic set, the archive
+// appears under filename only after it was successfully closed
+func NewAtomicWriter(filename string) (*Writer, error) {
+	w := &Writer{Filename: filename, Atomic: true}
+
+	err := w.Init()
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// NewWriterTo creates a new Writer instance writing archive sequentially to
+// dest, e.g. stdout, a network connection or a compressor, so archive can be
+// created and uploaded in one pass
+func NewWriterTo(dest io.Writer) *Writer {
+	return &Writer{dest: dest}
+}
+
+// NewVolumeWriter creates a new Writer instance writing archive split into
+// volumes of volumeSize bytes named by VolumeName, e.g. "site.wpress.001",
+// "site.wpress.002". Volumes are split at exact sizes, so a file may
+// continue in the next volume, and concatenated they form the archive.
+func NewVolumeWriter(filename string, volumeSize int64) (*Writer, error) {
+	if volumeSize <= 0 {
+		return nil, errors.New("volume size must be positive")
+	}
+
+	v := &volumeWriter{filename: filename, size: volumeSize}
+	return &Writer{Filename: filename, dest: v, closer: v}, nil
+}
+
 // Init is Writer constructor
 func (w *Writer) Init() error {
 	// try to create the file
-	file, err := os.Create(w.Filename)
+	file, err := w.create()
 	if err != nil {
 		return err
 	}
 
-	// file was created, assign it to its holding variable
+	// file was created, assign it to its holding variables
 	w.File = file
+	w.dest = file
 
 	return nil
 }
 
+// create creates the file the archive is written to
+func (w *Writer) create() (*os.File, error) {
+	if !w.Atomic {
+		return os.Create(w.Filename)
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(w.Filename), "."+filepath.Base(w.Filename)+".tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	// temporary files are private, archive gets the usual permissions
+	err = file.Chmod(0644)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
 // AddFile addd a file to the archive
 func (w *Writer) AddFile(filename string) error {
 	// populate header block from the filename passed
@@ -74,51 +262,247 @@ func (w *Writer) AddFile(filename string) error {
 		return err
 	}
 
-	// write header block
-	_, err = w.File.Write(h.GetHeaderBlock())
+	return w.addFile(filename, h)
+}
+
+// AddFileAs adds a file to the archive stored under name, e.g.
+// "wp-content/uploads/logo.png", instead of its path on disk
+func (w *Writer) AddFileAs(filename string, name string) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	h, err := NewHeader(name, fi.Size(), fi.ModTime())
+	if err != nil {
+		return err
+	}
+
+	return w.addFile(filename, h)
+}
+
+// Add adds a file described by h with content read from src, e.g. a
+// database dump or a remote object. Exactly the size recorded in h is
+// copied, io.ErrUnexpectedEOF is returned when src ends earlier, leaving
+// the archive incomplete. Content is passed through matching Transforms.
+func (w *Writer) Add(h *Header, src io.Reader) error {
+	err := h.ValidateName()
 	if err != nil {
 		return err
 	}
-	// write file content
+
+	return w.writeEntry(h, src)
+}
+
+// addFile writes header h followed by content of filename
+func (w *Writer) addFile(filename string, h *Header) error {
 	// open the file for reading
 	input, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
 
-	for {
-		bytesToRead := 512
-		content := make([]byte, bytesToRead)
-		bytesRead, err := input.Read(content)
+	// record attributes unless they are kept from another archive
+	if w.Attributes && h.attrs == nil {
+		fi, err := input.Stat()
 		if err != nil {
+			input.Close()
 			return err
 		}
+		h.attrs = fileAttributes(fi, "")
+	}
 
-		// if we have read less than 100 or 0 bytes, we reached end of file
-		if bytesRead < bytesToRead {
-			// obtain only the bytes that were read
-			contentRead := content[0:bytesRead]
-			_, err = w.File.Write(contentRead)
-			if err != nil {
-				return err
-			}
+	err = w.writeEntry(h, input)
+	if err != nil {
+		input.Close()
+		return err
+	}
+
+	// done reading from the file, let's close it
+	return input.Close()
+}
 
-			// exit the loop, we reached end of file
-			break
+// writeEntry writes header h and content read from src passed through the
+// matching transforms and compressed by EntryCompression to the archive.
+// Compressed contents of files copied from other archives are written as
+// they are unless they have to be transformed.
+func (w *Writer) writeEntry(h *Header, src io.Reader) error {
+	h, src, cleanupDecompressed, err := w.decompressTransformed(h, src)
+	if err != nil {
+		return err
+	}
+	defer cleanupDecompressed()
+	if h.compression != NoCompression {
+		return w.copyEntry(h, src)
+	}
+
+	h, src, cleanupTransformed, err := w.transform(h, src)
+	if err != nil {
+		return err
+	}
+	defer cleanupTransformed()
+
+	h, src, cleanupCompressed, err := w.compressEntry(h, src)
+	if err != nil {
+		return err
+	}
+	defer cleanupCompressed()
+
+	return w.copyEntry(h, src)
+}
+
+// copyEntry writes header h and content read from src to the archive
+func (w *Writer) copyEntry(h *Header, src io.Reader) error {
+	size, err := h.GetSize64()
+	if err == nil {
+		err = w.Layout.get().Validate()
+	}
+	if err != nil {
+		return err
+	}
+
+	w.startSigning()
+	err = w.startEncryption()
+	if err == nil {
+		err = w.startCompression()
+	}
+	if err != nil {
+		return err
+	}
+
+	// stop between blocks when cancelled
+	ctx := w.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	out := w.output(ctx)
+
+	// archive info precedes the first file
+	err = w.writeInfo(out)
+	if err != nil {
+		return err
+	}
+
+	// the full path when it doesn't fit the header
+	var records [][]byte
+	if h.longName != "" {
+		records = append(records, longNameRecord(h))
+	}
+
+	// tell readers the content has to be decompressed
+	if h.compression != NoCompression {
+		records = append(records, compressionRecord(h))
+	}
+
+	// metadata of the file
+	h = w.withMetadata(h)
+	if h.metadata != nil {
+		record, err := metadataRecord(h)
+		if err != nil {
+			return &os.PathError{Op: "add", Path: h.GetPath(), Err: err}
 		}
+		records = append(records, record)
+	}
+
+	// nanoseconds of the modification date
+	if w.PreciseMtime && h.mtimeNsec != 0 {
+		records = append(records, mtimeRecord(h))
+	}
 
-		// we write the content we just read to the archive
-		_, err = w.File.Write(content)
+	// attributes of the file
+	if h.attrs != nil {
+		record, err := attributesRecord(h)
 		if err != nil {
 			return err
 		}
+		records = append(records, record)
 	}
 
-	// done reading from the file, let's close it
-	err = input.Close()
+	// the records precede the header block, all laid out by Layout
+	layout := w.Layout.get()
+	block, err := layout.block(h)
+	if err != nil {
+		return &os.PathError{Op: "add", Path: h.GetPath(), Err: err}
+	}
+	for _, record := range records {
+		record, err = layout.record(record)
+		if err != nil {
+			return &os.PathError{Op: "add", Path: h.GetPath(), Err: err}
+		}
+		n, err := out.Write(record)
+		w.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	// write header block
+	n, err := out.Write(block)
+	w.size += int64(n)
 	if err != nil {
 		return err
 	}
+	offset := w.size
+
+	// copy exactly the number of bytes recorded in the header, the file
+	// may have changed since
+	fp := w.progress.startFile(h.GetPath(), size)
+	buf := getBuffer(defaultBufferSize)
+	defer putBuffer(buf)
+	dest := &progressWriter{ctx, out, fp}
+	var sum, manifestSum hash.Hash
+	src = io.LimitReader(src, size)
+	if w.Checksum != NoChecksum {
+		sum = w.Checksum.newHash()
+		src = io.TeeReader(src, sum)
+	}
+	if w.Manifest {
+		manifestSum = sha256.New()
+		src = io.TeeReader(src, manifestSum)
+	}
+	written, err := io.CopyBuffer(dest, src, buf)
+	w.size += written
+	if err == nil && written != size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+
+	// record the checksum right after the content
+	if sum != nil {
+		record, err := layout.record(checksumRecord(h, w.Checksum, sum.Sum(nil)))
+		if err != nil {
+			return err
+		}
+		n, err := out.Write(record)
+		w.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	fp.end()
+	w.complete = w.size
+
+	// list the file in the table of contents
+	if w.TOC {
+		e, err := newEntryInfo(h, offset)
+		if err != nil {
+			return err
+		}
+		w.toc = append(w.toc, e)
+	}
+
+	// list the file in manifest
+	if manifestSum != nil {
+		if w.manifest == nil {
+			w.manifest = &Manifest{}
+		}
+		err = w.manifest.add(h, manifestSum.Sum(nil))
+		if err != nil {
+			return err
+		}
+	}
 
 	// file was added to the archive, increment fileAdded
 	w.FilesAdded++
@@ -137,7 +521,10 @@ func (w *Writer) AddDirectory(path string) error {
 	// files are added using AddFile, directories are parsed recursevely
 	for _, fi := range fiArray {
 		if fi.IsDir() {
-			w.AddDirectory(path + string(os.PathSeparator) + fi.Name())
+			err = w.AddDirectory(path + string(os.PathSeparator) + fi.Name())
+			if err != nil {
+				return err
+			}
 		} else {
 			err = w.AddFile(path + string(os.PathSeparator) + fi.Name())
 			if err != nil {
@@ -149,26 +536,588 @@ func (w *Writer) AddDirectory(path string) error {
 	return nil
 }
 
-// Close appends EOF sequence to the end of the file and closes the file
-func (w Writer) Close() error {
-	// if we haven't added any files, we don't append EOF sequence
-	if w.FilesAdded == 0 {
+// ErrSymlink is returned when a symbolic link is found and FailSymlinks
+// policy is used
+var ErrSymlink = errors.New("symbolic link found")
+
+// SymlinkPolicy tells what to do with symbolic links found in directories
+type SymlinkPolicy int
+
+const (
+	// FollowSymlinks adds the files and directories links point to under
+	// the path of the link, it is the default. Links leading back to one of
+	// their parent directories and dangling links are skipped.
+	FollowSymlinks SymlinkPolicy = iota
+	// SkipSymlinks leaves links out of the archive
+	SkipSymlinks
+	// FailSymlinks stops adding with ErrSymlink
+	FailSymlinks
+	// StoreSymlinks adds links themselves as empty files with their
+	// targets in attributes records, the Reader recreates the links which
+	// point inside the destination directory
+	StoreSymlinks
+)
+
+// CancelPolicy tells what to do with the archive when adding is cancelled
+type CancelPolicy int
+
+const (
+	// KeepPartialArchive leaves the archive as it is, it is the default.
+	// Close doesn't append EOF block when the archive ends in the middle of
+	// a file.
+	KeepPartialArchive CancelPolicy = iota
+	// RemovePartialArchive closes and removes the archive
+	RemovePartialArchive
+	// FinalizePartialArchive drops the file being written and closes the
+	// archive, so it holds the files added before cancellation
+	FinalizePartialArchive
+)
+
+// AddOptions controls adding directories to the archive
+type AddOptions struct {
+	// Exclude lists patterns of files and directories to leave out.
+	// Patterns without a slash match the name at any depth, e.g.
+	// "node_modules" or "*.log", other patterns match the path relative to
+	// the root using the syntax of Glob, e.g. "wp-content/cache".
+	Exclude []string
+
+	// Presets add exclusion patterns of the presets to Exclude, e.g.
+	// WordPressPresets to leave out caches, logs and other backups
+	Presets []ExcludePreset
+
+	// NoIgnoreFile doesn't read the ignore file in the root, by default
+	// files it lists are left out too, see IgnoreFileName
+	NoIgnoreFile bool
+
+	// Symlinks tells what to do with symbolic links
+	Symlinks SymlinkPolicy
+
+	// Followed is called with path of every followed link relative to the
+	// root and the target it points to
+	Followed func(name string, target string)
+
+	// Progress is called when adding a file starts and finishes and after
+	// every ProgressInterval bytes written, totals are counted before
+	// adding the first file
+	Progress func(ProgressEvent)
+
+	// ProgressInterval is the number of bytes between progress reports,
+	// 1 MiB is used when zero
+	ProgressInterval int64
+
+	// Workers is the number of goroutines opening and reading files ahead
+	// of writing them, which speeds up archiving many small files. Files
+	// are still written in lexical order.
+	Workers int
+
+	// OnCancel tells what to do with the archive created by NewWriter when
+	// AddDirectoryContext is cancelled
+	OnCancel CancelPolicy
+
+	// DedupHardLinks stores content of files hard linked to each other only
+	// once, the other paths are listed in LinksFileName and extracted as
+	// copies
+	DedupHardLinks bool
+
+	// DedupContent stores content of files repeating the content of a file
+	// added before only once, the other paths are listed in LinksFileName
+	// and extracted as copies. Files of the same size are compared by their
+	// SHA-256 hashes.
+	DedupContent bool
+}
+
+// excluded reports whether the file or directory at name, relative to the
+// root, is left out
+func (o AddOptions) excluded(name string) bool {
+	patterns := append([]string{}, o.Exclude...)
+	for _, preset := range o.Presets {
+		patterns = append(patterns, presetPatterns[preset]...)
+	}
+
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "/") {
+			if matchAny([]string{pattern}, name) {
+				return true
+			}
+			continue
+		}
+
+		// match the name of any of the directories and the file
+		for _, segment := range strings.Split(name, "/") {
+			matched, _ := path.Match(pattern, segment)
+			if matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AddDirectoryWithOptions adds all regular files below root to the archive
+// stored under their paths relative to root, files directly in root get
+// prefix ".". Files are added in lexical order, so the same tree always
+// produces the same archive. Special files are skipped, so are files listed
+// in the ignore file of root.
+func (w *Writer) AddDirectoryWithOptions(root string, opts AddOptions) error {
+	return w.AddDirectoryContext(context.Background(), root, opts)
+}
+
+// AddDirectoryContext is like AddDirectoryWithOptions but aborts when ctx is
+// done, the partial archive is then handled according to opts.OnCancel
+func (w *Writer) AddDirectoryContext(ctx context.Context, root string, opts AddOptions) error {
+	w.ctx = ctx
+	defer func() {
+		w.ctx = nil
+	}()
+
+	err := w.addDirectory(root, opts)
+	if err != nil && ctx.Err() != nil {
+		abortErr := w.abort(opts.OnCancel)
+		if abortErr != nil {
+			return abortErr
+		}
+		return ctx.Err()
+	}
+	return err
+}
+
+// addDirectory adds files below root according to opts
+func (w *Writer) addDirectory(root string, opts AddOptions) error {
+	t, err := newTree(w, root, opts)
+	if err != nil {
+		return err
+	}
+
+	// set up progress reporting with totals counted beforehand
+	if opts.Progress != nil {
+		files, bytes := 0, int64(0)
+		t.counting = true
+		err = t.walk(func(pathToFile string, name string, fi os.FileInfo, source string) error {
+			if source == "" {
+				files++
+				bytes += storedSize(fi)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		t.counting = false
+
+		w.progress = newProgressTracker(opts.Progress, opts.ProgressInterval)
+		w.progress.setTotals(files, bytes)
+		defer func() {
+			w.progress = nil
+		}()
+	}
+
+	// open and read files ahead of writing them
+	if opts.Workers > 1 {
+		return w.addPipelined(t, opts.Workers)
+	}
+
+	return t.walk(func(pathToFile string, name string, fi os.FileInfo, source string) error {
+		// stop between files when cancelled
+		if w.ctx.Err() != nil {
+			return w.ctx.Err()
+		}
+
+		if source != "" {
+			w.links = append(w.links, hardLink{Name: name, Source: source})
+			return nil
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return w.addSymlink(pathToFile, name, fi)
+		}
+		if opts.DedupContent {
+			return w.addFileDeduped(pathToFile, name)
+		}
+		return w.AddFileAs(pathToFile, name)
+	})
+}
+
+// EstimateSize walks root applying opts like AddDirectoryWithOptions and
+// returns the number of files and the exact size of the archive it would
+// create, provided the files don't change in the meantime, without writing
+// anything. Options of the Writer changing what is written, e.g.
+// Transforms or EntryCompression, and DedupContent are not taken into
+// account.
+func EstimateSize(root string, opts AddOptions) (int, int64, error) {
+	t, err := newTree(&Writer{}, root, opts)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	files, size := 0, int64(0)
+	var links []hardLink
+	t.counting = true
+	err = t.walk(func(pathToFile string, name string, fi os.FileInfo, source string) error {
+		if source != "" {
+			links = append(links, hardLink{Name: name, Source: source})
+			return nil
+		}
+		files++
+		size += longNameOverhead(name) + headerSize + storedSize(fi)
 		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// the list of hard links is stored as a file
+	if len(links) > 0 {
+		content, err := json.Marshal(links)
+		if err != nil {
+			return 0, 0, err
+		}
+		files++
+		size += headerSize + int64(len(content))
+	}
+
+	// archive without files has no EOF block
+	if files > 0 {
+		size += headerSize
+	}
+
+	return files, size, nil
+}
+
+// visitFunc is called for every file of the tree, source is the name the
+// file was already visited as when it is a hard link stored only once
+type visitFunc func(pathToFile string, name string, fi os.FileInfo, source string) error
+
+// tree holds the state of adding a directory
+type tree struct {
+	w     *Writer
+	root  string
+	opts  AddOptions
+	rules []ignoreRule
+	// files visited so far by size, for hard link detection
+	seen map[int64][]seenFile
+	// visit is called for every file to add
+	visit visitFunc
+	// counting doesn't report followed links, the tree is walked again
+	counting bool
+}
+
+// newTree validates opts and creates a tree rooted at root
+func newTree(w *Writer, root string, opts AddOptions) (*tree, error) {
+	// validate the patterns before writing anything
+	err := Filter{Exclude: opts.Exclude}.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// read the rules of the site owner
+	var rules []ignoreRule
+	if !opts.NoIgnoreFile {
+		rules, err = readIgnoreFile(root)
+		if err != nil {
+			return nil, err
+		}
 	}
-	// create new header instance
-	h := &Header{}
 
-	// write eof sequence
-	_, err := w.File.Write(h.GetEOFBlock())
+	return &tree{w: w, root: root, opts: opts, rules: rules}, nil
+}
+
+// walk calls visit for every file to add in lexical order
+func (t *tree) walk(visit visitFunc) error {
+	fi, err := os.Stat(t.root)
 	if err != nil {
 		return err
 	}
 
-	// close the archive
-	err = w.File.Close()
+	t.visit = visit
+	t.seen = nil
+	return t.add("", []os.FileInfo{fi})
+}
+
+// add adds files of directory dir, relative to the root, ancestors are the
+// directories walked to reach it
+func (t *tree) add(dir string, ancestors []os.FileInfo) error {
+	entries, err := os.ReadDir(filepath.Join(t.root, filepath.FromSlash(dir)))
 	if err != nil {
 		return err
 	}
 
+	for _, d := range entries {
+		name := path.Join(dir, d.Name())
+		pathToFile := filepath.Join(t.root, filepath.FromSlash(name))
+
+		// links are described by their targets
+		link := d.Type()&fs.ModeSymlink != 0
+		fi, err := d.Info()
+		if link {
+			fi, err = os.Stat(pathToFile)
+		}
+		isDir := d.IsDir() || link && err == nil && fi.IsDir()
+
+		// leave out the excluded files and everything below excluded
+		// directories
+		if t.opts.excluded(name) || ignored(t.rules, name, isDir) {
+			continue
+		}
+
+		if link {
+			switch {
+			case t.opts.Symlinks == SkipSymlinks:
+				continue
+			case t.opts.Symlinks == FailSymlinks:
+				return &os.PathError{Op: "add", Path: pathToFile, Err: ErrSymlink}
+			case t.opts.Symlinks == StoreSymlinks:
+				// the link itself is added, whether its target exists or not
+				fi, err = d.Info()
+				if err == nil {
+					err = t.visit(pathToFile, name, fi, "")
+				}
+				if err != nil {
+					return err
+				}
+				continue
+			case err != nil:
+				// dangling link
+				continue
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if isDir {
+			// don't follow links back to a parent directory
+			if sameFileAny(fi, ancestors) {
+				continue
+			}
+			t.followed(link, name, pathToFile)
+			err = t.add(name, append(ancestors, fi))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+
+		// don't add the archive being written to itself
+		if t.w.File != nil {
+			archive, err := t.w.File.Stat()
+			if err == nil && os.SameFile(fi, archive) {
+				continue
+			}
+		}
+
+		// store content of hard linked files once
+		source := ""
+		if t.opts.DedupHardLinks {
+			source, _ = t.dedup(fi, name)
+		}
+
+		t.followed(link, name, pathToFile)
+		err = t.visit(pathToFile, name, fi, source)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// followed reports the link at pathToFile when link is true
+func (t *tree) followed(link bool, name string, pathToFile string) {
+	if link && t.opts.Followed != nil && !t.counting {
+		target, _ := os.Readlink(pathToFile)
+		t.opts.Followed(name, target)
+	}
+}
+
+// sameFileAny reports whether fi describes the same file as any of infos
+func sameFileAny(fi os.FileInfo, infos []os.FileInfo) bool {
+	for _, info := range infos {
+		if os.SameFile(fi, info) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddFilesFrom adds files listed in list, one path relative to root per
+// line, like tar --files-from. Files are stored under the listed paths,
+// blank lines are skipped. Number of files added is returned.
+func (w *Writer) AddFilesFrom(root string, list io.Reader) (int, error) {
+	added := 0
+
+	scanner := bufio.NewScanner(list)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// listed paths must stay inside root
+		name := path.Clean(filepath.ToSlash(line))
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return added, &os.PathError{Op: "add", Path: line, Err: ErrUnsafePath}
+		}
+
+		pathToFile := filepath.Join(root, filepath.FromSlash(name))
+		fi, err := os.Stat(pathToFile)
+		if err != nil {
+			return added, err
+		}
+		if !fi.Mode().IsRegular() {
+			return added, &os.PathError{Op: "add", Path: line, Err: errors.New("not a regular file")}
+		}
+
+		err = w.AddFileAs(pathToFile, name)
+		if err != nil {
+			return added, err
+		}
+		added++
+	}
+
+	return added, scanner.Err()
+}
+
+// abort handles the partial archive of a cancelled operation according to
+// policy, archives of NewWriterTo are always kept
+func (w *Writer) abort(policy CancelPolicy) error {
+	if w.File == nil {
+		return nil
+	}
+
+	switch policy {
+	case RemovePartialArchive:
+		return w.discard()
+	case FinalizePartialArchive:
+		// compressed, encrypted or signed archive can't be cut, it ends in
+		// the middle of the file
+		if w.compressor != nil || w.encrypter != nil || w.digest != nil {
+			return w.Close()
+		}
+
+		// drop the file being written and close the archive
+		err := w.File.Truncate(w.complete)
+		if err == nil {
+			_, err = w.File.Seek(w.complete, io.SeekStart)
+		}
+		if err != nil {
+			return err
+		}
+		w.size = w.complete
+		return w.Close()
+	}
+
+	return nil
+}
+
+// discard closes and removes the archive file
+func (w *Writer) discard() error {
+	w.closed = true
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}
+
+// Close appends EOF sequence to the end of the archive and closes the file,
+// destinations passed to NewWriterTo are left for the caller to close
+func (w *Writer) Close() error {
+	// archive was already closed when adding was cancelled
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.finish()
+	closeErr := w.closeDest()
+	if err == nil {
+		err = closeErr
+	}
+
+	// move the atomic archive in place only when it is complete
+	if w.Atomic && w.File != nil {
+		if err == nil {
+			err = os.Rename(w.File.Name(), w.Filename)
+		}
+		if err != nil {
+			os.Remove(w.File.Name())
+		}
+	}
+
+	return err
+}
+
+// finish writes the list of hard links and EOF sequence
+func (w *Writer) finish() error {
+	// archive ending in the middle of a file is left without EOF block, so
+	// readers report it as truncated
+	if w.size != w.complete {
+		if w.Atomic {
+			return ErrIncompleteArchive
+		}
+		return nil
+	}
+
+	// list the files stored only once
+	if len(w.links) > 0 {
+		err := w.writeLinks()
+		if err != nil {
+			return err
+		}
+	}
+
+	// list all files with their hashes
+	if w.Manifest && w.FilesAdded > 0 {
+		err := w.writeManifest()
+		if err != nil {
+			return err
+		}
+	}
+
+	// if we haven't added any files, we don't append EOF sequence, unless
+	// the info was written before adding the first file failed
+	if w.FilesAdded > 0 || w.infoWritten {
+		// write eof sequence
+		n, err := w.dest.Write(w.Layout.get().eofBlock())
+		w.size += int64(n)
+		if err != nil {
+			return err
+		}
+
+		// table of contents follows the EOF block
+		if w.TOC {
+			return w.writeTOC()
+		}
+	}
+
 	return nil
 }
+
+// closeDest closes the file or volumes the archive is written to
+func (w *Writer) closeDest() error {
+	// encrypted archive without files still needs the prologue
+	w.startSigning()
+	err := w.startEncryption()
+
+	// flush the compressed stream, then the last encrypted chunk before
+	// closing the destination
+	if w.compressor != nil && err == nil {
+		err = w.compressor.Close()
+	}
+	if w.encrypter != nil && err == nil {
+		err = w.encrypter.Close()
+	}
+	if err == nil {
+		err = w.writeSignature()
+	}
+
+	var closeErr error
+	if w.File != nil {
+		closeErr = w.File.Close()
+	} else if w.closer != nil {
+		closeErr = w.closer.Close()
+	}
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -26,11 +26,19 @@ package wpress
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"path"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestNewWriter tests creating a new writer
@@ -125,6 +133,692 @@ func TestAddFile(t *testing.T) {
 	}
 }
 
+// TestAddEmptyFile tests adding files of sizes which don't fill a buffer
+func TestAddEmptyFile(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	// create an empty file and a file of exactly 512 bytes
+	empty := tempPath + string(os.PathSeparator) + "empty.txt"
+	ioutil.WriteFile(empty, nil, 0644)
+	block := tempPath + string(os.PathSeparator) + "block.txt"
+	ioutil.WriteFile(block, make([]byte, 512), 0644)
+
+	filename := tempPath + string(os.PathSeparator) + "output.wpress"
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	for _, file := range []string{empty, block} {
+		err = w.AddFile(file)
+		if err != nil {
+			t.Errorf("Failed to add `%s` because: %s", file, err)
+		}
+	}
+	w.Close()
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instace: %s", err)
+	}
+	filesCount, err := r.GetFilesCount()
+	if err != nil || filesCount != 2 {
+		t.Errorf("The archive contains %d files instead of 2: %v", filesCount, err)
+	}
+}
+
+// TestAddFileAs tests adding a file under a name of choice
+func TestAddFileAs(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	filename := tempPath + string(os.PathSeparator) + "output.wpress"
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	source := _getPathToTests(t) + string(os.PathSeparator) + "lipsum.txt"
+	err = w.AddFileAs(source, "wp-content/uploads/lipsum.txt")
+	if err != nil {
+		t.Errorf("Failed to add file: %s", err)
+	}
+	err = w.AddFileAs(source, "readme.txt")
+	if err != nil {
+		t.Errorf("Failed to add file: %s", err)
+	}
+	w.Close()
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instace: %s", err)
+	}
+	defer r.Close()
+	entries, err := r.ListEntries()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("The archive contains %d files instead of 2: %v", len(entries), err)
+	}
+	if entries[0].Name != "lipsum.txt" || entries[0].Prefix != "wp-content/uploads" {
+		t.Errorf("Unexpected name `%s` and prefix `%s`", entries[0].Name, entries[0].Prefix)
+	}
+	if entries[1].Name != "readme.txt" || entries[1].Prefix != "." {
+		t.Errorf("Unexpected name `%s` and prefix `%s`", entries[1].Name, entries[1].Prefix)
+	}
+
+	expected, _ := ioutil.ReadFile(source)
+	content, err := r.ExtractFile("lipsum.txt", "wp-content/uploads")
+	if err != nil || !bytes.Equal(content, expected) {
+		t.Errorf("Extracted content differs from the source: %v", err)
+	}
+}
+
+// TestAdd tests adding content from a reader
+func TestAdd(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	filename := tempPath + string(os.PathSeparator) + "output.wpress"
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	dump := "CREATE TABLE wp_options;"
+	h, err := NewHeader("database.sql", int64(len(dump)), time.Unix(1420382531, 0))
+	if err != nil {
+		t.Fatalf("Failed to create header: %s", err)
+	}
+	err = w.Add(h, strings.NewReader(dump))
+	if err != nil {
+		t.Errorf("Failed to add content: %s", err)
+	}
+
+	// content shorter than the header says
+	err = w.Add(h, strings.NewReader("CREATE"))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	w.Close()
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instace: %s", err)
+	}
+	defer r.Close()
+	e, err := r.Stat("database.sql")
+	if err != nil || e.Size != int64(len(dump)) || e.ModTime.Unix() != 1420382531 {
+		t.Errorf("Unexpected entry %+v: %v", e, err)
+	}
+	content, err := r.ExtractFile("database.sql", ".")
+	if err != nil || string(content) != dump {
+		t.Errorf("Unexpected content `%s`: %v", content, err)
+	}
+}
+
+// TestLargeSizes tests encoding sizes which don't fit the size field
+func TestLargeSizes(t *testing.T) {
+	for _, size := range []int64{0, 99999999999999, 100000000000000, 1<<62 + 5, math.MaxInt64} {
+		h, err := NewHeader("database.sql", size, time.Unix(1420382531, 0))
+		if err != nil {
+			t.Fatalf("Failed to create header of size %d: %s", size, err)
+		}
+		read := &Header{}
+		read.PopulateFromBytes(h.GetHeaderBlock())
+		got, err := read.GetSize64()
+		if err != nil || got != size {
+			t.Errorf("Read size %d instead of %d: %v", got, size, err)
+		}
+		if size < 100000000000000 && (read.Size[0]&binarySizeFlag != 0 || !numericField(read.Size)) {
+			t.Errorf("Size %d isn't stored as decimal digits", size)
+		}
+	}
+
+	// size with garbage between the flag and the value is refused
+	h, _ := NewHeader("database.sql", 1<<62, time.Now())
+	h.Size[3] = 1
+	_, err := h.GetSize64()
+	if err != ErrCorruptHeader {
+		t.Errorf("Expected ErrCorruptHeader, got %v", err)
+	}
+
+	_, err = NewHeader("database.sql", -1, time.Now())
+	if err == nil {
+		t.Errorf("Expected negative size to be refused")
+	}
+}
+
+// TestTransforms tests changing contents of files while adding them
+func TestTransforms(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	upper := func(name string, content io.Reader) (io.Reader, error) {
+		b, err := ioutil.ReadAll(content)
+		return bytes.NewReader(bytes.ToUpper(b)), err
+	}
+	scrub := func(name string, content io.Reader) (io.Reader, error) {
+		return strings.NewReader("define('DB_PASSWORD', '');"), nil
+	}
+	w.Transforms = []Transform{{"**/*.sql", upper}, {"wp-config.php", scrub}, {"*.php", upper}}
+
+	for name, content := range map[string]string{
+		"wp-config.php":       "define('DB_PASSWORD', 'secret');",
+		"backup/database.sql": "create table wp_options;",
+		"readme.txt":          "unchanged",
+	} {
+		h, _ := NewHeader(name, int64(len(content)), time.Unix(1420382531, 0))
+		err = w.Add(h, strings.NewReader(content))
+		if err != nil {
+			t.Errorf("Failed to add %s: %s", name, err)
+		}
+	}
+	w.Close()
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instace: %s", err)
+	}
+	defer r.Close()
+	for name, expected := range map[string]string{
+		"wp-config.php":       "DEFINE('DB_PASSWORD', '');",
+		"backup/database.sql": "CREATE TABLE WP_OPTIONS;",
+		"readme.txt":          "unchanged",
+	} {
+		e, err := r.Stat(name)
+		if err != nil || e.Size != int64(len(expected)) || e.ModTime.Unix() != 1420382531 {
+			t.Errorf("Unexpected entry %+v: %v", e, err)
+		}
+		content, err := r.ExtractFile(path.Base(name), path.Dir(name))
+		if err != nil || string(content) != expected {
+			t.Errorf("Unexpected content of %s `%s`: %v", name, content, err)
+		}
+	}
+
+	// failing transform fails adding the file
+	w = NewWriterTo(ioutil.Discard)
+	w.Transforms = []Transform{{"**", func(name string, content io.Reader) (io.Reader, error) {
+		return nil, errors.New("boom")
+	}}}
+	h, _ := NewHeader("readme.txt", 9, time.Now())
+	err = w.Add(h, strings.NewReader("unchanged"))
+	if err == nil {
+		t.Errorf("Expected failing transform to fail adding")
+	}
+}
+
+// TestRateLimit tests limiting the rate of writing
+func TestRateLimit(t *testing.T) {
+	w := NewWriterTo(ioutil.Discard)
+	w.RateLimit = 1 << 20
+
+	// the first tenth of a second worth of bytes is written without waiting
+	content := strings.Repeat("x", 300<<10)
+	h, _ := NewHeader("database.sql", int64(len(content)), time.Now())
+	start := time.Now()
+	err := w.Add(h, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to add content: %s", err)
+	}
+	elapsed := time.Since(start)
+	if elapsed < 150*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Writing 300 KiB at 1 MiB/s took %s", elapsed)
+	}
+}
+
+// TestAddDirectoryIgnoreFile tests honoring the ignore file
+func TestAddDirectoryIgnoreFile(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	// create a small site root
+	root := filepath.Join(tempPath, "site")
+	for _, name := range []string{
+		"index.php",
+		"debug.log",
+		"wp-content/debug.log",
+		"wp-content/keep.log",
+		"wp-content/cache/page.html",
+		"wp-content/plugins/cache/cache.php",
+		"wp-content/uploads/backup/old.zip",
+	} {
+		pathToFile := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(pathToFile), 0755)
+		ioutil.WriteFile(pathToFile, []byte(name), 0644)
+	}
+	ignore := "# never back up\n*.log\n!keep.log\n/wp-content/cache/\nuploads/backup\n"
+	ioutil.WriteFile(filepath.Join(root, IgnoreFileName), []byte(ignore), 0644)
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	err = w.AddDirectoryWithOptions(root, AddOptions{})
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	w.Close()
+
+	expected := []string{
+		IgnoreFileName,
+		"index.php",
+		"wp-content/keep.log",
+		"wp-content/plugins/cache/cache.php",
+		"wp-content/uploads/backup/old.zip",
+	}
+	paths := _listPaths(t, filename)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Added %v instead of %v", paths, expected)
+	}
+}
+
+// TestAddDirectorySymlinks tests symbolic link policies
+func TestAddDirectorySymlinks(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	// wp-content lives outside of the site root
+	root := filepath.Join(tempPath, "site")
+	content := filepath.Join(tempPath, "shared", "wp-content")
+	os.MkdirAll(filepath.Join(content, "uploads"), 0755)
+	os.MkdirAll(root, 0755)
+	ioutil.WriteFile(filepath.Join(root, "index.php"), []byte("<?php"), 0644)
+	ioutil.WriteFile(filepath.Join(content, "uploads", "logo.png"), []byte("png"), 0644)
+	err = os.Symlink(content, filepath.Join(root, "wp-content"))
+	if err != nil {
+		t.Skipf("Unable to create symbolic link: %s", err)
+	}
+	// cycle and dangling link
+	os.Symlink(content, filepath.Join(content, "uploads", "loop"))
+	os.Symlink(filepath.Join(tempPath, "missing"), filepath.Join(root, "missing"))
+
+	for _, test := range []struct {
+		policy   SymlinkPolicy
+		expected []string
+		followed []string
+		fails    bool
+	}{
+		{FollowSymlinks, []string{"index.php", "wp-content/uploads/logo.png"}, []string{"wp-content"}, false},
+		{SkipSymlinks, []string{"index.php"}, nil, false},
+		{FailSymlinks, []string{"index.php"}, nil, true},
+	} {
+		filename := filepath.Join(tempPath, "output.wpress")
+		w, err := NewWriter(filename)
+		if err != nil {
+			t.Fatalf("Failed to create a new Writer because %s", err)
+		}
+		var followed []string
+		opts := AddOptions{Symlinks: test.policy, Followed: func(name string, target string) {
+			followed = append(followed, name)
+		}}
+		err = w.AddDirectoryWithOptions(root, opts)
+		if errors.Is(err, ErrSymlink) != test.fails {
+			t.Errorf("Unexpected error with policy %d: %v", test.policy, err)
+		}
+		w.Close()
+
+		paths := _listPaths(t, filename)
+		if !reflect.DeepEqual(paths, test.expected) {
+			t.Errorf("Added %v instead of %v with policy %d", paths, test.expected, test.policy)
+		}
+		if !reflect.DeepEqual(followed, test.followed) {
+			t.Errorf("Followed %v instead of %v", followed, test.followed)
+		}
+	}
+}
+
+// TestAddDirectoryProgress tests reporting progress of adding a directory
+func TestAddDirectoryProgress(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	w, err := NewWriter(filepath.Join(tempPath, "output.wpress"))
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	defer w.Close()
+
+	var events []ProgressEvent
+	opts := AddOptions{ProgressInterval: 1000, Progress: func(event ProgressEvent) {
+		events = append(events, event)
+	}}
+	err = w.AddDirectoryWithOptions(_getPathToTests(t), opts)
+	if err != nil {
+		t.Fatalf("Failed to add directory: %s", err)
+	}
+
+	if len(events) == 0 {
+		t.Fatalf("No progress was reported")
+	}
+	last := events[len(events)-1]
+	if last.TotalFiles != 5 || last.Files != 5 || last.Bytes != last.TotalBytes {
+		t.Errorf("Unexpected last event %+v", last)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Bytes < events[i-1].Bytes {
+			t.Errorf("Progress went backwards %+v", events[i])
+		}
+	}
+}
+
+// TestAddDirectoryContext tests cancelling adding a directory
+func TestAddDirectoryContext(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	for _, policy := range []CancelPolicy{KeepPartialArchive, RemovePartialArchive, FinalizePartialArchive} {
+		filename := filepath.Join(tempPath, "output.wpress")
+		w, err := NewWriter(filename)
+		if err != nil {
+			t.Fatalf("Failed to create a new Writer because %s", err)
+		}
+
+		// cancel after header of the fourth file was written
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := AddOptions{OnCancel: policy, Progress: func(event ProgressEvent) {
+			if event.Path == "logo3.png" && !event.FileDone {
+				cancel()
+			}
+		}}
+		err = w.AddDirectoryContext(ctx, _getPathToTests(t), opts)
+		if err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Errorf("Failed to close the archive: %s", err)
+		}
+
+		_, err = os.Stat(filename)
+		if policy == RemovePartialArchive {
+			if !os.IsNotExist(err) {
+				t.Errorf("Partial archive was not removed")
+			}
+			continue
+		}
+
+		r, err := NewReader(filename)
+		if err != nil {
+			t.Fatalf("Failed to create a new Reader instance: %s", err)
+		}
+		entries, err := r.ListEntries()
+		r.Close()
+		if policy == KeepPartialArchive && (err != ErrTruncated || len(entries) != 4) {
+			t.Errorf("Listed %d entries of partial archive: %v", len(entries), err)
+		}
+		if policy == FinalizePartialArchive && (err != nil || len(entries) != 3) {
+			t.Errorf("Listed %d entries of finalized archive: %v", len(entries), err)
+		}
+		os.Remove(filename)
+	}
+}
+
+// TestAddDirectoryWorkers tests reading files ahead by workers
+func TestAddDirectoryWorkers(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	// many small files and one large file
+	root := filepath.Join(tempPath, "site")
+	for i := 0; i < 50; i++ {
+		name := filepath.Join(root, "uploads", fmt.Sprintf("%02d", i%7), fmt.Sprintf("file%d.txt", i))
+		os.MkdirAll(filepath.Dir(name), 0755)
+		ioutil.WriteFile(name, bytes.Repeat([]byte{byte(i)}, i*10), 0644)
+	}
+	ioutil.WriteFile(filepath.Join(root, "database.sql"), bytes.Repeat([]byte("INSERT;"), 20000), 0644)
+
+	// archive the tree sequentially and by workers
+	var archives [][]byte
+	for _, workers := range []int{0, 4} {
+		archive := &bytes.Buffer{}
+		w := NewWriterTo(archive)
+		err = w.AddDirectoryWithOptions(root, AddOptions{Workers: workers})
+		if err != nil {
+			t.Fatalf("Failed to add directory with %d workers: %s", workers, err)
+		}
+		w.Close()
+		archives = append(archives, archive.Bytes())
+	}
+
+	if !bytes.Equal(archives[0], archives[1]) {
+		t.Errorf("Archive written by workers differs from sequential one")
+	}
+	r := NewReaderAt(bytes.NewReader(archives[1]), int64(len(archives[1])))
+	filesCount, err := r.GetFilesCount()
+	if err != nil || filesCount != 51 {
+		t.Errorf("The archive contains %d files instead of 51: %v", filesCount, err)
+	}
+}
+
+// TestNewVolumeWriter tests splitting archive into volumes
+func TestNewVolumeWriter(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	filename := filepath.Join(tempPath, "site.wpress")
+	w, err := NewVolumeWriter(filename, 5000)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	for _, file := range []string{"logo.svg", "lipsum.txt", "logo3.png"} {
+		err = w.AddFileAs(filepath.Join(_getPathToTests(t), file), "/repos/wpress/testdata/"+file)
+		if err != nil {
+			t.Errorf("Failed to add `%s` because: %s", file, err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("Failed to close the archive: %s", err)
+	}
+
+	// concatenated volumes equal the archive written at once
+	expected, err := ioutil.ReadFile(filepath.Join(_getPathToTests(t), TestArchiveName))
+	if err != nil {
+		t.Fatalf("Unable to read test archive: %s", err)
+	}
+	var archive []byte
+	for n := 1; ; n++ {
+		volume, err := ioutil.ReadFile(VolumeName(filename, n))
+		if os.IsNotExist(err) {
+			break
+		}
+		if len(volume) > 5000 {
+			t.Errorf("Volume %d has %d bytes", n, len(volume))
+		}
+		archive = append(archive, volume...)
+	}
+	if len(archive) != len(expected) {
+		t.Fatalf("Volumes have %d bytes instead of %d", len(archive), len(expected))
+	}
+	r := NewReaderAt(bytes.NewReader(archive), int64(len(archive)))
+	filesCount, err := r.GetFilesCount()
+	if err != nil || filesCount != 3 {
+		t.Errorf("The archive contains %d files instead of 3: %v", filesCount, err)
+	}
+}
+
+// TestEstimateSize tests estimating size of the archive
+func TestEstimateSize(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	opts := AddOptions{Exclude: []string{"*.png"}}
+	files, size, err := EstimateSize(_getPathToTests(t), opts)
+	if err != nil {
+		t.Fatalf("Unable to estimate size: %s", err)
+	}
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	err = w.AddDirectoryWithOptions(_getPathToTests(t), opts)
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	w.Close()
+
+	fi, err := os.Stat(filename)
+	if err != nil || fi.Size() != size {
+		t.Errorf("Estimated %d bytes instead of %d: %v", size, fi.Size(), err)
+	}
+	if files != w.FilesAdded || files != 4 {
+		t.Errorf("Estimated %d files instead of %d", files, w.FilesAdded)
+	}
+}
+
+// TestNewAtomicWriter tests creating archive by renaming a temporary file
+func TestNewAtomicWriter(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewAtomicWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	err = w.AddFileAs(filepath.Join(_getPathToTests(t), "lipsum.txt"), "lipsum.txt")
+	if err != nil {
+		t.Errorf("Failed to add file: %s", err)
+	}
+
+	// archive is not visible until closed
+	_, err = os.Stat(filename)
+	if !os.IsNotExist(err) {
+		t.Errorf("Archive appeared before it was closed")
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("Failed to close the archive: %s", err)
+	}
+	paths := _listPaths(t, filename)
+	if !reflect.DeepEqual(paths, []string{"lipsum.txt"}) {
+		t.Errorf("Unexpected files %v", paths)
+	}
+
+	// incomplete archive is removed and doesn't replace the previous one
+	w, err = NewAtomicWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	h, _ := NewHeader("database.sql", 100, time.Now())
+	w.Add(h, strings.NewReader("CREATE"))
+	err = w.Close()
+	if err != ErrIncompleteArchive {
+		t.Errorf("Expected ErrIncompleteArchive, got %v", err)
+	}
+	paths = _listPaths(t, filename)
+	if !reflect.DeepEqual(paths, []string{"lipsum.txt"}) {
+		t.Errorf("Unexpected files %v", paths)
+	}
+	files, _ := ioutil.ReadDir(tempPath)
+	if len(files) != 1 {
+		t.Errorf("Temporary file was left behind")
+	}
+}
+
+// TestAddFilesFrom tests adding files listed in a manifest
+func TestAddFilesFrom(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	list := "lipsum.txt\r\n\ninner_directory/lipsum2.txt\n"
+	added, err := w.AddFilesFrom(_getPathToTests(t), strings.NewReader(list))
+	if err != nil || added != 2 {
+		t.Errorf("Added %d files instead of 2: %v", added, err)
+	}
+
+	// paths outside root are refused
+	_, err = w.AddFilesFrom(_getPathToTests(t), strings.NewReader("../reader.go"))
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Expected ErrUnsafePath, got %v", err)
+	}
+	w.Close()
+
+	expected := []string{"lipsum.txt", "inner_directory/lipsum2.txt"}
+	paths := _listPaths(t, filename)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Added %v instead of %v", paths, expected)
+	}
+}
+
+// TestNewWriterTo tests writing archive to a stream
+func TestNewWriterTo(t *testing.T) {
+	archive := &bytes.Buffer{}
+	w := NewWriterTo(archive)
+
+	source := _getPathToTests(t) + string(os.PathSeparator) + "lipsum.txt"
+	err := w.AddFileAs(source, "wp-content/lipsum.txt")
+	if err != nil {
+		t.Errorf("Failed to add file: %s", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("Failed to close the archive: %s", err)
+	}
+
+	// read the archive back sequentially
+	r := NewReaderFrom(archive)
+	h, err := r.Next()
+	if err != nil || h.GetPath() != "wp-content/lipsum.txt" {
+		t.Fatalf("Unexpected header: %v", err)
+	}
+	content, err := ioutil.ReadAll(r)
+	expected, _ := ioutil.ReadFile(source)
+	if err != nil || !bytes.Equal(content, expected) {
+		t.Errorf("Read content differs from the source: %v", err)
+	}
+	_, err = r.Next()
+	if err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
 // TestAddDirectory tests adding a directory
 func TestAddDirectory(t *testing.T) {
 	// obtain cwd
@@ -178,6 +872,107 @@ func TestAddDirectory(t *testing.T) {
 	}
 }
 
+// TestAddDirectoryWithOptions tests adding a directory with excludes
+func TestAddDirectoryWithOptions(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "wpressTest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary folder %s", err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	// create a small site root
+	root := filepath.Join(tempPath, "site")
+	for _, name := range []string{
+		"index.php",
+		"debug.log",
+		"wp-content/plugins/akismet/akismet.php",
+		"wp-content/plugins/akismet/node_modules/lib.js",
+		"wp-content/cache/page.html",
+		"wp-content/uploads/logo.png",
+		"wp-content/uploads/error.log",
+	} {
+		pathToFile := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(pathToFile), 0755)
+		ioutil.WriteFile(pathToFile, []byte(name), 0644)
+	}
+
+	filename := filepath.Join(tempPath, "output.wpress")
+	w, err := NewWriter(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Writer because %s", err)
+	}
+	opts := AddOptions{Exclude: []string{"wp-content/cache", "node_modules", "*.log"}}
+	err = w.AddDirectoryWithOptions(root, opts)
+	if err != nil {
+		t.Errorf("Failed to add directory: %s", err)
+	}
+	w.Close()
+
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instace: %s", err)
+	}
+	defer r.Close()
+	entries, err := r.ListEntries()
+	if err != nil {
+		t.Fatalf("Unable to list entries: %s", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Prefix+" "+e.Name)
+	}
+	expected := []string{
+		". index.php",
+		"wp-content/plugins/akismet akismet.php",
+		"wp-content/uploads logo.png",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Added %v instead of %v", names, expected)
+	}
+
+	// malformed patterns are refused
+	err = w.AddDirectoryWithOptions(root, AddOptions{Exclude: []string{"[a-"}})
+	if err == nil {
+		t.Errorf("Expected malformed pattern to be refused")
+	}
+}
+
+// TestAddDirectoryPresets tests leaving out files by exclusion presets
+func TestAddDirectoryPresets(t *testing.T) {
+	tempPath := _newTempDir(t)
+	defer os.RemoveAll(tempPath)
+
+	// create a site in a subdirectory of the root
+	root := filepath.Join(tempPath, "public_html")
+	for _, name := range []string{
+		"index.php",
+		"error_log",
+		".git/HEAD",
+		"wp-content/cache/page.html",
+		"wp-content/upgrade/akismet.zip",
+		"wp-content/ai1wm-backups/site.wpress",
+		"wp-content/debug.log",
+		"wp-content/plugins/cache/cache.php",
+		"wp-content/uploads/logo.png",
+	} {
+		pathToFile := filepath.Join(root, "site", filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(pathToFile), 0755)
+		ioutil.WriteFile(pathToFile, []byte(name), 0644)
+	}
+
+	files, _, err := EstimateSize(root, AddOptions{Presets: WordPressPresets})
+	if err != nil || files != 3 {
+		t.Errorf("Expected 3 files to be kept, got %d: %v", files, err)
+	}
+	files, _, err = EstimateSize(root, AddOptions{Presets: []ExcludePreset{ExcludeVCS}, Exclude: []string{"*.log"}})
+	if err != nil || files != 7 {
+		t.Errorf("Expected 7 files to be kept, got %d: %v", files, err)
+	}
+	if len(ExcludeLogs.Patterns()) == 0 {
+		t.Errorf("Expected patterns of ExcludeLogs")
+	}
+}
+
 // TestClose tests closing archive
 func TestClose(t *testing.T) {
 	// obtain cwd
@@ -205,7 +1000,25 @@ func TestClose(t *testing.T) {
 		t.Errorf("Failed to create a new Writer because %s", err)
 	}
 
-	w.Close()
+	err = w.Close()
+	if err != nil {
+		t.Errorf("Failed to close the archive: %s", err)
+	}
+	_, err = w.File.Write([]byte("data"))
+	if err == nil {
+		t.Errorf("Close method didn't close the file")
+	}
+
+	// archive without files can still be read
+	r, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("Failed to create a new Reader instace: %s", err)
+	}
+	filesCount, err := r.GetFilesCount()
+	r.Close()
+	if err != nil || filesCount != 0 {
+		t.Errorf("The archive contains %d files instead of 0: %v", filesCount, err)
+	}
 
 	file, err := os.Open(filename)
 	data := make([]byte, 100)